@@ -2,13 +2,22 @@ package app
 
 import (
 	"context"
+	"crypto/ed25519"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/Kaamos-Comms/server/internal/did"
+	"github.com/Kaamos-Comms/server/internal/invite"
 	"github.com/Kaamos-Comms/server/internal/middleware"
+	"github.com/Kaamos-Comms/server/internal/prekey"
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/revocation"
 	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/Kaamos-Comms/server/internal/transparency"
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
 	"golang.org/x/time/rate"
@@ -16,19 +25,125 @@ import (
 
 var defaultPort = "8080"
 
+const (
+	didCacheTTL  = 5 * time.Minute
+	didCacheSize = 1000
+)
+
 type App struct {
 	e               *echo.Echo
 	signalingServer *signaling.Server
+	verifier        roomTokenVerifier
+	invites         invite.Store
+	rooms           registry.Store
+	didChallenges   *didChallengeStore
+	resolver        did.Resolver
+	prekeys         prekey.Store
+	prekeyFetches   *middleware.IPRateLimiter
+	keyLog          *transparency.Log
 	port            string
 }
 
 func Initialize() *App {
+	signingKeys, err := newSigningKeyring()
+	if err != nil {
+		log.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	verifier := roomTokenVerifier{keys: signingKeys, revocations: revocation.NewMemoryStore()}
+	resolver := did.NewCachingResolver(did.NewDefaultRegistry(did.NewHTTPFetcher()), didCacheTTL, didCacheSize)
+
+	keyLog := newKeyLog()
+
+	// TRUSTED_PROXIES lists the reverse proxies whose X-Forwarded-For
+	// headers identify clients
+	ipExtractor, err := newIPExtractor(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	slowConsumerPolicy, ok := signaling.ParseSlowConsumerPolicy(os.Getenv("SLOW_CONSUMER_POLICY"))
+	if !ok {
+		log.Fatalf("Invalid SLOW_CONSUMER_POLICY %q", os.Getenv("SLOW_CONSUMER_POLICY"))
+	}
+
+	hostPromotion, ok := signaling.ParseHostPromotionPolicy(os.Getenv("HOST_PROMOTION_POLICY"))
+	if !ok {
+		log.Fatalf("Invalid HOST_PROMOTION_POLICY %q", os.Getenv("HOST_PROMOTION_POLICY"))
+	}
+
+	// KNOCK_TIMEOUT=0 lets guests knock indefinitely
+	knockTimeout := signaling.DefaultKnockTimeout
+	if value := os.Getenv("KNOCK_TIMEOUT"); value != "" {
+		knockTimeout, err = time.ParseDuration(value)
+		if err != nil || knockTimeout < 0 {
+			log.Fatalf("Invalid KNOCK_TIMEOUT %q", value)
+		}
+	}
+
+	// PING_INTERVAL=0 turns off WebSocket pings, and with them the pong
+	// deadline; IDLE_TIMEOUT evicts participants that send nothing
+	pingInterval := durationFromEnv("PING_INTERVAL", signaling.DefaultPingInterval)
+	if pingInterval < 0 {
+		log.Fatalf("Invalid PING_INTERVAL %q", os.Getenv("PING_INTERVAL"))
+	}
+	pongTimeout := durationFromEnv("PONG_TIMEOUT", signaling.DefaultPongTimeout)
+	if pongTimeout <= 0 {
+		log.Fatalf("Invalid PONG_TIMEOUT %q", os.Getenv("PONG_TIMEOUT"))
+	}
+	idleTimeout := durationFromEnv("IDLE_TIMEOUT", 0)
+	if idleTimeout < 0 {
+		log.Fatalf("Invalid IDLE_TIMEOUT %q", os.Getenv("IDLE_TIMEOUT"))
+	}
+
+	// ROOM_DB keeps the room registry in a SQLite database instead of memory
+	var rooms registry.Store = registry.NewMemoryStore()
+	if path := os.Getenv("ROOM_DB"); path != "" {
+		store, err := registry.NewSQLiteStore(path)
+		if err != nil {
+			log.Fatalf("Failed to open room registry %q: %v", path, err)
+		}
+		rooms = store
+	}
+
+	signalingOptions := []signaling.Option{
+		signaling.WithTokenVerifier(verifier),
+		signaling.WithRoomRegistry(roomRegistry{rooms: rooms}),
+		signaling.WithSendQueue(signaling.DefaultSendQueueSize, slowConsumerPolicy),
+		signaling.WithHostPromotion(hostPromotion, signaling.DefaultHostPromotionGrace),
+		signaling.WithKnockTimeout(knockTimeout),
+		signaling.WithHeartbeat(pingInterval, pongTimeout),
+		signaling.WithIdleTimeout(idleTimeout),
+		signaling.WithDIDResolver(resolver),
+		signaling.WithKeyLog(keyLog),
+		signaling.WithIPExtractor(ipExtractor),
+	}
+
+	// permessage-deflate is off unless a level is configured
+	if value := os.Getenv("WS_COMPRESSION_LEVEL"); value != "" {
+		level, ok := signaling.ParseCompressionLevel(value)
+		if !ok {
+			log.Fatalf("Invalid WS_COMPRESSION_LEVEL %q", value)
+		}
+		signalingOptions = append(signalingOptions, signaling.WithCompression(level))
+	}
+
+	signalingServer := signaling.NewServer(signalingOptions...)
+
 	app := &App{
 		e:               echo.New(),
-		signalingServer: signaling.NewServer(),
+		signalingServer: signalingServer,
+		verifier:        verifier,
+		invites:         invite.NewMemoryStore(),
+		rooms:           rooms,
+		didChallenges:   newDIDChallengeStore(),
+		resolver:        resolver,
+		prekeys:         prekey.NewMemoryStore(),
+		prekeyFetches:   middleware.NewIPRateLimiter(rate.Every(prekeyFetchInterval), prekeyFetchBurst),
+		keyLog:          keyLog,
 		port:            getPort(),
 	}
 
+	app.e.IPExtractor = ipExtractor
 	app.e.HideBanner = true
 	app.e.HidePort = false
 
@@ -39,12 +154,29 @@ func Initialize() *App {
 
 	// 🟢 No rate limiting
 	app.e.GET("/health", healthHandler)
+	app.e.GET("/.well-known/jwks.json", func(c echo.Context) error {
+		return jwksHandler(c, app.verifier.keys)
+	})
 
 	// 🟡 10 req/min
 	lightLimiter := middleware.NewIPRateLimiter(rate.Every(time.Minute/10), 2)
 	lightProtected := app.e.Group("")
 	lightProtected.Use(lightLimiter.Middleware())
-	lightProtected.GET("/rooms/:slug/guest-token", guestTokenHandler)
+	lightProtected.GET("/rooms", func(c echo.Context) error {
+		return listRoomsHandler(c, app.verifier, app.rooms)
+	})
+	lightProtected.GET("/rooms/:slug", func(c echo.Context) error {
+		return getRoomHandler(c, app.verifier, app.rooms)
+	})
+	lightProtected.PATCH("/rooms/:slug", func(c echo.Context) error {
+		return updateRoomHandler(c, app.verifier, app.rooms, app.signalingServer)
+	})
+	lightProtected.DELETE("/rooms/:slug", func(c echo.Context) error {
+		return deleteRoomHandler(c, app.verifier, app.rooms, app.signalingServer)
+	})
+	lightProtected.POST("/rooms/:slug/host-token", func(c echo.Context) error {
+		return roomHostTokenHandler(c, app.verifier, app.rooms)
+	})
 	lightProtected.GET("/rooms/:slug/stats", func(c echo.Context) error {
 		slug := c.Param("slug")
 		stats := app.signalingServer.GetRoomStats(slug)
@@ -54,16 +186,77 @@ func Initialize() *App {
 		return c.JSON(http.StatusOK, stats)
 	})
 
-	lightProtected.GET("/rooms/:slug/guest-token", guestTokenHandler)
+	lightProtected.POST("/rooms/:slug/invites", func(c echo.Context) error {
+		return createInviteHandler(c, app.verifier, app.invites, app.signalingServer)
+	})
+	lightProtected.GET("/rooms/:slug/invites", func(c echo.Context) error {
+		return listInvitesHandler(c, app.verifier, app.invites, app.signalingServer)
+	})
+	lightProtected.DELETE("/rooms/:slug/invites/:code", func(c echo.Context) error {
+		return revokeInviteHandler(c, app.verifier, app.invites, app.signalingServer)
+	})
+	lightProtected.POST("/invites/:code/redeem", func(c echo.Context) error {
+		return redeemInviteHandler(c, app.verifier, app.invites, app.rooms)
+	})
 	lightProtected.GET("/rooms/:slug/keys", func(c echo.Context) error {
 		return roomKeysHandler(c, app.signalingServer)
 	})
+	lightProtected.GET("/rooms/:slug/safety-number", func(c echo.Context) error {
+		return safetyNumberHandler(c, app.signalingServer)
+	})
+	lightProtected.POST("/auth/did/challenge", func(c echo.Context) error {
+		return didChallengeHandler(c, app.didChallenges)
+	})
+	lightProtected.POST("/auth/did/verify", func(c echo.Context) error {
+		return didVerifyHandler(c, app.verifier, app.didChallenges)
+	})
+	lightProtected.GET("/dids/:did", func(c echo.Context) error {
+		return resolveDIDHandler(c, app.resolver)
+	})
+	lightProtected.PUT("/prekeys", func(c echo.Context) error {
+		return uploadPreKeysHandler(c, app.verifier, app.prekeys)
+	})
+	lightProtected.GET("/prekeys", func(c echo.Context) error {
+		return preKeyCountHandler(c, app.verifier, app.prekeys)
+	})
+	lightProtected.DELETE("/prekeys", func(c echo.Context) error {
+		return deletePreKeysHandler(c, app.verifier, app.prekeys)
+	})
+	lightProtected.GET("/prekeys/:did", func(c echo.Context) error {
+		return fetchPreKeyBundleHandler(c, app.verifier, app.prekeys, app.prekeyFetches, app.signalingServer)
+	})
+	lightProtected.GET("/transparency/sth", func(c echo.Context) error {
+		return treeHeadHandler(c, app.keyLog)
+	})
+	lightProtected.GET("/transparency/key", func(c echo.Context) error {
+		return treeHeadKeyHandler(c, app.keyLog)
+	})
+	lightProtected.GET("/transparency/proofs/inclusion", func(c echo.Context) error {
+		return inclusionProofHandler(c, app.keyLog)
+	})
+	lightProtected.GET("/transparency/proofs/consistency", func(c echo.Context) error {
+		return consistencyProofHandler(c, app.keyLog)
+	})
+	lightProtected.GET("/transparency/entries/:index", func(c echo.Context) error {
+		return logEntryHandler(c, app.keyLog)
+	})
+	lightProtected.GET("/transparency/identities", func(c echo.Context) error {
+		return identityEntriesHandler(c, app.keyLog)
+	})
+	lightProtected.POST("/tokens/revoke", func(c echo.Context) error {
+		return revokeTokenHandler(c, app.verifier, app.signalingServer)
+	})
+	lightProtected.POST("/rooms/:slug/revoke-all", func(c echo.Context) error {
+		return roomRevokeAllHandler(c, app.verifier, app.invites, app.signalingServer)
+	})
 
 	// 🔴 5 req/min
 	strictLimiter := middleware.NewIPRateLimiter(rate.Every(time.Minute/5), 1)
 	strictProtected := app.e.Group("")
 	strictProtected.Use(strictLimiter.Middleware())
-	strictProtected.POST("/rooms/anonymous", roomsAnonymousHandler)
+	strictProtected.POST("/rooms/anonymous", func(c echo.Context) error {
+		return roomsAnonymousHandler(c, app.verifier, app.rooms)
+	})
 
 	// 🔴 3 req/min
 	wsLimiter := middleware.NewIPRateLimiter(rate.Every(time.Minute/3), 1)
@@ -73,6 +266,8 @@ func Initialize() *App {
 }
 
 func (a *App) Start() {
+	a.verifier.keys.Start(keyRotationCheck)
+
 	go func() {
 		log.Printf("Starting server on port %s", a.port)
 		if err := a.e.Start(":" + a.port); err != nil && err != http.ErrServerClosed {
@@ -83,9 +278,59 @@ func (a *App) Start() {
 
 func (a *App) Shutdown(ctx context.Context) error {
 	a.signalingServer.Shutdown()
+	a.verifier.keys.Stop()
+	if closer, ok := a.rooms.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close room registry: %v", err)
+		}
+	}
+	if err := a.keyLog.Close(); err != nil {
+		log.Printf("Failed to close key transparency log: %v", err)
+	}
 	return a.e.Shutdown(ctx)
 }
 
+// newKeyLog creates the key transparency log. KEY_LOG_SIGNING_KEY is the
+// base64 Ed25519 key that signs tree heads, KEY_LOG_PATH the file its
+// entries are kept in and KEY_LOG_MAX_ENTRIES how many it may hold. Without
+// them, clients see a new key and an empty log after every restart.
+func newKeyLog() *transparency.Log {
+	var signer ed25519.PrivateKey
+	if value := os.Getenv("KEY_LOG_SIGNING_KEY"); value != "" {
+		key, err := transparency.ParseSigningKey(value)
+		if err != nil {
+			log.Fatalf("Invalid KEY_LOG_SIGNING_KEY: %v", err)
+		}
+		signer = key
+	} else {
+		log.Printf("KEY_LOG_SIGNING_KEY is not set, tree heads are signed with a key that changes on restart")
+	}
+
+	var opts []transparency.Option
+	if path := os.Getenv("KEY_LOG_PATH"); path != "" {
+		storage, err := transparency.NewFileStorage(path)
+		if err != nil {
+			log.Fatalf("Failed to open key log %q: %v", path, err)
+		}
+		opts = append(opts, transparency.WithStorage(storage))
+	} else {
+		log.Printf("KEY_LOG_PATH is not set, the key transparency log starts empty on restart")
+	}
+	if value := os.Getenv("KEY_LOG_MAX_ENTRIES"); value != "" {
+		maxEntries, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || maxEntries == 0 {
+			log.Fatalf("Invalid KEY_LOG_MAX_ENTRIES %q", value)
+		}
+		opts = append(opts, transparency.WithMaxEntries(maxEntries))
+	}
+
+	keyLog, err := transparency.NewLog(signer, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create key transparency log: %v", err)
+	}
+	return keyLog
+}
+
 func getPort() string {
 	if port := os.Getenv("PORT"); port != "" {
 		return port
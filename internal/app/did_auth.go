@@ -0,0 +1,266 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	didChallengeValidity = 5 * time.Minute
+	didSessionValidity   = 12 * time.Hour
+	didSessionAudience   = "kaamos-did-session"
+	maxPendingChallenges = 10000
+)
+
+var (
+	errChallengeNotFound = errors.New("challenge not found or expired")
+	errTooManyChallenges = errors.New("too many pending challenges")
+)
+
+type DIDChallengeRequest struct {
+	DID string `json:"did"`
+}
+
+type DIDChallengeResponse struct {
+	Nonce     string `json:"nonce"`
+	Challenge string `json:"challenge"` // the exact string the client must sign
+	ExpiresAt string `json:"expires_at"`
+}
+
+type DIDVerifyRequest struct {
+	DID       string `json:"did"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"` // base64 Ed25519 signature over Challenge
+}
+
+type DIDSessionResponse struct {
+	DID        string `json:"did"`
+	SessionJWT string `json:"session_jwt"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// SessionClaims are carried by DID session tokens. The subject is the DID.
+type SessionClaims struct {
+	jwt.RegisteredClaims
+}
+
+type didChallenge struct {
+	did       string
+	nonce     string
+	expiresAt time.Time
+}
+
+// challengeText is the message a client signs to prove control of did.
+func (c *didChallenge) challengeText() string {
+	return fmt.Sprintf("kaamos-did-auth:%s:%s", c.did, c.nonce)
+}
+
+// didChallengeStore keeps single-use login challenges in memory.
+type didChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]*didChallenge
+	now        func() time.Time
+}
+
+func newDIDChallengeStore() *didChallengeStore {
+	return &didChallengeStore{
+		challenges: make(map[string]*didChallenge),
+		now:        time.Now,
+	}
+}
+
+func (s *didChallengeStore) issue(did string) (*didChallenge, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for nonce, challenge := range s.challenges {
+		if !now.Before(challenge.expiresAt) {
+			delete(s.challenges, nonce)
+		}
+	}
+	if len(s.challenges) >= maxPendingChallenges {
+		return nil, errTooManyChallenges
+	}
+
+	challenge := &didChallenge{
+		did:       did,
+		nonce:     hex.EncodeToString(bytes),
+		expiresAt: now.Add(didChallengeValidity),
+	}
+	s.challenges[challenge.nonce] = challenge
+	return challenge, nil
+}
+
+// consume removes and returns the challenge. A challenge can be answered only
+// once, whether or not the answer is correct.
+func (s *didChallengeStore) consume(nonce, did string) (*didChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, exists := s.challenges[nonce]
+	if !exists {
+		return nil, errChallengeNotFound
+	}
+	delete(s.challenges, nonce)
+
+	if challenge.did != did || !s.now().Before(challenge.expiresAt) {
+		return nil, errChallengeNotFound
+	}
+	return challenge, nil
+}
+
+func (v roomTokenVerifier) generateSessionJWT(did string) (string, time.Time, error) {
+	tokenID, err := generateTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(didSessionValidity)
+	token, err := v.keys.Sign(SessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   did,
+			Audience:  jwt.ClaimStrings{didSessionAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// verifySession validates a DID session token and returns its DID.
+func (v roomTokenVerifier) verifySession(tokenString string) (string, error) {
+	claims := &SessionClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.keys.Keyfunc,
+		jwt.WithValidMethods(v.keys.Methods()),
+		jwt.WithAudience(didSessionAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := signaling.ParseDIDKey(claims.Subject); err != nil {
+		return "", err
+	}
+
+	if claims.IssuedAt == nil {
+		return "", errors.New("token has no iat")
+	}
+	revoked, err := v.isRevoked(claims.ID, "", claims.IssuedAt.Time)
+	if err != nil {
+		return "", err
+	}
+	if revoked {
+		return "", signaling.ErrTokenRevoked
+	}
+
+	return claims.Subject, nil
+}
+
+// sessionDID returns the DID of the optional session token in the
+// Authorization header. An absent header yields an empty DID; an invalid
+// token is an error rather than being silently ignored.
+func sessionDID(c echo.Context, verifier roomTokenVerifier) (string, error) {
+	token := bearerToken(c)
+	if token == "" {
+		return "", nil
+	}
+	return verifier.verifySession(token)
+}
+
+func didChallengeHandler(c echo.Context, challenges *didChallengeStore) error {
+	var req DIDChallengeRequest
+	if err := c.Bind(&req); err != nil || req.DID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "did is required",
+		})
+	}
+
+	if _, err := signaling.ParseDIDKey(req.DID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "did must be an Ed25519 did:key",
+		})
+	}
+
+	challenge, err := challenges.issue(req.DID)
+	if err != nil {
+		log.Printf("Failed to issue DID challenge: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errTooManyChallenges) {
+			status = http.StatusServiceUnavailable
+		}
+		return c.JSON(status, map[string]string{
+			"error": "failed to issue challenge",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DIDChallengeResponse{
+		Nonce:     challenge.nonce,
+		Challenge: challenge.challengeText(),
+		ExpiresAt: challenge.expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func didVerifyHandler(c echo.Context, verifier roomTokenVerifier, challenges *didChallengeStore) error {
+	var req DIDVerifyRequest
+	if err := c.Bind(&req); err != nil || req.DID == "" || req.Nonce == "" || req.Signature == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "did, nonce and signature are required",
+		})
+	}
+
+	publicKey, err := signaling.ParseDIDKey(req.DID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "did must be an Ed25519 did:key",
+		})
+	}
+
+	challenge, err := challenges.consume(req.Nonce, req.DID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if err := signaling.VerifyEd25519Signature(publicKey, []byte(challenge.challengeText()), req.Signature); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "invalid signature",
+		})
+	}
+
+	token, expiresAt, err := verifier.generateSessionJWT(req.DID)
+	if err != nil {
+		log.Printf("Failed to generate DID session JWT: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to generate session token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DIDSessionResponse{
+		DID:        req.DID,
+		SessionJWT: token,
+		ExpiresAt:  expiresAt.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/invite"
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDIDServer() (*echo.Echo, roomTokenVerifier) {
+	verifier := newTestVerifier()
+	challenges := newDIDChallengeStore()
+	invites := invite.NewMemoryStore()
+	rooms := registry.NewMemoryStore()
+
+	e := echo.New()
+	e.POST("/auth/did/challenge", func(c echo.Context) error {
+		return didChallengeHandler(c, challenges)
+	})
+	e.POST("/auth/did/verify", func(c echo.Context) error {
+		return didVerifyHandler(c, verifier, challenges)
+	})
+	e.POST("/rooms/anonymous", func(c echo.Context) error {
+		return roomsAnonymousHandler(c, verifier, rooms)
+	})
+	e.POST("/rooms/:slug/invites", func(c echo.Context) error {
+		return createInviteHandler(c, verifier, invites, signaling.NewServer())
+	})
+	e.POST("/invites/:code/redeem", func(c echo.Context) error {
+		return redeemInviteHandler(c, verifier, invites, rooms)
+	})
+	return e, verifier
+}
+
+func requestChallenge(t *testing.T, e *echo.Echo, did string) DIDChallengeResponse {
+	rec := doRequest(e, http.MethodPost, "/auth/did/challenge", "", `{"did":"`+did+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var challenge DIDChallengeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &challenge))
+	return challenge
+}
+
+func didLogin(t *testing.T, e *echo.Echo) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	did := signaling.DIDKeyFromEd25519(pub)
+
+	challenge := requestChallenge(t, e, did)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(challenge.Challenge)))
+
+	body, _ := json.Marshal(DIDVerifyRequest{DID: did, Nonce: challenge.Nonce, Signature: signature})
+	rec := doRequest(e, http.MethodPost, "/auth/did/verify", "", string(body))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var session DIDSessionResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+	assert.Equal(t, did, session.DID)
+	return did, session.SessionJWT
+}
+
+func TestDIDLogin(t *testing.T) {
+	e, verifier := setupDIDServer()
+
+	did, sessionToken := didLogin(t, e)
+
+	subject, err := verifier.verifySession(sessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, did, subject)
+
+	// A session token is not a room token
+	_, err = verifier.VerifyToken(sessionToken)
+	assert.Error(t, err)
+}
+
+func TestDIDLoginRejectsBadSignature(t *testing.T) {
+	e, _ := setupDIDServer()
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	did := signaling.DIDKeyFromEd25519(pub)
+
+	challenge := requestChallenge(t, e, did)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, []byte(challenge.Challenge)))
+
+	body, _ := json.Marshal(DIDVerifyRequest{DID: did, Nonce: challenge.Nonce, Signature: signature})
+	rec := doRequest(e, http.MethodPost, "/auth/did/verify", "", string(body))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The challenge is consumed by the failed attempt
+	rec = doRequest(e, http.MethodPost, "/auth/did/verify", "", string(body))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestDIDChallengeRejectsUnsupportedDID(t *testing.T) {
+	e, _ := setupDIDServer()
+
+	rec := doRequest(e, http.MethodPost, "/auth/did/challenge", "", `{"did":"did:web:example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(e, http.MethodPost, "/auth/did/challenge", "", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDIDChallengeExpires(t *testing.T) {
+	store := newDIDChallengeStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	challenge, err := store.issue("did:key:z6Mk")
+	require.NoError(t, err)
+
+	_, err = store.consume(challenge.nonce, "did:key:other")
+	assert.ErrorIs(t, err, errChallengeNotFound)
+
+	challenge, err = store.issue("did:key:z6Mk")
+	require.NoError(t, err)
+	now = now.Add(didChallengeValidity)
+	_, err = store.consume(challenge.nonce, "did:key:z6Mk")
+	assert.ErrorIs(t, err, errChallengeNotFound)
+}
+
+func TestRoomTokensBoundToDID(t *testing.T) {
+	e, verifier := setupDIDServer()
+	did, sessionToken := didLogin(t, e)
+
+	rec := doRequest(e, http.MethodPost, "/rooms/anonymous", sessionToken, "")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var room RoomResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &room))
+
+	claims, err := verifier.VerifyToken(room.JWT)
+	require.NoError(t, err)
+	assert.Equal(t, did, claims.DID)
+	assert.Equal(t, did, claims.Subject)
+
+	rec = doRequest(e, http.MethodPost, "/rooms/"+room.Slug+"/invites", room.JWT, `{}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var inv invite.Invite
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inv))
+
+	guestDID, guestSession := didLogin(t, e)
+	rec = doRequest(e, http.MethodPost, "/invites/"+inv.Code+"/redeem", guestSession, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var guest GuestTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &guest))
+
+	claims, err = verifier.VerifyToken(guest.GuestJWT)
+	require.NoError(t, err)
+	assert.Equal(t, guestDID, claims.DID)
+
+	// An invalid session token is rejected rather than ignored
+	rec = doRequest(e, http.MethodPost, "/rooms/anonymous", "garbage", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Kaamos-Comms/server/internal/did"
+	"github.com/labstack/echo/v4"
+)
+
+// DIDResolutionResponse follows the shape of the DID Resolution result.
+type DIDResolutionResponse struct {
+	Document           *did.Document          `json:"didDocument"`
+	ResolutionMetadata map[string]interface{} `json:"didResolutionMetadata"`
+	DocumentMetadata   map[string]interface{} `json:"didDocumentMetadata"`
+}
+
+// didParam reads the :did path parameter. The DID may be given as is
+// ("/dids/did:key:z6Mk...") or fully percent-encoded, which is needed for
+// did:web identifiers that contain "%3A".
+func didParam(c echo.Context) (string, error) {
+	id := c.Param("did")
+	if strings.HasPrefix(id, "did:") {
+		return id, nil
+	}
+	return url.PathUnescape(id)
+}
+
+// resolveDIDHandler resolves the DID in the path.
+func resolveDIDHandler(c echo.Context, resolver did.Resolver) error {
+	id, err := didParam(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid DID encoding"})
+	}
+
+	doc, err := resolver.Resolve(c.Request().Context(), id)
+	if err != nil {
+		status, code := http.StatusBadGateway, "internalError"
+		switch {
+		case errors.Is(err, did.ErrInvalidDID):
+			status, code = http.StatusBadRequest, "invalidDid"
+		case errors.Is(err, did.ErrNotFound):
+			status, code = http.StatusNotFound, "notFound"
+		case errors.Is(err, did.ErrMethodNotSupported):
+			status, code = http.StatusNotImplemented, "methodNotSupported"
+		default:
+			log.Printf("Failed to resolve %s: %v", id, err)
+		}
+		return c.JSON(status, DIDResolutionResponse{
+			ResolutionMetadata: map[string]interface{}{"error": code},
+			DocumentMetadata:   map[string]interface{}{},
+		})
+	}
+
+	return c.JSON(http.StatusOK, DIDResolutionResponse{
+		Document:           doc,
+		ResolutionMetadata: map[string]interface{}{"contentType": "application/did+json"},
+		DocumentMetadata:   map[string]interface{}{},
+	})
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Kaamos-Comms/server/internal/did"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDIDKey = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+type failingFetcher struct{}
+
+func (failingFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if url == "https://missing.example/.well-known/did.json" {
+		return nil, did.ErrNotFound
+	}
+	if url == "https://localhost:8443/.well-known/did.json" {
+		return []byte(`{"id":"did:web:localhost%3A8443"}`), nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func setupResolverServer() *echo.Echo {
+	resolver := did.NewDefaultRegistry(failingFetcher{})
+
+	e := echo.New()
+	e.GET("/dids/:did", func(c echo.Context) error {
+		return resolveDIDHandler(c, resolver)
+	})
+	return e
+}
+
+func TestResolveDIDEndpoint(t *testing.T) {
+	e := setupResolverServer()
+
+	rec := doRequest(e, http.MethodGet, "/dids/"+testDIDKey, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp DIDResolutionResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Document)
+	assert.Equal(t, testDIDKey, resp.Document.ID)
+	assert.Len(t, resp.Document.VerificationKeys(), 1)
+}
+
+func TestResolveDIDEndpointPercentEncoded(t *testing.T) {
+	e := setupResolverServer()
+
+	rec := doRequest(e, http.MethodGet, "/dids/did%3Aweb%3Alocalhost%253A8443", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp DIDResolutionResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "did:web:localhost%3A8443", resp.Document.ID)
+}
+
+func TestResolveDIDEndpointErrors(t *testing.T) {
+	e := setupResolverServer()
+
+	tests := []struct {
+		did    string
+		status int
+		code   string
+	}{
+		{"not-a-did", http.StatusBadRequest, "invalidDid"},
+		{"did:key:zzzz", http.StatusBadRequest, "invalidDid"},
+		{"did:ion:abc", http.StatusNotImplemented, "methodNotSupported"},
+		{"did:web:missing.example", http.StatusNotFound, "notFound"},
+		{"did:web:down.example", http.StatusBadGateway, "internalError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.did, func(t *testing.T) {
+			rec := doRequest(e, http.MethodGet, "/dids/"+tt.did, "", "")
+			assert.Equal(t, tt.status, rec.Code)
+
+			var resp DIDResolutionResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Nil(t, resp.Document)
+			assert.Equal(t, tt.code, resp.ResolutionMetadata["error"])
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package app
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/Kaamos-Comms/server/internal/invite"
+	"github.com/Kaamos-Comms/server/internal/keyring"
+	"github.com/Kaamos-Comms/server/internal/registry"
 	"github.com/Kaamos-Comms/server/internal/signaling"
 	"github.com/labstack/echo/v4"
 )
@@ -13,13 +17,25 @@ const (
 	tokenValidity      = 24 * time.Hour
 	slugLength         = 8
 	guestTokenValidity = 2 * time.Hour
+	defaultKeyRotation = 24 * time.Hour
+	keyRotationCheck   = time.Minute
 )
 
-var jwtSecret = getJWTSecret()
-
 type RoomResponse struct {
-	Slug string `json:"slug"`
-	JWT  string `json:"jwt"`
+	Slug      string                 `json:"slug"`
+	JWT       string                 `json:"jwt"`
+	Settings  signaling.RoomSettings `json:"settings"`
+	ExpiresAt time.Time              `json:"expires_at"`
+}
+
+type RevokeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type RevokeResponse struct {
+	Revoked      bool `json:"revoked"`
+	Disconnected int  `json:"disconnected"`
+	Invites      int  `json:"invites_revoked,omitempty"` // revoke-all only
 }
 
 type HealthResponse struct {
@@ -34,7 +50,66 @@ func healthHandler(c echo.Context) error {
 	})
 }
 
-func roomsAnonymousHandler(c echo.Context) error {
+// jwksHandler publishes the public keys that verify room tokens so other
+// services can check them without holding the signing keys.
+func jwksHandler(c echo.Context, keys *keyring.Keyring) error {
+	set, err := keys.JWKS()
+	if err != nil {
+		log.Printf("Failed to build JWKS: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to publish signing keys",
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, set)
+}
+
+// roomsAnonymousHandler creates a room and registers it, optionally with
+// settings and a lifetime. A DID session token in the Authorization header
+// binds the host token to that identity, which then owns the room and can
+// get new host tokens. Anonymous rooms are owned by their only host token and
+// expire with it.
+func roomsAnonymousHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store) error {
+	did, err := sessionDID(c, verifier)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "invalid session token",
+		})
+	}
+
+	var req CreateRoomRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room request",
+		})
+	}
+
+	settings := signaling.DefaultRoomSettings()
+	if req.Settings != nil {
+		settings = req.Settings.Apply(settings)
+	}
+	validity := defaultRoomValidity
+	if req.ExpiresIn != 0 {
+		validity = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	if err := settings.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if validity <= 0 || validity > maxRoomValidity {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "expires_in must be between 1 second and 7 days",
+		})
+	}
+	if did == "" && validity > tokenValidity {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "anonymous rooms cannot outlive their host token, log in with a DID for rooms longer than 24 hours",
+		})
+	}
+
 	slug, err := generateSlug(slugLength)
 	if err != nil {
 		log.Printf("Failed to generate slug: %v", err)
@@ -43,7 +118,7 @@ func roomsAnonymousHandler(c echo.Context) error {
 		})
 	}
 
-	token, err := generateJWT(slug)
+	token, claims, err := verifier.generateHostJWT(slug, did)
 	if err != nil {
 		log.Printf("Failed to generate JWT: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
@@ -51,9 +126,26 @@ func roomsAnonymousHandler(c echo.Context) error {
 		})
 	}
 
+	now := time.Now()
+	room := &registry.Room{
+		Slug:      slug,
+		Owner:     roomOwner(claims),
+		Settings:  settings,
+		CreatedAt: now,
+		ExpiresAt: now.Add(validity),
+	}
+	if err := rooms.Create(room); err != nil {
+		log.Printf("Failed to register room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to create room",
+		})
+	}
+
 	return c.JSON(http.StatusCreated, RoomResponse{
-		Slug: slug,
-		JWT:  token,
+		Slug:      slug,
+		JWT:       token,
+		Settings:  settings,
+		ExpiresAt: room.ExpiresAt,
 	})
 }
 
@@ -83,43 +175,189 @@ func roomKeysHandler(c echo.Context, signalingServer *signaling.Server) error {
 	}
 
 	keys := make(map[string]string)
-	if participants.Host != nil && participants.Host.Keys.PublicKey != "" {
-		keys[participants.Host.ID] = participants.Host.Keys.PublicKey
+	bundles := make(map[string][]signaling.TypedKey)
+	proofs := make(map[string]*signaling.KeyProof)
+	fingerprints := make(map[string]string)
+	for _, participant := range participantList(participants) {
+		if participant.Keys.PublicKey == "" {
+			continue
+		}
+		keys[participant.ID] = participant.Keys.PublicKey
+		bundles[participant.ID] = participant.Keys.Bundle
+		proofs[participant.ID] = participant.Keys.Proof
+		fingerprints[participant.ID] = participant.Keys.Fingerprint
 	}
-	for id, guest := range participants.Guests {
-		if guest.Keys.PublicKey != "" {
-			keys[id] = guest.Keys.PublicKey
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"room":         slug,
+		"keys":         keys,
+		"bundles":      bundles,
+		"proofs":       proofs,
+		"fingerprints": fingerprints,
+		"transparency": signalingServer.KeyTransparency(slug),
+	})
+}
+
+// safetyNumberHandler returns the safety number two participants of a room
+// should see when they compare their keys.
+func safetyNumberHandler(c echo.Context, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	a, b := c.QueryParam("a"), c.QueryParam("b")
+	if a == "" || b == "" || a == b {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "two distinct participants a and b are required",
+		})
+	}
+
+	stats := signalingServer.GetRoomStats(slug)
+	if stats == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "room not found",
+		})
+	}
+	participants, ok := stats["participants"].(*signaling.ParticipantsData)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get participant data",
+		})
+	}
+
+	fingerprints := make(map[string]string, 2)
+	for _, participant := range participantList(participants) {
+		if (participant.ID == a || participant.ID == b) && participant.Keys.Fingerprint != "" {
+			fingerprints[participant.ID] = participant.Keys.Fingerprint
 		}
 	}
+	if len(fingerprints) != 2 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "both participants must be in the room and have keys",
+		})
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"room": slug,
-		"keys": keys,
+		"a":             a,
+		"b":             b,
+		"fingerprints":  fingerprints,
+		"safety_number": signaling.SafetyNumber(fingerprints[a], fingerprints[b]),
 	})
 }
 
-func guestTokenHandler(c echo.Context) error {
-	slug := c.Param("slug")
+// participantList flattens the host and guests of a room.
+func participantList(data *signaling.ParticipantsData) []*signaling.Participant {
+	list := make([]*signaling.Participant, 0, len(data.Guests)+1)
+	if data.Host != nil {
+		list = append(list, data.Host)
+	}
+	for _, guest := range data.Guests {
+		list = append(list, guest)
+	}
+	return list
+}
 
-	// Валидируем slug
-	slug = sanitizeSlug(slug)
+// revokeTokenHandler revokes the token in the request body. Holding the token
+// is the authorization to revoke it.
+func revokeTokenHandler(c echo.Context, verifier roomTokenVerifier, signalingServer *signaling.Server) error {
+	var req RevokeTokenRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "token is required",
+		})
+	}
+
+	claims, err := verifier.verify(req.Token)
+	if errors.Is(err, signaling.ErrTokenRevoked) {
+		return c.JSON(http.StatusOK, RevokeResponse{Revoked: true})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid token",
+		})
+	}
+
+	if err := verifier.revocations.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+		log.Printf("Failed to revoke token %s: %v", claims.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to revoke token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, RevokeResponse{
+		Revoked:      true,
+		Disconnected: signalingServer.DisconnectToken(claims.ID),
+	})
+}
+
+// roomRevokeAllHandler revokes every token issued for the room so far except
+// the one used to authorize the request, and the invites that would issue
+// more.
+func roomRevokeAllHandler(c echo.Context, verifier roomTokenVerifier, invites invite.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
 	if slug == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "invalid room slug",
 		})
 	}
 
-	// Генерируем гостевой токен
-	token, expiresAt, err := generateGuestJWT(slug)
+	claims, status, message := authorizeHost(c, verifier, signalingServer, slug)
+	if claims == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	// Room tokens carry their issue time to the millisecond, so only those
+	// issued within this millisecond cannot be told apart and are revoked as
+	// well. No token for the room can outlive tokenValidity, so neither can
+	// the entry.
+	now := tokenClock().Truncate(time.Millisecond)
+	if err := verifier.revocations.RevokeRoom(slug, now, claims.ID, now.Add(tokenValidity)); err != nil {
+		log.Printf("Failed to revoke tokens for room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to revoke tokens",
+		})
+	}
+
+	revokedInvites, err := invites.RevokeAll(slug)
 	if err != nil {
-		log.Printf("Failed to generate guest JWT: %v", err)
+		log.Printf("Failed to revoke invites for room %s: %v", slug, err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "failed to generate guest token",
+			"error": "failed to revoke invites",
 		})
 	}
 
-	return c.JSON(http.StatusOK, GuestTokenResponse{
-		GuestJWT:  token,
-		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	return c.JSON(http.StatusOK, RevokeResponse{
+		Revoked:      true,
+		Disconnected: signalingServer.DisconnectRoom(slug, claims.ID),
+		Invites:      revokedInvites,
 	})
 }
+
+// authorizeHost checks that the request comes from the host of slug. While
+// the room is live that is whoever hosts it now, identified by the token they
+// joined with or their DID, so hosting follows transfer_host and promotions.
+// Otherwise it takes a host token for the room. On failure it returns nil
+// claims with the status and message to reply with.
+func authorizeHost(c echo.Context, verifier roomTokenVerifier, signalingServer *signaling.Server, slug string) (*RoomClaims, int, string) {
+	claims, err := verifier.verify(bearerToken(c))
+	if err != nil {
+		return nil, http.StatusUnauthorized, "valid host token required"
+	}
+	if claims.Slug != slug {
+		return nil, http.StatusForbidden, "only the room host can do this"
+	}
+
+	if tokenID, did, live := signalingServer.RoomHost(slug); live {
+		if claims.ID != tokenID && (claims.DID == "" || claims.DID != did) {
+			return nil, http.StatusForbidden, "only the room host can do this"
+		}
+	} else if claims.Role != string(signaling.RoleHost) {
+		return nil, http.StatusForbidden, "only the room host can do this"
+	}
+	return claims, 0, ""
+}
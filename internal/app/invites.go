@@ -0,0 +1,227 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/invite"
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultInviteValidity = 24 * time.Hour
+	maxInviteValidity     = 7 * 24 * time.Hour
+	maxInviteNameLength   = 64
+)
+
+type CreateInviteRequest struct {
+	MaxUses   int    `json:"max_uses"`   // 0 means unlimited
+	SingleUse bool   `json:"single_use"` // shorthand for max_uses = 1
+	ExpiresIn int    `json:"expires_in"` // seconds, defaults to 24h
+	Name      string `json:"name"`       // display name for the guest
+}
+
+type InvitesResponse struct {
+	Room    string           `json:"room"`
+	Invites []*invite.Invite `json:"invites"`
+}
+
+// createInviteHandler lets the room host mint an invite code.
+func createInviteHandler(c echo.Context, verifier roomTokenVerifier, invites invite.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	claims, status, message := authorizeHost(c, verifier, signalingServer, slug)
+	if claims == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	var req CreateInviteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid invite request",
+		})
+	}
+
+	validity := defaultInviteValidity
+	if req.ExpiresIn != 0 {
+		validity = time.Duration(req.ExpiresIn) * time.Second
+	}
+	name := strings.TrimSpace(req.Name)
+
+	switch {
+	case req.MaxUses < 0:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "max_uses must not be negative",
+		})
+	case validity <= 0 || validity > maxInviteValidity:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "expires_in must be between 1 second and 7 days",
+		})
+	case len(name) > maxInviteNameLength:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "name is too long",
+		})
+	}
+
+	maxUses := req.MaxUses
+	if req.SingleUse {
+		maxUses = 1
+	}
+
+	code, err := invite.GenerateCode()
+	if err != nil {
+		log.Printf("Failed to generate invite code: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to create invite",
+		})
+	}
+
+	now := time.Now()
+	inv := &invite.Invite{
+		Code:      code,
+		Slug:      slug,
+		Name:      name,
+		MaxUses:   maxUses,
+		CreatedBy: claims.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(validity),
+	}
+	if err := invites.Create(inv); err != nil {
+		log.Printf("Failed to store invite for room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to create invite",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// listInvitesHandler returns every invite of the room, including used up,
+// expired and revoked ones.
+func listInvitesHandler(c echo.Context, verifier roomTokenVerifier, invites invite.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	if claims, status, message := authorizeHost(c, verifier, signalingServer, slug); claims == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	list, err := invites.List(slug)
+	if err != nil {
+		log.Printf("Failed to list invites for room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to list invites",
+		})
+	}
+
+	return c.JSON(http.StatusOK, InvitesResponse{
+		Room:    slug,
+		Invites: list,
+	})
+}
+
+func revokeInviteHandler(c echo.Context, verifier roomTokenVerifier, invites invite.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	if claims, status, message := authorizeHost(c, verifier, signalingServer, slug); claims == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	if err := invites.Revoke(slug, c.Param("code")); err != nil {
+		if errors.Is(err, invite.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "invite not found",
+			})
+		}
+		log.Printf("Failed to revoke invite for room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to revoke invite",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// redeemInviteHandler exchanges an invite code for a guest token. A DID
+// session token in the Authorization header binds the guest token to that
+// identity. Invites die with their room.
+func redeemInviteHandler(c echo.Context, verifier roomTokenVerifier, invites invite.Store, rooms registry.Store) error {
+	did, err := sessionDID(c, verifier)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "invalid session token",
+		})
+	}
+
+	// Look the room up before the invite is used up
+	if inv, err := invites.Get(c.Param("code")); err == nil {
+		if _, err := rooms.Get(inv.Slug); errors.Is(err, registry.ErrNotFound) {
+			return c.JSON(http.StatusGone, map[string]string{
+				"error": "the room no longer exists",
+			})
+		} else if err != nil {
+			log.Printf("Failed to look up room %s: %v", inv.Slug, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to redeem invite",
+			})
+		}
+	}
+
+	inv, err := invites.Redeem(c.Param("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, invite.ErrNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "invite not found",
+			})
+		case errors.Is(err, invite.ErrExpired), errors.Is(err, invite.ErrExhausted), errors.Is(err, invite.ErrRevoked):
+			return c.JSON(http.StatusGone, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to redeem invite: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to redeem invite",
+		})
+	}
+
+	token, expiresAt, err := verifier.generateGuestJWT(inv.Slug, inv.Name, did, inv.Code)
+	if err != nil {
+		log.Printf("Failed to generate guest JWT: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to generate guest token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, GuestTokenResponse{
+		GuestJWT:  token,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+		Slug:      inv.Slug,
+		Name:      inv.Name,
+	})
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/invite"
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupInviteServer() (*echo.Echo, roomTokenVerifier) {
+	verifier := newTestVerifier()
+	invites := invite.NewMemoryStore()
+	signalingServer := signaling.NewServer()
+	rooms := registry.NewMemoryStore()
+	rooms.Create(&registry.Room{Slug: "room-123", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	e := echo.New()
+	e.POST("/rooms/:slug/invites", func(c echo.Context) error {
+		return createInviteHandler(c, verifier, invites, signalingServer)
+	})
+	e.GET("/rooms/:slug/invites", func(c echo.Context) error {
+		return listInvitesHandler(c, verifier, invites, signalingServer)
+	})
+	e.DELETE("/rooms/:slug/invites/:code", func(c echo.Context) error {
+		return revokeInviteHandler(c, verifier, invites, signalingServer)
+	})
+	e.POST("/invites/:code/redeem", func(c echo.Context) error {
+		return redeemInviteHandler(c, verifier, invites, rooms)
+	})
+	return e, verifier
+}
+
+func doRequest(e *echo.Echo, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func createInvite(t *testing.T, e *echo.Echo, hostToken, body string) invite.Invite {
+	rec := doRequest(e, http.MethodPost, "/rooms/room-123/invites", hostToken, body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var inv invite.Invite
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inv))
+	return inv
+}
+
+func TestCreateInviteRequiresHost(t *testing.T) {
+	e, _ := setupInviteServer()
+
+	rec := doRequest(e, http.MethodPost, "/rooms/room-123/invites", "", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	guestToken, _, err := newTestVerifier().generateGuestJWT("room-123", "", "", "")
+	require.NoError(t, err)
+	rec = doRequest(e, http.MethodPost, "/rooms/room-123/invites", guestToken, `{}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	otherHost, err := newTestVerifier().generateJWT("room-456", "")
+	require.NoError(t, err)
+	rec = doRequest(e, http.MethodPost, "/rooms/room-123/invites", otherHost, `{}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestCreateInviteValidation(t *testing.T) {
+	e, _ := setupInviteServer()
+	hostToken, err := newTestVerifier().generateJWT("room-123", "")
+	require.NoError(t, err)
+
+	for _, body := range []string{
+		`{"max_uses": -1}`,
+		`{"expires_in": -5}`,
+		`{"expires_in": 99999999}`,
+		`{"name": "` + strings.Repeat("n", 65) + `"}`,
+	} {
+		rec := doRequest(e, http.MethodPost, "/rooms/room-123/invites", hostToken, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestRedeemInvite(t *testing.T) {
+	e, verifier := setupInviteServer()
+	hostToken, err := newTestVerifier().generateJWT("room-123", "")
+	require.NoError(t, err)
+
+	inv := createInvite(t, e, hostToken, `{"single_use": true, "name": "Alice"}`)
+	assert.Equal(t, 1, inv.MaxUses)
+	assert.Equal(t, "Alice", inv.Name)
+
+	rec := doRequest(e, http.MethodPost, "/invites/"+inv.Code+"/redeem", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response GuestTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "room-123", response.Slug)
+
+	claims, err := verifier.VerifyToken(response.GuestJWT)
+	require.NoError(t, err)
+	assert.Equal(t, "room-123", claims.Slug)
+	assert.Equal(t, signaling.RoleGuest, claims.Role)
+	assert.Equal(t, "Alice", claims.Name)
+
+	// Single-use invites cannot be redeemed twice
+	rec = doRequest(e, http.MethodPost, "/invites/"+inv.Code+"/redeem", "", "")
+	assert.Equal(t, http.StatusGone, rec.Code)
+
+	rec = doRequest(e, http.MethodPost, "/invites/unknown/redeem", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Invites of rooms that are not registered, e.g. deleted ones, are dead
+	otherHost, err := newTestVerifier().generateJWT("room-456", "")
+	require.NoError(t, err)
+	rec = doRequest(e, http.MethodPost, "/rooms/room-456/invites", otherHost, `{}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var orphan invite.Invite
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orphan))
+	rec = doRequest(e, http.MethodPost, "/invites/"+orphan.Code+"/redeem", "", "")
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
+
+func TestAnonymousGuestsAreIdentifiedByInvite(t *testing.T) {
+	e, verifier := setupInviteServer()
+	hostToken, err := newTestVerifier().generateJWT("room-123", "")
+	require.NoError(t, err)
+
+	subjects := map[string]string{}
+	for _, body := range []string{`{}`, `{}`} {
+		inv := createInvite(t, e, hostToken, body)
+		for range 2 {
+			rec := doRequest(e, http.MethodPost, "/invites/"+inv.Code+"/redeem", "", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			var response GuestTokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+			claims, err := verifier.verify(response.GuestJWT)
+			require.NoError(t, err)
+			require.NotEmpty(t, claims.Subject)
+			assert.NotContains(t, claims.Subject, inv.Code)
+			if subject, seen := subjects[inv.Code]; seen {
+				assert.Equal(t, subject, claims.Subject, "redeeming an invite again keeps the subject")
+			}
+			subjects[inv.Code] = claims.Subject
+		}
+	}
+	assert.Len(t, slices.Compact(slices.Sorted(maps.Values(subjects))), 2)
+}
+
+func TestHostEndpointsFollowTransferHost(t *testing.T) {
+	verifier := newTestVerifier()
+	invites := invite.NewMemoryStore()
+	signalingServer := signaling.NewServer(
+		signaling.WithTokenVerifier(verifier),
+		signaling.WithRoomSettings(signaling.RoomSettings{Admission: signaling.AdmissionOpen}),
+	)
+
+	e := echo.New()
+	e.POST("/rooms/:slug/invites", func(c echo.Context) error {
+		return createInviteHandler(c, verifier, invites, signalingServer)
+	})
+
+	testServer := httptest.NewServer(http.HandlerFunc(signalingServer.HandleWebSocket))
+	t.Cleanup(testServer.Close)
+	dial := func(token string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http")+"?token="+token, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	hostToken, err := verifier.generateJWT("room-123", "")
+	require.NoError(t, err)
+	guestToken, _, err := verifier.generateGuestJWT("room-123", "", "", "code")
+	require.NoError(t, err)
+	guestClaims, err := verifier.verify(guestToken)
+	require.NoError(t, err)
+
+	host := dial(hostToken)
+	require.Eventually(t, func() bool {
+		_, _, live := signalingServer.RoomHost("room-123")
+		return live
+	}, time.Second, 5*time.Millisecond)
+	dial(guestToken)
+	var guestID string
+	require.Eventually(t, func() bool {
+		participants := signalingServer.GetRoomStats("room-123")["participants"].(*signaling.ParticipantsData)
+		for id := range participants.Guests {
+			guestID = id
+		}
+		return guestID != ""
+	}, time.Second, 5*time.Millisecond)
+
+	createInvite(t, e, hostToken, `{}`)
+
+	require.NoError(t, host.WriteJSON(map[string]interface{}{
+		"type": "transfer_host",
+		"data": map[string]string{"participant_id": guestID},
+	}))
+	require.Eventually(t, func() bool {
+		tokenID, _, _ := signalingServer.RoomHost("room-123")
+		return tokenID == guestClaims.ID
+	}, time.Second, 5*time.Millisecond)
+
+	rec := doRequest(e, http.MethodPost, "/rooms/room-123/invites", hostToken, `{}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the former host lost the room")
+	createInvite(t, e, guestToken, `{}`)
+}
+
+func TestListAndRevokeInvites(t *testing.T) {
+	e, _ := setupInviteServer()
+	hostToken, err := newTestVerifier().generateJWT("room-123", "")
+	require.NoError(t, err)
+
+	first := createInvite(t, e, hostToken, `{}`)
+	createInvite(t, e, hostToken, `{"max_uses": 5}`)
+
+	rec := doRequest(e, http.MethodGet, "/rooms/room-123/invites", hostToken, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list InvitesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Invites, 2)
+
+	rec = doRequest(e, http.MethodDelete, "/rooms/room-123/invites/"+first.Code, hostToken, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(e, http.MethodDelete, "/rooms/room-123/invites/unknown", hostToken, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(e, http.MethodPost, "/invites/"+first.Code+"/redeem", "", "")
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
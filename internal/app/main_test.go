@@ -1,19 +1,47 @@
 package app
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Kaamos-Comms/server/internal/invite"
+	"github.com/Kaamos-Comms/server/internal/keyring"
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/revocation"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
+// testKeys signs the tokens of every test, like the keyring of a server.
+var testKeys = func() *keyring.Keyring {
+	k, err := keyring.New(keyring.Config{Algorithm: keyring.AlgorithmEdDSA, Overlap: tokenValidity})
+	if err != nil {
+		panic(err)
+	}
+	return k
+}()
+
+func newTestVerifier() roomTokenVerifier {
+	return roomTokenVerifier{keys: testKeys, revocations: revocation.NewMemoryStore()}
+}
+
 func setupTestServer() *echo.Echo {
+	verifier := newTestVerifier()
+
 	e := echo.New()
 	e.GET("/health", healthHandler)
-	e.POST("/rooms/anonymous", roomsAnonymousHandler)
+	e.POST("/rooms/anonymous", func(c echo.Context) error {
+		return roomsAnonymousHandler(c, verifier, registry.NewMemoryStore())
+	})
+	e.GET("/.well-known/jwks.json", func(c echo.Context) error {
+		return jwksHandler(c, testKeys)
+	})
 	return e
 }
 
@@ -34,106 +62,6 @@ func TestRoomsAnonymousEndpoint(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, rec.Code)
 }
 
-// func TestGuestTokenHandler(t *testing.T) {
-// 	e := echo.New()
-// 	e.GET("/rooms/:slug/guest-token", guestTokenHandler)
-
-// 	tests := []struct {
-// 		name           string
-// 		slug           string
-// 		expectedStatus int
-// 		checkToken     bool
-// 	}{
-// 		{
-// 			name:           "valid slug",
-// 			slug:           "test-room-123",
-// 			expectedStatus: http.StatusOK,
-// 			checkToken:     true,
-// 		},
-// 		{
-// 			name:           "invalid slug with spaces",
-// 			slug:           "test room",
-// 			expectedStatus: http.StatusBadRequest,
-// 			checkToken:     false,
-// 		},
-// 		{
-// 			name:           "empty slug",
-// 			slug:           "",
-// 			expectedStatus: http.StatusBadRequest,
-// 			checkToken:     false,
-// 		},
-// 		{
-// 			name:           "invalid characters",
-// 			slug:           "test@room!",
-// 			expectedStatus: http.StatusBadRequest,
-// 			checkToken:     false,
-// 		},
-// 	}
-
-// 	for _, tt := range tests {
-// 		t.Run(tt.name, func(t *testing.T) {
-// 			req := httptest.NewRequest(http.MethodGet, "/rooms/"+tt.slug+"/guest-token", nil)
-// 			rec := httptest.NewRecorder()
-
-// 			e.ServeHTTP(rec, req)
-
-// 			assert.Equal(t, tt.expectedStatus, rec.Code)
-
-// 			if tt.checkToken {
-// 				var response GuestTokenResponse
-// 				err := json.Unmarshal(rec.Body.Bytes(), &response)
-// 				assert.NoError(t, err)
-
-// 				// Проверяем, что токен не пустой
-// 				assert.NotEmpty(t, response.GuestJWT)
-// 				assert.NotEmpty(t, response.ExpiresAt)
-
-// 				// Проверяем, что можем распарсить токен
-// 				token, err := jwt.ParseWithClaims(response.GuestJWT, &GuestClaims{}, func(token *jwt.Token) (interface{}, error) {
-// 					return []byte(jwtSecret), nil
-// 				})
-// 				assert.NoError(t, err)
-
-// 				claims, ok := token.Claims.(*GuestClaims)
-// 				assert.True(t, ok)
-// 				assert.Equal(t, tt.slug, claims.Slug)
-// 				assert.Equal(t, "guest", claims.Role)
-
-// 				// Проверяем время истечения
-// 				expiresAt, err := time.Parse(time.RFC3339, response.ExpiresAt)
-// 				assert.NoError(t, err)
-// 				assert.True(t, expiresAt.After(time.Now()))
-// 			}
-// 		})
-// 	}
-// }
-
-func TestGuestTokenHandlerWithContext(t *testing.T) {
-	e := echo.New()
-
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
-	c.SetPath("/rooms/:slug/guest-token")
-	c.SetParamNames("slug")
-	c.SetParamValues("valid-room-123")
-
-	err := guestTokenHandler(c)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, rec.Code)
-
-	req2 := httptest.NewRequest(http.MethodGet, "/", nil)
-	rec2 := httptest.NewRecorder()
-	c2 := e.NewContext(req2, rec2)
-	c2.SetPath("/rooms/:slug/guest-token")
-	c2.SetParamNames("slug")
-	c2.SetParamValues("invalid slug with spaces")
-
-	err2 := guestTokenHandler(c2)
-	assert.NoError(t, err2) // Handler не возвращает ошибку, но устанавливает статус
-	assert.Equal(t, http.StatusBadRequest, rec2.Code)
-}
-
 func TestSanitizeSlug(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -155,3 +83,230 @@ func TestSanitizeSlug(t *testing.T) {
 		})
 	}
 }
+
+func TestRoomTokenVerifier(t *testing.T) {
+	verifier := newTestVerifier()
+
+	hostToken, err := verifier.generateJWT("room-123", "")
+	assert.NoError(t, err)
+	claims, err := verifier.VerifyToken(hostToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "room-123", claims.Slug)
+	assert.Equal(t, signaling.RoleHost, claims.Role)
+
+	guestToken, _, err := verifier.generateGuestJWT("room-123", "", "", "")
+	assert.NoError(t, err)
+	claims, err = verifier.VerifyToken(guestToken)
+	assert.NoError(t, err)
+	assert.Equal(t, signaling.RoleGuest, claims.Role)
+
+	_, err = verifier.VerifyToken(guestToken + "tampered")
+	assert.Error(t, err)
+
+	expiredToken, err := testKeys.Sign(RoomClaims{
+		Slug: "room-123",
+		Role: "host",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "expired-jti",
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(expiredToken)
+	assert.ErrorIs(t, err, signaling.ErrTokenExpired)
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, RoomClaims{
+		Slug: "room-123",
+		Role: "host",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	unsignedToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(unsignedToken)
+	assert.Error(t, err)
+
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, RoomClaims{
+		Slug: "room-123",
+		Role: "host",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(""))
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(hmacToken)
+	assert.Error(t, err)
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	e := setupTestServer()
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var set keyring.JWKS
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	if assert.NotEmpty(t, set.Keys) {
+		assert.Equal(t, testKeys.Active().ID, set.Keys[len(set.Keys)-1].KeyID)
+	}
+
+	// A room token's kid must be resolvable from the published set
+	token, err := newTestVerifier().generateJWT("room-123", "")
+	assert.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &RoomClaims{})
+	assert.NoError(t, err)
+	kid := parsed.Header["kid"]
+	found := false
+	for _, key := range set.Keys {
+		if key.KeyID == kid {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestRevokeTokenEndpoint(t *testing.T) {
+	verifier := newTestVerifier()
+	signalingServer := signaling.NewServer(signaling.WithTokenVerifier(verifier))
+
+	e := echo.New()
+	e.POST("/tokens/revoke", func(c echo.Context) error {
+		return revokeTokenHandler(c, verifier, signalingServer)
+	})
+
+	token, _, err := verifier.generateGuestJWT("room-123", "", "", "")
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(token)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/tokens/revoke", strings.NewReader(`{"token":"`+token+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, err = verifier.VerifyToken(token)
+	assert.ErrorIs(t, err, signaling.ErrTokenRevoked)
+
+	req = httptest.NewRequest(http.MethodPost, "/tokens/revoke", strings.NewReader(`{"token":"garbage"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRoomRevokeAllEndpoint(t *testing.T) {
+	verifier := newTestVerifier()
+	signalingServer := signaling.NewServer(signaling.WithTokenVerifier(verifier))
+	invites := invite.NewMemoryStore()
+
+	e := echo.New()
+	e.POST("/rooms/:slug/revoke-all", func(c echo.Context) error {
+		return roomRevokeAllHandler(c, verifier, invites, signalingServer)
+	})
+
+	// Everything happens within one second, which iat cannot tell apart
+	now := time.Now().Truncate(time.Second)
+	tokenClock = func() time.Time { return now }
+	t.Cleanup(func() { tokenClock = time.Now })
+
+	hostToken, err := verifier.generateJWT("room-123", "")
+	assert.NoError(t, err)
+	guestToken, _, err := verifier.generateGuestJWT("room-123", "", "", "")
+	assert.NoError(t, err)
+	otherRoomToken, _, err := verifier.generateGuestJWT("room-456", "", "", "")
+	assert.NoError(t, err)
+	roomInvite := &invite.Invite{Code: "room-code", Slug: "room-123", ExpiresAt: now.Add(time.Hour)}
+	otherRoomInvite := &invite.Invite{Code: "other-code", Slug: "room-456", ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, invites.Create(roomInvite))
+	assert.NoError(t, invites.Create(otherRoomInvite))
+
+	// Guests cannot revoke the room
+	req := httptest.NewRequest(http.MethodPost, "/rooms/room-123/revoke-all", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+guestToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/rooms/room-123/revoke-all", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	now = now.Add(500 * time.Millisecond)
+	req = httptest.NewRequest(http.MethodPost, "/rooms/room-123/revoke-all", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+hostToken)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, err = verifier.VerifyToken(guestToken)
+	assert.ErrorIs(t, err, signaling.ErrTokenRevoked)
+	_, err = verifier.VerifyToken(hostToken)
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(otherRoomToken)
+	assert.NoError(t, err)
+
+	var response RevokeResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Invites)
+	_, err = invites.Redeem(roomInvite.Code)
+	assert.ErrorIs(t, err, invite.ErrRevoked)
+	_, err = invites.Redeem(otherRoomInvite.Code)
+	assert.NoError(t, err)
+
+	// Tokens issued after the revocation work, even within the same second
+	now = now.Add(time.Millisecond)
+	newGuestToken, _, err := verifier.generateGuestJWT("room-123", "", "", "")
+	assert.NoError(t, err)
+	_, err = verifier.VerifyToken(newGuestToken)
+	assert.NoError(t, err)
+}
+
+func TestIPExtractorTrustsOnlyConfiguredProxies(t *testing.T) {
+	request := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.9")
+		return req
+	}
+
+	direct, err := newIPExtractor("")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", direct(request("10.0.0.2:5000")), "headers are ignored without proxies")
+
+	extract, err := newIPExtractor("10.0.0.2, 198.51.100.0/24")
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", extract(request("10.0.0.2:5000")))
+	assert.Equal(t, "10.0.0.3", extract(request("10.0.0.3:5000")), "other private addresses are not proxies")
+
+	_, err = newIPExtractor("10.0.0.2, not-an-address")
+	assert.Error(t, err)
+}
+
+func TestSafetyNumberEndpoint(t *testing.T) {
+	signalingServer := signaling.NewServer()
+	e := echo.New()
+	e.GET("/rooms/:slug/safety-number", func(c echo.Context) error {
+		return safetyNumberHandler(c, signalingServer)
+	})
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/rooms/room-123/safety-number?a=host1", http.StatusBadRequest},
+		{"/rooms/room-123/safety-number?a=host1&b=host1", http.StatusBadRequest},
+		{"/rooms/bad@slug/safety-number?a=host1&b=guest1", http.StatusBadRequest},
+		{"/rooms/room-123/safety-number?a=host1&b=guest1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, tt.code, rec.Code, tt.path)
+	}
+}
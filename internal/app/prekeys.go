@@ -0,0 +1,205 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/middleware"
+	"github.com/Kaamos-Comms/server/internal/prekey"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+)
+
+// A requester may fetch prekeyFetchBurst bundles of a DID in a row, then
+// one every prekeyFetchInterval.
+const (
+	prekeyFetchBurst    = 3
+	prekeyFetchInterval = 10 * time.Minute
+)
+
+type UploadPreKeysRequest struct {
+	SignedPreKey   *prekey.SignedPreKey   `json:"signed_prekey"`
+	OneTimePreKeys []prekey.OneTimePreKey `json:"one_time_prekeys"`
+}
+
+type PreKeyCountResponse struct {
+	Identity  string `json:"identity"`
+	Remaining int    `json:"remaining"`
+}
+
+// uploadPreKeysHandler stores the prekeys of the DID behind the session
+// token. The signed prekey must be signed by the DID's key.
+func uploadPreKeysHandler(c echo.Context, verifier roomTokenVerifier, prekeys prekey.Store) error {
+	identity, err := sessionDID(c, verifier)
+	if err != nil || identity == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "a DID session token is required",
+		})
+	}
+
+	var req UploadPreKeysRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid prekey upload",
+		})
+	}
+	if req.SignedPreKey == nil && len(req.OneTimePreKeys) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "signed_prekey or one_time_prekeys is required",
+		})
+	}
+
+	for _, key := range req.OneTimePreKeys {
+		if err := prekey.ValidatePreKey(key.PublicKey); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	if req.SignedPreKey != nil {
+		identityKey, err := signaling.ParseDIDKey(identity)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "session DID has no Ed25519 key",
+			})
+		}
+		if err := prekey.VerifySignedPreKey(identity, identityKey, *req.SignedPreKey); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		req.SignedPreKey.CreatedAt = time.Time{}
+		encodedKey := base64.StdEncoding.EncodeToString(identityKey)
+		if err := prekeys.SetSignedPreKey(identity, encodedKey, *req.SignedPreKey); err != nil {
+			log.Printf("Failed to store signed prekey for %s: %v", identity, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to store prekeys",
+			})
+		}
+	}
+
+	if len(req.OneTimePreKeys) > 0 {
+		if _, err := prekeys.AddOneTimePreKeys(identity, req.OneTimePreKeys); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, prekey.ErrNotFound):
+				status = http.StatusConflict
+			case errors.Is(err, prekey.ErrTooManyKeys), errors.Is(err, prekey.ErrDuplicateKey):
+				status = http.StatusBadRequest
+			default:
+				log.Printf("Failed to store one-time prekeys for %s: %v", identity, err)
+			}
+			return c.JSON(status, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return preKeyCount(c, identity, prekeys)
+}
+
+// preKeyCountHandler tells the session's DID how many one-time prekeys remain.
+func preKeyCountHandler(c echo.Context, verifier roomTokenVerifier, prekeys prekey.Store) error {
+	identity, err := sessionDID(c, verifier)
+	if err != nil || identity == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "a DID session token is required",
+		})
+	}
+	return preKeyCount(c, identity, prekeys)
+}
+
+func preKeyCount(c echo.Context, identity string, prekeys prekey.Store) error {
+	remaining, err := prekeys.Count(identity)
+	if err != nil {
+		if errors.Is(err, prekey.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "no prekeys uploaded",
+			})
+		}
+		log.Printf("Failed to count prekeys for %s: %v", identity, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to count prekeys",
+		})
+	}
+
+	return c.JSON(http.StatusOK, PreKeyCountResponse{
+		Identity:  identity,
+		Remaining: remaining,
+	})
+}
+
+// deletePreKeysHandler removes every prekey of the session's DID.
+func deletePreKeysHandler(c echo.Context, verifier roomTokenVerifier, prekeys prekey.Store) error {
+	identity, err := sessionDID(c, verifier)
+	if err != nil || identity == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "a DID session token is required",
+		})
+	}
+
+	if err := prekeys.Delete(identity); err != nil && !errors.Is(err, prekey.ErrNotFound) {
+		log.Printf("Failed to delete prekeys for %s: %v", identity, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to delete prekeys",
+		})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// fetchPreKeyBundleHandler hands out a prekey bundle of the DID in the path,
+// consuming one of its one-time prekeys. Callers must hold a DID session
+// token; room tokens do not count, since anyone can create a room. fetches
+// limits each requester to a few bundles of the same DID, so draining a
+// supply takes as many DIDs as it holds keys. The owner is told over the
+// signaling connection when the supply runs low.
+func fetchPreKeyBundleHandler(c echo.Context, verifier roomTokenVerifier, prekeys prekey.Store, fetches *middleware.IPRateLimiter, signalingServer *signaling.Server) error {
+	requester, err := sessionDID(c, verifier)
+	if err != nil || requester == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "a DID session token is required",
+		})
+	}
+
+	identity, err := didParam(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid DID encoding"})
+	}
+
+	if !fetches.Allow(requester + " " + identity) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{
+			"error": "too many prekey bundles fetched for this DID",
+		})
+	}
+
+	bundle, remaining, err := prekeys.FetchBundle(identity)
+	if err != nil {
+		if errors.Is(err, prekey.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "no prekey bundle for this DID",
+			})
+		}
+		log.Printf("Failed to fetch prekey bundle for %s: %v", identity, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch prekey bundle",
+		})
+	}
+
+	if bundle.OneTimePreKey != nil && remaining < prekey.LowWatermark {
+		signalingServer.NotifyDID(identity, &signaling.Message{
+			Type: signaling.MessageTypePreKeysLow,
+			Data: signaling.PreKeysLowData{
+				Remaining: remaining,
+				Threshold: prekey.LowWatermark,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, bundle)
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Kaamos-Comms/server/internal/middleware"
+	"github.com/Kaamos-Comms/server/internal/prekey"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func setupPreKeyServer() (*echo.Echo, roomTokenVerifier) {
+	verifier := newTestVerifier()
+	prekeys := prekey.NewMemoryStore()
+	fetches := middleware.NewIPRateLimiter(rate.Every(prekeyFetchInterval), prekeyFetchBurst)
+	signalingServer := signaling.NewServer()
+
+	e := echo.New()
+	e.PUT("/prekeys", func(c echo.Context) error {
+		return uploadPreKeysHandler(c, verifier, prekeys)
+	})
+	e.GET("/prekeys", func(c echo.Context) error {
+		return preKeyCountHandler(c, verifier, prekeys)
+	})
+	e.DELETE("/prekeys", func(c echo.Context) error {
+		return deletePreKeysHandler(c, verifier, prekeys)
+	})
+	e.GET("/prekeys/:did", func(c echo.Context) error {
+		return fetchPreKeyBundleHandler(c, verifier, prekeys, fetches, signalingServer)
+	})
+	return e, verifier
+}
+
+type preKeyOwner struct {
+	did     string
+	private ed25519.PrivateKey
+	session string
+}
+
+func newPreKeyOwner(t *testing.T) *preKeyOwner {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	did := signaling.DIDKeyFromEd25519(pub)
+
+	session, _, err := newTestVerifier().generateSessionJWT(did)
+	require.NoError(t, err)
+	return &preKeyOwner{did: did, private: priv, session: session}
+}
+
+func (o *preKeyOwner) signedPreKey(t *testing.T, id uint32) prekey.SignedPreKey {
+	key := prekey.SignedPreKey{ID: id, PublicKey: testX25519Key(t)}
+	key.Signature = base64.StdEncoding.EncodeToString(
+		ed25519.Sign(o.private, prekey.SignedPreKeyPayload(o.did, key.ID, key.PublicKey)))
+	return key
+}
+
+func testX25519Key(t *testing.T) string {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func uploadPreKeys(t *testing.T, e *echo.Echo, token string, req UploadPreKeysRequest) (int, PreKeyCountResponse) {
+	body, _ := json.Marshal(req)
+	rec := doRequest(e, http.MethodPut, "/prekeys", token, string(body))
+
+	var resp PreKeyCountResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+func TestPreKeyUploadAndFetch(t *testing.T) {
+	e, _ := setupPreKeyServer()
+	owner := newPreKeyOwner(t)
+
+	signed := owner.signedPreKey(t, 1)
+	status, resp := uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{
+		SignedPreKey: &signed,
+		OneTimePreKeys: []prekey.OneTimePreKey{
+			{ID: 1, PublicKey: testX25519Key(t)},
+			{ID: 2, PublicKey: testX25519Key(t)},
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, owner.did, resp.Identity)
+	assert.Equal(t, 2, resp.Remaining)
+
+	peer := newPreKeyOwner(t)
+	rec := doRequest(e, http.MethodGet, "/prekeys/"+owner.did, peer.session, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var bundle prekey.Bundle
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bundle))
+	assert.Equal(t, owner.did, bundle.Identity)
+	assert.Equal(t, signed.PublicKey, bundle.SignedPreKey.PublicKey)
+	require.NotNil(t, bundle.OneTimePreKey)
+	assert.Equal(t, uint32(1), bundle.OneTimePreKey.ID)
+
+	// The peer can check the signed prekey against the identity key
+	identityKey, err := signaling.ParseDIDKey(bundle.Identity)
+	require.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(identityKey), bundle.IdentityKey)
+	assert.NoError(t, prekey.VerifySignedPreKey(bundle.Identity, identityKey, bundle.SignedPreKey))
+
+	rec = doRequest(e, http.MethodGet, "/prekeys", owner.session, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Remaining)
+}
+
+func TestPreKeyUploadRejectsBadSignature(t *testing.T) {
+	e, _ := setupPreKeyServer()
+	owner := newPreKeyOwner(t)
+	other := newPreKeyOwner(t)
+
+	// Signed by a key that is not the session's DID
+	signed := other.signedPreKey(t, 1)
+	status, _ := uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{SignedPreKey: &signed})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// One-time prekeys need a signed prekey first
+	status, _ = uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{
+		OneTimePreKeys: []prekey.OneTimePreKey{{ID: 1, PublicKey: testX25519Key(t)}},
+	})
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, _ = uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{
+		OneTimePreKeys: []prekey.OneTimePreKey{{ID: 1, PublicKey: "not-a-key"}},
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestPreKeyEndpointsRequireAuth(t *testing.T) {
+	e, _ := setupPreKeyServer()
+	owner := newPreKeyOwner(t)
+
+	hostToken, err := newTestVerifier().generateJWT("room-123", "")
+	require.NoError(t, err)
+
+	// Room tokens are not DID sessions
+	signed := owner.signedPreKey(t, 1)
+	status, _ := uploadPreKeys(t, e, hostToken, UploadPreKeysRequest{SignedPreKey: &signed})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{SignedPreKey: &signed})
+	require.Equal(t, http.StatusOK, status)
+
+	rec := doRequest(e, http.MethodGet, "/prekeys/"+owner.did, "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Nor can they fetch bundles, since anyone can create a room
+	rec = doRequest(e, http.MethodGet, "/prekeys/"+owner.did, hostToken, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	peer := newPreKeyOwner(t)
+	rec = doRequest(e, http.MethodGet, "/prekeys/"+owner.did, peer.session, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(e, http.MethodDelete, "/prekeys", owner.session, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(e, http.MethodGet, "/prekeys/"+owner.did, peer.session, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestPreKeyFetchesAreLimitedPerRequester(t *testing.T) {
+	e, _ := setupPreKeyServer()
+	owner := newPreKeyOwner(t)
+
+	signed := owner.signedPreKey(t, 1)
+	status, _ := uploadPreKeys(t, e, owner.session, UploadPreKeysRequest{SignedPreKey: &signed})
+	require.Equal(t, http.StatusOK, status)
+
+	peer := newPreKeyOwner(t)
+	for i := 0; i < prekeyFetchBurst; i++ {
+		rec := doRequest(e, http.MethodGet, "/prekeys/"+owner.did, peer.session, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := doRequest(e, http.MethodGet, "/prekeys/"+owner.did, peer.session, "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	rec = doRequest(e, http.MethodGet, "/prekeys/"+owner.did, newPreKeyOwner(t).session, "")
+	assert.Equal(t, http.StatusOK, rec.Code, "other requesters have their own limit")
+	rec = doRequest(e, http.MethodGet, "/prekeys/"+peer.did, peer.session, "")
+	assert.NotEqual(t, http.StatusTooManyRequests, rec.Code, "and so do other targets")
+}
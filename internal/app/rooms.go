@@ -0,0 +1,295 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultRoomValidity = tokenValidity
+	maxRoomValidity     = 7 * 24 * time.Hour
+)
+
+type CreateRoomRequest struct {
+	Settings  *signaling.RoomSettingsUpdate `json:"settings"`   // applied to the default settings
+	ExpiresIn int                           `json:"expires_in"` // seconds, defaults to 24h
+}
+
+type UpdateRoomRequest struct {
+	Settings  *signaling.RoomSettingsUpdate `json:"settings"`   // fields left out keep their value
+	ExpiresIn int                           `json:"expires_in"` // seconds from now, 0 keeps the expiry
+}
+
+type RoomsResponse struct {
+	Rooms []*registry.Room `json:"rooms"`
+}
+
+// roomRegistry lets the signaling server look up registered rooms.
+type roomRegistry struct {
+	rooms registry.Store
+}
+
+func (r roomRegistry) RoomSettings(slug string) (signaling.RoomSettings, error) {
+	room, err := r.rooms.Get(slug)
+	if errors.Is(err, registry.ErrNotFound) {
+		return signaling.RoomSettings{}, signaling.ErrRoomNotRegistered
+	}
+	if err != nil {
+		return signaling.RoomSettings{}, err
+	}
+	return room.Settings, nil
+}
+
+func (r roomRegistry) SaveRoomSettings(slug string, settings signaling.RoomSettings) error {
+	room, err := r.rooms.Get(slug)
+	if err != nil {
+		return err
+	}
+	room.Settings = settings
+	return r.rooms.Update(room)
+}
+
+func (r roomRegistry) RoomBans(slug string) ([]signaling.Ban, error) {
+	room, err := r.rooms.Get(slug)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, signaling.ErrRoomNotRegistered
+	}
+	if err != nil {
+		return nil, err
+	}
+	return room.Bans, nil
+}
+
+func (r roomRegistry) SaveRoomBans(slug string, bans []signaling.Ban) error {
+	return r.rooms.UpdateBans(slug, bans)
+}
+
+// roomOwner returns who owns the rooms created with the host token: its DID,
+// or for anonymous hosts the token itself.
+func roomOwner(claims *RoomClaims) string {
+	if claims.DID != "" {
+		return claims.DID
+	}
+	return claims.ID
+}
+
+// ownedByDID reports whether the room belongs to a DID rather than to the
+// host token it was created with.
+func ownedByDID(room *registry.Room) bool {
+	return strings.HasPrefix(room.Owner, "did:")
+}
+
+// requestOwner returns the room owner the request acts for, taken from a host
+// token or a DID session token.
+func requestOwner(c echo.Context, verifier roomTokenVerifier) (string, int, string) {
+	token := bearerToken(c)
+	if claims, err := verifier.verify(token); err == nil {
+		if claims.Role != string(signaling.RoleHost) {
+			return "", http.StatusForbidden, "only room owners can do this"
+		}
+		return roomOwner(claims), 0, ""
+	}
+	if did, err := verifier.verifySession(token); err == nil {
+		return did, 0, ""
+	}
+	return "", http.StatusUnauthorized, "valid host or session token required"
+}
+
+// authorizeOwner returns the registered room if the request acts for its
+// owner.
+func authorizeOwner(c echo.Context, verifier roomTokenVerifier, rooms registry.Store, slug string) (*registry.Room, int, string) {
+	owner, status, message := requestOwner(c, verifier)
+	if owner == "" {
+		return nil, status, message
+	}
+
+	room, err := rooms.Get(slug)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, http.StatusNotFound, "room not found"
+	}
+	if err != nil {
+		log.Printf("Failed to look up room %s: %v", slug, err)
+		return nil, http.StatusInternalServerError, "failed to look up room"
+	}
+	if room.Owner != owner {
+		return nil, http.StatusForbidden, "only the room owner can do this"
+	}
+	return room, 0, ""
+}
+
+// listRoomsHandler returns the rooms owned by the caller that have not
+// expired, oldest first.
+func listRoomsHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store) error {
+	owner, status, message := requestOwner(c, verifier)
+	if owner == "" {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	owned, err := rooms.List(owner)
+	if err != nil {
+		log.Printf("Failed to list rooms: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to list rooms",
+		})
+	}
+
+	return c.JSON(http.StatusOK, RoomsResponse{Rooms: owned})
+}
+
+func getRoomHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	room, status, message := authorizeOwner(c, verifier, rooms, slug)
+	if room == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, room)
+}
+
+// updateRoomHandler changes the settings or lifetime of a room. New settings
+// also apply to the live room and are announced to everyone in it.
+func updateRoomHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	room, status, message := authorizeOwner(c, verifier, rooms, slug)
+	if room == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	var req UpdateRoomRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room request",
+		})
+	}
+
+	if req.Settings != nil {
+		room.Settings = req.Settings.Apply(room.Settings)
+		if err := room.Settings.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+	if req.ExpiresIn != 0 {
+		validity := time.Duration(req.ExpiresIn) * time.Second
+		if validity <= 0 || validity > maxRoomValidity {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "expires_in must be between 1 second and 7 days",
+			})
+		}
+		expiresAt := time.Now().Add(validity)
+		if !ownedByDID(room) && expiresAt.After(room.CreatedAt.Add(tokenValidity)) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "anonymous rooms cannot outlive their host token",
+			})
+		}
+		room.ExpiresAt = expiresAt
+	}
+
+	if err := rooms.Update(room); err != nil {
+		if errors.Is(err, registry.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "room not found",
+			})
+		}
+		log.Printf("Failed to update room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to update room",
+		})
+	}
+
+	if req.Settings != nil {
+		signalingServer.ApplyRoomSettings(slug, room.Settings)
+	}
+	return c.JSON(http.StatusOK, room)
+}
+
+// deleteRoomHandler unregisters a room and disconnects everyone in it. Its
+// tokens can no longer be used to join.
+func deleteRoomHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store, signalingServer *signaling.Server) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	if room, status, message := authorizeOwner(c, verifier, rooms, slug); room == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+
+	if err := rooms.Delete(slug); err != nil && !errors.Is(err, registry.ErrNotFound) {
+		log.Printf("Failed to delete room %s: %v", slug, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to delete room",
+		})
+	}
+
+	signalingServer.CloseRoom(slug)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// roomHostTokenHandler issues a new host token for a room owned by a DID, so
+// that rooms can outlive the host token they were created with.
+func roomHostTokenHandler(c echo.Context, verifier roomTokenVerifier, rooms registry.Store) error {
+	slug := sanitizeSlug(c.Param("slug"))
+	if slug == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid room slug",
+		})
+	}
+
+	room, status, message := authorizeOwner(c, verifier, rooms, slug)
+	if room == nil {
+		return c.JSON(status, map[string]string{
+			"error": message,
+		})
+	}
+	if !ownedByDID(room) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "anonymous rooms cannot get new host tokens",
+		})
+	}
+
+	token, err := verifier.generateJWT(slug, room.Owner)
+	if err != nil {
+		log.Printf("Failed to generate JWT: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to generate access token",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, RoomResponse{
+		Slug:      slug,
+		JWT:       token,
+		Settings:  room.Settings,
+		ExpiresAt: room.ExpiresAt,
+	})
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/registry"
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRoomServer() (*echo.Echo, registry.Store) {
+	verifier := newTestVerifier()
+	rooms := registry.NewMemoryStore()
+	signalingServer := signaling.NewServer(signaling.WithRoomRegistry(roomRegistry{rooms: rooms}))
+
+	e := echo.New()
+	e.POST("/rooms/anonymous", func(c echo.Context) error {
+		return roomsAnonymousHandler(c, verifier, rooms)
+	})
+	e.GET("/rooms", func(c echo.Context) error {
+		return listRoomsHandler(c, verifier, rooms)
+	})
+	e.GET("/rooms/:slug", func(c echo.Context) error {
+		return getRoomHandler(c, verifier, rooms)
+	})
+	e.PATCH("/rooms/:slug", func(c echo.Context) error {
+		return updateRoomHandler(c, verifier, rooms, signalingServer)
+	})
+	e.DELETE("/rooms/:slug", func(c echo.Context) error {
+		return deleteRoomHandler(c, verifier, rooms, signalingServer)
+	})
+	e.POST("/rooms/:slug/host-token", func(c echo.Context) error {
+		return roomHostTokenHandler(c, verifier, rooms)
+	})
+	return e, rooms
+}
+
+func createRoom(t *testing.T, e *echo.Echo, sessionToken, body string) RoomResponse {
+	rec := doRequest(e, http.MethodPost, "/rooms/anonymous", sessionToken, body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var room RoomResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &room))
+	return room
+}
+
+func testSession(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	token, _, err := newTestVerifier().generateSessionJWT(signaling.DIDKeyFromEd25519(pub))
+	require.NoError(t, err)
+	return token
+}
+
+func TestCreateRoomRegistersIt(t *testing.T) {
+	e, rooms := setupRoomServer()
+
+	created := createRoom(t, e, "", `{"settings": {"max_participants": 4, "admission": "open"}, "expires_in": 3600}`)
+	assert.Equal(t, 4, created.Settings.MaxParticipants)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), created.ExpiresAt, time.Minute)
+
+	room, err := rooms.Get(created.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, signaling.AdmissionOpen, room.Settings.Admission)
+	assert.True(t, room.Settings.GuestBroadcast, "settings left out keep their default")
+
+	claims, err := newTestVerifier().verify(created.JWT)
+	require.NoError(t, err)
+	assert.Equal(t, claims.ID, room.Owner, "anonymous hosts own rooms through their token")
+
+	defaults := createRoom(t, e, "", "")
+	assert.Equal(t, signaling.DefaultRoomSettings(), defaults.Settings)
+	assert.WithinDuration(t, time.Now().Add(defaultRoomValidity), defaults.ExpiresAt, time.Minute)
+
+	for _, body := range []string{
+		`{"settings": {"admission": "whenever"}}`,
+		`{"settings": {"max_participants": -1}}`,
+		`{"expires_in": -5}`,
+		`{"expires_in": 99999999}`,
+		`{"expires_in": 172800}`, // anonymous rooms expire with their host token
+	} {
+		rec := doRequest(e, http.MethodPost, "/rooms/anonymous", "", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestListRooms(t *testing.T) {
+	e, _ := setupRoomServer()
+	session := testSession(t)
+
+	first := createRoom(t, e, session, "")
+	second := createRoom(t, e, session, "")
+	anonymous := createRoom(t, e, "", "")
+
+	list := func(token string) []string {
+		rec := doRequest(e, http.MethodGet, "/rooms", token, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp RoomsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		var slugs []string
+		for _, room := range resp.Rooms {
+			slugs = append(slugs, room.Slug)
+		}
+		return slugs
+	}
+
+	assert.ElementsMatch(t, []string{first.Slug, second.Slug}, list(session))
+	assert.ElementsMatch(t, []string{first.Slug, second.Slug}, list(first.JWT), "host tokens act for their DID")
+	assert.Equal(t, []string{anonymous.Slug}, list(anonymous.JWT))
+
+	rec := doRequest(e, http.MethodGet, "/rooms", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	guestToken, _, err := newTestVerifier().generateGuestJWT(anonymous.Slug, "", "", "")
+	require.NoError(t, err)
+	rec = doRequest(e, http.MethodGet, "/rooms", guestToken, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestRoomRequiresOwner(t *testing.T) {
+	e, _ := setupRoomServer()
+	owned := createRoom(t, e, testSession(t), "")
+	other := createRoom(t, e, "", "")
+
+	rec := doRequest(e, http.MethodGet, "/rooms/"+owned.Slug, owned.JWT, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		rec := doRequest(e, method, "/rooms/"+owned.Slug, other.JWT, "{}")
+		assert.Equal(t, http.StatusForbidden, rec.Code, method)
+		rec = doRequest(e, method, "/rooms/"+owned.Slug, testSession(t), "{}")
+		assert.Equal(t, http.StatusForbidden, rec.Code, method)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/rooms/missing-room", owned.JWT, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestUpdateRoom(t *testing.T) {
+	e, rooms := setupRoomServer()
+	created := createRoom(t, e, "", `{"settings": {"max_participants": 4}}`)
+
+	rec := doRequest(e, http.MethodPatch, "/rooms/"+created.Slug, created.JWT, `{"settings": {"locked": true}, "expires_in": 7200}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	room, err := rooms.Get(created.Slug)
+	require.NoError(t, err)
+	assert.True(t, room.Settings.Locked)
+	assert.Equal(t, 4, room.Settings.MaxParticipants, "fields left out keep their value")
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), room.ExpiresAt, time.Minute)
+
+	rec = doRequest(e, http.MethodPatch, "/rooms/"+created.Slug, created.JWT, `{"settings": {"allowed_roles": ["emperor"]}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	room, err = rooms.Get(created.Slug)
+	require.NoError(t, err)
+	assert.Empty(t, room.Settings.AllowedRoles)
+}
+
+func TestDeleteRoom(t *testing.T) {
+	e, rooms := setupRoomServer()
+	created := createRoom(t, e, "", "")
+
+	rec := doRequest(e, http.MethodDelete, "/rooms/"+created.Slug, created.JWT, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, err := rooms.Get(created.Slug)
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+	_, err = roomRegistry{rooms: rooms}.RoomSettings(created.Slug)
+	assert.ErrorIs(t, err, signaling.ErrRoomNotRegistered)
+
+	rec = doRequest(e, http.MethodDelete, "/rooms/"+created.Slug, created.JWT, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRoomLifetimeIsBoundToHostTokens(t *testing.T) {
+	e, _ := setupRoomServer()
+	session := testSession(t)
+
+	anonymous := createRoom(t, e, "", "")
+	rec := doRequest(e, http.MethodPatch, "/rooms/"+anonymous.Slug, anonymous.JWT, `{"expires_in": 172800}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = doRequest(e, http.MethodPost, "/rooms/"+anonymous.Slug+"/host-token", anonymous.JWT, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	owned := createRoom(t, e, session, `{"expires_in": 172800}`)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), owned.ExpiresAt, time.Minute)
+
+	rec = doRequest(e, http.MethodPost, "/rooms/"+owned.Slug+"/host-token", session, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var reissued RoomResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reissued))
+
+	claims, err := newTestVerifier().verify(reissued.JWT)
+	require.NoError(t, err)
+	assert.Equal(t, owned.Slug, claims.Slug)
+	assert.Equal(t, string(signaling.RoleHost), claims.Role)
+	assert.NotEmpty(t, claims.DID)
+
+	rec = doRequest(e, http.MethodGet, "/rooms/"+owned.Slug, reissued.JWT, "")
+	assert.Equal(t, http.StatusOK, rec.Code, "the new token acts for the owner")
+	rec = doRequest(e, http.MethodPost, "/rooms/"+owned.Slug+"/host-token", testSession(t), "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Kaamos-Comms/server/internal/transparency"
+	"github.com/labstack/echo/v4"
+)
+
+type TransparencyKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+type IdentityEntriesResponse struct {
+	Identity string   `json:"identity"`
+	Indexes  []uint64 `json:"indexes"`
+}
+
+// treeHeadHandler returns a freshly signed head of the key log.
+func treeHeadHandler(c echo.Context, keyLog *transparency.Log) error {
+	return c.JSON(http.StatusOK, keyLog.SignedTreeHead())
+}
+
+// treeHeadKeyHandler returns the key that signs tree heads.
+func treeHeadKeyHandler(c echo.Context, keyLog *transparency.Log) error {
+	return c.JSON(http.StatusOK, TransparencyKeyResponse{
+		Algorithm: "ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(keyLog.PublicKey()),
+	})
+}
+
+// inclusionProofHandler proves ?index= against the tree of ?tree_size=,
+// which defaults to the current size.
+func inclusionProofHandler(c echo.Context, keyLog *transparency.Log) error {
+	index, err := strconv.ParseUint(c.QueryParam("index"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "index is required",
+		})
+	}
+	treeSize, ok := uintQueryParam(c, "tree_size", keyLog.Size())
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid tree_size",
+		})
+	}
+
+	proof, err := keyLog.InclusionProof(index, treeSize)
+	if err != nil {
+		return transparencyError(c, err)
+	}
+	return c.JSON(http.StatusOK, proof)
+}
+
+// consistencyProofHandler proves that the tree of ?second= (default: the
+// current size) extends the tree of ?first=.
+func consistencyProofHandler(c echo.Context, keyLog *transparency.Log) error {
+	first, err := strconv.ParseUint(c.QueryParam("first"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "first is required",
+		})
+	}
+	second, ok := uintQueryParam(c, "second", keyLog.Size())
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid second",
+		})
+	}
+
+	proof, err := keyLog.ConsistencyProof(first, second)
+	if err != nil {
+		return transparencyError(c, err)
+	}
+	return c.JSON(http.StatusOK, proof)
+}
+
+// logEntryHandler returns a single entry of the key log.
+func logEntryHandler(c echo.Context, keyLog *transparency.Log) error {
+	index, err := strconv.ParseUint(c.Param("index"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid index",
+		})
+	}
+
+	entry, err := keyLog.Entry(index)
+	if err != nil {
+		return transparencyError(c, err)
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// identityEntriesHandler lists the log entries of ?identity=, so that the
+// owner of a DID can check which keys were published in its name.
+func identityEntriesHandler(c echo.Context, keyLog *transparency.Log) error {
+	identity := c.QueryParam("identity")
+	if identity == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "identity is required",
+		})
+	}
+
+	return c.JSON(http.StatusOK, IdentityEntriesResponse{
+		Identity: identity,
+		Indexes:  keyLog.IdentityIndexes(identity),
+	})
+}
+
+func uintQueryParam(c echo.Context, name string, fallback uint64) (uint64, bool) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return fallback, true
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	return parsed, err == nil
+}
+
+func transparencyError(c echo.Context, err error) error {
+	if errors.Is(err, transparency.ErrIndexOutOfRange) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "index or tree size out of range",
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "failed to read the key log",
+	})
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Kaamos-Comms/server/internal/transparency"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTransparencyServer(t *testing.T) (*echo.Echo, *transparency.Log) {
+	keyLog, err := transparency.NewLog(nil)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.GET("/transparency/sth", func(c echo.Context) error {
+		return treeHeadHandler(c, keyLog)
+	})
+	e.GET("/transparency/key", func(c echo.Context) error {
+		return treeHeadKeyHandler(c, keyLog)
+	})
+	e.GET("/transparency/proofs/inclusion", func(c echo.Context) error {
+		return inclusionProofHandler(c, keyLog)
+	})
+	e.GET("/transparency/proofs/consistency", func(c echo.Context) error {
+		return consistencyProofHandler(c, keyLog)
+	})
+	e.GET("/transparency/entries/:index", func(c echo.Context) error {
+		return logEntryHandler(c, keyLog)
+	})
+	e.GET("/transparency/identities", func(c echo.Context) error {
+		return identityEntriesHandler(c, keyLog)
+	})
+	return e, keyLog
+}
+
+func appendEntries(t *testing.T, keyLog *transparency.Log, identities ...string) {
+	for i, identity := range identities {
+		_, err := keyLog.Append(transparency.Entry{
+			Identity:      identity,
+			Room:          "room-123",
+			ParticipantID: fmt.Sprintf("p%d", i),
+			Keys:          []transparency.Key{{Algorithm: "ed25519", PublicKey: fmt.Sprintf("key-%d", i)}},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func getJSON(t *testing.T, e *echo.Echo, path string, v interface{}) int {
+	rec := doRequest(e, http.MethodGet, path, "", "")
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+	return rec.Code
+}
+
+func TestTransparencyTreeHeadAndInclusion(t *testing.T) {
+	e, keyLog := setupTransparencyServer(t)
+	appendEntries(t, keyLog, "did:key:alice", "did:key:bob", "did:key:alice")
+
+	var key TransparencyKeyResponse
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/key", &key))
+	publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	require.NoError(t, err)
+
+	var sth transparency.SignedTreeHead
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/sth", &sth))
+	assert.Equal(t, uint64(3), sth.TreeSize)
+	assert.NoError(t, transparency.VerifyTreeHead(publicKey, sth))
+
+	var proof transparency.InclusionProof
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/proofs/inclusion?index=1&tree_size=3", &proof))
+	assert.NoError(t, proof.Verify(sth.RootHash))
+
+	var entry transparency.Entry
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/entries/1", &entry))
+	assert.Equal(t, "did:key:bob", entry.Identity)
+
+	var entries IdentityEntriesResponse
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/identities?identity=did:key:alice", &entries))
+	assert.Equal(t, []uint64{0, 2}, entries.Indexes)
+}
+
+func TestTransparencyConsistency(t *testing.T) {
+	e, keyLog := setupTransparencyServer(t)
+	appendEntries(t, keyLog, "did:key:alice", "did:key:bob", "did:key:carol")
+
+	var old transparency.SignedTreeHead
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/sth", &old))
+
+	appendEntries(t, keyLog, "did:key:dave", "did:key:erin")
+	var current transparency.SignedTreeHead
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/sth", &current))
+
+	var proof transparency.ConsistencyProof
+	require.Equal(t, http.StatusOK, getJSON(t, e, "/transparency/proofs/consistency?first=3", &proof))
+	assert.Equal(t, uint64(5), proof.Second)
+
+	path, err := transparency.DecodeHashes(proof.Path)
+	require.NoError(t, err)
+	oldRoot, _ := base64.StdEncoding.DecodeString(old.RootHash)
+	newRoot, _ := base64.StdEncoding.DecodeString(current.RootHash)
+	assert.NoError(t, transparency.VerifyConsistency(3, 5, path, oldRoot, newRoot))
+}
+
+func TestTransparencyBadRequests(t *testing.T) {
+	e, keyLog := setupTransparencyServer(t)
+	appendEntries(t, keyLog, "did:key:alice")
+
+	for _, path := range []string{
+		"/transparency/proofs/inclusion",
+		"/transparency/proofs/inclusion?index=1",
+		"/transparency/proofs/inclusion?index=0&tree_size=x",
+		"/transparency/proofs/consistency?first=2",
+		"/transparency/entries/5",
+		"/transparency/entries/x",
+		"/transparency/identities",
+	} {
+		rec := doRequest(e, http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, path)
+	}
+}
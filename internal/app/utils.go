@@ -2,24 +2,56 @@ package app
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"os"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/Kaamos-Comms/server/internal/keyring"
+	"github.com/Kaamos-Comms/server/internal/revocation"
+	"github.com/Kaamos-Comms/server/internal/signaling"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
 )
 
-type GuestClaims struct {
+// RoomClaims are the claims carried by host and guest room tokens.
+type RoomClaims struct {
 	Slug string `json:"slug"`
 	Role string `json:"role"`
+	Name string `json:"name,omitempty"` // display name pre-assigned by an invite
+	DID  string `json:"did,omitempty"`  // identity proven via did:key login
+	// IssuedAtMillis is iat to the millisecond, so that revoking a room tells
+	// apart tokens issued within the same second
+	IssuedAtMillis int64 `json:"iat_ms,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// issuedAt returns when the token was issued, as precisely as it says.
+func (c *RoomClaims) issuedAt() time.Time {
+	if c.IssuedAtMillis != 0 {
+		return time.UnixMilli(c.IssuedAtMillis)
+	}
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
+
+// tokenClock tells the time room tokens are issued and revoked at.
+var tokenClock = time.Now
+
 type GuestTokenResponse struct {
 	GuestJWT  string `json:"guest_jwt"`
 	ExpiresAt string `json:"expires_at"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name,omitempty"`
 }
 
 func generateSlug(length int) (string, error) {
@@ -34,16 +66,45 @@ func generateSlug(length int) (string, error) {
 	return encoded, nil
 }
 
-func generateJWT(slug string) (string, error) {
-	claims := jwt.MapClaims{
-		"slug": slug,
-		"role": "host",
-		"iat":  time.Now().Unix(),
-		"exp":  time.Now().Add(tokenValidity).Unix(),
+// generateTokenID returns a random jti so tokens can be revoked individually.
+func generateTokenID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// generateJWT issues a host token. When did is set the token is bound to that
+// identity and it becomes the subject.
+func (v roomTokenVerifier) generateJWT(slug, did string) (string, error) {
+	token, _, err := v.generateHostJWT(slug, did)
+	return token, err
+}
+
+// generateHostJWT issues a host token and also returns its claims.
+func (v roomTokenVerifier) generateHostJWT(slug, did string) (string, *RoomClaims, error) {
+	tokenID, err := generateTokenID()
+	if err != nil {
+		return "", nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	now := tokenClock()
+	claims := &RoomClaims{
+		Slug:           slug,
+		Role:           "host",
+		DID:            did,
+		IssuedAtMillis: now.UnixMilli(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   did,
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenValidity)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token, err := v.keys.Sign(claims)
+	return token, claims, err
 }
 
 func sanitizeSlug(slug string) string {
@@ -61,20 +122,33 @@ func sanitizeSlug(slug string) string {
 	return slug
 }
 
-func generateGuestJWT(slug string) (string, time.Time, error) {
-	expiresAt := time.Now().Add(guestTokenValidity)
+// generateGuestJWT issues a guest token for a redeemed invite. Its subject
+// is the guest's DID, or for anonymous guests the invite, so that banning
+// them also bans redeeming the invite again.
+func (v roomTokenVerifier) generateGuestJWT(slug, name, did, inviteCode string) (string, time.Time, error) {
+	now := tokenClock()
+	expiresAt := now.Add(guestTokenValidity)
+
+	tokenID, err := generateTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
 
-	claims := GuestClaims{
-		Slug: slug,
-		Role: "guest",
+	claims := RoomClaims{
+		Slug:           slug,
+		Role:           "guest",
+		Name:           name,
+		DID:            did,
+		IssuedAtMillis: now.UnixMilli(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   guestSubject(did, inviteCode),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	tokenString, err := v.keys.Sign(claims)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -82,9 +156,176 @@ func generateGuestJWT(slug string) (string, time.Time, error) {
 	return tokenString, expiresAt, nil
 }
 
-func getJWTSecret() string {
-	if secret := strings.TrimSpace(os.Getenv("JWT_SECRET")); secret != "" {
-		return secret
+// guestSubject identifies a guest for bans: by DID when they have one, by
+// the invite they redeemed otherwise. The code is hashed since tokens are
+// less guarded than invites.
+func guestSubject(did, inviteCode string) string {
+	if did != "" {
+		return did
+	}
+	sum := sha256.Sum256([]byte(inviteCode))
+	return "invite:" + hex.EncodeToString(sum[:16])
+}
+
+// parseRoomJWT verifies a room token issued by generateJWT or generateGuestJWT.
+func (v roomTokenVerifier) parseRoomJWT(tokenString string) (*RoomClaims, error) {
+	claims := &RoomClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.keys.Keyfunc,
+		jwt.WithValidMethods(v.keys.Methods()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// DID session tokens are signed by the same keys but grant no room access
+	if claims.Slug == "" || claims.Role == "" {
+		return nil, errors.New("not a room token")
+	}
+
+	return claims, nil
+}
+
+// roomTokenVerifier lets the signaling server authenticate /ws handshakes
+// with the room tokens issued by this package.
+type roomTokenVerifier struct {
+	keys        *keyring.Keyring
+	revocations revocation.Store
+}
+
+func (v roomTokenVerifier) VerifyToken(tokenString string) (*signaling.TokenClaims, error) {
+	claims, err := v.verify(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signaling.TokenClaims{
+		ID:        claims.ID,
+		Slug:      claims.Slug,
+		Role:      signaling.ParticipantRole(claims.Role),
+		Subject:   claims.Subject,
+		Name:      claims.Name,
+		DID:       claims.DID,
+		IssuedAt:  claims.issuedAt(),
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// verify parses a room token and checks it against the revocation store.
+func (v roomTokenVerifier) verify(tokenString string) (*RoomClaims, error) {
+	claims, err := v.parseRoomJWT(tokenString)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, fmt.Errorf("%w: %v", signaling.ErrTokenExpired, err)
+		}
+		return nil, err
+	}
+
+	revoked, err := v.isRevoked(claims.ID, claims.Slug, claims.issuedAt())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, signaling.ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+func (v roomTokenVerifier) isRevoked(tokenID, slug string, issuedAt time.Time) (bool, error) {
+	if tokenID == "" || issuedAt.IsZero() {
+		return false, errors.New("token has no jti or iat")
+	}
+
+	revoked, err := v.revocations.IsRevoked(revocation.Token{
+		ID:       tokenID,
+		Slug:     slug,
+		IssuedAt: issuedAt,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
 	}
 	return ""
 }
+
+// newSigningKeyring creates the keyring used for room tokens. The algorithm
+// and rotation schedule come from JWT_SIGNING_ALG, JWT_KEY_ROTATION and
+// JWT_KEY_OVERLAP. The keys are kept in the JWT_KEYS_PATH file so tokens
+// survive restarts; instances behind a load balancer must share it.
+func newSigningKeyring() (*keyring.Keyring, error) {
+	config := keyring.Config{
+		Algorithm:        keyring.AlgorithmEdDSA,
+		RotationInterval: defaultKeyRotation,
+		// Retired keys must outlive the longest-lived token they signed
+		Overlap: tokenValidity,
+	}
+
+	if alg := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALG")); alg != "" {
+		config.Algorithm = alg
+	}
+	config.RotationInterval = durationFromEnv("JWT_KEY_ROTATION", config.RotationInterval)
+	config.Overlap = durationFromEnv("JWT_KEY_OVERLAP", config.Overlap)
+	if path := strings.TrimSpace(os.Getenv("JWT_KEYS_PATH")); path != "" {
+		config.Storage = keyring.NewFileStorage(path)
+	} else {
+		log.Printf("JWT_KEYS_PATH is not set, tokens will not survive a restart")
+	}
+
+	return keyring.New(config)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return d
+}
+
+// newIPExtractor returns how client addresses are taken from requests, for
+// rate limits and IP bans. trustedProxies lists the addresses or CIDR ranges
+// of the reverse proxies, separated by commas; X-Forwarded-For is only
+// believed when it was added by one of them. Without proxies the address a
+// request comes from is used.
+func newIPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	var options []echo.TrustOption
+	for _, entry := range strings.Split(trustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+
+	if len(options) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	// Only the configured proxies, not every private address
+	options = append(options, echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
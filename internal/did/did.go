@@ -0,0 +1,115 @@
+package did
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidDID         = errors.New("invalid DID")
+	ErrMethodNotSupported = errors.New("DID method not supported")
+	ErrNotFound           = errors.New("DID document not found")
+)
+
+const contextDIDv1 = "https://www.w3.org/ns/did/v1"
+
+// Document is a DID document (W3C DID Core). Only the properties the server
+// uses are modelled.
+type Document struct {
+	Context              interface{}          `json:"@context"` // a string or a list of strings
+	ID                   string               `json:"id"`
+	AlsoKnownAs          []string             `json:"alsoKnownAs,omitempty"`
+	Controller           interface{}          `json:"controller,omitempty"`
+	VerificationMethod   []VerificationMethod `json:"verificationMethod,omitempty"`
+	Authentication       []Relationship       `json:"authentication,omitempty"`
+	AssertionMethod      []Relationship       `json:"assertionMethod,omitempty"`
+	KeyAgreement         []Relationship       `json:"keyAgreement,omitempty"`
+	CapabilityInvocation []Relationship       `json:"capabilityInvocation,omitempty"`
+	CapabilityDelegation []Relationship       `json:"capabilityDelegation,omitempty"`
+	Service              []Service            `json:"service,omitempty"`
+}
+
+// Service is a DID document service endpoint.
+type Service struct {
+	ID              string      `json:"id"`
+	Type            interface{} `json:"type"`
+	ServiceEndpoint interface{} `json:"serviceEndpoint"`
+	RoutingKeys     []string    `json:"routingKeys,omitempty"`
+	Accept          []string    `json:"accept,omitempty"`
+}
+
+// Resolver resolves a DID into its document.
+type Resolver interface {
+	Resolve(ctx context.Context, did string) (*Document, error)
+}
+
+// Method returns the method name of a DID, e.g. "key" for "did:key:z6Mk...".
+func Method(did string) (string, error) {
+	parts := strings.SplitN(did, ":", 3)
+	if len(parts) != 3 || parts[0] != "did" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDID, did)
+	}
+
+	for _, r := range parts[1] {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return "", fmt.Errorf("%w: %q", ErrInvalidDID, did)
+		}
+	}
+
+	return parts[1], nil
+}
+
+// methodSpecificID returns the part after "did:<method>:".
+func methodSpecificID(did string) string {
+	parts := strings.SplitN(did, ":", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// VerificationKeys returns the verification methods referenced by the
+// authentication relationship, resolving references against the document.
+func (d *Document) VerificationKeys() []VerificationMethod {
+	return d.resolveRelationship(d.Authentication)
+}
+
+// KeyAgreementKeys returns the verification methods usable for key agreement.
+func (d *Document) KeyAgreementKeys() []VerificationMethod {
+	return d.resolveRelationship(d.KeyAgreement)
+}
+
+func (d *Document) resolveRelationship(relationship []Relationship) []VerificationMethod {
+	var methods []VerificationMethod
+	for _, entry := range relationship {
+		if entry.Embedded != nil {
+			methods = append(methods, *entry.Embedded)
+			continue
+		}
+		if method := d.FindVerificationMethod(entry.Reference); method != nil {
+			methods = append(methods, *method)
+		}
+	}
+	return methods
+}
+
+// FindVerificationMethod looks a verification method up by absolute or
+// relative ("#key-1") ID.
+func (d *Document) FindVerificationMethod(id string) *VerificationMethod {
+	for i := range d.VerificationMethod {
+		method := &d.VerificationMethod[i]
+		if method.ID == id || absoluteID(d.ID, method.ID) == absoluteID(d.ID, id) {
+			return method
+		}
+	}
+	return nil
+}
+
+func absoluteID(did, id string) string {
+	if strings.HasPrefix(id, "#") {
+		return did + id
+	}
+	return id
+}
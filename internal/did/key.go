@@ -0,0 +1,98 @@
+package did
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+)
+
+const contextMultikey = "https://w3id.org/security/multikey/v1"
+
+// KeyResolver resolves did:key identifiers. Resolution is purely local.
+type KeyResolver struct{}
+
+func (KeyResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	if !strings.HasPrefix(did, "did:key:") {
+		return nil, fmt.Errorf("%w: %q is not a did:key", ErrInvalidDID, did)
+	}
+	return documentFromMultikey(did, strings.TrimPrefix(did, "did:key:"))
+}
+
+// ParseKey extracts the public key from a did:key identifier.
+func ParseKey(did string) (*PublicKey, error) {
+	if !strings.HasPrefix(did, "did:key:") {
+		return nil, fmt.Errorf("%w: %q is not a did:key", ErrInvalidDID, did)
+	}
+
+	key, err := DecodeMultikey(strings.TrimPrefix(did, "did:key:"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+	}
+	return key, nil
+}
+
+// KeyDID encodes a public key as a did:key identifier.
+func KeyDID(key *PublicKey) (string, error) {
+	multibase, err := EncodeMultikey(key)
+	if err != nil {
+		return "", err
+	}
+	return "did:key:" + multibase, nil
+}
+
+// documentFromMultikey builds the document of a did:key (or did:peer:0)
+// whose method-specific ID is the multikey. Ed25519 keys also get the derived
+// X25519 key for key agreement, as the did:key specification describes.
+func documentFromMultikey(did, multibase string) (*Document, error) {
+	key, err := DecodeMultikey(multibase)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+	}
+
+	doc := &Document{
+		Context: []string{contextDIDv1, contextMultikey},
+		ID:      did,
+	}
+
+	method := VerificationMethod{
+		ID:                 did + "#" + multibase,
+		Type:               TypeMultikey,
+		Controller:         did,
+		PublicKeyMultibase: multibase,
+	}
+	reference := []Relationship{{Reference: method.ID}}
+	doc.VerificationMethod = append(doc.VerificationMethod, method)
+
+	switch key.Type {
+	case KeyTypeX25519:
+		doc.KeyAgreement = reference
+		return doc, nil
+	case KeyTypeP256:
+		doc.KeyAgreement = reference
+	case KeyTypeEd25519:
+		x25519, err := Ed25519ToX25519(ed25519.PublicKey(key.Bytes))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+		}
+		agreementMultibase, err := EncodeMultikey(&PublicKey{Type: KeyTypeX25519, Bytes: x25519})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+		}
+		agreement := VerificationMethod{
+			ID:                 did + "#" + agreementMultibase,
+			Type:               TypeMultikey,
+			Controller:         did,
+			PublicKeyMultibase: agreementMultibase,
+		}
+		doc.VerificationMethod = append(doc.VerificationMethod, agreement)
+		doc.KeyAgreement = []Relationship{{Reference: agreement.ID}}
+	}
+
+	doc.Authentication = reference
+	doc.AssertionMethod = reference
+	doc.CapabilityInvocation = reference
+	doc.CapabilityDelegation = reference
+
+	return doc, nil
+}
@@ -0,0 +1,81 @@
+package did
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test vector from the did:key specification
+const specDIDKey = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+func TestResolveDIDKeyEd25519(t *testing.T) {
+	doc, err := KeyResolver{}.Resolve(context.Background(), specDIDKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, specDIDKey, doc.ID)
+	require.Len(t, doc.VerificationMethod, 2)
+
+	keys := doc.VerificationKeys()
+	require.Len(t, keys, 1)
+	assert.Equal(t, specDIDKey+"#z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", keys[0].ID)
+
+	// The derived X25519 key matches the one given by the specification
+	agreement := doc.KeyAgreementKeys()
+	require.Len(t, agreement, 1)
+	assert.Equal(t, specDIDKey+"#z6LSj72tK8brWgZja8NLRwPigth2T9QRiG1uH9oKZuKjdh9p", agreement[0].ID)
+
+	key, err := agreement[0].PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, KeyTypeX25519, key.Type)
+}
+
+func TestResolveDIDKeyX25519(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	did, err := KeyDID(&PublicKey{Type: KeyTypeX25519, Bytes: priv.PublicKey().Bytes()})
+	require.NoError(t, err)
+
+	doc, err := KeyResolver{}.Resolve(context.Background(), did)
+	require.NoError(t, err)
+	assert.Empty(t, doc.VerificationKeys())
+	assert.Len(t, doc.KeyAgreementKeys(), 1)
+}
+
+func TestParseKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	did, err := KeyDID(&PublicKey{Type: KeyTypeEd25519, Bytes: pub})
+	require.NoError(t, err)
+
+	key, err := ParseKey(did)
+	require.NoError(t, err)
+	edKey, err := key.Ed25519()
+	require.NoError(t, err)
+	assert.Equal(t, pub, edKey)
+
+	_, err = ParseKey("did:web:example.com")
+	assert.ErrorIs(t, err, ErrInvalidDID)
+	_, err = ParseKey("did:key:zzzz")
+	assert.ErrorIs(t, err, ErrInvalidDID)
+}
+
+func TestEd25519ToX25519MatchesPrivateKeyConversion(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	x25519, err := Ed25519ToX25519(pub)
+	require.NoError(t, err)
+	_, err = ecdh.X25519().NewPublicKey(x25519)
+	assert.NoError(t, err)
+
+	_, err = Ed25519ToX25519(pub[:10])
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package did
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// KeyType names the curve of a public key.
+type KeyType string
+
+const (
+	KeyTypeEd25519 KeyType = "Ed25519"
+	KeyTypeX25519  KeyType = "X25519"
+	KeyTypeP256    KeyType = "P-256"
+
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	// multibaseBase58BTC is the multibase prefix of base58btc
+	multibaseBase58BTC = 'z'
+)
+
+// Unsigned-varint multicodec prefixes of the supported public key types
+var multicodecs = map[KeyType][]byte{
+	KeyTypeEd25519: {0xed, 0x01},
+	KeyTypeX25519:  {0xec, 0x01},
+	KeyTypeP256:    {0x80, 0x24},
+}
+
+// PublicKey is a decoded public key. Bytes holds the raw key for the 25519
+// curves and the SEC 1 compressed point for P-256.
+type PublicKey struct {
+	Type  KeyType
+	Bytes []byte
+}
+
+// Ed25519 returns the key as an ed25519.PublicKey.
+func (k *PublicKey) Ed25519() (ed25519.PublicKey, error) {
+	if k.Type != KeyTypeEd25519 {
+		return nil, fmt.Errorf("key is %s, not Ed25519", k.Type)
+	}
+	return ed25519.PublicKey(k.Bytes), nil
+}
+
+// Base64 returns the key bytes in standard base64, the encoding the
+// signaling protocol uses for keys.
+func (k *PublicKey) Base64() string {
+	return base64.StdEncoding.EncodeToString(k.Bytes)
+}
+
+// validate checks the key length and, for the Weierstrass and Montgomery
+// curves, that the point is valid.
+func (k *PublicKey) validate() error {
+	switch k.Type {
+	case KeyTypeEd25519:
+		if len(k.Bytes) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 key size: %d", len(k.Bytes))
+		}
+	case KeyTypeX25519:
+		if _, err := ecdh.X25519().NewPublicKey(k.Bytes); err != nil {
+			return fmt.Errorf("invalid X25519 key: %w", err)
+		}
+	case KeyTypeP256:
+		if len(k.Bytes) != 33 {
+			return fmt.Errorf("invalid compressed P-256 key size: %d", len(k.Bytes))
+		}
+		if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k.Bytes); x == nil {
+			return errors.New("invalid P-256 point")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %q", k.Type)
+	}
+	return nil
+}
+
+// DecodeMultikey decodes a base58btc multibase, multicodec-prefixed public
+// key such as the method-specific ID of a did:key.
+func DecodeMultikey(multibase string) (*PublicKey, error) {
+	if len(multibase) < 2 || multibase[0] != multibaseBase58BTC {
+		return nil, errors.New("multikey must be base58btc multibase")
+	}
+
+	decoded, err := DecodeBase58(multibase[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	for keyType, prefix := range multicodecs {
+		if bytes.HasPrefix(decoded, prefix) {
+			key := &PublicKey{Type: keyType, Bytes: decoded[len(prefix):]}
+			if err := key.validate(); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("unsupported multicodec key type")
+}
+
+// EncodeMultikey encodes a public key as base58btc multibase with its
+// multicodec prefix.
+func EncodeMultikey(key *PublicKey) (string, error) {
+	prefix, ok := multicodecs[key.Type]
+	if !ok {
+		return "", fmt.Errorf("unsupported key type %q", key.Type)
+	}
+	if err := key.validate(); err != nil {
+		return "", err
+	}
+
+	data := append(append([]byte{}, prefix...), key.Bytes...)
+	return string(multibaseBase58BTC) + EncodeBase58(data), nil
+}
+
+// Ed25519ToX25519 converts an Ed25519 public key to the X25519 public key of
+// the same key pair, u = (1 + y) / (1 - y) mod p.
+func Ed25519ToX25519(publicKey ed25519.PublicKey) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key size: %d", len(publicKey))
+	}
+
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// y is encoded little-endian with the sign of x in the top bit
+	le := append([]byte{}, publicKey...)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, errors.New("invalid Ed25519 point")
+	}
+
+	one := big.NewInt(1)
+	denominator := new(big.Int).Mod(new(big.Int).Sub(one, y), p)
+	if denominator.Sign() == 0 {
+		return nil, errors.New("Ed25519 point has no X25519 equivalent")
+	}
+
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, new(big.Int).ModInverse(denominator, p))
+	u.Mod(u, p)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverse(out), nil
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// EncodeBase58 encodes data with the Bitcoin base58 alphabet.
+func EncodeBase58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	return string(reverse(out))
+}
+
+// DecodeBase58 decodes a Bitcoin base58 string.
+func DecodeBase58(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, errors.New("empty base58 string")
+	}
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range encoded {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(encoded) && encoded[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+
+	return append(make([]byte, leadingZeros), n.Bytes()...), nil
+}
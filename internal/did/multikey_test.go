@@ -0,0 +1,57 @@
+package did
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBase58RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x00, 0x00, 0x01},
+		[]byte("hello world"),
+		{0xff},
+	} {
+		decoded, err := DecodeBase58(EncodeBase58(data))
+		assert.NoError(t, err)
+		assert.Equal(t, data, decoded)
+	}
+
+	assert.Equal(t, "StV1DL6CwTryKyV", EncodeBase58([]byte("hello world")))
+
+	_, err := DecodeBase58("0OIl")
+	assert.Error(t, err)
+	_, err = DecodeBase58("")
+	assert.Error(t, err)
+}
+
+func TestMultikeyP256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	compressed := elliptic.MarshalCompressed(elliptic.P256(), priv.X, priv.Y)
+	encoded, err := EncodeMultikey(&PublicKey{Type: KeyTypeP256, Bytes: compressed})
+	require.NoError(t, err)
+	assert.Equal(t, "zDn", encoded[:3])
+
+	key, err := DecodeMultikey(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, KeyTypeP256, key.Type)
+	assert.Equal(t, compressed, key.Bytes)
+}
+
+func TestDecodeMultikeyRejectsInvalid(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"f0123", // base16 multibase
+		"z6Mk",  // truncated
+		"zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme", // secp256k1
+	} {
+		_, err := DecodeMultikey(encoded)
+		assert.Error(t, err, encoded)
+	}
+}
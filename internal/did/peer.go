@@ -0,0 +1,156 @@
+package did
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// serviceAbbreviations expands the abbreviated keys and values used in
+// did:peer:2 service blocks
+var serviceAbbreviations = map[string]string{
+	"t":  "type",
+	"s":  "serviceEndpoint",
+	"r":  "routingKeys",
+	"a":  "accept",
+	"dm": "DIDCommMessaging",
+}
+
+// PeerResolver resolves did:peer identifiers with numalgo 0 (a single
+// inception key) and 2 (multiple inline keys and services). Resolution is
+// purely local.
+type PeerResolver struct{}
+
+func (PeerResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	id := strings.TrimPrefix(did, "did:peer:")
+	if id == did || id == "" {
+		return nil, fmt.Errorf("%w: %q is not a did:peer", ErrInvalidDID, did)
+	}
+
+	switch id[0] {
+	case '0':
+		return documentFromMultikey(did, id[1:])
+	case '2':
+		return resolvePeer2(did, id[1:])
+	default:
+		return nil, fmt.Errorf("%w: did:peer numalgo %c", ErrMethodNotSupported, id[0])
+	}
+}
+
+func resolvePeer2(did, elements string) (*Document, error) {
+	if !strings.HasPrefix(elements, ".") {
+		return nil, fmt.Errorf("%w: malformed did:peer:2", ErrInvalidDID)
+	}
+
+	doc := &Document{
+		Context: []string{contextDIDv1, contextMultikey},
+		ID:      did,
+	}
+
+	keyIndex := 0
+	for _, element := range strings.Split(elements[1:], ".") {
+		if len(element) < 2 {
+			return nil, fmt.Errorf("%w: empty did:peer:2 element", ErrInvalidDID)
+		}
+		purpose, value := element[0], element[1:]
+
+		if purpose == 'S' {
+			service, err := decodePeerService(value, len(doc.Service))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+			}
+			doc.Service = append(doc.Service, *service)
+			continue
+		}
+
+		if _, err := DecodeMultikey(value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+		}
+
+		keyIndex++
+		method := VerificationMethod{
+			ID:                 fmt.Sprintf("#key-%d", keyIndex),
+			Type:               TypeMultikey,
+			Controller:         did,
+			PublicKeyMultibase: value,
+		}
+		doc.VerificationMethod = append(doc.VerificationMethod, method)
+		reference := Relationship{Reference: method.ID}
+
+		switch purpose {
+		case 'A':
+			doc.AssertionMethod = append(doc.AssertionMethod, reference)
+		case 'E':
+			doc.KeyAgreement = append(doc.KeyAgreement, reference)
+		case 'V':
+			doc.Authentication = append(doc.Authentication, reference)
+		case 'I':
+			doc.CapabilityInvocation = append(doc.CapabilityInvocation, reference)
+		case 'D':
+			doc.CapabilityDelegation = append(doc.CapabilityDelegation, reference)
+		default:
+			return nil, fmt.Errorf("%w: unknown did:peer:2 purpose %q", ErrInvalidDID, purpose)
+		}
+	}
+
+	return doc, nil
+}
+
+func decodePeerService(encoded string, index int) (*Service, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid service encoding: %w", err)
+	}
+
+	var abbreviated map[string]interface{}
+	if err := json.Unmarshal(raw, &abbreviated); err != nil {
+		return nil, fmt.Errorf("invalid service JSON: %w", err)
+	}
+
+	expanded, err := json.Marshal(expandAbbreviations(abbreviated))
+	if err != nil {
+		return nil, err
+	}
+
+	var service Service
+	if err := json.Unmarshal(expanded, &service); err != nil {
+		return nil, fmt.Errorf("invalid service: %w", err)
+	}
+
+	if service.ID == "" {
+		service.ID = "#service"
+		if index > 0 {
+			service.ID = fmt.Sprintf("#service-%d", index)
+		}
+	}
+	return &service, nil
+}
+
+func expandAbbreviations(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			if full, ok := serviceAbbreviations[key]; ok {
+				key = full
+			}
+			expanded[key] = expandAbbreviations(inner)
+		}
+		return expanded
+	case []interface{}:
+		for i := range v {
+			v[i] = expandAbbreviations(v[i])
+		}
+		return v
+	case string:
+		// Only the "dm" type is abbreviated among values
+		if v == "dm" {
+			return serviceAbbreviations[v]
+		}
+		return v
+	default:
+		return v
+	}
+}
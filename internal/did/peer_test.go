@@ -0,0 +1,74 @@
+package did
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePeerNumalgo0(t *testing.T) {
+	did := "did:peer:0z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+	doc, err := PeerResolver{}.Resolve(context.Background(), did)
+	require.NoError(t, err)
+	assert.Equal(t, did, doc.ID)
+	assert.Len(t, doc.VerificationKeys(), 1)
+	assert.Len(t, doc.KeyAgreementKeys(), 1)
+}
+
+func TestResolvePeerNumalgo2(t *testing.T) {
+	signing, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	agreement, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signingMultibase, err := EncodeMultikey(&PublicKey{Type: KeyTypeEd25519, Bytes: signing})
+	require.NoError(t, err)
+	agreementMultibase, err := EncodeMultikey(&PublicKey{Type: KeyTypeX25519, Bytes: agreement.PublicKey().Bytes()})
+	require.NoError(t, err)
+	service := base64.RawURLEncoding.EncodeToString([]byte(
+		`{"t":"dm","s":{"uri":"https://example.com/didcomm","a":["didcomm/v2"]}}`))
+
+	did := "did:peer:2.V" + signingMultibase + ".E" + agreementMultibase + ".S" + service
+
+	doc, err := PeerResolver{}.Resolve(context.Background(), did)
+	require.NoError(t, err)
+
+	require.Len(t, doc.VerificationMethod, 2)
+	assert.Equal(t, "#key-1", doc.VerificationMethod[0].ID)
+	assert.Equal(t, "#key-2", doc.VerificationMethod[1].ID)
+
+	keys := doc.VerificationKeys()
+	require.Len(t, keys, 1)
+	key, err := keys[0].PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, []byte(signing), key.Bytes)
+
+	require.Len(t, doc.KeyAgreementKeys(), 1)
+
+	require.Len(t, doc.Service, 1)
+	assert.Equal(t, "#service", doc.Service[0].ID)
+	assert.Equal(t, "DIDCommMessaging", doc.Service[0].Type)
+	endpoint := doc.Service[0].ServiceEndpoint.(map[string]interface{})
+	assert.Equal(t, "https://example.com/didcomm", endpoint["uri"])
+	assert.Equal(t, []interface{}{"didcomm/v2"}, endpoint["accept"])
+}
+
+func TestResolvePeerInvalid(t *testing.T) {
+	for _, did := range []string{
+		"did:peer:",
+		"did:peer:1zQmZMygzYqNwU6Uhmewx5Xepf2VLp5S4HLSwwgf2aiKZuwa",
+		"did:peer:2Vz6Mk",
+		"did:peer:2.Xz6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK",
+		"did:peer:2.S!!!",
+	} {
+		_, err := PeerResolver{}.Resolve(context.Background(), did)
+		assert.Error(t, err, did)
+	}
+}
@@ -0,0 +1,111 @@
+package did
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Registry dispatches resolution to a resolver per DID method.
+type Registry struct {
+	resolvers map[string]Resolver
+}
+
+func NewRegistry() *Registry {
+	return &Registry{resolvers: make(map[string]Resolver)}
+}
+
+// NewLocalRegistry supports the methods that resolve without network
+// access: did:key and did:peer.
+func NewLocalRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register("key", KeyResolver{})
+	registry.Register("peer", PeerResolver{})
+	return registry
+}
+
+// NewDefaultRegistry supports did:key, did:peer and did:web, the latter
+// fetched with fetcher.
+func NewDefaultRegistry(fetcher Fetcher) *Registry {
+	registry := NewLocalRegistry()
+	registry.Register("web", NewWebResolver(fetcher))
+	return registry
+}
+
+// Register sets the resolver for a DID method.
+func (r *Registry) Register(method string, resolver Resolver) {
+	r.resolvers[method] = resolver
+}
+
+func (r *Registry) Resolve(ctx context.Context, did string) (*Document, error) {
+	method, err := Method(did)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, ok := r.resolvers[method]
+	if !ok {
+		return nil, fmt.Errorf("%w: did:%s", ErrMethodNotSupported, method)
+	}
+	return resolver.Resolve(ctx, did)
+}
+
+type cacheEntry struct {
+	doc       *Document
+	expiresAt time.Time
+}
+
+// CachingResolver caches successful resolutions for a fixed TTL. Failures
+// are not cached so that a transient did:web outage does not stick.
+type CachingResolver struct {
+	next       Resolver
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+func NewCachingResolver(next Resolver, ttl time.Duration, maxEntries int) *CachingResolver {
+	return &CachingResolver{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]cacheEntry),
+		now:        time.Now,
+	}
+}
+
+func (c *CachingResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	c.mu.Lock()
+	entry, exists := c.entries[did]
+	if exists && c.now().Before(entry.expiresAt) {
+		c.mu.Unlock()
+		return entry.doc, nil
+	}
+	c.mu.Unlock()
+
+	doc, err := c.next.Resolve(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.maxEntries {
+		for key, cached := range c.entries {
+			if !now.Before(cached.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		c.entries[did] = cacheEntry{doc: doc, expiresAt: now.Add(c.ttl)}
+	}
+
+	return doc, nil
+}
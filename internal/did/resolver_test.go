@@ -0,0 +1,94 @@
+package did
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	r.calls++
+	if did == "did:example:missing" {
+		return nil, ErrNotFound
+	}
+	return &Document{ID: did}, nil
+}
+
+func TestMethod(t *testing.T) {
+	method, err := Method("did:key:z6Mk")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", method)
+
+	for _, did := range []string{"", "did:", "did:key", "did:KEY:abc", "urn:key:abc"} {
+		_, err := Method(did)
+		assert.ErrorIs(t, err, ErrInvalidDID, did)
+	}
+}
+
+func TestRegistryDispatch(t *testing.T) {
+	registry := NewDefaultRegistry(staticFetcher{})
+
+	doc, err := registry.Resolve(context.Background(), specDIDKey)
+	require.NoError(t, err)
+	assert.Equal(t, specDIDKey, doc.ID)
+
+	_, err = registry.Resolve(context.Background(), "did:ion:abc")
+	assert.ErrorIs(t, err, ErrMethodNotSupported)
+
+	_, err = registry.Resolve(context.Background(), "did:web:example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCachingResolver(t *testing.T) {
+	next := &countingResolver{}
+	cache := NewCachingResolver(next, time.Minute, 10)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := cache.Resolve(context.Background(), "did:example:alice")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, next.calls)
+
+	// Failures are not cached
+	for i := 0; i < 2; i++ {
+		_, err := cache.Resolve(context.Background(), "did:example:missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, 3, next.calls)
+
+	now = now.Add(2 * time.Minute)
+	_, err := cache.Resolve(context.Background(), "did:example:alice")
+	require.NoError(t, err)
+	assert.Equal(t, 4, next.calls)
+}
+
+func TestFindVerificationMethod(t *testing.T) {
+	doc := &Document{
+		ID: "did:example:alice",
+		VerificationMethod: []VerificationMethod{
+			{ID: "did:example:alice#key-1"},
+			{ID: "#key-2"},
+		},
+		Authentication: []Relationship{
+			{Reference: "#key-1"},
+			{Reference: "did:example:alice#key-2"},
+			{Embedded: &VerificationMethod{ID: "#embedded"}},
+			{Reference: "#missing"},
+		},
+	}
+
+	keys := doc.VerificationKeys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, "did:example:alice#key-1", keys[0].ID)
+	assert.Equal(t, "#key-2", keys[1].ID)
+	assert.Equal(t, "#embedded", keys[2].ID)
+}
@@ -0,0 +1,141 @@
+package did
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	TypeMultikey                  = "Multikey"
+	TypeJSONWebKey2020            = "JsonWebKey2020"
+	TypeEd25519VerificationKey    = "Ed25519VerificationKey2018"
+	TypeEd25519VerificationKey20  = "Ed25519VerificationKey2020"
+	TypeX25519KeyAgreementKey2019 = "X25519KeyAgreementKey2019"
+	TypeX25519KeyAgreementKey2020 = "X25519KeyAgreementKey2020"
+)
+
+// VerificationMethod is a public key listed in a DID document.
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+	PublicKeyBase58    string `json:"publicKeyBase58,omitempty"`
+	PublicKeyJwk       *JWK   `json:"publicKeyJwk,omitempty"`
+}
+
+// JWK is the subset of JSON Web Key fields used for OKP and EC public keys.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y,omitempty"`
+}
+
+// Relationship is an entry of a verification relationship such as
+// authentication: either a reference to a verification method or an
+// embedded one.
+type Relationship struct {
+	Reference string
+	Embedded  *VerificationMethod
+}
+
+func (r Relationship) MarshalJSON() ([]byte, error) {
+	if r.Embedded != nil {
+		return json.Marshal(r.Embedded)
+	}
+	return json.Marshal(r.Reference)
+}
+
+func (r *Relationship) UnmarshalJSON(data []byte) error {
+	var reference string
+	if err := json.Unmarshal(data, &reference); err == nil {
+		r.Reference = reference
+		return nil
+	}
+
+	var embedded VerificationMethod
+	if err := json.Unmarshal(data, &embedded); err != nil {
+		return fmt.Errorf("relationship must be a reference or a verification method: %w", err)
+	}
+	r.Embedded = &embedded
+	return nil
+}
+
+// PublicKey decodes the key material of the verification method.
+func (m *VerificationMethod) PublicKey() (*PublicKey, error) {
+	switch {
+	case m.PublicKeyMultibase != "":
+		if m.Type == TypeEd25519VerificationKey20 || m.Type == TypeX25519KeyAgreementKey2020 {
+			// The 2020 suites use multibase without a multicodec prefix
+			return rawMultibaseKey(m.PublicKeyMultibase, keyTypeForSuite(m.Type))
+		}
+		return DecodeMultikey(m.PublicKeyMultibase)
+	case m.PublicKeyBase58 != "":
+		raw, err := DecodeBase58(m.PublicKeyBase58)
+		if err != nil {
+			return nil, err
+		}
+		key := &PublicKey{Type: keyTypeForSuite(m.Type), Bytes: raw}
+		return key, key.validate()
+	case m.PublicKeyJwk != nil:
+		return m.PublicKeyJwk.PublicKey()
+	}
+	return nil, fmt.Errorf("verification method %s has no supported key material", m.ID)
+}
+
+func keyTypeForSuite(suite string) KeyType {
+	switch suite {
+	case TypeX25519KeyAgreementKey2019, TypeX25519KeyAgreementKey2020:
+		return KeyTypeX25519
+	default:
+		return KeyTypeEd25519
+	}
+}
+
+func rawMultibaseKey(multibase string, keyType KeyType) (*PublicKey, error) {
+	if len(multibase) < 2 || multibase[0] != multibaseBase58BTC {
+		return nil, errors.New("key must be base58btc multibase")
+	}
+	raw, err := DecodeBase58(multibase[1:])
+	if err != nil {
+		return nil, err
+	}
+	key := &PublicKey{Type: keyType, Bytes: raw}
+	return key, key.validate()
+}
+
+// PublicKey decodes an OKP (Ed25519, X25519) or EC (P-256) JWK.
+func (j *JWK) PublicKey() (*PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwk x: %w", err)
+	}
+
+	var key *PublicKey
+	switch {
+	case j.KeyType == "OKP" && j.Curve == "Ed25519":
+		key = &PublicKey{Type: KeyTypeEd25519, Bytes: x}
+	case j.KeyType == "OKP" && j.Curve == "X25519":
+		key = &PublicKey{Type: KeyTypeX25519, Bytes: x}
+	case j.KeyType == "EC" && j.Curve == "P-256":
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk y: %w", err)
+		}
+		curve := elliptic.P256()
+		bx, by := new(big.Int).SetBytes(x), new(big.Int).SetBytes(y)
+		if len(x) != 32 || len(y) != 32 || !curve.IsOnCurve(bx, by) {
+			return nil, errors.New("invalid P-256 jwk")
+		}
+		key = &PublicKey{Type: KeyTypeP256, Bytes: elliptic.MarshalCompressed(curve, bx, by)}
+	default:
+		return nil, fmt.Errorf("unsupported jwk %s/%s", j.KeyType, j.Curve)
+	}
+
+	return key, key.validate()
+}
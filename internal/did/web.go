@@ -0,0 +1,191 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const maxDocumentSize = 64 * 1024
+
+// ErrForbiddenAddress is returned when a did:web document would be fetched
+// from an address the server must not reach, e.g. a loopback or private one.
+var ErrForbiddenAddress = errors.New("forbidden did:web address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip does not
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Fetcher retrieves a did:web document. It is an interface so that tests and
+// deployments behind egress proxies can substitute their own transport.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// HTTPFetcher fetches documents over HTTP(S) with a size limit.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+// NewHTTPFetcher returns a fetcher that is safe to expose to anyone who can
+// name a did:web: it only connects to public addresses on port 443 over
+// HTTPS, checked after DNS resolution, and does not follow redirects.
+func NewHTTPFetcher() *HTTPFetcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: checkDialAddress}
+	return &HTTPFetcher{
+		Client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: httpsOnly{&http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+			}},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return fmt.Errorf("%w: redirects are not followed", ErrForbiddenAddress)
+			},
+		},
+	}
+}
+
+// httpsOnly refuses requests that are not HTTPS.
+type httpsOnly struct {
+	base http.RoundTripper
+}
+
+func (t httpsOnly) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s is not https", ErrForbiddenAddress, req.URL.Redacted())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// checkDialAddress only lets connections through to public addresses on
+// port 443. It runs after DNS resolution, so hostnames that resolve to
+// internal addresses are refused too.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+	if addrPort.Port() != 443 {
+		return fmt.Errorf("%w: port %d", ErrForbiddenAddress, addrPort.Port())
+	}
+
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, documentURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/did+json, application/json")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, documentURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDocumentSize {
+		return nil, errors.New("DID document too large")
+	}
+	return body, nil
+}
+
+// WebResolver resolves did:web identifiers by fetching did.json.
+type WebResolver struct {
+	Fetcher Fetcher
+	// Scheme defaults to "https". Tests may set "http" to use a local server.
+	Scheme string
+}
+
+func NewWebResolver(fetcher Fetcher) *WebResolver {
+	return &WebResolver{Fetcher: fetcher, Scheme: "https"}
+}
+
+// WebDocumentURL maps a did:web identifier to the URL of its document.
+func WebDocumentURL(did, scheme string) (string, error) {
+	id := strings.TrimPrefix(did, "did:web:")
+	if id == did || id == "" {
+		return "", fmt.Errorf("%w: %q is not a did:web", ErrInvalidDID, did)
+	}
+
+	segments := strings.Split(id, ":")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded == "" || strings.Contains(decoded, "/") {
+			return "", fmt.Errorf("%w: malformed did:web %q", ErrInvalidDID, did)
+		}
+		segments[i] = decoded
+	}
+
+	host := segments[0]
+	if strings.ContainsAny(host, "@?#") {
+		return "", fmt.Errorf("%w: malformed did:web host %q", ErrInvalidDID, host)
+	}
+
+	path := "/.well-known/did.json"
+	if len(segments) > 1 {
+		escaped := make([]string, 0, len(segments)-1)
+		for _, segment := range segments[1:] {
+			escaped = append(escaped, url.PathEscape(segment))
+		}
+		path = "/" + strings.Join(escaped, "/") + "/did.json"
+	}
+
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + host + path, nil
+}
+
+func (r *WebResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	documentURL, err := WebDocumentURL(did, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := r.Fetcher.Fetch(ctx, documentURL)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, did)
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", documentURL, err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid DID document for %s: %w", did, err)
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID document id %q does not match %q", doc.ID, did)
+	}
+
+	return &doc, nil
+}
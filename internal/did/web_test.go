@@ -0,0 +1,127 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebDocumentURL(t *testing.T) {
+	tests := []struct {
+		did      string
+		expected string
+	}{
+		{"did:web:example.com", "https://example.com/.well-known/did.json"},
+		{"did:web:example.com:user:alice", "https://example.com/user/alice/did.json"},
+		{"did:web:localhost%3A8443", "https://localhost:8443/.well-known/did.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.did, func(t *testing.T) {
+			url, err := WebDocumentURL(tt.did, "https")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, url)
+		})
+	}
+
+	for _, did := range []string{"did:web:", "did:key:z6Mk", "did:web:evil.com%2F..", "did:web:user@evil.com"} {
+		_, err := WebDocumentURL(did, "https")
+		assert.ErrorIs(t, err, ErrInvalidDID, did)
+	}
+}
+
+// localWebServer serves did:web documents for DIDs rooted at its own host.
+func localWebServer(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/alice/did.json" {
+			http.NotFound(w, r)
+			return
+		}
+		host := strings.ReplaceAll(r.Host, ":", "%3A")
+		did := "did:web:" + host + ":user:alice"
+		doc := map[string]interface{}{
+			"@context": "https://www.w3.org/ns/did/v1",
+			"id":       did,
+			"verificationMethod": []map[string]interface{}{{
+				"id":              did + "#key-1",
+				"type":            "Ed25519VerificationKey2018",
+				"controller":      did,
+				"publicKeyBase58": "H3C2AVvLMv6gmMNam3uVAjZpfkcJCwDwnZn6z3wXmqPV",
+			}},
+			"authentication": []string{"#key-1"},
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	return server, "did:web:" + strings.ReplaceAll(host, ":", "%3A")
+}
+
+func TestResolveDIDWeb(t *testing.T) {
+	server, base := localWebServer(t)
+
+	resolver := &WebResolver{Fetcher: &HTTPFetcher{Client: server.Client()}, Scheme: "http"}
+
+	doc, err := resolver.Resolve(context.Background(), base+":user:alice")
+	require.NoError(t, err)
+
+	keys := doc.VerificationKeys()
+	require.Len(t, keys, 1)
+	key, err := keys[0].PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, KeyTypeEd25519, key.Type)
+
+	_, err = resolver.Resolve(context.Background(), base+":user:bob")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestHTTPFetcherRefusesInternalAddresses(t *testing.T) {
+	server, base := localWebServer(t)
+	fetcher := NewHTTPFetcher()
+
+	_, err := fetcher.Fetch(context.Background(), server.URL+"/user/alice/did.json")
+	assert.ErrorIs(t, err, ErrForbiddenAddress, "plain http")
+
+	resolver := NewWebResolver(fetcher)
+	_, err = resolver.Resolve(context.Background(), base+":user:alice")
+	assert.ErrorIs(t, err, ErrForbiddenAddress, "loopback")
+
+	for _, address := range []string{
+		"127.0.0.1:443", "[::1]:443", "10.0.0.1:443", "192.168.1.1:443", "172.16.0.1:443",
+		"169.254.169.254:443", "100.64.0.1:443", "0.0.0.0:443", "[::ffff:127.0.0.1]:443",
+		"[fe80::1]:443", "[fd00::1]:443", "93.184.216.34:6379",
+	} {
+		assert.ErrorIs(t, checkDialAddress("tcp", address, nil), ErrForbiddenAddress, address)
+	}
+	assert.NoError(t, checkDialAddress("tcp", "93.184.216.34:443", nil))
+	assert.NoError(t, checkDialAddress("tcp6", "[2606:2800:220:1:248:1893:25c8:1946]:443", nil))
+
+	redirect := httptest.NewRequest(http.MethodGet, "https://example.com/.well-known/did.json", nil)
+	assert.ErrorIs(t, fetcher.Client.CheckRedirect(redirect, []*http.Request{redirect}), ErrForbiddenAddress)
+}
+
+type staticFetcher map[string]string
+
+func (f staticFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	body, ok := f[url]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(body), nil
+}
+
+func TestResolveDIDWebRejectsMismatchedID(t *testing.T) {
+	resolver := NewWebResolver(staticFetcher{
+		"https://example.com/.well-known/did.json": `{"id":"did:web:attacker.com"}`,
+	})
+
+	_, err := resolver.Resolve(context.Background(), "did:web:example.com")
+	assert.Error(t, err)
+}
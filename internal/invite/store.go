@@ -0,0 +1,177 @@
+package invite
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("invite not found")
+	ErrExpired   = errors.New("invite expired")
+	ErrExhausted = errors.New("invite has no uses left")
+	ErrRevoked   = errors.New("invite revoked")
+)
+
+// Invite lets guests obtain a guest token for a room without the host
+// handing out tokens one by one.
+type Invite struct {
+	Code      string     `json:"code"`
+	Slug      string     `json:"slug"`
+	Name      string     `json:"name,omitempty"`     // display name pre-assigned to the guest
+	MaxUses   int        `json:"max_uses,omitempty"` // 0 means unlimited
+	Uses      int        `json:"uses"`
+	CreatedBy string     `json:"-"` // jti of the host token that created the invite
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usable reports why the invite cannot be redeemed at now, or nil.
+func (i *Invite) Usable(now time.Time) error {
+	switch {
+	case i.RevokedAt != nil:
+		return ErrRevoked
+	case !now.Before(i.ExpiresAt):
+		return ErrExpired
+	case i.MaxUses > 0 && i.Uses >= i.MaxUses:
+		return ErrExhausted
+	}
+	return nil
+}
+
+// Store keeps invites. Redeem must be atomic so that an invite is never used
+// more than MaxUses times.
+type Store interface {
+	Create(invite *Invite) error
+	Get(code string) (*Invite, error)
+	List(slug string) ([]*Invite, error)
+	Revoke(slug, code string) error
+	// RevokeAll revokes the invites of the room not revoked yet and returns
+	// how many there were.
+	RevokeAll(slug string) (int, error)
+	Redeem(code string) (*Invite, error)
+}
+
+// GenerateCode returns a random, URL-safe invite code.
+func GenerateCode() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	invites map[string]*Invite
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		invites: make(map[string]*Invite),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Create(invite *Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	copied := *invite
+	s.invites[invite.Code] = &copied
+	return nil
+}
+
+func (s *MemoryStore) Get(code string) (*Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, exists := s.invites[code]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *invite
+	return &copied, nil
+}
+
+func (s *MemoryStore) List(slug string) ([]*Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invites := []*Invite{}
+	for _, invite := range s.invites {
+		if invite.Slug == slug {
+			copied := *invite
+			invites = append(invites, &copied)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.Before(invites[j].CreatedAt)
+	})
+	return invites, nil
+}
+
+func (s *MemoryStore) Revoke(slug, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, exists := s.invites[code]
+	if !exists || invite.Slug != slug {
+		return ErrNotFound
+	}
+	if invite.RevokedAt == nil {
+		now := s.now()
+		invite.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeAll(slug string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	revoked := 0
+	for _, invite := range s.invites {
+		if invite.Slug == slug && invite.RevokedAt == nil {
+			invite.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStore) Redeem(code string) (*Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, exists := s.invites[code]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	if err := invite.Usable(s.now()); err != nil {
+		return nil, err
+	}
+
+	invite.Uses++
+	copied := *invite
+	return &copied, nil
+}
+
+// pruneLocked drops invites that expired more than a day ago, so hosts can
+// still see recently expired invites when listing.
+func (s *MemoryStore) pruneLocked() {
+	cutoff := s.now().Add(-24 * time.Hour)
+	for code, invite := range s.invites {
+		if invite.ExpiresAt.Before(cutoff) {
+			delete(s.invites, code)
+		}
+	}
+}
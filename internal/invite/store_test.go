@@ -0,0 +1,127 @@
+package invite
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInvite(t *testing.T, slug string, maxUses int, ttl time.Duration) *Invite {
+	code, err := GenerateCode()
+	require.NoError(t, err)
+
+	return &Invite{
+		Code:      code,
+		Slug:      slug,
+		MaxUses:   maxUses,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+}
+
+func TestRedeemSingleUse(t *testing.T) {
+	store := NewMemoryStore()
+	inv := newInvite(t, "room", 1, time.Hour)
+	require.NoError(t, store.Create(inv))
+
+	redeemed, err := store.Redeem(inv.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "room", redeemed.Slug)
+	assert.Equal(t, 1, redeemed.Uses)
+
+	_, err = store.Redeem(inv.Code)
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestRedeemUnlimited(t *testing.T) {
+	store := NewMemoryStore()
+	inv := newInvite(t, "room", 0, time.Hour)
+	require.NoError(t, store.Create(inv))
+
+	for i := 0; i < 5; i++ {
+		_, err := store.Redeem(inv.Code)
+		assert.NoError(t, err)
+	}
+}
+
+func TestRedeemIsAtomic(t *testing.T) {
+	store := NewMemoryStore()
+	inv := newInvite(t, "room", 3, time.Hour)
+	require.NoError(t, store.Create(inv))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successes := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Redeem(inv.Code); err == nil {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, successes)
+}
+
+func TestRedeemExpiredAndRevoked(t *testing.T) {
+	store := NewMemoryStore()
+
+	expired := newInvite(t, "room", 0, -time.Minute)
+	require.NoError(t, store.Create(expired))
+	_, err := store.Redeem(expired.Code)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	revoked := newInvite(t, "room", 0, time.Hour)
+	require.NoError(t, store.Create(revoked))
+	assert.ErrorIs(t, store.Revoke("other-room", revoked.Code), ErrNotFound)
+	require.NoError(t, store.Revoke("room", revoked.Code))
+	_, err = store.Redeem(revoked.Code)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	_, err = store.Redeem("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRevokeAll(t *testing.T) {
+	store := NewMemoryStore()
+
+	first := newInvite(t, "room", 0, time.Hour)
+	second := newInvite(t, "room", 0, time.Hour)
+	other := newInvite(t, "other-room", 0, time.Hour)
+	for _, invite := range []*Invite{first, second, other} {
+		require.NoError(t, store.Create(invite))
+	}
+	require.NoError(t, store.Revoke("room", first.Code))
+
+	revoked, err := store.RevokeAll("room")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked, "invites revoked before are not counted")
+
+	_, err = store.Redeem(second.Code)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = store.Redeem(other.Code)
+	assert.NoError(t, err)
+}
+
+func TestList(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, store.Create(newInvite(t, "room", 0, time.Hour)))
+	require.NoError(t, store.Create(newInvite(t, "room", 1, time.Hour)))
+	require.NoError(t, store.Create(newInvite(t, "other", 0, time.Hour)))
+
+	invites, err := store.List("room")
+	assert.NoError(t, err)
+	assert.Len(t, invites, 2)
+
+	invites, err = store.List("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, invites)
+}
@@ -0,0 +1,70 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+)
+
+// JWK is the public JSON Web Key representation of a signing key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKS is a JSON Web Key Set as served from /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that are currently accepted for verification.
+func (k *Keyring) JWKS() (*JWKS, error) {
+	set := &JWKS{Keys: []JWK{}}
+
+	for _, key := range k.Keys() {
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+
+	return set, nil
+}
+
+// JWK encodes the public half of the key.
+func (k *Key) JWK() (*JWK, error) {
+	jwk := &JWK{
+		KeyID:     k.ID,
+		Algorithm: k.Algorithm,
+		Use:       "sig",
+	}
+
+	switch pub := k.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *ecdsa.PublicKey:
+		point, err := pub.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key %s: %w", k.ID, err)
+		}
+		// Uncompressed SEC 1 point: 0x04 || X || Y
+		size := (len(point) - 1) / 2
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	default:
+		return nil, fmt.Errorf("%w: key %s", ErrUnsupportedAlgorithm, k.ID)
+	}
+
+	return jwk, nil
+}
@@ -0,0 +1,424 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmES256 = "ES256"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrKeyExpired           = errors.New("signing key no longer accepted")
+)
+
+// Key is a versioned signing key. A key signs new tokens until RetiredAt and
+// is still accepted for verification until ExpiresAt, which gives tokens
+// signed just before a rotation time to live out their lifetime.
+type Key struct {
+	ID        string
+	Version   int
+	Algorithm string
+	CreatedAt time.Time
+	RetiredAt time.Time // zero while the key is the active signing key
+	ExpiresAt time.Time // zero while the key is the active signing key
+
+	signer crypto.Signer
+}
+
+// Public returns the public half of the key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.signer.Public()
+}
+
+// Config controls key generation and rotation.
+type Config struct {
+	// Algorithm is AlgorithmEdDSA or AlgorithmES256.
+	Algorithm string
+	// RotationInterval is how long a key signs tokens before it is replaced.
+	// Zero disables scheduled rotation.
+	RotationInterval time.Duration
+	// Overlap is how long a retired key is still accepted and published.
+	// It should be at least the lifetime of the longest-lived token.
+	Overlap time.Duration
+	// Storage persists the keys. Without it they are lost on restart, and
+	// with them every token signed so far.
+	Storage Storage
+}
+
+// reloadInterval is how often a token signed with an unknown key may make
+// the keyring look for keys that other instances added to the storage.
+const reloadInterval = 10 * time.Second
+
+// Keyring holds the active signing key and the retired keys that are still
+// inside their overlap window.
+type Keyring struct {
+	mu      sync.RWMutex
+	config  Config
+	keys    []*Key // ordered by version, the last one is active
+	version int
+	now     func() time.Time
+	stop    chan struct{}
+
+	reloaded time.Time // when keys were last read from storage
+}
+
+// New creates a keyring with the keys in the storage, generating an active
+// key if none of them can sign with the configured algorithm.
+func New(config Config) (*Keyring, error) {
+	if _, err := signingMethod(config.Algorithm); err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		config: config,
+		now:    time.Now,
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	usable := len(k.keys) > 0 && k.activeLocked().Algorithm == config.Algorithm
+	k.mu.RUnlock()
+	if !usable {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// Reload adds the keys in the storage that the keyring does not hold yet,
+// such as those of other instances sharing it.
+func (k *Keyring) Reload() error {
+	if k.config.Storage == nil {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.reloadLocked()
+}
+
+func (k *Keyring) reloadLocked() error {
+	stored, err := k.config.Storage.Load()
+	if err != nil {
+		return err
+	}
+	k.reloaded = k.now()
+
+	byID := make(map[string]*Key, len(k.keys))
+	for _, key := range k.keys {
+		byID[key.ID] = key
+	}
+	for _, key := range stored {
+		if _, exists := byID[key.ID]; !exists {
+			k.keys = append(k.keys, key)
+			byID[key.ID] = key
+		} else if !key.RetiredAt.IsZero() {
+			byID[key.ID].RetiredAt = key.RetiredAt
+			byID[key.ID].ExpiresAt = key.ExpiresAt
+		}
+	}
+
+	sort.SliceStable(k.keys, func(i, j int) bool {
+		return k.keys[i].CreatedAt.Before(k.keys[j].CreatedAt)
+	})
+	// Instances that rotated at once each left an active key; only the
+	// newest keeps signing
+	for i, key := range k.keys[:max(len(k.keys)-1, 0)] {
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = k.keys[i+1].CreatedAt
+			key.ExpiresAt = key.RetiredAt.Add(k.config.Overlap)
+		}
+	}
+	for _, key := range k.keys {
+		k.version = max(k.version, key.Version)
+	}
+	k.pruneLocked(k.now())
+	return nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+func generateSigner(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// Rotate generates a new active key. The previous active key stops signing
+// immediately but is accepted for verification for the configured overlap.
+func (k *Keyring) Rotate() (*Key, error) {
+	signer, err := generateSigner(k.config.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Rotate from the latest stored keys, so that no instance overwrites
+	// the keys another one added
+	if k.config.Storage != nil {
+		if err := k.reloadLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	now := k.now()
+	var current *Key
+	if len(k.keys) > 0 {
+		current = k.keys[len(k.keys)-1]
+		current.RetiredAt = now
+		current.ExpiresAt = now.Add(k.config.Overlap)
+	}
+
+	key := &Key{
+		ID:        fmt.Sprintf("v%d-%s", k.version+1, hex.EncodeToString(suffix)),
+		Version:   k.version + 1,
+		Algorithm: k.config.Algorithm,
+		CreatedAt: now,
+		signer:    signer,
+	}
+	keys := append(slices.Clone(k.keys), key)
+
+	if k.config.Storage != nil {
+		if err := k.config.Storage.Save(keys); err != nil {
+			if current != nil {
+				current.RetiredAt = time.Time{}
+				current.ExpiresAt = time.Time{}
+			}
+			return nil, err
+		}
+	}
+
+	k.version++
+	k.keys = keys
+	k.pruneLocked(now)
+
+	return key, nil
+}
+
+// pruneLocked drops retired keys whose overlap window has passed.
+func (k *Keyring) pruneLocked(now time.Time) {
+	kept := k.keys[:0]
+	for _, key := range k.keys {
+		if key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt) {
+			kept = append(kept, key)
+		}
+	}
+	k.keys = kept
+}
+
+// RotateIfDue rotates the active key when it is older than the rotation
+// interval. It reports whether a rotation happened.
+func (k *Keyring) RotateIfDue() (bool, error) {
+	if k.config.RotationInterval <= 0 {
+		return false, nil
+	}
+
+	// Another instance may have rotated already
+	if err := k.Reload(); err != nil {
+		return false, err
+	}
+
+	k.mu.RLock()
+	due := !k.now().Before(k.activeLocked().CreatedAt.Add(k.config.RotationInterval))
+	k.mu.RUnlock()
+
+	if !due {
+		k.mu.Lock()
+		k.pruneLocked(k.now())
+		k.mu.Unlock()
+		return false, nil
+	}
+
+	if _, err := k.Rotate(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Start runs scheduled rotation in the background until Stop is called.
+func (k *Keyring) Start(checkEvery time.Duration) {
+	k.mu.Lock()
+	if k.stop != nil {
+		k.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	k.stop = stop
+	k.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(checkEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				rotated, err := k.RotateIfDue()
+				if err != nil {
+					log.Printf("Signing key rotation failed: %v", err)
+				} else if rotated {
+					log.Printf("Signing key rotated, active key is %s", k.Active().ID)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends scheduled rotation.
+func (k *Keyring) Stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+func (k *Keyring) activeLocked() *Key {
+	return k.keys[len(k.keys)-1]
+}
+
+// Active returns the key currently used for signing.
+func (k *Keyring) Active() *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeLocked()
+}
+
+// Keys returns the keys that are currently accepted for verification.
+func (k *Keyring) Keys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.now()
+	keys := make([]*Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.Active()
+
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signer)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header. It is
+// meant to be passed to jwt.Parse together with jwt.WithValidMethods(k.Methods()).
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("%w: missing kid", ErrUnknownKey)
+	}
+
+	key := k.find(kid)
+	if key == nil && k.config.Storage != nil {
+		// The key may be new from another instance
+		k.mu.Lock()
+		if k.now().Sub(k.reloaded) >= reloadInterval {
+			if err := k.reloadLocked(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+			}
+		}
+		k.mu.Unlock()
+		key = k.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	if !key.ExpiresAt.IsZero() && !k.now().Before(key.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExpired, kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("%w: %s is not a %s key", ErrUnsupportedAlgorithm, kid, token.Method.Alg())
+	}
+	return key.Public(), nil
+}
+
+func (k *Keyring) find(kid string) *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Methods lists the algorithms accepted by Keyfunc: the configured one and
+// those of keys signed with before it changed.
+func (k *Keyring) Methods() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	methods := []string{k.config.Algorithm}
+	for _, key := range k.keys {
+		if !slices.Contains(methods, key.Algorithm) {
+			methods = append(methods, key.Algorithm)
+		}
+	}
+	return methods
+}
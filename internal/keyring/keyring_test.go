@@ -0,0 +1,129 @@
+package keyring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestKeyring(t *testing.T, algorithm string) (*Keyring, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	k, err := New(Config{
+		Algorithm:        algorithm,
+		RotationInterval: time.Hour,
+		Overlap:          30 * time.Minute,
+	})
+	require.NoError(t, err)
+	k.now = clock.Now
+	k.keys[0].CreatedAt = clock.now
+
+	return k, clock
+}
+
+func parse(k *Keyring, token string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, k.Keyfunc, jwt.WithValidMethods(k.Methods()))
+	return claims, err
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			k, _ := newTestKeyring(t, algorithm)
+
+			token, err := k.Sign(jwt.RegisteredClaims{Subject: "alice"})
+			require.NoError(t, err)
+
+			claims, err := parse(k, token)
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", claims.Subject)
+		})
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	_, err := New(Config{Algorithm: "HS256"})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestRotationOverlap(t *testing.T) {
+	k, clock := newTestKeyring(t, AlgorithmEdDSA)
+
+	oldToken, err := k.Sign(jwt.RegisteredClaims{Subject: "old"})
+	require.NoError(t, err)
+	oldKey := k.Active()
+
+	clock.now = clock.now.Add(time.Hour)
+	rotated, err := k.RotateIfDue()
+	require.NoError(t, err)
+	assert.True(t, rotated)
+	assert.NotEqual(t, oldKey.ID, k.Active().ID)
+	assert.Equal(t, 2, k.Active().Version)
+
+	// Old tokens are still accepted inside the overlap window
+	_, err = parse(k, oldToken)
+	assert.NoError(t, err)
+	assert.Len(t, k.Keys(), 2)
+
+	// ... and rejected once it has passed
+	clock.now = clock.now.Add(31 * time.Minute)
+	_, err = parse(k, oldToken)
+	assert.ErrorIs(t, err, ErrKeyExpired)
+	assert.Len(t, k.Keys(), 1)
+
+	rotated, err = k.RotateIfDue()
+	require.NoError(t, err)
+	assert.False(t, rotated)
+}
+
+func TestKeyfuncRejectsUnknownKid(t *testing.T) {
+	k, _ := newTestKeyring(t, AlgorithmEdDSA)
+	other, _ := newTestKeyring(t, AlgorithmEdDSA)
+
+	token, err := other.Sign(jwt.RegisteredClaims{Subject: "mallory"})
+	require.NoError(t, err)
+
+	_, err = parse(k, token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestJWKS(t *testing.T) {
+	k, clock := newTestKeyring(t, AlgorithmES256)
+	clock.now = clock.now.Add(time.Hour)
+	_, err := k.RotateIfDue()
+	require.NoError(t, err)
+
+	set, err := k.JWKS()
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 2)
+
+	for _, jwk := range set.Keys {
+		assert.Equal(t, "EC", jwk.KeyType)
+		assert.Equal(t, "P-256", jwk.Curve)
+		assert.Equal(t, AlgorithmES256, jwk.Algorithm)
+		assert.Equal(t, "sig", jwk.Use)
+		assert.NotEmpty(t, jwk.X)
+		assert.NotEmpty(t, jwk.Y)
+	}
+
+	ed, _ := newTestKeyring(t, AlgorithmEdDSA)
+	set, err = ed.JWKS()
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, "OKP", set.Keys[0].KeyType)
+	assert.Equal(t, "Ed25519", set.Keys[0].Curve)
+	assert.Equal(t, ed.Active().ID, set.Keys[0].KeyID)
+	assert.Empty(t, set.Keys[0].Y)
+}
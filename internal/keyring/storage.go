@@ -0,0 +1,125 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage keeps the keys of a keyring, so that tokens survive restarts and
+// instances sharing the storage sign and verify with the same keys.
+type Storage interface {
+	// Load returns the stored keys, or none if nothing was stored yet.
+	Load() ([]*Key, error)
+	// Save replaces the stored keys.
+	Save(keys []*Key) error
+}
+
+// storedKey is a Key as written by FileStorage.
+type storedKey struct {
+	ID         string    `json:"id"`
+	Version    int       `json:"version"`
+	Algorithm  string    `json:"algorithm"`
+	CreatedAt  time.Time `json:"created_at"`
+	RetiredAt  time.Time `json:"retired_at,omitzero"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	PrivateKey []byte    `json:"private_key"` // PKCS #8 DER
+}
+
+// FileStorage stores keys in a JSON file readable only by its owner.
+type FileStorage struct {
+	path string
+}
+
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{path: path}
+}
+
+func (s *FileStorage) Load() ([]*Key, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("corrupt signing key file: %w", err)
+	}
+
+	keys := make([]*Key, len(stored))
+	for i, s := range stored {
+		parsed, err := x509.ParsePKCS8PrivateKey(s.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", s.ID, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: key %s", ErrUnsupportedAlgorithm, s.ID)
+		}
+		keys[i] = &Key{
+			ID:        s.ID,
+			Version:   s.Version,
+			Algorithm: s.Algorithm,
+			CreatedAt: s.CreatedAt,
+			RetiredAt: s.RetiredAt,
+			ExpiresAt: s.ExpiresAt,
+			signer:    signer,
+		}
+	}
+	return keys, nil
+}
+
+// Save writes the keys to a new file and renames it over the old one, so
+// that readers never see a partial file.
+func (s *FileStorage) Save(keys []*Key) error {
+	stored := make([]storedKey, len(keys))
+	for i, key := range keys {
+		der, err := x509.MarshalPKCS8PrivateKey(key.signer)
+		if err != nil {
+			return fmt.Errorf("failed to encode signing key %s: %w", key.ID, err)
+		}
+		stored[i] = storedKey{
+			ID:         key.ID,
+			Version:    key.Version,
+			Algorithm:  key.Algorithm,
+			CreatedAt:  key.CreatedAt,
+			RetiredAt:  key.RetiredAt,
+			ExpiresAt:  key.ExpiresAt,
+			PrivateKey: der,
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	if err := os.Rename(file.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package keyring
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStoredKeyring(t *testing.T, storage Storage) *Keyring {
+	k, err := New(Config{
+		Algorithm:        AlgorithmEdDSA,
+		RotationInterval: time.Hour,
+		Overlap:          30 * time.Minute,
+		Storage:          storage,
+	})
+	require.NoError(t, err)
+	return k
+}
+
+func TestKeysSurviveRestart(t *testing.T) {
+	storage := NewFileStorage(filepath.Join(t.TempDir(), "keys.json"))
+
+	before := newStoredKeyring(t, storage)
+	token, err := before.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	require.NoError(t, err)
+
+	after := newStoredKeyring(t, storage)
+	assert.Equal(t, before.Active().ID, after.Active().ID)
+
+	claims, err := parse(after, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+}
+
+func TestRotationIsSharedThroughStorage(t *testing.T) {
+	storage := NewFileStorage(filepath.Join(t.TempDir(), "keys.json"))
+
+	a := newStoredKeyring(t, storage)
+	b := newStoredKeyring(t, storage)
+	require.Equal(t, a.Active().ID, b.Active().ID)
+
+	rotated, err := a.Rotate()
+	require.NoError(t, err)
+	token, err := a.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	require.NoError(t, err)
+
+	// b reads the new key when it first sees a token signed with it
+	b.now = func() time.Time { return time.Now().Add(reloadInterval) }
+	claims, err := parse(b, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, rotated.ID, b.Active().ID)
+
+	// Rotating b keeps a's keys in the storage
+	_, err = b.Rotate()
+	require.NoError(t, err)
+	stored, err := storage.Load()
+	require.NoError(t, err)
+	assert.Len(t, stored, 3)
+	for _, key := range stored[:2] {
+		assert.False(t, key.RetiredAt.IsZero())
+	}
+}
+
+func TestUnknownKeysReloadAtMostEveryInterval(t *testing.T) {
+	storage := NewFileStorage(filepath.Join(t.TempDir(), "keys.json"))
+
+	a := newStoredKeyring(t, storage)
+	b := newStoredKeyring(t, storage)
+
+	_, err := a.Rotate()
+	require.NoError(t, err)
+	token, err := a.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	require.NoError(t, err)
+
+	// b has just loaded the storage, so forged kids cannot make it reread
+	_, err = parse(b, token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
@@ -50,6 +50,12 @@ func (rl *IPRateLimiter) cleanupLimiter(ip string, after time.Duration) {
 	rl.mu.Unlock()
 }
 
+// Allow reports whether a request keyed by key, which need not be an IP
+// address, is within the limit.
+func (rl *IPRateLimiter) Allow(key string) bool {
+	return rl.getLimiter(key).Allow()
+}
+
 func (rl *IPRateLimiter) Middleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
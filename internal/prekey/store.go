@@ -0,0 +1,212 @@
+package prekey
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxOneTimePreKeys bounds the one-time prekeys stored per identity.
+	MaxOneTimePreKeys = 100
+	// LowWatermark is the supply below which owners are asked to upload more.
+	LowWatermark = 10
+)
+
+var (
+	ErrNotFound         = errors.New("no prekey bundle for identity")
+	ErrTooManyKeys      = errors.New("too many one-time prekeys")
+	ErrDuplicateKey     = errors.New("duplicate one-time prekey id")
+	ErrInvalidKey       = errors.New("invalid prekey")
+	ErrInvalidSignature = errors.New("invalid signed prekey signature")
+)
+
+// SignedPreKey is a medium-term X25519 key signed by the identity key.
+type SignedPreKey struct {
+	ID        uint32    `json:"id"`
+	PublicKey string    `json:"public_key"` // base64 X25519 key
+	Signature string    `json:"signature"`  // base64 Ed25519 signature over SignedPreKeyPayload
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OneTimePreKey is an X25519 key handed out to a single peer.
+type OneTimePreKey struct {
+	ID        uint32 `json:"id"`
+	PublicKey string `json:"public_key"`
+}
+
+// Bundle is what a peer fetches to start a session with an identity that
+// may be offline.
+type Bundle struct {
+	Identity      string         `json:"identity"`     // DID of the owner
+	IdentityKey   string         `json:"identity_key"` // base64 Ed25519 key that signed SignedPreKey
+	SignedPreKey  SignedPreKey   `json:"signed_prekey"`
+	OneTimePreKey *OneTimePreKey `json:"one_time_prekey,omitempty"` // absent once the supply is exhausted
+}
+
+// Store keeps prekeys per identity. FetchBundle must hand each one-time
+// prekey out at most once.
+type Store interface {
+	SetSignedPreKey(identity, identityKey string, key SignedPreKey) error
+	AddOneTimePreKeys(identity string, keys []OneTimePreKey) (int, error)
+	FetchBundle(identity string) (*Bundle, int, error)
+	Count(identity string) (int, error)
+	Delete(identity string) error
+}
+
+// SignedPreKeyPayload is the message the identity key signs for a signed
+// prekey. It binds the key to its owner and ID.
+func SignedPreKeyPayload(identity string, id uint32, publicKey string) []byte {
+	return []byte(fmt.Sprintf("kaamos-signed-prekey:%s:%d:%s", identity, id, publicKey))
+}
+
+// VerifySignedPreKey checks the key material and the signature of key.
+func VerifySignedPreKey(identity string, identityKey ed25519.PublicKey, key SignedPreKey) error {
+	if err := ValidatePreKey(key.PublicKey); err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(key.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(identityKey, SignedPreKeyPayload(identity, key.ID, key.PublicKey), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ValidatePreKey checks that publicKey is a base64 X25519 public key.
+func ValidatePreKey(publicKey string) error {
+	keyBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("%w: invalid base64 encoding", ErrInvalidKey)
+	}
+	if _, err := ecdh.X25519().NewPublicKey(keyBytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if subtle.ConstantTimeCompare(keyBytes, make([]byte, len(keyBytes))) == 1 {
+		return fmt.Errorf("%w: all-zero point", ErrInvalidKey)
+	}
+	return nil
+}
+
+type entry struct {
+	identityKey  string
+	signedPreKey SignedPreKey
+	oneTime      []OneTimePreKey
+}
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// SetSignedPreKey sets or rotates the signed prekey. A new identity key
+// discards the one-time prekeys uploaded under the old one.
+func (s *MemoryStore) SetSignedPreKey(identity, identityKey string, key SignedPreKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = s.now()
+	}
+
+	e, exists := s.entries[identity]
+	if !exists || e.identityKey != identityKey {
+		e = &entry{identityKey: identityKey}
+		s.entries[identity] = e
+	}
+	e.signedPreKey = key
+	return nil
+}
+
+// AddOneTimePreKeys appends keys and returns the new supply. Uploading
+// before a signed prekey exists fails with ErrNotFound.
+func (s *MemoryStore) AddOneTimePreKeys(identity string, keys []OneTimePreKey) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.entries[identity]
+	if !exists {
+		return 0, ErrNotFound
+	}
+	if len(e.oneTime)+len(keys) > MaxOneTimePreKeys {
+		return len(e.oneTime), ErrTooManyKeys
+	}
+
+	ids := make(map[uint32]bool, len(e.oneTime)+len(keys))
+	for _, key := range e.oneTime {
+		ids[key.ID] = true
+	}
+	for _, key := range keys {
+		if ids[key.ID] {
+			return len(e.oneTime), fmt.Errorf("%w: %d", ErrDuplicateKey, key.ID)
+		}
+		ids[key.ID] = true
+	}
+
+	e.oneTime = append(e.oneTime, keys...)
+	return len(e.oneTime), nil
+}
+
+// FetchBundle returns the bundle of identity, consuming one one-time prekey,
+// and the number of one-time prekeys left.
+func (s *MemoryStore) FetchBundle(identity string) (*Bundle, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.entries[identity]
+	if !exists {
+		return nil, 0, ErrNotFound
+	}
+
+	bundle := &Bundle{
+		Identity:     identity,
+		IdentityKey:  e.identityKey,
+		SignedPreKey: e.signedPreKey,
+	}
+	if len(e.oneTime) > 0 {
+		key := e.oneTime[0]
+		e.oneTime = e.oneTime[1:]
+		bundle.OneTimePreKey = &key
+	}
+
+	return bundle, len(e.oneTime), nil
+}
+
+func (s *MemoryStore) Count(identity string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.entries[identity]
+	if !exists {
+		return 0, ErrNotFound
+	}
+	return len(e.oneTime), nil
+}
+
+func (s *MemoryStore) Delete(identity string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[identity]; !exists {
+		return ErrNotFound
+	}
+	delete(s.entries, identity)
+	return nil
+}
@@ -0,0 +1,143 @@
+package prekey
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdentity = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+func newX25519Key(t *testing.T) string {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func oneTimeKeys(t *testing.T, first, n int) []OneTimePreKey {
+	keys := make([]OneTimePreKey, n)
+	for i := range keys {
+		keys[i] = OneTimePreKey{ID: uint32(first + i), PublicKey: newX25519Key(t)}
+	}
+	return keys
+}
+
+func TestVerifySignedPreKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	key := SignedPreKey{ID: 1, PublicKey: newX25519Key(t)}
+	key.Signature = base64.StdEncoding.EncodeToString(
+		ed25519.Sign(priv, SignedPreKeyPayload(testIdentity, key.ID, key.PublicKey)))
+
+	assert.NoError(t, VerifySignedPreKey(testIdentity, pub, key))
+	assert.ErrorIs(t, VerifySignedPreKey("did:key:other", pub, key), ErrInvalidSignature)
+
+	renumbered := key
+	renumbered.ID = 2
+	assert.ErrorIs(t, VerifySignedPreKey(testIdentity, pub, renumbered), ErrInvalidSignature)
+
+	zero := key
+	zero.PublicKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	assert.ErrorIs(t, VerifySignedPreKey(testIdentity, pub, zero), ErrInvalidKey)
+}
+
+func TestFetchBundleConsumesOneTimePreKeys(t *testing.T) {
+	store := NewMemoryStore()
+
+	_, _, err := store.FetchBundle(testIdentity)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 1, 2))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "identity-key", SignedPreKey{ID: 1, PublicKey: newX25519Key(t)}))
+	remaining, err := store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 1, 2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, remaining)
+
+	bundle, remaining, err := store.FetchBundle(testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, "identity-key", bundle.IdentityKey)
+	assert.Equal(t, uint32(1), bundle.OneTimePreKey.ID)
+	assert.Equal(t, 1, remaining)
+
+	bundle, remaining, _ = store.FetchBundle(testIdentity)
+	assert.Equal(t, uint32(2), bundle.OneTimePreKey.ID)
+	assert.Equal(t, 0, remaining)
+
+	// Without one-time prekeys the signed prekey is still served
+	bundle, _, err = store.FetchBundle(testIdentity)
+	require.NoError(t, err)
+	assert.Nil(t, bundle.OneTimePreKey)
+	assert.Equal(t, uint32(1), bundle.SignedPreKey.ID)
+}
+
+func TestAddOneTimePreKeysLimits(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "identity-key", SignedPreKey{ID: 1}))
+
+	_, err := store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 1, 3))
+	require.NoError(t, err)
+
+	_, err = store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 3, 1))
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+
+	_, err = store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 10, MaxOneTimePreKeys))
+	assert.ErrorIs(t, err, ErrTooManyKeys)
+
+	count, err := store.Count(testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestNewIdentityKeyDiscardsOneTimePreKeys(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "old-key", SignedPreKey{ID: 1}))
+	_, err := store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 1, 3))
+	require.NoError(t, err)
+
+	// Rotating the signed prekey keeps them
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "old-key", SignedPreKey{ID: 2}))
+	count, _ := store.Count(testIdentity)
+	assert.Equal(t, 3, count)
+
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "new-key", SignedPreKey{ID: 3}))
+	count, _ = store.Count(testIdentity)
+	assert.Equal(t, 0, count)
+}
+
+func TestFetchBundleConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, store.SetSignedPreKey(testIdentity, "identity-key", SignedPreKey{ID: 1}))
+	_, err := store.AddOneTimePreKeys(testIdentity, oneTimeKeys(t, 1, 50))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	seen := make(map[uint32]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 80; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bundle, _, err := store.FetchBundle(testIdentity)
+			if err != nil || bundle.OneTimePreKey == nil {
+				return
+			}
+			mu.Lock()
+			seen[bundle.OneTimePreKey.ID]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, 50)
+	for id, n := range seen {
+		assert.Equal(t, 1, n, "prekey %d handed out %d times", id, n)
+	}
+}
@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS rooms (
+	slug       TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	settings   TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	bans       TEXT NOT NULL DEFAULT '[]'
+);
+CREATE INDEX IF NOT EXISTS rooms_owner ON rooms (owner);
+`
+
+// sqliteMigrations bring databases created by earlier versions up to
+// sqliteSchema. Each one is skipped when its column already exists.
+var sqliteMigrations = []struct{ column, statement string }{
+	{"bans", `ALTER TABLE rooms ADD COLUMN bans TEXT NOT NULL DEFAULT '[]'`},
+}
+
+// SQLiteStore is a Store kept in a SQLite database, so rooms survive
+// restarts. The driver is pure Go, so it works in cgo-less builds.
+type SQLiteStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewSQLiteStore opens the database at path, creating it if needed.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time; a single connection avoids
+	// "database is locked" errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create room registry schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate room registry schema: %w", err)
+	}
+	return &SQLiteStore{db: db, now: time.Now}, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	for _, migration := range sqliteMigrations {
+		var exists bool
+		err := db.QueryRow(
+			`SELECT COUNT(*) > 0 FROM pragma_table_info('rooms') WHERE name = ?`, migration.column,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(migration.statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Create(room *Room) error {
+	settings, err := json.Marshal(room.Settings)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM rooms WHERE expires_at <= ?`, s.now().UnixNano()); err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		`INSERT OR IGNORE INTO rooms (slug, owner, settings, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		room.Slug, room.Owner, string(settings), room.CreatedAt.UnixNano(), room.ExpiresAt.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 {
+		return ErrExists
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Get(slug string) (*Room, error) {
+	row := s.db.QueryRow(
+		`SELECT slug, owner, settings, created_at, expires_at, bans FROM rooms WHERE slug = ? AND expires_at > ?`,
+		slug, s.now().UnixNano(),
+	)
+	room, err := scanRoom(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return room, err
+}
+
+func (s *SQLiteStore) List(owner string) ([]*Room, error) {
+	rows, err := s.db.Query(
+		`SELECT slug, owner, settings, created_at, expires_at, bans FROM rooms WHERE owner = ? AND expires_at > ? ORDER BY created_at`,
+		owner, s.now().UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []*Room{}
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// Update replaces the settings and expiry of the room; its owner and creation
+// time do not change.
+func (s *SQLiteStore) Update(room *Room) error {
+	settings, err := json.Marshal(room.Settings)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`UPDATE rooms SET settings = ?, expires_at = ? WHERE slug = ? AND expires_at > ?`,
+		string(settings), room.ExpiresAt.UnixNano(), room.Slug, s.now().UnixNano(),
+	)
+	return affectedOne(result, err)
+}
+
+// UpdateBans replaces the bans of the room.
+func (s *SQLiteStore) UpdateBans(slug string, bans []signaling.Ban) error {
+	if bans == nil {
+		bans = []signaling.Ban{}
+	}
+	encoded, err := json.Marshal(bans)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`UPDATE rooms SET bans = ? WHERE slug = ? AND expires_at > ?`,
+		string(encoded), slug, s.now().UnixNano(),
+	)
+	return affectedOne(result, err)
+}
+
+func (s *SQLiteStore) Delete(slug string) error {
+	result, err := s.db.Exec(`DELETE FROM rooms WHERE slug = ? AND expires_at > ?`, slug, s.now().UnixNano())
+	return affectedOne(result, err)
+}
+
+// affectedOne turns a statement that matched no room into ErrNotFound.
+func affectedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanRoom(row interface{ Scan(...any) error }) (*Room, error) {
+	var (
+		room                 Room
+		settings, bans       string
+		createdAt, expiresAt int64
+	)
+	if err := row.Scan(&room.Slug, &room.Owner, &settings, &createdAt, &expiresAt, &bans); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(settings), &room.Settings); err != nil {
+		return nil, fmt.Errorf("decode settings of room %s: %w", room.Slug, err)
+	}
+	if err := json.Unmarshal([]byte(bans), &room.Bans); err != nil {
+		return nil, fmt.Errorf("decode bans of room %s: %w", room.Slug, err)
+	}
+	room.CreatedAt = time.Unix(0, createdAt)
+	room.ExpiresAt = time.Unix(0, expiresAt)
+	return &room, nil
+}
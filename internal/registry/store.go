@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/signaling"
+)
+
+var (
+	ErrNotFound = errors.New("room not found")
+	ErrExists   = errors.New("room already exists")
+)
+
+// Room is a room created through the API. Rooms that are not registered
+// cannot be joined; expired rooms count as not registered and their slug can
+// be reused.
+type Room struct {
+	Slug      string                 `json:"slug"`
+	Owner     string                 `json:"-"` // DID of the host, or the jti of its host token for anonymous hosts
+	Settings  signaling.RoomSettings `json:"settings"`
+	CreatedAt time.Time              `json:"created_at"`
+	ExpiresAt time.Time              `json:"expires_at"`
+	Bans      []signaling.Ban        `json:"-"` // kept only by the server, they name addresses
+}
+
+// Expired reports whether the room has expired at now.
+func (r *Room) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+func (r *Room) copy() *Room {
+	copied := *r
+	copied.Settings.AllowedRoles = append([]signaling.ParticipantRole(nil), r.Settings.AllowedRoles...)
+	copied.Bans = append([]signaling.Ban(nil), r.Bans...)
+	return &copied
+}
+
+// Store keeps registered rooms. Get, List, Update, UpdateBans and Delete
+// treat expired rooms as missing.
+type Store interface {
+	Create(room *Room) error
+	Get(slug string) (*Room, error)
+	List(owner string) ([]*Room, error)
+	Update(room *Room) error
+	UpdateBans(slug string, bans []signaling.Ban) error
+	Delete(slug string) error
+}
+
+// MemoryStore is an in-process Store. Its rooms are lost on restart; use
+// SQLiteStore to keep them.
+type MemoryStore struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+	now   func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rooms: make(map[string]*Room),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryStore) Create(room *Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if _, exists := s.rooms[room.Slug]; exists {
+		return ErrExists
+	}
+	s.rooms[room.Slug] = room.copy()
+	return nil
+}
+
+func (s *MemoryStore) Get(slug string) (*Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, err := s.getLocked(slug)
+	if err != nil {
+		return nil, err
+	}
+	return room.copy(), nil
+}
+
+func (s *MemoryStore) List(owner string) ([]*Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	rooms := []*Room{}
+	for _, room := range s.rooms {
+		if room.Owner == owner && !room.Expired(now) {
+			rooms = append(rooms, room.copy())
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms, nil
+}
+
+// Update replaces the settings and expiry of the room; its owner and creation
+// time do not change.
+func (s *MemoryStore) Update(room *Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getLocked(room.Slug)
+	if err != nil {
+		return err
+	}
+	updated := room.copy()
+	stored.Settings = updated.Settings
+	stored.ExpiresAt = updated.ExpiresAt
+	return nil
+}
+
+// UpdateBans replaces the bans of the room.
+func (s *MemoryStore) UpdateBans(slug string, bans []signaling.Ban) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getLocked(slug)
+	if err != nil {
+		return err
+	}
+	stored.Bans = append([]signaling.Ban(nil), bans...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getLocked(slug); err != nil {
+		return err
+	}
+	delete(s.rooms, slug)
+	return nil
+}
+
+// getLocked returns the stored room, not a copy. The caller must hold the
+// mutex.
+func (s *MemoryStore) getLocked(slug string) (*Room, error) {
+	room, exists := s.rooms[slug]
+	if !exists || room.Expired(s.now()) {
+		return nil, ErrNotFound
+	}
+	return room, nil
+}
+
+// pruneLocked drops expired rooms. The caller must hold the mutex.
+func (s *MemoryStore) pruneLocked() {
+	now := s.now()
+	for slug, room := range s.rooms {
+		if room.Expired(now) {
+			delete(s.rooms, slug)
+		}
+	}
+}
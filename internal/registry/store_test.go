@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/signaling"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forEachStore runs test against both stores with a clock it can move.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store, advance func(time.Duration))) {
+	t.Run("memory", func(t *testing.T) {
+		store := NewMemoryStore()
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		test(t, store, func(d time.Duration) { now = now.Add(d) })
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "rooms.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		test(t, store, func(d time.Duration) { now = now.Add(d) })
+	})
+}
+
+func newRoom(slug, owner string, ttl time.Duration) *Room {
+	return &Room{
+		Slug:      slug,
+		Owner:     owner,
+		Settings:  signaling.DefaultRoomSettings(),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+}
+
+func TestCreateAndGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, _ func(time.Duration)) {
+		room := newRoom("room-1", "did:key:alice", time.Hour)
+		room.Settings.AllowedRoles = []signaling.ParticipantRole{signaling.RoleGuest}
+		require.NoError(t, store.Create(room))
+		room.Settings.AllowedRoles[0] = signaling.RoleViewer
+
+		stored, err := store.Get("room-1")
+		require.NoError(t, err)
+		assert.Equal(t, "did:key:alice", stored.Owner)
+		assert.Equal(t, []signaling.ParticipantRole{signaling.RoleGuest}, stored.Settings.AllowedRoles)
+		assert.True(t, stored.ExpiresAt.Equal(room.ExpiresAt))
+
+		assert.ErrorIs(t, store.Create(newRoom("room-1", "someone-else", time.Hour)), ErrExists)
+		_, err = store.Get("missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestListByOwner(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, _ func(time.Duration)) {
+		first := newRoom("first", "alice", time.Hour)
+		second := newRoom("second", "alice", time.Hour)
+		second.CreatedAt = first.CreatedAt.Add(time.Second)
+		require.NoError(t, store.Create(second))
+		require.NoError(t, store.Create(first))
+		require.NoError(t, store.Create(newRoom("other", "bob", time.Hour)))
+
+		rooms, err := store.List("alice")
+		require.NoError(t, err)
+		require.Len(t, rooms, 2)
+		assert.Equal(t, "first", rooms[0].Slug)
+		assert.Equal(t, "second", rooms[1].Slug)
+
+		rooms, err = store.List("nobody")
+		require.NoError(t, err)
+		assert.Empty(t, rooms)
+	})
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, _ func(time.Duration)) {
+		require.NoError(t, store.Create(newRoom("room-1", "alice", time.Hour)))
+
+		update := newRoom("room-1", "mallory", 2*time.Hour)
+		update.Settings.Locked = true
+		require.NoError(t, store.Update(update))
+
+		stored, err := store.Get("room-1")
+		require.NoError(t, err)
+		assert.True(t, stored.Settings.Locked)
+		assert.True(t, stored.ExpiresAt.Equal(update.ExpiresAt))
+		assert.Equal(t, "alice", stored.Owner, "the owner does not change")
+
+		require.NoError(t, store.Delete("room-1"))
+		_, err = store.Get("room-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, store.Delete("room-1"), ErrNotFound)
+		assert.ErrorIs(t, store.Update(update), ErrNotFound)
+	})
+}
+
+func TestUpdateBans(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, _ func(time.Duration)) {
+		require.NoError(t, store.Create(newRoom("room-1", "alice", time.Hour)))
+
+		ban := signaling.Ban{ParticipantID: "guest-1", Subject: "invite:abc", IP: "203.0.113.7", Reason: signaling.RemovalReasonSpam}
+		require.NoError(t, store.UpdateBans("room-1", []signaling.Ban{ban}))
+		require.NoError(t, store.Update(newRoom("room-1", "alice", 2*time.Hour)))
+
+		stored, err := store.Get("room-1")
+		require.NoError(t, err)
+		assert.Equal(t, []signaling.Ban{ban}, stored.Bans, "settings updates keep the bans")
+
+		require.NoError(t, store.UpdateBans("room-1", nil))
+		stored, err = store.Get("room-1")
+		require.NoError(t, err)
+		assert.Empty(t, stored.Bans)
+		assert.ErrorIs(t, store.UpdateBans("missing", nil), ErrNotFound)
+	})
+}
+
+func TestExpiredRooms(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, advance func(time.Duration)) {
+		require.NoError(t, store.Create(newRoom("room-1", "alice", time.Hour)))
+		advance(2 * time.Hour)
+
+		_, err := store.Get("room-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		rooms, err := store.List("alice")
+		require.NoError(t, err)
+		assert.Empty(t, rooms)
+		assert.ErrorIs(t, store.Update(newRoom("room-1", "alice", 3*time.Hour)), ErrNotFound)
+
+		assert.NoError(t, store.Create(newRoom("room-1", "bob", 3*time.Hour)), "expired slugs can be reused")
+		stored, err := store.Get("room-1")
+		require.NoError(t, err)
+		assert.Equal(t, "bob", stored.Owner)
+	})
+}
+
+func TestSQLiteStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.db")
+	store, err := NewSQLiteStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(newRoom("room-1", "alice", time.Hour)))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewSQLiteStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	room, err := reopened.Get("room-1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", room.Owner)
+}
+
+func TestSQLiteStoreMigratesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE rooms (
+		slug TEXT PRIMARY KEY, owner TEXT NOT NULL, settings TEXT NOT NULL,
+		created_at INTEGER NOT NULL, expires_at INTEGER NOT NULL
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO rooms VALUES ('room-1', 'alice', '{}', 0, ?)`, time.Now().Add(time.Hour).UnixNano())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err := NewSQLiteStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	room, err := store.Get("room-1")
+	require.NoError(t, err)
+	assert.Empty(t, room.Bans)
+	assert.NoError(t, store.UpdateBans("room-1", []signaling.Ban{{ParticipantID: "guest-1"}}))
+}
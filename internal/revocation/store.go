@@ -0,0 +1,108 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// Token identifies an issued token for revocation checks.
+type Token struct {
+	ID       string // jti
+	Slug     string
+	IssuedAt time.Time
+}
+
+// Store is a token denylist. Entries only need to be kept until the tokens
+// they cover would have expired anyway.
+type Store interface {
+	// Revoke denylists a single token ID until expiresAt.
+	Revoke(tokenID string, expiresAt time.Time) error
+	// RevokeRoom revokes every token for slug issued at or before cutoff,
+	// except the token with ID keep. The entry is kept until expiresAt.
+	RevokeRoom(slug string, cutoff time.Time, keep string, expiresAt time.Time) error
+	// IsRevoked reports whether the token has been revoked.
+	IsRevoked(token Token) (bool, error)
+}
+
+type roomRevocation struct {
+	cutoff    time.Time
+	keep      string
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-process Store. It does not survive restarts and is
+// not shared between instances.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	rooms  map[string]roomRevocation
+	now    func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[string]time.Time),
+		rooms:  make(map[string]roomRevocation),
+		now:    time.Now,
+	}
+}
+
+func (s *MemoryStore) Revoke(tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if current, exists := s.tokens[tokenID]; !exists || expiresAt.After(current) {
+		s.tokens[tokenID] = expiresAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeRoom(slug string, cutoff time.Time, keep string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	s.rooms[slug] = roomRevocation{
+		cutoff:    cutoff,
+		keep:      keep,
+		expiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(token Token) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+
+	if expiresAt, exists := s.tokens[token.ID]; exists && now.Before(expiresAt) {
+		return true, nil
+	}
+
+	room, exists := s.rooms[token.Slug]
+	if !exists || !now.Before(room.expiresAt) {
+		return false, nil
+	}
+	if token.ID != "" && token.ID == room.keep {
+		return false, nil
+	}
+	return !token.IssuedAt.After(room.cutoff), nil
+}
+
+// pruneLocked drops entries whose tokens have expired on their own.
+func (s *MemoryStore) pruneLocked() {
+	now := s.now()
+
+	for id, expiresAt := range s.tokens {
+		if !now.Before(expiresAt) {
+			delete(s.tokens, id)
+		}
+	}
+	for slug, room := range s.rooms {
+		if !now.Before(room.expiresAt) {
+			delete(s.rooms, slug)
+		}
+	}
+}
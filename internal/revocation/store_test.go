@@ -0,0 +1,75 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeToken(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+
+	token := Token{ID: "jti-1", Slug: "room", IssuedAt: now}
+
+	revoked, err := store.IsRevoked(token)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, store.Revoke("jti-1", now.Add(time.Hour)))
+
+	revoked, err = store.IsRevoked(token)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = store.IsRevoked(Token{ID: "jti-2", Slug: "room", IssuedAt: now})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevokeRoom(t *testing.T) {
+	store := NewMemoryStore()
+	cutoff := time.Now()
+
+	assert.NoError(t, store.RevokeRoom("room", cutoff, "host-jti", cutoff.Add(time.Hour)))
+
+	tests := []struct {
+		name    string
+		token   Token
+		revoked bool
+	}{
+		{"issued before cutoff", Token{ID: "a", Slug: "room", IssuedAt: cutoff.Add(-time.Minute)}, true},
+		{"issued at cutoff", Token{ID: "b", Slug: "room", IssuedAt: cutoff}, true},
+		{"issued after cutoff", Token{ID: "c", Slug: "room", IssuedAt: cutoff.Add(time.Second)}, false},
+		{"kept token", Token{ID: "host-jti", Slug: "room", IssuedAt: cutoff.Add(-time.Minute)}, false},
+		{"other room", Token{ID: "d", Slug: "other", IssuedAt: cutoff.Add(-time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := store.IsRevoked(tt.token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.revoked, revoked)
+		})
+	}
+}
+
+func TestExpiredEntriesArePruned(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	assert.NoError(t, store.Revoke("old", now.Add(time.Minute)))
+	assert.NoError(t, store.RevokeRoom("room", now, "", now.Add(time.Minute)))
+
+	now = now.Add(2 * time.Minute)
+
+	revoked, err := store.IsRevoked(Token{ID: "old", Slug: "room", IssuedAt: now.Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, store.Revoke("new", now.Add(time.Minute)))
+	assert.Len(t, store.tokens, 1)
+	assert.Len(t, store.rooms, 0)
+}
@@ -0,0 +1,169 @@
+package signaling
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every message relayed or emitted in a room carries a per-room sequence
+// number. Clients may give their messages an ID, which makes resending them
+// idempotent, and set ack to learn whether a message reached its recipient.
+
+const maxSeenMessageIDs = 1024
+
+const (
+	AckStatusDelivered = "delivered" // written to the recipient's connection
+	AckStatusAccepted  = "accepted"  // processed; it had no single recipient
+	AckStatusDuplicate = "duplicate" // the ID was seen before, nothing was done
+
+	DeliveryFailedRecipientGone = "recipient_gone"
+	DeliveryFailedSlowConsumer  = "slow_consumer"
+	DeliveryFailedWriteFailed   = "write_failed"
+)
+
+var (
+	errRecipientGone = errors.New("recipient is not connected")
+	errQueueFull     = errors.New("recipient's send queue is full")
+	errSlowConsumer  = errors.New("recipient was disconnected as a slow consumer")
+)
+
+type AckData struct {
+	ID     string `json:"id"`
+	Seq    uint64 `json:"seq"`
+	Status string `json:"status"`
+}
+
+type DeliveryFailedData struct {
+	ID     string `json:"id"`
+	Seq    uint64 `json:"seq"`
+	To     string `json:"to,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// receipt reports the outcome of a message whose sender asked for an ack.
+// Exactly one ack or delivery_failed is sent per receipt.
+type receipt struct {
+	sender  *Participant
+	id      string
+	seq     uint64
+	to      string
+	claimed atomic.Bool // a delivery will report the outcome
+	once    sync.Once
+}
+
+func newReceipt(sender *Participant, message *Message) *receipt {
+	if !message.Ack || message.ID == "" {
+		return nil
+	}
+	return &receipt{sender: sender, id: message.ID, seq: message.Seq, to: message.To}
+}
+
+// claim marks the receipt as reported by a delivery rather than by the
+// handler having run.
+func (r *receipt) claim() *receipt {
+	if r != nil {
+		r.claimed.Store(true)
+	}
+	return r
+}
+
+// report acks a delivery, or tells the sender why it failed.
+func (r *receipt) report(err error) {
+	if r == nil {
+		return
+	}
+	r.claimed.Store(true)
+	r.once.Do(func() {
+		if err == nil {
+			r.sendAck(AckStatusDelivered)
+			return
+		}
+
+		reason := DeliveryFailedWriteFailed
+		switch err {
+		case errRecipientGone:
+			reason = DeliveryFailedRecipientGone
+		case errQueueFull, errSlowConsumer:
+			reason = DeliveryFailedSlowConsumer
+		}
+		r.sender.Send(&Message{
+			Type:      MessageTypeDeliveryFailed,
+			Data:      DeliveryFailedData{ID: r.id, Seq: r.seq, To: r.to, Reason: reason},
+			Timestamp: time.Now(),
+		})
+	})
+}
+
+// accept acks a message no delivery reported on.
+func (r *receipt) accept() {
+	if r == nil || r.claimed.Load() {
+		return
+	}
+	r.once.Do(func() {
+		r.sendAck(AckStatusAccepted)
+	})
+}
+
+func (r *receipt) sendAck(status string) {
+	r.sender.Send(&Message{
+		Type:      MessageTypeAck,
+		Data:      AckData{ID: r.id, Seq: r.seq, Status: status},
+		Timestamp: time.Now(),
+	})
+}
+
+// nextSeq returns the next sequence number of the room.
+func (r *Room) nextSeq() uint64 {
+	return r.seq.Add(1)
+}
+
+// stamp gives a message emitted in the room a sequence number, unless it
+// already has one.
+func (r *Room) stamp(message *Message) {
+	if message.Seq == 0 {
+		message.Seq = r.nextSeq()
+	}
+}
+
+// seenMessage records a client message ID and returns the sequence number it
+// was first handled with, if it was seen before.
+func (r *Room) seenMessage(senderID, messageID string, seq uint64) (uint64, bool) {
+	r.seenMutex.Lock()
+	defer r.seenMutex.Unlock()
+
+	key := senderID + "\x00" + messageID
+	if first, exists := r.seenIDs[key]; exists {
+		return first, true
+	}
+
+	if r.seenIDs == nil {
+		r.seenIDs = make(map[string]uint64)
+	}
+	if len(r.seenOrder) >= maxSeenMessageIDs {
+		delete(r.seenIDs, r.seenOrder[0])
+		r.seenOrder = r.seenOrder[1:]
+	}
+	r.seenIDs[key] = seq
+	r.seenOrder = append(r.seenOrder, key)
+	return 0, false
+}
+
+// deliverTo sends a message to one participant and reports the outcome to
+// receipt.
+func (r *Room) deliverTo(participantID string, message *Message, receipt *receipt) bool {
+	r.mutex.RLock()
+	participant := r.findParticipant(participantID)
+	if participant != nil {
+		r.stamp(message)
+	}
+	r.mutex.RUnlock()
+
+	if participant == nil {
+		receipt.report(errRecipientGone)
+		return false
+	}
+	participant.deliver(message, receipt)
+	return true
+}
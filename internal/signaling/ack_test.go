@@ -0,0 +1,143 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// captureMessage records the next message of the given type written to conn.
+func captureMessage(conn *MockWebSocketConn, messageType MessageType, returnErr error) *Message {
+	captured := &Message{}
+	conn.On("WriteJSON", isMessageType(messageType)).Run(func(args mock.Arguments) {
+		*captured = *args.Get(0).(*Message)
+	}).Return(returnErr).Once()
+	return captured
+}
+
+func TestMessagesGetIncreasingSequenceNumbers(t *testing.T) {
+	server := NewServer()
+	_, guest, _, hostConn := keyExchangeRoom(t, server)
+
+	first := captureMessage(hostConn, MessageTypeOffer, nil)
+	second := captureMessage(hostConn, MessageTypeOffer, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "host1"})
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "host1", Seq: 1})
+
+	assert.NotZero(t, first.Seq)
+	assert.Greater(t, second.Seq, first.Seq)
+	hostConn.AssertExpectations(t)
+}
+
+func TestAckOnDelivery(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	offer := captureMessage(hostConn, MessageTypeOffer, nil)
+	ack := captureMessage(guestConn, MessageTypeAck, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "host1", ID: "m1", Ack: true})
+
+	assert.Equal(t, "m1", offer.ID)
+	require.IsType(t, AckData{}, ack.Data)
+	assert.Equal(t, AckData{ID: "m1", Seq: offer.Seq, Status: AckStatusDelivered}, ack.Data)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestDeliveryFailedWhenRecipientGone(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, _ := keyExchangeRoom(t, server)
+
+	failed := captureMessage(guestConn, MessageTypeDeliveryFailed, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "nobody", ID: "m1", Ack: true})
+
+	require.IsType(t, DeliveryFailedData{}, failed.Data)
+	data := failed.Data.(DeliveryFailedData)
+	assert.Equal(t, "m1", data.ID)
+	assert.Equal(t, "nobody", data.To)
+	assert.Equal(t, DeliveryFailedRecipientGone, data.Reason)
+	guestConn.AssertExpectations(t)
+}
+
+func TestDeliveryFailedWhenWriteFails(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	captureMessage(hostConn, MessageTypeAnswer, errors.New("broken pipe"))
+	failed := captureMessage(guestConn, MessageTypeDeliveryFailed, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeAnswer, To: "host1", ID: "m1", Ack: true})
+
+	require.IsType(t, DeliveryFailedData{}, failed.Data)
+	assert.Equal(t, DeliveryFailedWriteFailed, failed.Data.(DeliveryFailedData).Reason)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestDuplicateMessageIDIsNotRelayed(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	offer := captureMessage(hostConn, MessageTypeOffer, nil)
+	captureMessage(guestConn, MessageTypeAck, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "host1", ID: "m1", Ack: true})
+
+	duplicate := captureMessage(guestConn, MessageTypeAck, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeOffer, To: "host1", ID: "m1", Ack: true})
+
+	assert.Equal(t, AckData{ID: "m1", Seq: offer.Seq, Status: AckStatusDuplicate}, duplicate.Data)
+	hostConn.AssertNumberOfCalls(t, "WriteJSON", 1)
+	guestConn.AssertExpectations(t)
+}
+
+func TestAckForBroadcast(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	captureMessage(hostConn, MessageTypeICECandidate, nil)
+	ack := captureMessage(guestConn, MessageTypeAck, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeICECandidate, ID: "m1", Ack: true})
+
+	require.IsType(t, AckData{}, ack.Data)
+	assert.Equal(t, AckStatusAccepted, ack.Data.(AckData).Status)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestAckForAllow(t *testing.T) {
+	server := NewServer()
+	room, _, _, hostConn := keyExchangeRoom(t, server)
+	host := room.GetParticipant("host1")
+
+	waitingConn := &MockWebSocketConn{}
+	require.NoError(t, room.AddParticipant(&Participant{ID: "guest2", Conn: waitingConn, Role: RoleGuest}))
+
+	captureMessage(waitingConn, MessageTypeAllow, nil)
+	waitingConn.On("WriteJSON", isMessageType(MessageTypeParticipants)).Return(nil)
+	hostConn.On("WriteJSON", isMessageType(MessageTypeParticipants)).Return(nil)
+	hostConn.On("WriteJSON", isMessageType(MessageTypeLobby)).Return(nil)
+	room.GetParticipant("guest1").Conn.(*MockWebSocketConn).On("WriteJSON", isMessageType(MessageTypeParticipants)).Return(nil)
+	ack := captureMessage(hostConn, MessageTypeAck, nil)
+
+	server.handleMessage("test-room", host, &Message{Type: MessageTypeAllow, Data: "guest2", ID: "a1", Ack: true})
+
+	require.IsType(t, AckData{}, ack.Data)
+	assert.Equal(t, AckStatusDelivered, ack.Data.(AckData).Status)
+	waitingConn.AssertExpectations(t)
+}
+
+func TestSeenMessageIDsAreBounded(t *testing.T) {
+	room := NewRoom("test-room")
+	_, seen := room.seenMessage("p1", "first", 1)
+	assert.False(t, seen)
+
+	for i := 0; i < maxSeenMessageIDs; i++ {
+		room.seenMessage("p1", fmt.Sprintf("m%d", i), uint64(i+2))
+	}
+
+	_, seen = room.seenMessage("p1", "first", 9999)
+	assert.False(t, seen)
+}
@@ -0,0 +1,197 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenQueryParam is the query parameter carrying the access token.
+	TokenQueryParam = "token"
+	// TokenCookieName is the cookie carrying the access token.
+	TokenCookieName = "kaamos_token"
+	// TokenProtocolPrefix prefixes the access token when it is sent as a
+	// Sec-WebSocket-Protocol entry, e.g. "kaamos.bearer.<jwt>". Browsers require
+	// the server to select one of the offered protocols, so such clients must
+	// also offer a protocol version or the plain BaseProtocol.
+	TokenProtocolPrefix = "kaamos.bearer."
+	// BaseProtocol is the unversioned subprotocol, standing for v1.
+	BaseProtocol = "kaamos"
+
+	ErrCodeTokenMissing    = "TOKEN_MISSING"
+	ErrCodeTokenInvalid    = "TOKEN_INVALID"
+	ErrCodeTokenExpired    = "TOKEN_EXPIRED"
+	ErrCodeTokenRevoked    = "TOKEN_REVOKED"
+	ErrCodeSlugMismatch    = "SLUG_MISMATCH"
+	ErrCodeRoleMismatch    = "ROLE_MISMATCH"
+	ErrCodeInvalidRole     = "INVALID_ROLE"
+	ErrCodeAuthUnavailable = "AUTH_UNAVAILABLE"
+)
+
+var (
+	// ErrTokenExpired is returned (possibly wrapped) by a TokenVerifier when
+	// the token was valid but is past its expiry.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenRevoked is returned (possibly wrapped) by a TokenVerifier when
+	// the token was valid but has been revoked.
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// errTokenRevokedData tells participants why their session was closed after
+// a revocation.
+var errTokenRevokedData = ErrorData{Code: ErrCodeTokenRevoked, Message: "access token revoked"}
+
+// TokenClaims is the subset of access token claims the signaling server relies on.
+type TokenClaims struct {
+	ID        string // jti
+	Slug      string
+	Role      ParticipantRole
+	Subject   string
+	Name      string // display name pre-assigned to the participant, if any
+	DID       string // identity bound to the token via did:key login, if any
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenVerifier validates access tokens presented on the /ws handshake.
+type TokenVerifier interface {
+	VerifyToken(token string) (*TokenClaims, error)
+}
+
+// handshakeError describes why a /ws handshake was refused before the upgrade.
+type handshakeError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *handshakeError) Error() string {
+	return e.message
+}
+
+func writeHandshakeError(w http.ResponseWriter, herr *handshakeError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(herr.status)
+	json.NewEncoder(w).Encode(ErrorData{
+		Code:    herr.code,
+		Message: herr.message,
+	})
+}
+
+// tokenFromRequest extracts the access token from the query string, the
+// Sec-WebSocket-Protocol header or the token cookie, in that order.
+func tokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get(TokenQueryParam); token != "" {
+		return token
+	}
+
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, TokenProtocolPrefix) {
+				return strings.TrimPrefix(protocol, TokenProtocolPrefix)
+			}
+		}
+	}
+
+	if cookie, err := r.Cookie(TokenCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+func parseRole(roleStr string) (ParticipantRole, bool) {
+	switch ParticipantRole(roleStr) {
+	case RoleHost:
+		return RoleHost, true
+	case RoleGuest:
+		return RoleGuest, true
+	default:
+		return "", false
+	}
+}
+
+// authenticate verifies the handshake token and checks the optional slug and
+// role query parameters against its claims.
+func (s *Server) authenticate(r *http.Request) (*TokenClaims, *handshakeError) {
+	if s.verifier == nil {
+		return nil, &handshakeError{http.StatusServiceUnavailable, ErrCodeAuthUnavailable, "token verification is not configured"}
+	}
+
+	token := tokenFromRequest(r)
+	if token == "" {
+		return nil, &handshakeError{http.StatusUnauthorized, ErrCodeTokenMissing, "missing access token"}
+	}
+
+	claims, err := s.verifier.VerifyToken(token)
+	if err != nil {
+		if errors.Is(err, ErrTokenExpired) {
+			return nil, &handshakeError{http.StatusUnauthorized, ErrCodeTokenExpired, "access token expired"}
+		}
+		if errors.Is(err, ErrTokenRevoked) {
+			return nil, &handshakeError{http.StatusUnauthorized, ErrCodeTokenRevoked, "access token revoked"}
+		}
+		return nil, &handshakeError{http.StatusUnauthorized, ErrCodeTokenInvalid, "invalid access token"}
+	}
+
+	role, ok := parseRole(string(claims.Role))
+	if !ok || claims.Slug == "" {
+		return nil, &handshakeError{http.StatusUnauthorized, ErrCodeTokenInvalid, "access token has no room or role"}
+	}
+	claims.Role = role
+
+	query := r.URL.Query()
+	if slug := query.Get("slug"); slug != "" && slug != claims.Slug {
+		return nil, &handshakeError{http.StatusForbidden, ErrCodeSlugMismatch, "token was issued for a different room"}
+	}
+	if roleStr := query.Get("role"); roleStr != "" {
+		requested, ok := parseRole(roleStr)
+		if !ok {
+			return nil, &handshakeError{http.StatusBadRequest, ErrCodeInvalidRole, "role must be host or guest"}
+		}
+		if requested != claims.Role {
+			return nil, &handshakeError{http.StatusForbidden, ErrCodeRoleMismatch, "token was issued for a different role"}
+		}
+	}
+
+	return claims, nil
+}
+
+// DisconnectToken closes every live session that was opened with the token
+// tokenID and returns how many were closed.
+func (s *Server) DisconnectToken(tokenID string) int {
+	if tokenID == "" {
+		return 0
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	closed := 0
+	for _, room := range s.rooms {
+		closed += room.disconnectMatching(func(p *Participant) bool {
+			return p.TokenID == tokenID
+		}, errTokenRevokedData)
+	}
+	return closed
+}
+
+// DisconnectRoom closes every live session in the room except the one opened
+// with exceptTokenID and returns how many were closed.
+func (s *Server) DisconnectRoom(slug, exceptTokenID string) int {
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return 0
+	}
+
+	return room.disconnectMatching(func(p *Participant) bool {
+		return exceptTokenID == "" || p.TokenID != exceptTokenID
+	}, errTokenRevokedData)
+}
@@ -0,0 +1,239 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Participants allowed to kick can remove anyone but the host from the room
+// with kick, or also keep them out with ban. A ban matches later joiners by
+// DID, token subject, token ID and, when asked for, IP address.
+//
+// With a RoomRegistry, bans are saved with the registered room and last as
+// long as it does. Otherwise they are dropped with the Room once the last
+// participant leaves.
+
+const (
+	ErrCodeBanned        = "BANNED"
+	ErrCodeRemovalFailed = "REMOVAL_FAILED"
+)
+
+// RemovalReason tells a kicked or banned participant why.
+type RemovalReason string
+
+const (
+	RemovalReasonDisruptive    RemovalReason = "disruptive"
+	RemovalReasonSpam          RemovalReason = "spam"
+	RemovalReasonHarassment    RemovalReason = "harassment"
+	RemovalReasonImpersonation RemovalReason = "impersonation"
+	RemovalReasonOther         RemovalReason = "other"
+)
+
+func (r RemovalReason) valid() bool {
+	switch r {
+	case RemovalReasonDisruptive, RemovalReasonSpam, RemovalReasonHarassment,
+		RemovalReasonImpersonation, RemovalReasonOther:
+		return true
+	}
+	return false
+}
+
+// RemovalData is the payload of kick and ban. The removed participant gets
+// it back with the message set by the moderator, if any.
+type RemovalData struct {
+	ParticipantID string        `json:"participant_id"`
+	Reason        RemovalReason `json:"reason,omitempty"` // RemovalReasonOther if empty
+	Message       string        `json:"message,omitempty"`
+	BanIP         bool          `json:"ban_ip,omitempty"` // ban only: also match the IP address
+}
+
+func (d RemovalData) Validate() error {
+	if d.ParticipantID == "" {
+		return errors.New("participant_id is required")
+	}
+	if d.Reason != "" && !d.Reason.valid() {
+		return fmt.Errorf("unknown reason %q", d.Reason)
+	}
+	return nil
+}
+
+// Ban keeps a participant out of a room.
+type Ban struct {
+	ParticipantID string        `json:"participant_id"`
+	DID           string        `json:"did,omitempty"`
+	Subject       string        `json:"subject,omitempty"`
+	TokenID       string        `json:"token_id,omitempty"`
+	IP            string        `json:"ip,omitempty"`
+	Reason        RemovalReason `json:"reason"`
+	By            string        `json:"by"`
+	At            time.Time     `json:"at"`
+}
+
+func (b *Ban) matches(p *Participant) bool {
+	return (b.DID != "" && b.DID == p.DID) ||
+		(b.Subject != "" && b.Subject == p.tokenSubject) ||
+		(b.TokenID != "" && b.TokenID == p.TokenID) ||
+		(b.IP != "" && b.IP == p.remoteIP)
+}
+
+// WithIPExtractor sets how the client address that IP bans match is taken
+// from the handshake request. Without one it is the address the connection
+// comes from and proxy headers, which anyone can send, are ignored. Behind a
+// reverse proxy, pass an extractor that only believes the proxy's headers,
+// such as the one the HTTP server's rate limiter uses.
+func WithIPExtractor(extract func(*http.Request) string) Option {
+	return func(s *Server) {
+		s.ipExtractor = extract
+	}
+}
+
+// clientIP returns the address of the client.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.ipExtractor != nil {
+		return s.ipExtractor(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// banFor returns the ban matching participant, if any. The caller must hold
+// the mutex.
+func (r *Room) banFor(participant *Participant) *Ban {
+	for _, ban := range r.bans {
+		if ban.matches(participant) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// Ban records a ban of participant.
+func (r *Room) Ban(participant *Participant, reason RemovalReason, by string, banIP bool) *Ban {
+	ban := &Ban{
+		ParticipantID: participant.ID,
+		DID:           participant.DID,
+		Subject:       participant.tokenSubject,
+		TokenID:       participant.TokenID,
+		Reason:        reason,
+		By:            by,
+		At:            time.Now(),
+	}
+	if banIP {
+		ban.IP = participant.remoteIP
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bans = append(r.bans, ban)
+	return ban
+}
+
+// Unban lifts the bans of a participant and reports whether there were any.
+func (r *Room) Unban(participantID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	kept := r.bans[:0]
+	for _, ban := range r.bans {
+		if ban.ParticipantID != participantID {
+			kept = append(kept, ban)
+		}
+	}
+	lifted := len(kept) < len(r.bans)
+	r.bans = kept
+	return lifted
+}
+
+func (s *Server) handleKick(room *Room, participant *Participant, message *Message) {
+	target, data := s.removalTarget(room, participant, message)
+	if target == nil {
+		return
+	}
+
+	log.Printf("Participant %s kicked from room %s by %s (%s)", target.ID, room.Slug, participant.ID, data.Reason)
+	s.removeParticipant(room, participant, target, MessageTypeKick, data, message.receipt)
+}
+
+func (s *Server) handleBan(room *Room, participant *Participant, message *Message) {
+	target, data := s.removalTarget(room, participant, message)
+	if target == nil {
+		return
+	}
+
+	room.Ban(target, data.Reason, participant.ID, data.BanIP)
+	s.saveRoomBans(room)
+	log.Printf("Participant %s banned from room %s by %s (%s)", target.ID, room.Slug, participant.ID, data.Reason)
+	s.removeParticipant(room, participant, target, MessageTypeBan, data, message.receipt)
+}
+
+func (s *Server) handleUnban(room *Room, participant *Participant, message *Message) {
+	if !room.permits(participant, PermissionKick) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to lift bans")
+		return
+	}
+
+	if !room.Unban(message.Data.(string)) {
+		rejectMessage(participant, message, ErrCodeRemovalFailed, "participant is not banned")
+		return
+	}
+	s.saveRoomBans(room)
+}
+
+// removalTarget checks that participant may remove the target of a kick or
+// ban and returns it with the request. Participants who may kick themselves
+// can only be removed by those who manage roles.
+func (s *Server) removalTarget(room *Room, participant *Participant, message *Message) (*Participant, RemovalData) {
+	data := message.Data.(RemovalData)
+	if data.Reason == "" {
+		data.Reason = RemovalReasonOther
+	}
+
+	if !room.permits(participant, PermissionKick) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to remove participants")
+		return nil, data
+	}
+
+	target := room.GetParticipant(data.ParticipantID)
+	var targetRole ParticipantRole
+	if target != nil {
+		targetRole = room.roleOf(target)
+	}
+	switch {
+	case target == nil:
+		rejectMessage(participant, message, ErrCodeRemovalFailed, "participant not found")
+		return nil, data
+	case target == participant:
+		rejectMessage(participant, message, ErrCodeRemovalFailed, "you cannot remove yourself")
+		return nil, data
+	case targetRole == RoleHost:
+		rejectMessage(participant, message, ErrCodeRemovalFailed, "the host cannot be removed")
+		return nil, data
+	case targetRole.Can(PermissionKick) && !room.permits(participant, PermissionManageRoles):
+		rejectMessage(participant, message, ErrCodeForbidden, "only the host can remove a "+string(targetRole))
+		return nil, data
+	}
+	return target, data
+}
+
+// removeParticipant tells target why it was removed and makes it leave the
+// room without a chance to resume.
+func (s *Server) removeParticipant(room *Room, by, target *Participant, messageType MessageType, data RemovalData, receipt *receipt) {
+	data.BanIP = false
+
+	room.deliverTo(target.ID, &Message{
+		Type:      messageType,
+		From:      by.ID,
+		To:        target.ID,
+		Slug:      room.Slug,
+		Data:      data,
+		Timestamp: time.Now(),
+	}, receipt)
+	s.leaveRoom(room.Slug, target)
+}
@@ -0,0 +1,151 @@
+package signaling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKick(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeKick, Data: map[string]interface{}{"participant_id": "guest1", "reason": "spam", "message": "stop posting links"}})
+
+	assert.Equal(t, []RemovalData{{ParticipantID: "guest1", Reason: RemovalReasonSpam, Message: "stop posting links"}}, messagesOfType[RemovalData](conns["guest1"], MessageTypeKick))
+	waitClosed(t, conns["guest1"])
+
+	assert.Nil(t, room.GetParticipant("guest1"))
+	var types []MessageType
+	for _, message := range conns["guest2"].written() {
+		types = append(types, message.Type)
+	}
+	assert.Contains(t, types, MessageTypeLeave)
+
+	// A kick is not a ban
+	server.joinRoom("test-room", &Participant{ID: "guest1-again", Conn: newRecordingConn(), Role: RoleGuest})
+	assert.NotNil(t, room.GetParticipant("guest1-again"))
+}
+
+func TestKickRejections(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleModerator
+	moderator := room.GetParticipant("guest1")
+
+	expectRejected := func(sender *Participant, code string, data map[string]interface{}) {
+		rejected := len(messagesOfType[ErrorData](conns[sender.ID], MessageTypeError))
+		server.handleMessage("test-room", sender, &Message{Type: MessageTypeKick, Data: data})
+		errs := messagesOfType[ErrorData](conns[sender.ID], MessageTypeError)
+		require.Len(t, errs, rejected+1)
+		assert.Equal(t, code, errs[rejected].Code)
+	}
+
+	expectRejected(room.GetParticipant("guest2"), ErrCodeForbidden, map[string]interface{}{"participant_id": "guest1"})
+	expectRejected(moderator, ErrCodeRemovalFailed, map[string]interface{}{"participant_id": "host1"})
+	expectRejected(moderator, ErrCodeRemovalFailed, map[string]interface{}{"participant_id": "nobody"})
+	expectRejected(moderator, ErrCodeInvalidMessage, map[string]interface{}{"participant_id": "guest2", "reason": "boredom"})
+
+	// Only the host removes those who can remove others
+	room.GetParticipant("guest2").Role = RoleCoHost
+	expectRejected(moderator, ErrCodeForbidden, map[string]interface{}{"participant_id": "guest2"})
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeKick, Data: map[string]interface{}{"participant_id": "guest2"}})
+	assert.Nil(t, room.GetParticipant("guest2"))
+	assert.Equal(t, RemovalReasonOther, lastOfType(conns["guest2"], MessageTypeKick).Data.(RemovalData).Reason)
+}
+
+func TestBanRejectsJoiners(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	guest := room.GetParticipant("guest1")
+	guest.DID = "did:key:zBanned"
+	guest.tokenSubject = "subject-1"
+	guest.remoteIP = "203.0.113.7"
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeBan, Data: map[string]interface{}{"participant_id": "guest1", "reason": "harassment"}})
+	assert.NotNil(t, lastOfType(conns["guest1"], MessageTypeBan))
+	assert.Nil(t, room.GetParticipant("guest1"))
+
+	join := func(participant *Participant) *recordingConn {
+		conn := newRecordingConn()
+		participant.Conn = conn
+		participant.Role = RoleGuest
+		server.joinRoom("test-room", participant)
+		return conn
+	}
+
+	conn := join(&Participant{ID: "same-did", DID: "did:key:zBanned"})
+	assert.Equal(t, []ErrorData{{Code: ErrCodeBanned, Message: "you are banned from this room: harassment"}}, messagesOfType[ErrorData](conn, MessageTypeError))
+	waitClosed(t, conn)
+	assert.Nil(t, room.GetParticipant("same-did"))
+
+	join(&Participant{ID: "same-subject", tokenSubject: "subject-1"})
+	assert.Nil(t, room.GetParticipant("same-subject"))
+
+	// The IP address is only banned when asked for
+	join(&Participant{ID: "same-ip", remoteIP: "203.0.113.7"})
+	assert.NotNil(t, room.GetParticipant("same-ip"))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeUnban, Data: map[string]interface{}{"participant_id": "guest1"}})
+	join(&Participant{ID: "forgiven", DID: "did:key:zBanned"})
+	assert.NotNil(t, room.GetParticipant("forgiven"))
+}
+
+func TestBanIP(t *testing.T) {
+	server := NewServer()
+	room, _ := handoffRoom(t, server)
+	room.GetParticipant("guest1").remoteIP = "203.0.113.7"
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeBan, Data: map[string]interface{}{"participant_id": "guest1", "ban_ip": true}})
+
+	server.joinRoom("test-room", &Participant{ID: "same-ip", Conn: newRecordingConn(), Role: RoleGuest, remoteIP: "203.0.113.7"})
+	assert.Nil(t, room.GetParticipant("same-ip"))
+}
+
+func TestBansOutliveTheRoom(t *testing.T) {
+	registry := newFakeRegistry("test-room")
+	server := NewServer(WithRoomRegistry(registry))
+	room, _ := handoffRoom(t, server)
+	room.GetParticipant("guest1").tokenSubject = "invite:abc"
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeBan, Data: map[string]interface{}{"participant_id": "guest1"}})
+	require.Len(t, registry.bans["test-room"], 1)
+
+	server.leaveRoom("test-room", room.GetParticipant("guest2"))
+	server.leaveRoom("test-room", room.Host)
+	require.NotContains(t, server.rooms, "test-room")
+
+	conn := newRecordingConn()
+	server.joinRoom("test-room", &Participant{ID: "returning", Conn: conn, Role: RoleGuest, tokenSubject: "invite:abc"})
+	assert.Equal(t, ErrCodeBanned, errorCode(conn))
+
+	// Lifting the ban is saved too
+	server.joinRoom("test-room", &Participant{ID: "host2", Conn: newRecordingConn(), Role: RoleHost})
+	server.handleMessage("test-room", server.rooms["test-room"].Host, &Message{Type: MessageTypeUnban, Data: map[string]interface{}{"participant_id": "guest1"}})
+	assert.Empty(t, registry.bans["test-room"])
+}
+
+func TestUnbanUnknownParticipant(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeUnban, Data: map[string]interface{}{"participant_id": "guest1"}})
+	assert.Equal(t, ErrCodeRemovalFailed, errorCode(conns["host1"]))
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	// Anyone can send proxy headers, so by default they are ignored
+	assert.Equal(t, "192.0.2.1", NewServer().clientIP(r))
+
+	fromHeader := func(r *http.Request) string { return r.Header.Get("X-Real-IP") }
+	assert.Equal(t, "198.51.100.2", NewServer(WithIPExtractor(fromHeader)).clientIP(r))
+}
@@ -0,0 +1,262 @@
+package signaling
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A codec encodes the messages of one connection. It is chosen at the
+// handshake by appending its name to the v2 subprotocol, e.g.
+// "kaamos.v2+cbor"; plain subprotocols use JSON. Every codec maps to the
+// same Message types, using their json struct tags as field names.
+
+const (
+	CodecJSON    = "json"
+	CodecCBOR    = "cbor"
+	CodecMsgpack = "msgpack"
+)
+
+type Codec interface {
+	Name() string
+	// Marshal encodes a message for a binary frame.
+	Marshal(message *Message) ([]byte, error)
+	// Unmarshal decodes a client message. Its data is left for
+	// decodeMessage.
+	Unmarshal(payload []byte, message *Message) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	CBORCodec    Codec = newCBORCodec()
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// codecs lists the codecs in order of preference.
+var codecs = []Codec{CBORCodec, MsgpackCodec, JSONCodec}
+
+// CodecFromSubprotocol returns the codec a negotiated subprotocol selects.
+func CodecFromSubprotocol(subprotocol string) Codec {
+	_, name, found := strings.Cut(subprotocol, "+")
+	if !found {
+		return JSONCodec
+	}
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return JSONCodec
+}
+
+// codecNames returns the names of the codecs a client can choose from.
+func codecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+// subprotocol returns the subprotocol selecting version and codec.
+func subprotocol(version ProtocolVersion, codec Codec) string {
+	if codec == nil || codec == JSONCodec {
+		return version.Subprotocol()
+	}
+	return version.Subprotocol() + "+" + codec.Name()
+}
+
+// writeMessage writes a message to conn with codec, or as JSON when codec
+// is nil.
+func writeMessage(conn WebSocketConnInterface, codec Codec, message *Message) error {
+	if codec == nil || codec == JSONCodec {
+		return conn.WriteJSON(message)
+	}
+	payload, err := codec.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, payload)
+}
+
+// portable returns message with relayed data that is still encoded, as raw
+// JSON or in a binary codec, decoded, so that binary codecs do not encode it
+// as a byte string.
+func portable(message *Message) (*Message, error) {
+	var data interface{}
+	switch raw := message.Data.(type) {
+	case json.RawMessage:
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, err
+		}
+	case binaryData:
+		if err := raw.decode(&data); err != nil {
+			return nil, err
+		}
+	default:
+		return message, nil
+	}
+	copied := *message
+	copied.Data = data
+	return &copied, nil
+}
+
+// binaryEnvelope holds the fields clients set on a message; the server
+// assigns the others. Data is kept encoded as D until decodeMessage knows
+// what to decode it into.
+type binaryEnvelope[D ~[]byte] struct {
+	Type MessageType `json:"type"`
+	ID   string      `json:"id,omitempty"`
+	Ack  bool        `json:"ack,omitempty"`
+	To   string      `json:"to,omitempty"`
+	Data D           `json:"data,omitempty"`
+}
+
+// message returns the message with its data wrapped by data, or without data
+// if it was absent or null.
+func (e *binaryEnvelope[D]) message(data func(D) binaryData) Message {
+	message := Message{Type: e.Type, ID: e.ID, Ack: e.Ack, To: e.To}
+	if len(e.Data) > 0 {
+		message.Data = data(e.Data)
+	}
+	return message
+}
+
+// binaryData is the data of a message read with a binary codec, still
+// encoded. unmarshalData decodes it straight into its typed form; data the
+// server relays is decoded generically for peers using other codecs.
+type binaryData interface {
+	decode(v interface{}) error
+}
+
+// binaryDataJSON encodes binary data for JSON clients.
+func binaryDataJSON(data binaryData) ([]byte, error) {
+	var v interface{}
+	if err := data.decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return CodecJSON }
+
+func (jsonCodec) Marshal(message *Message) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Unmarshal(payload []byte, message *Message) error {
+	// Whatever could be decoded, such as the ID, is kept for the error
+	var inbound inboundMessage
+	err := json.Unmarshal(payload, &inbound)
+	*message = inbound.message()
+	return err
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc, dec: dec}
+}
+
+// cborData is the encoded data of a CBOR message.
+type cborData struct {
+	raw cbor.RawMessage
+	dec cbor.DecMode
+}
+
+func (d cborData) decode(v interface{}) error {
+	return d.dec.Unmarshal(d.raw, v)
+}
+
+func (d cborData) MarshalJSON() ([]byte, error) {
+	return binaryDataJSON(d)
+}
+
+func (cborCodec) Name() string { return CodecCBOR }
+
+func (c cborCodec) Marshal(message *Message) ([]byte, error) {
+	message, err := portable(message)
+	if err != nil {
+		return nil, err
+	}
+	return c.enc.Marshal(message)
+}
+
+func (c cborCodec) Unmarshal(payload []byte, message *Message) error {
+	var envelope binaryEnvelope[cbor.RawMessage]
+	err := c.dec.Unmarshal(payload, &envelope)
+	// null and undefined
+	if len(envelope.Data) == 1 && (envelope.Data[0] == 0xf6 || envelope.Data[0] == 0xf7) {
+		envelope.Data = nil
+	}
+	*message = envelope.message(func(raw cbor.RawMessage) binaryData {
+		return cborData{raw: raw, dec: c.dec}
+	})
+	return err
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+func (msgpackCodec) Marshal(message *Message) ([]byte, error) {
+	message, err := portable(message)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(payload []byte, message *Message) error {
+	var envelope binaryEnvelope[msgpack.RawMessage]
+	err := newMsgpackDecoder(payload).Decode(&envelope)
+	// nil
+	if len(envelope.Data) == 1 && envelope.Data[0] == 0xc0 {
+		envelope.Data = nil
+	}
+	*message = envelope.message(func(raw msgpack.RawMessage) binaryData {
+		return msgpackData(raw)
+	})
+	return err
+}
+
+func newMsgpackDecoder(payload []byte) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(bytes.NewReader(payload))
+	dec.SetCustomStructTag("json")
+	return dec
+}
+
+// msgpackData is the encoded data of a msgpack message.
+type msgpackData msgpack.RawMessage
+
+func (d msgpackData) decode(v interface{}) error {
+	return newMsgpackDecoder(d).Decode(v)
+}
+
+func (d msgpackData) MarshalJSON() ([]byte, error) {
+	return binaryDataJSON(d)
+}
@@ -0,0 +1,142 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			payload, err := codec.Marshal(&Message{
+				Type: MessageTypeKeyExchange,
+				ID:   "k1",
+				Ack:  true,
+				Data: KeyExchangeData{PublicKey: "cHVibGlj", Timestamp: 1700000000000, Signature: "c2ln"},
+			})
+			require.NoError(t, err)
+
+			var message Message
+			require.NoError(t, codec.Unmarshal(payload, &message))
+			assert.Equal(t, MessageTypeKeyExchange, message.Type)
+			assert.Equal(t, "k1", message.ID)
+			assert.True(t, message.Ack)
+
+			// Every codec decodes to the same typed data
+			require.NoError(t, decodeMessage(&message, ProtocolV2))
+			assert.Equal(t, KeyExchangeData{PublicKey: "cHVibGlj", Timestamp: 1700000000000, Signature: "c2ln"}, message.Data)
+		})
+	}
+}
+
+func TestBinaryCodecsRelayRawJSON(t *testing.T) {
+	message := &Message{Type: MessageTypeOffer, Data: json.RawMessage(`{"sdp":"v=0"}`)}
+	for _, codec := range []Codec{CBORCodec, MsgpackCodec} {
+		payload, err := codec.Marshal(message)
+		require.NoError(t, err)
+
+		var decoded Message
+		require.NoError(t, codec.Unmarshal(payload, &decoded))
+		assert.Equal(t, map[string]interface{}{"sdp": "v=0"}, messageData[map[string]interface{}](t, &decoded), codec.Name())
+	}
+	assert.IsType(t, json.RawMessage{}, message.Data, "the shared message is not modified")
+}
+
+func TestBinaryDataRelaysToJSONClients(t *testing.T) {
+	for _, codec := range []Codec{CBORCodec, MsgpackCodec} {
+		payload, err := codec.Marshal(&Message{Type: MessageTypeOffer, Data: map[string]interface{}{"sdp": "v=0"}})
+		require.NoError(t, err)
+		var offer Message
+		require.NoError(t, codec.Unmarshal(payload, &offer))
+		assert.Implements(t, (*binaryData)(nil), offer.Data, "data stays encoded until it is decoded into its type")
+
+		encoded, err := json.Marshal(offer.Data)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"sdp":"v=0"}`, string(encoded), codec.Name())
+
+	}
+
+	// Explicit nulls count as no data
+	withNull := map[string]interface{}{"type": "ban", "data": nil}
+	cborPayload, err := cbor.Marshal(withNull)
+	require.NoError(t, err)
+	msgpackPayload, err := msgpack.Marshal(withNull)
+	require.NoError(t, err)
+	for codec, payload := range map[Codec][]byte{CBORCodec: cborPayload, MsgpackCodec: msgpackPayload} {
+		var ban Message
+		require.NoError(t, codec.Unmarshal(payload, &ban))
+		assert.Nil(t, ban.Data, codec.Name())
+	}
+}
+
+func TestCodecFromSubprotocol(t *testing.T) {
+	assert.Equal(t, CBORCodec, CodecFromSubprotocol("kaamos.v2+cbor"))
+	assert.Equal(t, MsgpackCodec, CodecFromSubprotocol("kaamos.v2+msgpack"))
+	assert.Equal(t, JSONCodec, CodecFromSubprotocol("kaamos.v2"))
+	assert.Equal(t, JSONCodec, CodecFromSubprotocol(BaseProtocol))
+	assert.Equal(t, ProtocolV2, ProtocolFromSubprotocol("kaamos.v2+cbor"))
+}
+
+func TestBinaryConnection(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()), WithCompression(5))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	dialer := websocket.Dialer{
+		Subprotocols:      []string{"kaamos.v2+msgpack", SubprotocolV2},
+		EnableCompression: true,
+	}
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?token=host-token"
+	conn, response, err := dialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "kaamos.v2+msgpack", conn.Subprotocol())
+	assert.Contains(t, response.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	read := func() *Message {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		frameType, payload, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, websocket.BinaryMessage, frameType)
+		var message Message
+		require.NoError(t, MsgpackCodec.Unmarshal(payload, &message))
+		return &message
+	}
+
+	assert.Equal(t, MessageTypeParticipants, read().Type)
+	hello := read()
+	require.Equal(t, MessageTypeHello, hello.Type)
+	assert.Equal(t, "kaamos.v2+msgpack", messageData[HelloData](t, hello).Protocol)
+
+	payload, err := MsgpackCodec.Marshal(&Message{Type: MessageTypePing, Data: map[string]interface{}{"nonce": "abc"}})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, payload))
+	for {
+		message := read()
+		if message.Type == MessageTypePong {
+			assert.Equal(t, map[string]interface{}{"nonce": "abc"}, messageData[map[string]interface{}](t, message))
+			return
+		}
+	}
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	level, ok := ParseCompressionLevel("6")
+	assert.True(t, ok)
+	assert.Equal(t, 6, level)
+
+	_, ok = ParseCompressionLevel("10")
+	assert.False(t, ok)
+	_, ok = ParseCompressionLevel("fast")
+	assert.False(t, ok)
+}
@@ -0,0 +1,45 @@
+package signaling
+
+import "strconv"
+
+// Compression levels accepted by WithCompression, as in compress/flate.
+const (
+	MinCompressionLevel = -2 // flate.HuffmanOnly
+	MaxCompressionLevel = 9  // flate.BestCompression
+)
+
+// ParseCompressionLevel parses a permessage-deflate compression level.
+func ParseCompressionLevel(value string) (int, bool) {
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return level, level >= MinCompressionLevel && level <= MaxCompressionLevel
+}
+
+// WithCompression negotiates permessage-deflate with clients that offer it
+// and compresses outgoing messages at level.
+func WithCompression(level int) Option {
+	return func(s *Server) {
+		s.upgrader.EnableCompression = true
+		s.compressionLevel = level
+	}
+}
+
+// compressionConn is implemented by connections that support
+// permessage-deflate.
+type compressionConn interface {
+	EnableWriteCompression(enable bool)
+	SetCompressionLevel(level int) error
+}
+
+// enableCompression compresses writes to conn if compression was
+// negotiated.
+func (s *Server) enableCompression(conn WebSocketConnInterface) error {
+	compressor, ok := conn.(compressionConn)
+	if !s.upgrader.EnableCompression || !ok {
+		return nil
+	}
+	compressor.EnableWriteCompression(true)
+	return compressor.SetCompressionLevel(s.compressionLevel)
+}
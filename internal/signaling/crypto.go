@@ -1,10 +1,18 @@
 package signaling
 
 import (
+	"crypto/ecdh"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/Kaamos-Comms/server/internal/did"
 )
 
 func GenerateEd25519KeyPair() (publicKey string, privateKey string, err error) {
@@ -33,6 +41,153 @@ func ValidatePublicKey(publicKeyB64 string) error {
 	return nil
 }
 
+// maxBundleKeys bounds the number of keys a participant may announce.
+const maxBundleKeys = 8
+
+// ValidateTypedKey checks that the key is well-formed for its algorithm.
+// Ed25519 and X25519 keys are 32 bytes; P-256 keys are SEC1 points, either
+// compressed (33 bytes) or uncompressed (65 bytes).
+func ValidateTypedKey(key TypedKey) error {
+	keyBytes, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+
+	switch key.Algorithm {
+	case KeyAlgorithmEd25519:
+		return ValidatePublicKey(key.PublicKey)
+	case KeyAlgorithmX25519:
+		if _, err := ecdh.X25519().NewPublicKey(keyBytes); err != nil {
+			return fmt.Errorf("invalid x25519 key: %w", err)
+		}
+		if subtle.ConstantTimeCompare(keyBytes, make([]byte, len(keyBytes))) == 1 {
+			return errors.New("invalid x25519 key: all-zero point")
+		}
+	case KeyAlgorithmP256:
+		if len(keyBytes) == 33 {
+			x, y := elliptic.UnmarshalCompressed(elliptic.P256(), keyBytes)
+			if x == nil {
+				return errors.New("invalid p256 key: not on curve")
+			}
+			keyBytes = elliptic.Marshal(elliptic.P256(), x, y)
+		}
+		if _, err := ecdh.P256().NewPublicKey(keyBytes); err != nil {
+			return fmt.Errorf("invalid p256 key: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported key algorithm %q", key.Algorithm)
+	}
+
+	return nil
+}
+
+// ValidateKeyBundle checks every key of a bundle and rejects empty,
+// oversized or duplicated bundles.
+func ValidateKeyBundle(keys []TypedKey) error {
+	if len(keys) == 0 {
+		return errors.New("key bundle is empty")
+	}
+	if len(keys) > maxBundleKeys {
+		return fmt.Errorf("key bundle has %d keys, at most %d allowed", len(keys), maxBundleKeys)
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if err := ValidateTypedKey(key); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		if seen[key.PublicKey] {
+			return fmt.Errorf("key %d: duplicate key", i)
+		}
+		seen[key.PublicKey] = true
+	}
+
+	return nil
+}
+
+// CanonicalKeyBundle is the encoding of a bundle covered by key_exchange
+// signatures: "<algorithm>.<base64 key>" entries joined by commas, in
+// announcement order.
+func CanonicalKeyBundle(keys []TypedKey) string {
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = string(key.Algorithm) + "." + key.PublicKey
+	}
+	return strings.Join(entries, ",")
+}
+
+// primaryKey picks the key reported as the participant's single public key
+// to clients that predate bundles: the first Ed25519 key, else the first key.
+func primaryKey(keys []TypedKey) string {
+	for _, key := range keys {
+		if key.Algorithm == KeyAlgorithmEd25519 {
+			return key.PublicKey
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0].PublicKey
+}
+
+const (
+	fingerprintVersion    = 1
+	fingerprintIterations = 5200
+	fingerprintChunks     = 6
+)
+
+// KeyFingerprint derives the 30-digit displayable fingerprint of a key
+// bundle bound to a stable identity, in the iterated-hash style of Signal's
+// safety numbers so that finding a colliding bundle is expensive. It covers
+// CanonicalKeyBundle(keys), so replacing any key of the bundle changes it.
+func KeyFingerprint(identity string, keys []TypedKey) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New("empty key bundle")
+	}
+	for i, key := range keys {
+		decoded, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("key %d: invalid base64 encoding: %w", i, err)
+		}
+		if len(decoded) == 0 {
+			return "", fmt.Errorf("key %d: empty public key", i)
+		}
+	}
+	bundle := []byte(CanonicalKeyBundle(keys))
+
+	h := sha512.New()
+	h.Write([]byte{0, fingerprintVersion})
+	h.Write(bundle)
+	h.Write([]byte(identity))
+	hash := h.Sum(nil)
+	for i := 0; i < fingerprintIterations; i++ {
+		h.Reset()
+		h.Write(hash)
+		h.Write(bundle)
+		hash = h.Sum(hash[:0])
+	}
+
+	// Every 5-byte chunk becomes 5 decimal digits
+	var b strings.Builder
+	for i := 0; i < fingerprintChunks; i++ {
+		chunk := hash[i*5 : i*5+5]
+		value := uint64(chunk[0])<<32 | uint64(chunk[1])<<24 | uint64(chunk[2])<<16 | uint64(chunk[3])<<8 | uint64(chunk[4])
+		fmt.Fprintf(&b, "%05d", value%100000)
+	}
+	return b.String(), nil
+}
+
+// SafetyNumber combines the fingerprints of two participants into the
+// 60-digit number both of them see, regardless of who computes it. The
+// server serves it for convenience; clients that do not trust the server
+// compute it from the keys they received themselves.
+func SafetyNumber(fingerprintA, fingerprintB string) string {
+	if fingerprintB < fingerprintA {
+		fingerprintA, fingerprintB = fingerprintB, fingerprintA
+	}
+	return fingerprintA + fingerprintB
+}
+
 func ParseEd25519PublicKey(publicKeyB64 string) (ed25519.PublicKey, error) {
 	if err := ValidatePublicKey(publicKeyB64); err != nil {
 		return nil, err
@@ -55,3 +210,37 @@ func ParseEd25519PrivateKey(privateKeyB64 string) (ed25519.PrivateKey, error) {
 
 	return ed25519.PrivateKey(keyBytes), nil
 }
+
+// ParseDIDKey extracts the Ed25519 public key from a did:key identifier.
+func ParseDIDKey(id string) (ed25519.PublicKey, error) {
+	key, err := did.ParseKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return key.Ed25519()
+}
+
+// DIDKeyFromEd25519 encodes an Ed25519 public key as a did:key identifier.
+func DIDKeyFromEd25519(publicKey ed25519.PublicKey) string {
+	id, _ := did.KeyDID(&did.PublicKey{Type: did.KeyTypeEd25519, Bytes: publicKey})
+	return id
+}
+
+// VerifyEd25519Signature checks a base64-encoded signature over message.
+func VerifyEd25519Signature(publicKey ed25519.PublicKey, message []byte, signatureB64 string) error {
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature size: got %d, expected %d",
+			len(signature), ed25519.SignatureSize)
+	}
+
+	if !ed25519.Verify(publicKey, message, signature) {
+		return errors.New("signature verification failed")
+	}
+
+	return nil
+}
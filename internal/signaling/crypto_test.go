@@ -1,9 +1,16 @@
 package signaling
 
 import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/base64"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -125,11 +132,10 @@ func TestKeyExchangeIntegration(t *testing.T) {
 	publicKey, _, err := GenerateEd25519KeyPair()
 	assert.NoError(t, err)
 
+	identity := newTestIdentity(t)
 	message := &Message{
 		Type: MessageTypeKeyExchange,
-		Data: map[string]interface{}{
-			"public_key": publicKey,
-		},
+		Data: identity.keyExchange("test-room", "user1", publicKey, time.Now()),
 	}
 
 	server.handleMessage("test-room", participant1, message)
@@ -140,3 +146,186 @@ func TestKeyExchangeIntegration(t *testing.T) {
 
 	mockConn2.AssertExpectations(t)
 }
+
+func TestDIDKeyRoundTrip(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	did := DIDKeyFromEd25519(pub)
+	assert.True(t, strings.HasPrefix(did, "did:key:z6Mk"))
+
+	parsed, err := ParseDIDKey(did)
+	assert.NoError(t, err)
+	assert.Equal(t, pub, parsed)
+}
+
+func TestParseDIDKeyKnownVector(t *testing.T) {
+	// Test vector from the did:key specification
+	did := "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+	pub, err := ParseDIDKey(did)
+	assert.NoError(t, err)
+	assert.Equal(t, ed25519.PublicKeySize, len(pub))
+	assert.Equal(t, did, DIDKeyFromEd25519(pub))
+}
+
+func TestParseDIDKeyInvalid(t *testing.T) {
+	for _, did := range []string{
+		"",
+		"did:web:example.com",
+		"did:key:z0OIl",
+		"did:key:zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme", // secp256k1
+	} {
+		_, err := ParseDIDKey(did)
+		assert.Error(t, err, did)
+	}
+}
+
+func TestVerifyEd25519Signature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	message := []byte("challenge")
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, message))
+
+	assert.NoError(t, VerifyEd25519Signature(pub, message, signature))
+	assert.Error(t, VerifyEd25519Signature(pub, []byte("other"), signature))
+	assert.Error(t, VerifyEd25519Signature(pub, message, "not-base64!"))
+	assert.Error(t, VerifyEd25519Signature(pub, message, base64.StdEncoding.EncodeToString([]byte("short"))))
+}
+
+// testKeyBundle returns an Ed25519 signing key, an X25519 agreement key and
+// a compressed P-256 agreement key.
+func testKeyBundle(t *testing.T) []TypedKey {
+	signing, _, err := GenerateEd25519KeyPair()
+	assert.NoError(t, err)
+
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	compressed := elliptic.MarshalCompressed(elliptic.P256(), p256Key.X, p256Key.Y)
+
+	return []TypedKey{
+		{Algorithm: KeyAlgorithmEd25519, PublicKey: signing},
+		{Algorithm: KeyAlgorithmX25519, PublicKey: base64.StdEncoding.EncodeToString(x25519Key.PublicKey().Bytes())},
+		{Algorithm: KeyAlgorithmP256, PublicKey: base64.StdEncoding.EncodeToString(compressed)},
+	}
+}
+
+func TestValidateTypedKey(t *testing.T) {
+	bundle := testKeyBundle(t)
+	for _, key := range bundle {
+		assert.NoError(t, ValidateTypedKey(key), key.Algorithm)
+	}
+
+	p256Key, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	uncompressed := base64.StdEncoding.EncodeToString(p256Key.PublicKey().Bytes())
+	assert.NoError(t, ValidateTypedKey(TypedKey{Algorithm: KeyAlgorithmP256, PublicKey: uncompressed}))
+
+	zero := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	// x = 2^256-1 exceeds the field prime
+	offCurve := base64.StdEncoding.EncodeToString(append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...))
+
+	for _, key := range []TypedKey{
+		{Algorithm: KeyAlgorithmX25519, PublicKey: zero},
+		{Algorithm: KeyAlgorithmX25519, PublicKey: base64.StdEncoding.EncodeToString([]byte("short"))},
+		{Algorithm: KeyAlgorithmP256, PublicKey: offCurve},
+		{Algorithm: KeyAlgorithmP256, PublicKey: bundle[0].PublicKey},
+		{Algorithm: KeyAlgorithmEd25519, PublicKey: "not-base64!"},
+		{Algorithm: "rsa", PublicKey: bundle[0].PublicKey},
+	} {
+		assert.Error(t, ValidateTypedKey(key), "%s %s", key.Algorithm, key.PublicKey)
+	}
+}
+
+func TestValidateKeyBundle(t *testing.T) {
+	bundle := testKeyBundle(t)
+	assert.NoError(t, ValidateKeyBundle(bundle))
+
+	assert.Error(t, ValidateKeyBundle(nil))
+	assert.Error(t, ValidateKeyBundle(append(bundle, bundle[1])))
+
+	var tooMany []TypedKey
+	for i := 0; i <= maxBundleKeys; i++ {
+		tooMany = append(tooMany, testKeyBundle(t)[0])
+	}
+	assert.Error(t, ValidateKeyBundle(tooMany))
+}
+
+func TestRoomKeyBundles(t *testing.T) {
+	room := NewRoom("test-room")
+	bundle := testKeyBundle(t)
+
+	// Agreement keys come first; the primary key is still the signing key
+	reordered := []TypedKey{bundle[1], bundle[2], bundle[0]}
+	err := room.SaveKeyProof("user1", KeyProof{Keys: reordered})
+	assert.NoError(t, err)
+
+	savedKey, _ := room.GetPublicKey("user1")
+	assert.Equal(t, bundle[0].PublicKey, savedKey)
+	savedBundle, exists := room.GetKeyBundle("user1")
+	assert.True(t, exists)
+	assert.Equal(t, reordered, savedBundle)
+
+	room.RemovePublicKey("user1")
+	_, exists = room.GetKeyBundle("user1")
+	assert.False(t, exists)
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	publicKey, _, err := GenerateEd25519KeyPair()
+	assert.NoError(t, err)
+
+	bundle := []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: publicKey}}
+	fingerprint, err := KeyFingerprint("did:key:z6Mkexample", bundle)
+	assert.NoError(t, err)
+	assert.Len(t, fingerprint, 30)
+	assert.Empty(t, strings.Trim(fingerprint, "0123456789"))
+
+	again, err := KeyFingerprint("did:key:z6Mkexample", bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+
+	otherIdentity, err := KeyFingerprint("did:key:z6Mkother", bundle)
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, otherIdentity)
+
+	// Every key of the bundle is covered, not just the primary one
+	encryptionKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	withEncryption, err := KeyFingerprint("did:key:z6Mkexample", append(bundle, TypedKey{Algorithm: KeyAlgorithmX25519, PublicKey: encryptionKey}))
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, withEncryption)
+
+	_, err = KeyFingerprint("did:key:z6Mkexample", []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: "not base64!"}})
+	assert.Error(t, err)
+	_, err = KeyFingerprint("did:key:z6Mkexample", []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: ""}})
+	assert.Error(t, err)
+	_, err = KeyFingerprint("did:key:z6Mkexample", nil)
+	assert.Error(t, err)
+}
+
+func TestSafetyNumberIsSymmetric(t *testing.T) {
+	a := strings.Repeat("1", 30)
+	b := strings.Repeat("2", 30)
+
+	assert.Equal(t, a+b, SafetyNumber(a, b))
+	assert.Equal(t, SafetyNumber(a, b), SafetyNumber(b, a))
+}
+
+func TestRoomFingerprints(t *testing.T) {
+	room := NewRoom("test-room")
+	publicKey, _, _ := GenerateEd25519KeyPair()
+	assert.NoError(t, room.SavePublicKey("participant1", publicKey))
+
+	fingerprint, exists := room.GetFingerprint("participant1")
+	assert.True(t, exists)
+	expected, _ := KeyFingerprint("participant1", []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: publicKey}})
+	assert.Equal(t, expected, fingerprint)
+
+	room.RemovePublicKey("participant1")
+	_, exists = room.GetFingerprint("participant1")
+	assert.False(t, exists)
+}
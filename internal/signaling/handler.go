@@ -1,6 +1,7 @@
 package signaling
 
 import (
+	"encoding/json"
 	"log"
 	"time"
 )
@@ -14,70 +15,151 @@ func (s *Server) handleMessage(slug string, participant *Participant, message *M
 		return
 	}
 
+	if err := decodeMessage(message, participant.protocolVersion()); err != nil {
+		messageErr := err.(*MessageError)
+		rejectMessage(participant, message, messageErr.Code, messageErr.Message)
+		return
+	}
+
+	message.Seq = room.nextSeq()
+	if message.ID != "" {
+		if first, seen := room.seenMessage(participant.ID, message.ID, message.Seq); seen {
+			if message.Ack {
+				participant.Send(&Message{
+					Type:      MessageTypeAck,
+					Data:      AckData{ID: message.ID, Seq: first, Status: AckStatusDuplicate},
+					Timestamp: time.Now(),
+				})
+			}
+			return
+		}
+	}
+	message.receipt = newReceipt(participant, message)
+	defer message.receipt.accept()
+
 	switch message.Type {
+	case MessageTypePing:
+		s.handlePing(participant, message)
+	case MessageTypePong:
+		// Only keeps the connection alive
+	case MessageTypeHello:
+		s.handleHello(room, participant, message)
 	case MessageTypeAllow:
 		s.handleAllow(room, participant, message)
 	case MessageTypeDeny:
 		s.handleDeny(room, participant, message)
+	case MessageTypeTransferHost:
+		s.handleTransferHost(room, participant, message)
+	case MessageTypeSetSuccessor:
+		s.handleSetSuccessor(room, participant, message)
+	case MessageTypeGrantRole:
+		s.handleGrantRole(room, participant, message)
+	case MessageTypeRevokeRole:
+		s.handleRevokeRole(room, participant, message)
+	case MessageTypeMuteRequest:
+		s.handleMuteRequest(room, participant, message)
+	case MessageTypeKick:
+		s.handleKick(room, participant, message)
+	case MessageTypeBan:
+		s.handleBan(room, participant, message)
+	case MessageTypeUnban:
+		s.handleUnban(room, participant, message)
+	case MessageTypeLobbyMessage:
+		s.handleLobbyMessage(room, participant, message)
+	case MessageTypeRoomSettings:
+		s.handleRoomSettings(room, participant, message)
 	case MessageTypeOffer, MessageTypeAnswer, MessageTypeICECandidate:
 		s.handleWebRTCMessage(room, participant, message)
 	case MessageTypeKeyExchange:
 		s.handleKeyExchange(room, participant, message)
 	case MessageTypeEncrypted:
 		s.handleEncryptedData(room, participant, message)
-	default:
-		log.Printf("Unknown message type: %s", message.Type)
+	case MessageTypeSASStart:
+		s.handleSASStart(room, participant, message)
+	case MessageTypeSASConfirm:
+		s.handleSASConfirm(room, participant, message)
+	case MessageTypeSASFail:
+		s.handleSASFail(room, participant, message)
+	case MessageTypeMLSKeyPackages:
+		s.handleMLSKeyPackages(room, participant, message)
+	case MessageTypeMLSKeyPackageRequest:
+		s.handleMLSKeyPackageRequest(room, participant, message)
+	case MessageTypeMLSProposal:
+		s.handleMLSProposal(room, participant, message)
+	case MessageTypeMLSCommit:
+		s.handleMLSCommit(room, participant, message)
 	}
 }
 
+// handleKeyExchange stores a participant's keys once the announcement is
+// proven to come from the participant's identity key.
 func (s *Server) handleKeyExchange(room *Room, participant *Participant, message *Message) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid key exchange data format")
-		return
+	data := message.Data.(KeyExchangeData)
+
+	proof := KeyProof{
+		PublicKey:   data.PublicKey,
+		Keys:        data.Keys,
+		IdentityKey: data.IdentityKey,
+		DID:         participant.DID,
+		Timestamp:   data.Timestamp,
+		Signature:   data.Signature,
+	}
+	if len(proof.Keys) > 0 {
+		// A bundle replaces the single key rather than adding to it
+		proof.PublicKey = ""
 	}
 
-	publicKey, ok := data["public_key"].(string)
-	if !ok {
-		log.Printf("Missing public key in key exchange")
+	keys := proof.bundle()
+	if err := ValidateKeyBundle(keys); err != nil {
+		log.Printf("Invalid public key from %s: %v", participant.ID, err)
+		rejectMessage(participant, message, ErrCodeInvalidPublicKey, "Invalid public key: "+err.Error())
 		return
 	}
 
-	if err := room.SavePublicKey(participant.ID, publicKey); err != nil {
-		log.Printf("Failed to save public key for %s: %v", participant.ID, err)
+	if proof.Signature == "" || proof.Timestamp == 0 {
+		rejectMessage(participant, message, ErrCodeKeyExchangeUnsigned, "key_exchange must be signed by the identity key")
+		return
+	}
 
-		errorMsg := &Message{
-			Type: MessageTypeError,
-			Data: ErrorData{
-				Code:    "INVALID_PUBLIC_KEY",
-				Message: "Invalid public key format",
-			},
-			Timestamp: time.Now(),
-		}
-		participant.Conn.WriteJSON(errorMsg)
+	if code, err := s.verifyKeyAnnouncement(room, participant, &proof); err != nil {
+		log.Printf("Rejected key exchange from %s: %v", participant.ID, err)
+		rejectMessage(participant, message, code, err.Error())
 		return
 	}
 
-	log.Printf("Saved public key for participant %s in room %s", participant.ID, room.Slug)
+	if err := room.SaveKeyProof(participant.ID, proof); err != nil {
+		log.Printf("Failed to save public key for %s: %v", participant.ID, err)
+		rejectMessage(participant, message, ErrCodeInvalidPublicKey, "Invalid public key format")
+		return
+	}
 
-	// ВАЖНО: Убедитесь что BroadcastPublicKeys вызывается
-	log.Printf("Broadcasting public keys to all participants")
+	log.Printf("Saved %d key(s) for participant %s in room %s", len(keys), participant.ID, room.Slug)
 	room.BroadcastPublicKeys("")
-	log.Printf("Broadcast completed")
 }
 
 func (s *Server) handleEncryptedData(room *Room, participant *Participant, message *Message) {
 	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
 		return
 	}
 
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		return
+	data := message.Data.(EncryptedData)
+	toParticipantID := data.To
+
+	// MLS application messages are encrypted for the whole group
+	if data.Algorithm == EncryptionAlgorithmMLS {
+		if toParticipantID != "all" {
+			rejectMessage(participant, message, ErrCodeMLSInvalidMessage, "MLS application messages must be sent to all")
+			return
+		}
+		if err := s.checkMLSApplication(room, data.Epoch); err != nil {
+			rejectMessage(participant, message, ErrCodeMLSStaleEpoch, err.Error())
+			return
+		}
 	}
 
-	toParticipantID, ok := data["to"].(string)
-	if !ok {
+	if toParticipantID == "all" && !room.Settings().mayBroadcast(room.roleOf(participant)) {
+		rejectMessage(participant, message, ErrCodeForbidden, "guests may not send to everyone in this room")
 		return
 	}
 
@@ -87,28 +169,22 @@ func (s *Server) handleEncryptedData(room *Room, participant *Participant, messa
 	if toParticipantID == "all" {
 		room.BroadcastToAll(message, participant.ID)
 	} else {
-		if room.Host != nil && room.Host.ID == toParticipantID {
-			room.BroadcastToHost(message)
-		} else {
-			room.BroadcastToGuest(toParticipantID, message)
-		}
+		room.deliverTo(toParticipantID, message, message.receipt)
 	}
 }
 
 func (s *Server) handleAllow(room *Room, participant *Participant, message *Message) {
-	// Only the host can allow
-	if participant.Role != RoleHost {
+	if !room.permits(participant, PermissionAdmit) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to admit guests")
 		return
 	}
 
-	guestID, ok := message.Data.(string)
-	if !ok {
-		return
-	}
+	guestID := message.Data.(string)
 
 	err := room.AllowGuest(guestID)
 	if err != nil {
 		log.Printf("Failed to allow guest: %v", err)
+		message.receipt.report(errRecipientGone)
 		return
 	}
 
@@ -120,7 +196,7 @@ func (s *Server) handleAllow(room *Room, participant *Participant, message *Mess
 		Slug:      room.Slug,
 		Timestamp: time.Now(),
 	}
-	room.BroadcastToGuest(guestID, allowMessage)
+	room.deliverTo(guestID, allowMessage, message.receipt)
 
 	// Notify all participants about the updated participant list
 	participantsMessage := &Message{
@@ -130,21 +206,20 @@ func (s *Server) handleAllow(room *Room, participant *Participant, message *Mess
 		Timestamp: time.Now(),
 	}
 	room.BroadcastToAll(participantsMessage, "")
+	announceLobby(room)
 }
 
 func (s *Server) handleDeny(room *Room, participant *Participant, message *Message) {
-	// Only the host can deny
-	if participant.Role != RoleHost {
+	if !room.permits(participant, PermissionDeny) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to deny guests")
 		return
 	}
 
-	guestID, ok := message.Data.(string)
-	if !ok {
-		return
-	}
+	guestID := message.Data.(string)
 
 	guest := room.GetParticipant(guestID)
 	if guest == nil {
+		message.receipt.report(errRecipientGone)
 		return
 	}
 
@@ -156,32 +231,46 @@ func (s *Server) handleDeny(room *Room, participant *Participant, message *Messa
 		Slug:      room.Slug,
 		Timestamp: time.Now(),
 	}
-	room.BroadcastToGuest(guestID, denyMessage)
+	room.deliverTo(guestID, denyMessage, message.receipt)
 
 	// Remove the guest from the room
 	room.DenyGuest(guestID)
-	guest.Conn.Close()
+	guest.Close()
+	announceLobby(room)
 }
 
 func (s *Server) handleWebRTCMessage(room *Room, participant *Participant, message *Message) {
 	// Only participants with "in_room" status can exchange WebRTC signals
 	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
+		return
+	}
+	if message.Type == MessageTypeOffer && !room.permits(participant, PermissionPublish) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to publish media")
 		return
 	}
 
 	// If a recipient is specified, send only to them
 	if message.To != "" {
 		targetParticipant := room.GetParticipant(message.To)
-		if targetParticipant != nil && targetParticipant.Status == StatusInRoom {
-			if targetParticipant.Role == RoleHost {
-				room.BroadcastToHost(message)
-			} else {
-				room.BroadcastToGuest(message.To, message)
-			}
+		if targetParticipant == nil || targetParticipant.Status != StatusInRoom {
+			message.receipt.report(errRecipientGone)
+			return
 		}
+		room.deliverTo(message.To, message, message.receipt)
 		return
 	}
 
 	// Otherwise, broadcast to all other participants in the room
 	room.BroadcastToAll(message, participant.ID)
 }
+
+// decodeData converts loosely typed message data, as produced by decoding a
+// Message from JSON, into v.
+func decodeData(data interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
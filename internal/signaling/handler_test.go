@@ -38,7 +38,7 @@ func TestHandleWebRTCMessageWithAllowedGuest(t *testing.T) {
 	}
 
 	mockGuestConn.On("WriteJSON", mock.Anything).Return(nil).Times(2) // Allow + Participants
-	mockHostConn.On("WriteJSON", mock.Anything).Return(nil).Times(1)  // Participants
+	mockHostConn.On("WriteJSON", mock.Anything).Return(nil).Times(2)  // Participants + Lobby
 
 	server.handleAllow(room, host, allowMessage)
 
@@ -79,10 +79,11 @@ func TestHandleWebRTCMessageNotInRoom(t *testing.T) {
 		Data: map[string]interface{}{"sdp": "test-offer"},
 	}
 
+	// The guest is told why, nothing is relayed
+	expectErrorCode(mockGuestConn, ErrCodeNotInRoom)
 	server.handleWebRTCMessage(room, guest, message)
 
-	// No messages should have been sent
-	mockGuestConn.AssertNotCalled(t, "WriteJSON")
+	mockGuestConn.AssertExpectations(t)
 }
 
 func TestHandleAllow(t *testing.T) {
@@ -116,7 +117,7 @@ func TestHandleAllow(t *testing.T) {
 	}
 
 	mockGuestConn.On("WriteJSON", mock.Anything).Return(nil).Times(2) // Allow + Participants
-	mockHostConn.On("WriteJSON", mock.Anything).Return(nil).Once()    // Participants
+	mockHostConn.On("WriteJSON", mock.Anything).Return(nil).Times(2)  // Participants + Lobby
 
 	server.handleAllow(room, host, message)
 
@@ -155,6 +156,7 @@ func TestHandleDeny(t *testing.T) {
 	// Ожидаем отправку сообщения гостю и закрытие соединения
 	mockGuestConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once()
 	mockGuestConn.On("Close").Return(nil).Once()
+	mockHostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once() // Lobby
 
 	server.handleDeny(room, host, message)
 
@@ -181,8 +183,9 @@ func TestHandleWebRTCMessageRejectsKnockingGuest(t *testing.T) {
 		Data: map[string]interface{}{"sdp": "test-offer"},
 	}
 
+	// The guest is told why, nothing is relayed
+	expectErrorCode(mockGuestConn, ErrCodeNotInRoom)
 	server.handleWebRTCMessage(room, guest, message)
 
-	// No messages should have been sent
-	mockGuestConn.AssertNotCalled(t, "WriteJSON")
+	mockGuestConn.AssertExpectations(t)
 }
@@ -0,0 +1,127 @@
+package signaling
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Half-open connections are detected with WebSocket ping frames: every pong,
+// like every message, extends the read deadline, and a read that times out
+// ends handleConnection and so runs the normal leaveRoom path. Clients behind
+// proxies that drop control frames send application "ping" messages instead.
+
+const (
+	DefaultPingInterval = 25 * time.Second
+	DefaultPongTimeout  = 10 * time.Second
+)
+
+// heartbeatConn is implemented by *websocket.Conn. Connections without it,
+// such as test doubles, get no heartbeat.
+type heartbeatConn interface {
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+}
+
+// heartbeat tracks the liveness of one connection. Apart from the pinger,
+// only the goroutine reading the connection uses it.
+type heartbeat struct {
+	conn         heartbeatConn
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+	writeTimeout time.Duration
+	lastMessage  time.Time
+	done         chan struct{}
+}
+
+// startHeartbeat sets the first read deadline of a participant's connection
+// and starts pinging it. It returns nil if the connection has no heartbeat.
+func (s *Server) startHeartbeat(participantID string, wsConn WebSocketConnInterface) *heartbeat {
+	conn, ok := wsConn.(heartbeatConn)
+	if !ok {
+		return nil
+	}
+
+	h := &heartbeat{
+		conn:         conn,
+		pingInterval: s.pingInterval,
+		pongTimeout:  s.pongTimeout,
+		idleTimeout:  s.idleTimeout,
+		writeTimeout: s.writeTimeout,
+		lastMessage:  time.Now(),
+		done:         make(chan struct{}),
+	}
+	conn.SetPongHandler(func(string) error {
+		h.extend()
+		return nil
+	})
+	h.extend()
+
+	if h.pingInterval > 0 {
+		go h.ping(participantID)
+	}
+	return h
+}
+
+// messageReceived records client activity.
+func (h *heartbeat) messageReceived() {
+	if h == nil {
+		return
+	}
+	h.lastMessage = time.Now()
+	h.extend()
+}
+
+// extend moves the read deadline to the earliest of the next expected pong
+// and the end of the idle period.
+func (h *heartbeat) extend() {
+	now := time.Now()
+	var deadline time.Time
+	if h.pingInterval > 0 {
+		deadline = now.Add(h.pingInterval + h.pongTimeout)
+	}
+	if h.idleTimeout > 0 {
+		idleDeadline := h.lastMessage.Add(h.idleTimeout)
+		if deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	h.conn.SetReadDeadline(deadline)
+}
+
+func (h *heartbeat) ping(participantID string) {
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			// WriteControl may be called concurrently with the writer
+			if err := h.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.writeTimeout)); err != nil {
+				log.Printf("Ping to %s failed: %v", participantID, err)
+				return
+			}
+		}
+	}
+}
+
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	close(h.done)
+}
+
+// handlePing answers an application-level ping, echoing its data.
+func (s *Server) handlePing(participant *Participant, message *Message) {
+	participant.Send(&Message{
+		Type:      MessageTypePong,
+		Data:      message.Data,
+		Timestamp: time.Now(),
+	})
+}
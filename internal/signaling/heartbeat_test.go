@@ -0,0 +1,82 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll keeps reading so that the client answers pings.
+func readAll(conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestHeartbeatEvictsUnresponsivePeer(t *testing.T) {
+	server := NewServer(
+		WithTokenVerifier(newTestVerifier()),
+		WithHeartbeat(30*time.Millisecond, 30*time.Millisecond),
+		WithResumeGracePeriod(0),
+	)
+	dialHost(t, server) // never reads, so never answers pings
+
+	assert.Eventually(t, func() bool { return server.GetRoomStats("test-room") == nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestHeartbeatKeepsResponsivePeer(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()), WithHeartbeat(30*time.Millisecond, 30*time.Millisecond))
+	conn := dialHost(t, server)
+	readAll(conn)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.NotNil(t, server.GetRoomStats("test-room"))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server := NewServer(
+		WithTokenVerifier(newTestVerifier()),
+		WithHeartbeat(20*time.Millisecond, 20*time.Millisecond),
+		WithIdleTimeout(100*time.Millisecond),
+		WithResumeGracePeriod(0),
+	)
+	conn := dialHost(t, server)
+	readAll(conn)
+
+	// Application pings count as activity
+	for i := 0; i < 5; i++ {
+		require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "ping"}))
+		time.Sleep(40 * time.Millisecond)
+	}
+	assert.NotNil(t, server.GetRoomStats("test-room"))
+
+	// Pongs alone do not
+	assert.Eventually(t, func() bool { return server.GetRoomStats("test-room") == nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestApplicationPing(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	conn := dialHost(t, server)
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{
+		"type": "ping",
+		"data": map[string]string{"nonce": "abc"},
+	}))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var message map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&message))
+		if message["type"] == string(MessageTypePong) {
+			assert.Equal(t, map[string]interface{}{"nonce": "abc"}, message["data"])
+			return
+		}
+	}
+}
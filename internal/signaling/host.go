@@ -0,0 +1,278 @@
+package signaling
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// The host can hand the room over with transfer_host. When the host leaves,
+// a guest may be promoted automatically after a grace period, unless a host
+// connects in the meantime. Every change is announced with host_changed.
+
+const (
+	DefaultHostPromotionGrace = 30 * time.Second
+	ErrCodeHostTransferFailed = "HOST_TRANSFER_FAILED"
+
+	HostChangeTransfer  = "transfer"  // the host handed the room over
+	HostChangePromotion = "promotion" // a guest was promoted automatically
+	HostChangeLeft      = "left"      // the host left, the room has none
+	HostChangeJoined    = "joined"    // a host connected to a room without one
+)
+
+var errNoHost = errors.New("the room has no host")
+
+// HostPromotionPolicy decides which guest becomes host when the host leaves.
+type HostPromotionPolicy string
+
+const (
+	// HostPromotionNone leaves the room without a host.
+	HostPromotionNone HostPromotionPolicy = "none"
+	// HostPromotionLongestPresent promotes the guest that joined first.
+	HostPromotionLongestPresent HostPromotionPolicy = "longest_present"
+	// HostPromotionSuccessor promotes the guest designated with
+	// set_successor, or the longest present one if there is none.
+	HostPromotionSuccessor HostPromotionPolicy = "successor"
+)
+
+// ParseHostPromotionPolicy parses a policy name. The empty string is
+// HostPromotionNone.
+func ParseHostPromotionPolicy(value string) (HostPromotionPolicy, bool) {
+	switch policy := HostPromotionPolicy(value); policy {
+	case "":
+		return HostPromotionNone, true
+	case HostPromotionNone, HostPromotionLongestPresent, HostPromotionSuccessor:
+		return policy, true
+	}
+	return "", false
+}
+
+// WithHostPromotion promotes a guest according to policy once the host has
+// been gone for the grace period.
+func WithHostPromotion(policy HostPromotionPolicy, grace time.Duration) Option {
+	return func(s *Server) {
+		s.hostPromotion = policy
+		s.hostPromotionGrace = grace
+	}
+}
+
+type HostChangedData struct {
+	HostID         string `json:"host_id,omitempty"` // empty while the room has no host
+	PreviousHostID string `json:"previous_host_id,omitempty"`
+	Reason         string `json:"reason"`
+	PromotionIn    int    `json:"promotion_in,omitempty"` // seconds until a guest is promoted
+}
+
+// authenticatedRole returns the role the participant's token was issued
+// for, which differs from Role after a handoff.
+func (p *Participant) authenticatedRole() ParticipantRole {
+	if p.tokenRole != "" {
+		return p.tokenRole
+	}
+	return p.Role
+}
+
+func (p *Participant) isSuspended() bool {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	return p.session.suspended
+}
+
+// setHost makes an admitted guest the host. The previous host, if any,
+// stays as an admitted guest. The caller must hold the mutex.
+func (r *Room) setHost(guest *Participant) *Participant {
+	previous := r.Host
+	if previous != nil {
+		previous.Role = RoleGuest
+		r.Guests[previous.ID] = previous
+	}
+
+	delete(r.Guests, guest.ID)
+	guest.Role = RoleHost
+	r.Host = guest
+	if r.successor == guest.ID {
+		r.successor = ""
+	}
+	r.cancelPromotion()
+	return previous
+}
+
+// TransferHost hands the room over to an admitted guest and returns the
+// previous host.
+func (r *Room) TransferHost(guestID string) (*Participant, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Host == nil {
+		return nil, errNoHost
+	}
+	guest, exists := r.Guests[guestID]
+	if !exists || guest.Status != StatusInRoom {
+		return nil, errors.New("the new host must be a guest in the room")
+	}
+	return r.setHost(guest), nil
+}
+
+// setSuccessor designates the guest HostPromotionSuccessor promotes.
+func (r *Room) setSuccessor(guestID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.Guests[guestID]; !exists {
+		return errors.New("the successor must be a guest in the room")
+	}
+	r.successor = guestID
+	return nil
+}
+
+// promotionCandidate returns the guest policy promotes, skipping guests
+// that are not admitted or whose connection dropped. The caller must hold
+// the mutex.
+func (r *Room) promotionCandidate(policy HostPromotionPolicy) *Participant {
+	eligible := func(guest *Participant) bool {
+		return guest != nil && guest.Status == StatusInRoom && !guest.isSuspended()
+	}
+
+	if policy == HostPromotionSuccessor {
+		if successor := r.Guests[r.successor]; eligible(successor) {
+			return successor
+		}
+	}
+
+	var candidate *Participant
+	for _, guest := range r.Guests {
+		if !eligible(guest) {
+			continue
+		}
+		if candidate == nil || guest.JoinedAt.Before(candidate.JoinedAt) ||
+			(guest.JoinedAt.Equal(candidate.JoinedAt) && guest.ID < candidate.ID) {
+			candidate = guest
+		}
+	}
+	return candidate
+}
+
+// promoteHost makes a guest host if the room still has none.
+func (r *Room) promoteHost(policy HostPromotionPolicy) *Participant {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.promotion = nil
+	if r.Host != nil {
+		return nil
+	}
+	candidate := r.promotionCandidate(policy)
+	if candidate != nil {
+		r.setHost(candidate)
+	}
+	return candidate
+}
+
+// cancelPromotion stops a pending promotion. The caller must hold the
+// mutex.
+func (r *Room) cancelPromotion() {
+	if r.promotion != nil {
+		r.promotion.Stop()
+		r.promotion = nil
+	}
+}
+
+// hostLeft announces that the room lost its host and schedules a promotion.
+// The caller must hold the server mutex.
+func (s *Server) hostLeft(slug string, room *Room, previousHostID string) {
+	data := HostChangedData{PreviousHostID: previousHostID, Reason: HostChangeLeft}
+
+	if s.hostPromotion != "" && s.hostPromotion != HostPromotionNone {
+		data.PromotionIn = int(s.hostPromotionGrace / time.Second)
+		room.mutex.Lock()
+		room.cancelPromotion()
+		room.promotion = time.AfterFunc(s.hostPromotionGrace, func() {
+			s.promoteHost(slug, room)
+		})
+		room.mutex.Unlock()
+	}
+
+	announceHost(room, data)
+}
+
+func (s *Server) promoteHost(slug string, room *Room) {
+	s.mutex.RLock()
+	current := s.rooms[slug]
+	s.mutex.RUnlock()
+	if current != room {
+		return
+	}
+
+	host := room.promoteHost(s.hostPromotion)
+	if host == nil {
+		return
+	}
+	log.Printf("Promoted %s to host of room %s", host.ID, slug)
+	announceHost(room, HostChangedData{HostID: host.ID, Reason: HostChangePromotion})
+}
+
+// announceHost tells every participant, admitted or not, about a host
+// change and sends the updated participant list.
+func announceHost(room *Room, data HostChangedData) {
+	room.sendMatching(func(*Participant) bool { return true }, &Message{
+		Type:      MessageTypeHostChanged,
+		Slug:      room.Slug,
+		Data:      data,
+		Timestamp: time.Now(),
+	})
+	room.BroadcastToAll(&Message{
+		Type:      MessageTypeParticipants,
+		Slug:      room.Slug,
+		Data:      room.GetParticipantsData(),
+		Timestamp: time.Now(),
+	}, "")
+}
+
+func (s *Server) handleTransferHost(room *Room, participant *Participant, message *Message) {
+	if !room.permits(participant, PermissionManageRoles) {
+		rejectMessage(participant, message, ErrCodeForbidden, "only the host can transfer the room")
+		return
+	}
+
+	guestID := message.Data.(string)
+	previous, err := room.TransferHost(guestID)
+	if err != nil {
+		rejectMessage(participant, message, ErrCodeHostTransferFailed, err.Error())
+		return
+	}
+
+	log.Printf("Host of room %s transferred from %s to %s", room.Slug, previous.ID, guestID)
+	announceHost(room, HostChangedData{HostID: guestID, PreviousHostID: previous.ID, Reason: HostChangeTransfer})
+}
+
+func (s *Server) handleSetSuccessor(room *Room, participant *Participant, message *Message) {
+	if !room.permits(participant, PermissionManageRoles) {
+		rejectMessage(participant, message, ErrCodeForbidden, "only the host can designate a successor")
+		return
+	}
+
+	if err := room.setSuccessor(message.Data.(string)); err != nil {
+		rejectMessage(participant, message, ErrCodeHostTransferFailed, err.Error())
+	}
+}
+
+// RoomHost identifies the host of a live room by the ID of the token it
+// joined with and its DID, if any. It reports false when the room is not
+// live or has no host.
+func (s *Server) RoomHost(slug string) (tokenID, did string, ok bool) {
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return "", "", false
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	if room.Host == nil {
+		return "", "", false
+	}
+	return room.Host.TokenID, room.Host.DID, true
+}
@@ -0,0 +1,146 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handoffRoom registers a room with a host and two admitted guests that
+// record what they are sent. guest1 joined before guest2.
+func handoffRoom(t *testing.T, server *Server) (*Room, map[string]*recordingConn) {
+	room := NewRoom("test-room")
+	server.rooms["test-room"] = room
+
+	conns := make(map[string]*recordingConn)
+	joined := time.Now()
+	for i, id := range []string{"host1", "guest1", "guest2"} {
+		conns[id] = newRecordingConn()
+		participant := &Participant{ID: id, Conn: conns[id], Role: RoleGuest, JoinedAt: joined.Add(time.Duration(i) * time.Second)}
+		if id == "host1" {
+			participant.Role = RoleHost
+		}
+		require.NoError(t, room.AddParticipant(participant))
+		if participant.Role == RoleGuest {
+			require.NoError(t, room.AllowGuest(id))
+		}
+	}
+	return room, conns
+}
+
+func TestTransferHost(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	host := room.GetParticipant("host1")
+
+	server.handleMessage("test-room", host, &Message{Type: MessageTypeTransferHost, Data: map[string]interface{}{"participant_id": "guest2"}})
+
+	require.NotNil(t, room.Host)
+	assert.Equal(t, "guest2", room.Host.ID)
+	assert.Equal(t, RoleHost, room.Host.Role)
+	assert.Equal(t, RoleGuest, host.Role)
+	assert.Equal(t, StatusInRoom, room.Guests["host1"].Status)
+
+	want := HostChangedData{HostID: "guest2", PreviousHostID: "host1", Reason: HostChangeTransfer}
+	for _, conn := range conns {
+		assert.Equal(t, []HostChangedData{want}, messagesOfType[HostChangedData](conn, MessageTypeHostChanged))
+	}
+
+	// The former host can no longer admit guests
+	server.handleMessage("test-room", host, &Message{Type: MessageTypeAllow, Data: "guest1"})
+	last := conns["host1"].written()
+	assert.Equal(t, ErrCodeForbidden, last[len(last)-1].Data.(ErrorData).Code)
+}
+
+func TestTransferHostRejections(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	require.NoError(t, room.AddParticipant(&Participant{ID: "knocking", Conn: newRecordingConn(), Role: RoleGuest}))
+
+	lastError := func(id string) ErrorData {
+		written := conns[id].written()
+		return written[len(written)-1].Data.(ErrorData)
+	}
+
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeTransferHost, Data: map[string]interface{}{"participant_id": "guest1"}})
+	assert.Equal(t, ErrCodeForbidden, lastError("guest1").Code)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeTransferHost, Data: map[string]interface{}{"participant_id": "knocking"}})
+	assert.Equal(t, ErrCodeHostTransferFailed, lastError("host1").Code)
+	assert.Equal(t, "host1", room.Host.ID)
+}
+
+func TestPromoteLongestPresentGuest(t *testing.T) {
+	server := NewServer(WithHostPromotion(HostPromotionLongestPresent, 20*time.Millisecond))
+	room, conns := handoffRoom(t, server)
+
+	server.leaveRoom("test-room", room.GetParticipant("host1"))
+	assert.Nil(t, room.Host, "promotion waits for the grace period")
+	assert.Equal(t, []HostChangedData{{PreviousHostID: "host1", Reason: HostChangeLeft}}, messagesOfType[HostChangedData](conns["guest1"], MessageTypeHostChanged))
+
+	require.Eventually(t, func() bool { return room.GetParticipantsData().Host != nil }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "guest1", room.Host.ID)
+	assert.Equal(t, HostChangedData{HostID: "guest1", Reason: HostChangePromotion}, messagesOfType[HostChangedData](conns["guest2"], MessageTypeHostChanged)[1])
+}
+
+func TestPromoteDesignatedSuccessor(t *testing.T) {
+	server := NewServer(WithHostPromotion(HostPromotionSuccessor, 10*time.Millisecond))
+	room, _ := handoffRoom(t, server)
+	host := room.GetParticipant("host1")
+
+	server.handleMessage("test-room", host, &Message{Type: MessageTypeSetSuccessor, Data: map[string]interface{}{"participant_id": "guest2"}})
+	server.leaveRoom("test-room", host)
+
+	require.Eventually(t, func() bool { return room.GetParticipantsData().Host != nil }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "guest2", room.Host.ID)
+}
+
+func TestReturningHostCancelsPromotion(t *testing.T) {
+	server := NewServer(WithHostPromotion(HostPromotionLongestPresent, 30*time.Millisecond))
+	room, conns := handoffRoom(t, server)
+
+	server.leaveRoom("test-room", room.GetParticipant("host1"))
+	server.joinRoom("test-room", &Participant{ID: "host2", Conn: newRecordingConn(), Role: RoleHost})
+	time.Sleep(60 * time.Millisecond)
+
+	assert.Equal(t, "host2", room.GetParticipantsData().Host.ID)
+	changes := messagesOfType[HostChangedData](conns["guest1"], MessageTypeHostChanged)
+	require.Len(t, changes, 2)
+	assert.Equal(t, HostChangedData{HostID: "host2", Reason: HostChangeJoined}, changes[1])
+}
+
+func TestNoPromotionByDefault(t *testing.T) {
+	server := NewServer(WithHostPromotion(HostPromotionNone, time.Millisecond))
+	room, conns := handoffRoom(t, server)
+
+	server.leaveRoom("test-room", room.GetParticipant("host1"))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Nil(t, room.GetParticipantsData().Host)
+	assert.Equal(t, []HostChangedData{{PreviousHostID: "host1", Reason: HostChangeLeft}}, messagesOfType[HostChangedData](conns["guest1"], MessageTypeHostChanged))
+}
+
+func TestPromotedGuestResumesWithGuestToken(t *testing.T) {
+	room := NewRoom("test-room")
+	promoted := &Participant{ID: "p1", Role: RoleHost, tokenRole: RoleGuest}
+	promoted.session = session{token: "resume", suspended: true}
+	require.NoError(t, room.AddParticipant(promoted))
+
+	assert.Same(t, promoted, room.findResumable("resume", &TokenClaims{Slug: "test-room", Role: RoleGuest}))
+	assert.Nil(t, room.findResumable("resume", &TokenClaims{Slug: "test-room", Role: RoleHost}))
+}
+
+func TestParseHostPromotionPolicy(t *testing.T) {
+	policy, ok := ParseHostPromotionPolicy("")
+	assert.True(t, ok)
+	assert.Equal(t, HostPromotionNone, policy)
+
+	policy, ok = ParseHostPromotionPolicy("successor")
+	assert.True(t, ok)
+	assert.Equal(t, HostPromotionSuccessor, policy)
+
+	_, ok = ParseHostPromotionPolicy("random")
+	assert.False(t, ok)
+}
@@ -0,0 +1,184 @@
+package signaling
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/did"
+)
+
+const (
+	// keyExchangeMaxSkew bounds how far a key announcement's timestamp may
+	// be from the server clock.
+	keyExchangeMaxSkew = 2 * time.Minute
+	// didResolveTimeout bounds DID resolution done from the message loop.
+	didResolveTimeout = 5 * time.Second
+
+	ErrCodeInvalidPublicKey    = "INVALID_PUBLIC_KEY"
+	ErrCodeKeyExchangeUnsigned = "KEY_EXCHANGE_UNSIGNED"
+	ErrCodeKeyExchangeStale    = "KEY_EXCHANGE_STALE"
+	ErrCodeInvalidSignature    = "INVALID_SIGNATURE"
+	ErrCodeIdentityMismatch    = "IDENTITY_MISMATCH"
+	ErrCodeIdentityUnavailable = "IDENTITY_UNAVAILABLE"
+)
+
+// ErrNoIdentity is returned for participants that connected without a DID.
+var ErrNoIdentity = errors.New("participant has no DID")
+
+// VerificationKeys resolves the participant's DID and returns the Ed25519
+// keys its document lists for authentication. Keys of other types are
+// skipped since the signaling protocol only verifies Ed25519 signatures.
+func (s *Server) VerificationKeys(ctx context.Context, participant *Participant) ([]ed25519.PublicKey, error) {
+	if participant.DID == "" {
+		return nil, ErrNoIdentity
+	}
+
+	doc, err := s.resolver.Resolve(ctx, participant.DID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", participant.DID, err)
+	}
+
+	var keys []ed25519.PublicKey
+	for _, method := range doc.VerificationKeys() {
+		key, err := method.PublicKey()
+		if err != nil || key.Type != did.KeyTypeEd25519 {
+			continue
+		}
+		edKey, _ := key.Ed25519()
+		keys = append(keys, edKey)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no Ed25519 authentication key", participant.DID)
+	}
+	return keys, nil
+}
+
+// NotifyDID sends message to every live session of the identity across all
+// rooms and returns how many sessions it reached.
+func (s *Server) NotifyDID(id string, message *Message) int {
+	if id == "" {
+		return 0
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sent := 0
+	for _, room := range s.rooms {
+		sent += room.sendMatching(func(p *Participant) bool {
+			return p.DID == id
+		}, message)
+	}
+	return sent
+}
+
+// KeyExchangePayload is the message signed by the identity key in a
+// key_exchange: it binds the keys to the room, the participant and a time.
+// keys is the announced public key, or CanonicalKeyBundle for a bundle.
+func KeyExchangePayload(slug, participantID, keys string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("kaamos-key-exchange:%s:%s:%s:%d", slug, participantID, keys, timestamp))
+}
+
+// signedKeys returns the keys part of the proof's KeyExchangePayload.
+func (p KeyProof) signedKeys() string {
+	if len(p.Keys) > 0 {
+		return CanonicalKeyBundle(p.Keys)
+	}
+	return p.PublicKey
+}
+
+// bundle returns the announced keys, treating a single public key as an
+// Ed25519 bundle of one.
+func (p KeyProof) bundle() []TypedKey {
+	if len(p.Keys) > 0 {
+		return p.Keys
+	}
+	if p.PublicKey == "" {
+		return nil
+	}
+	return []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: p.PublicKey}}
+}
+
+// VerifyKeyProof checks the signature of a proof forwarded in public_keys.
+// It does not check that IdentityKey belongs to proof.DID; peers that care
+// should resolve the DID themselves.
+func VerifyKeyProof(slug, participantID string, proof KeyProof) error {
+	identityKey, err := ParseEd25519PublicKey(proof.IdentityKey)
+	if err != nil {
+		return fmt.Errorf("invalid identity key: %w", err)
+	}
+	payload := KeyExchangePayload(slug, participantID, proof.signedKeys(), proof.Timestamp)
+	return VerifyEd25519Signature(identityKey, payload, proof.Signature)
+}
+
+// verifyKeyAnnouncement checks that proof is fresh and signed by an identity
+// key of the participant. On success proof.IdentityKey is set to the key
+// that made the signature.
+func (s *Server) verifyKeyAnnouncement(room *Room, participant *Participant, proof *KeyProof) (string, error) {
+	skew := time.Since(time.UnixMilli(proof.Timestamp))
+	if skew > keyExchangeMaxSkew || skew < -keyExchangeMaxSkew {
+		return ErrCodeKeyExchangeStale, errors.New("key announcement timestamp is outside the accepted window")
+	}
+	if previous, exists := room.GetKeyProof(participant.ID); exists && proof.Timestamp <= previous.Timestamp {
+		return ErrCodeKeyExchangeStale, errors.New("key announcement is not newer than the current one")
+	}
+
+	candidates, code, err := s.identityKeys(participant, proof.IdentityKey)
+	if err != nil {
+		return code, err
+	}
+
+	payload := KeyExchangePayload(room.Slug, participant.ID, proof.signedKeys(), proof.Timestamp)
+	for _, key := range candidates {
+		if VerifyEd25519Signature(key, payload, proof.Signature) == nil {
+			proof.IdentityKey = base64.StdEncoding.EncodeToString(key)
+			return "", nil
+		}
+	}
+	return ErrCodeInvalidSignature, errors.New("key announcement signature verification failed")
+}
+
+// identityKeys returns the keys allowed to sign the participant's key
+// announcements. Participants with a DID use its authentication keys; the
+// others pin the identity key of their first announcement.
+func (s *Server) identityKeys(participant *Participant, claimed string) ([]ed25519.PublicKey, string, error) {
+	if participant.DID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), didResolveTimeout)
+		defer cancel()
+
+		keys, err := s.VerificationKeys(ctx, participant)
+		if err != nil {
+			return nil, ErrCodeIdentityUnavailable, err
+		}
+		if claimed == "" {
+			return keys, "", nil
+		}
+		for _, key := range keys {
+			if base64.StdEncoding.EncodeToString(key) == claimed {
+				return []ed25519.PublicKey{key}, "", nil
+			}
+		}
+		return nil, ErrCodeIdentityMismatch, fmt.Errorf("identity key is not an authentication key of %s", participant.DID)
+	}
+
+	if participant.IdentityKey != "" {
+		if claimed != "" && claimed != participant.IdentityKey {
+			return nil, ErrCodeIdentityMismatch, errors.New("identity key differs from the pinned one")
+		}
+		claimed = participant.IdentityKey
+	}
+	if claimed == "" {
+		return nil, ErrCodeKeyExchangeUnsigned, errors.New("identity_key is required without a DID")
+	}
+
+	key, err := ParseEd25519PublicKey(claimed)
+	if err != nil {
+		return nil, ErrCodeIdentityMismatch, fmt.Errorf("invalid identity key: %w", err)
+	}
+	return []ed25519.PublicKey{key}, "", nil
+}
@@ -0,0 +1,287 @@
+package signaling
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type staticResolver map[string]*did.Document
+
+func (r staticResolver) Resolve(ctx context.Context, id string) (*did.Document, error) {
+	doc, ok := r[id]
+	if !ok {
+		return nil, did.ErrNotFound
+	}
+	return doc, nil
+}
+
+func TestVerificationKeysFromDIDKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	server := NewServer()
+	keys, err := server.VerificationKeys(context.Background(), &Participant{DID: DIDKeyFromEd25519(pub)})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, pub, keys[0])
+}
+
+func TestVerificationKeysWithResolver(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	doc := &did.Document{
+		ID: "did:web:example.com",
+		VerificationMethod: []did.VerificationMethod{{
+			ID:              "#key-1",
+			Type:            did.TypeEd25519VerificationKey,
+			PublicKeyBase58: did.EncodeBase58(pub),
+		}},
+		Authentication: []did.Relationship{{Reference: "#key-1"}},
+	}
+	server := NewServer(WithDIDResolver(staticResolver{doc.ID: doc}))
+
+	keys, err := server.VerificationKeys(context.Background(), &Participant{DID: doc.ID})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, pub, keys[0])
+
+	_, err = server.VerificationKeys(context.Background(), &Participant{DID: "did:web:other.com"})
+	assert.ErrorIs(t, err, did.ErrNotFound)
+}
+
+func TestVerificationKeysWithoutDID(t *testing.T) {
+	server := NewServer()
+
+	_, err := server.VerificationKeys(context.Background(), &Participant{})
+	assert.ErrorIs(t, err, ErrNoIdentity)
+
+	// did:web is not resolvable without a configured resolver
+	_, err = server.VerificationKeys(context.Background(), &Participant{DID: "did:web:example.com"})
+	assert.ErrorIs(t, err, did.ErrMethodNotSupported)
+}
+
+// keyExchangeRoom sets up a room with a host and an admitted guest. Only the
+// host's connection expects writes unless the test adds more.
+func keyExchangeRoom(t *testing.T, server *Server) (*Room, *Participant, *MockWebSocketConn, *MockWebSocketConn) {
+	room := NewRoom("test-room")
+	server.rooms["test-room"] = room
+
+	guestConn := &MockWebSocketConn{}
+	hostConn := &MockWebSocketConn{}
+	guest := &Participant{ID: "guest1", Conn: guestConn, Role: RoleGuest}
+	host := &Participant{ID: "host1", Conn: hostConn, Role: RoleHost}
+	require.NoError(t, room.AddParticipant(host))
+	require.NoError(t, room.AddParticipant(guest))
+	require.NoError(t, room.AllowGuest(guest.ID))
+
+	return room, guest, guestConn, hostConn
+}
+
+func expectErrorCode(conn *MockWebSocketConn, code string) {
+	conn.On("WriteJSON", mock.MatchedBy(func(msg *Message) bool {
+		data, ok := msg.Data.(ErrorData)
+		return msg.Type == MessageTypeError && ok && data.Code == code
+	})).Return(nil).Once()
+}
+
+func TestKeyExchangeBroadcastsProof(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	var broadcast *Message
+	hostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Run(func(args mock.Arguments) {
+		broadcast = args.Get(0).(*Message)
+	}).Return(nil).Once()
+	guestConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once()
+
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyExchange("test-room", guest.ID, publicKey, time.Now()),
+	})
+
+	require.NotNil(t, broadcast)
+	data := broadcast.Data.(PublicKeysData)
+	assert.Equal(t, publicKey, data.Keys[guest.ID])
+
+	proof := data.Proofs[guest.ID]
+	assert.Equal(t, publicKey, proof.PublicKey)
+	assert.NoError(t, VerifyKeyProof("test-room", guest.ID, proof))
+	assert.Error(t, VerifyKeyProof("other-room", guest.ID, proof))
+
+	// The first identity key is pinned to the participant
+	assert.Equal(t, proof.IdentityKey, room.GetParticipant(guest.ID).IdentityKey)
+}
+
+func TestKeyExchangeRejectsUnsignedAndStale(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, _ := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	expectErrorCode(guestConn, ErrCodeKeyExchangeUnsigned)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: map[string]interface{}{"public_key": publicKey},
+	})
+
+	expectErrorCode(guestConn, ErrCodeKeyExchangeStale)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyExchange("test-room", guest.ID, publicKey, time.Now().Add(-10*time.Minute)),
+	})
+
+	// Signed for a different participant
+	expectErrorCode(guestConn, ErrCodeInvalidSignature)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyExchange("test-room", "host1", publicKey, time.Now()),
+	})
+
+	_, exists := room.GetPublicKey(guest.ID)
+	assert.False(t, exists)
+	guestConn.AssertExpectations(t)
+}
+
+func TestKeyExchangeRejectsReplayAndSwappedIdentity(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	hostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil)
+	guestConn.On("WriteJSON", mock.MatchedBy(func(msg *Message) bool {
+		return msg.Type == MessageTypePublicKeys
+	})).Return(nil).Once()
+
+	announcement := identity.keyExchange("test-room", guest.ID, publicKey, time.Now())
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeKeyExchange, Data: announcement})
+
+	expectErrorCode(guestConn, ErrCodeKeyExchangeStale)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeKeyExchange, Data: announcement})
+
+	otherKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+	expectErrorCode(guestConn, ErrCodeIdentityMismatch)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: newTestIdentity(t).keyExchange("test-room", guest.ID, otherKey, time.Now().Add(time.Second)),
+	})
+
+	savedKey, _ := room.GetPublicKey(guest.ID)
+	assert.Equal(t, publicKey, savedKey)
+	guestConn.AssertExpectations(t)
+}
+
+func TestKeyExchangeUsesDIDKeys(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+	guest.DID = identity.did()
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	// A key that is not in the DID document is refused even if it signs
+	expectErrorCode(guestConn, ErrCodeIdentityMismatch)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: newTestIdentity(t).keyExchange("test-room", guest.ID, publicKey, time.Now()),
+	})
+
+	hostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once()
+	guestConn.On("WriteJSON", mock.MatchedBy(func(msg *Message) bool {
+		return msg.Type == MessageTypePublicKeys
+	})).Return(nil).Once()
+
+	data := identity.keyExchange("test-room", guest.ID, publicKey, time.Now())
+	delete(data, "identity_key")
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeKeyExchange, Data: data})
+
+	proof, exists := room.GetKeyProof(guest.ID)
+	require.True(t, exists)
+	assert.Equal(t, guest.DID, proof.DID)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(identity.public), proof.IdentityKey)
+	guestConn.AssertExpectations(t)
+	hostConn.AssertExpectations(t)
+}
+
+func TestKeyExchangeWithBundle(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+	bundle := testKeyBundle(t)
+
+	var broadcast *Message
+	hostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Run(func(args mock.Arguments) {
+		broadcast = args.Get(0).(*Message)
+	}).Return(nil).Once()
+	guestConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once()
+
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyBundleExchange("test-room", guest.ID, bundle, time.Now()),
+	})
+
+	require.NotNil(t, broadcast)
+	data := broadcast.Data.(PublicKeysData)
+	assert.Equal(t, bundle, data.Bundles[guest.ID])
+	assert.Equal(t, bundle[0].PublicKey, data.Keys[guest.ID])
+	assert.NoError(t, VerifyKeyProof("test-room", guest.ID, data.Proofs[guest.ID]))
+
+	// Swapping a key of the bundle breaks the signature
+	tampered := data.Proofs[guest.ID]
+	tampered.Keys = []TypedKey{bundle[0], testKeyBundle(t)[1], bundle[2]}
+	assert.Error(t, VerifyKeyProof("test-room", guest.ID, tampered))
+
+	participant := room.GetParticipant(guest.ID)
+	assert.Equal(t, bundle, participant.Keys.Bundle)
+}
+
+func TestKeyExchangeRejectsInvalidBundle(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, _ := keyExchangeRoom(t, server)
+	identity := newTestIdentity(t)
+
+	bundle := testKeyBundle(t)
+	bundle[1].Algorithm = KeyAlgorithmP256
+
+	expectErrorCode(guestConn, ErrCodeInvalidPublicKey)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyBundleExchange("test-room", guest.ID, bundle, time.Now()),
+	})
+	guestConn.AssertExpectations(t)
+}
+
+func TestNotifyDID(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	guest.DID = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+	message := &Message{Type: MessageTypePreKeysLow, Data: PreKeysLowData{Remaining: 3, Threshold: 10}}
+	guestConn.On("WriteJSON", message).Return(nil).Once()
+
+	assert.Equal(t, 1, server.NotifyDID(guest.DID, message))
+	assert.Equal(t, 0, server.NotifyDID("did:key:other", message))
+	assert.Equal(t, 0, server.NotifyDID("", message))
+
+	guestConn.AssertExpectations(t)
+	hostConn.AssertNotCalled(t, "WriteJSON", message)
+}
@@ -0,0 +1,130 @@
+package signaling
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/transparency"
+	"golang.org/x/time/rate"
+)
+
+// The key log is shared by every room and refuses entries for good once
+// full, so clients may only add to it at a limited rate.
+const (
+	// DefaultKeyLogInterval is how often a client address may log new keys
+	// once it has used up DefaultKeyLogBurst.
+	DefaultKeyLogInterval = time.Minute
+	DefaultKeyLogBurst    = 5
+)
+
+// WithKeyLogRate limits how often keys published from one address, or by
+// one identity when the address is unknown, are appended to the key log.
+// Keys over the limit are still shared, without an inclusion proof.
+func WithKeyLogRate(interval time.Duration, burst int) Option {
+	return func(s *Server) {
+		s.keyLogLimiter = newKeyLogLimiter(interval, burst)
+	}
+}
+
+// keyLogLimiter is a token bucket per client address or identity.
+type keyLogLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+	pruned   time.Time
+}
+
+func newKeyLogLimiter(interval time.Duration, burst int) *keyLogLimiter {
+	return &keyLogLimiter{
+		limit:    rate.Every(interval),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+		pruned:   time.Now(),
+	}
+}
+
+// allow reports whether key may append to the key log now. A nil limiter
+// allows everything.
+func (l *keyLogLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Full buckets behave like new ones, so they can be dropped
+	if now.Sub(l.pruned) > time.Minute {
+		for k, limiter := range l.limiters {
+			if limiter.TokensAt(now) >= float64(l.burst) {
+				delete(l.limiters, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	limiter, exists := l.limiters[key]
+	if !exists {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[key] = limiter
+	}
+	return limiter.AllowN(now, 1)
+}
+
+// pendingKeys are saved keys still to be appended to the key log.
+type pendingKeys struct {
+	participantID string
+	keys          []TypedKey
+	identity      string
+	limitKey      string // who the append counts against
+}
+
+// logKeys appends saved keys to the key log and records their index. It
+// must be called without the mutex, since appending may wait for storage.
+func (r *Room) logKeys(pending *pendingKeys) {
+	if pending == nil {
+		return
+	}
+
+	entry := transparency.Entry{
+		Identity:      pending.identity,
+		Room:          r.Slug,
+		ParticipantID: pending.participantID,
+		Keys:          make([]transparency.Key, len(pending.keys)),
+		Timestamp:     time.Now().UnixMilli(),
+	}
+	for i, key := range pending.keys {
+		entry.Keys[i] = transparency.Key{Algorithm: string(key.Algorithm), PublicKey: key.PublicKey}
+	}
+
+	// Keys the identity already logged cost nothing
+	index, logged := r.keyLog.Logged(entry)
+	if !logged {
+		if !r.keyLogLimit.allow(pending.limitKey) {
+			log.Printf("Not logging keys of %s: too many key log entries from %s", pending.participantID, pending.limitKey)
+			return
+		}
+		var err error
+		index, err = r.keyLog.Append(entry)
+		if err != nil {
+			log.Printf("Failed to append keys of %s to the key log: %v", pending.participantID, err)
+			return
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// The keys may have been replaced or removed while appending
+	if !slices.Equal(r.KeyBundles[pending.participantID], pending.keys) {
+		return
+	}
+	if r.KeyLogIndex == nil {
+		r.KeyLogIndex = make(map[string]uint64)
+	}
+	r.KeyLogIndex[pending.participantID] = index
+}
@@ -0,0 +1,263 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// Knocking guests wait in the lobby in the order they knocked. Whenever the
+// lobby changes, waiting guests get their position with lobby_position and
+// those who may admit get the queue with lobby. A knock that is neither
+// allowed nor denied within the knock timeout is denied automatically.
+// Admitters and waiting guests can talk with lobby_message.
+
+const (
+	// DefaultKnockTimeout is how long the app lets guests knock.
+	DefaultKnockTimeout = 5 * time.Minute
+	// MaxKnockNoteLength and MaxLobbyMessageLength are in characters.
+	MaxKnockNoteLength    = 200
+	MaxLobbyMessageLength = 1000
+
+	// DenyReasonTimeout is the reason of a deny sent when a knock expires.
+	DenyReasonTimeout = "timeout"
+)
+
+// WithKnockTimeout denies knocking guests that have not been let in after
+// timeout. Zero lets them wait indefinitely.
+func WithKnockTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.knockTimeout = timeout
+	}
+}
+
+// lobbyEntry is a knocking guest in the lobby queue.
+type lobbyEntry struct {
+	participant *Participant
+	knockedAt   time.Time
+	expiresAt   time.Time // zero without a knock timeout
+	timer       *time.Timer
+}
+
+// LobbyEntry describes a waiting guest to those who may admit.
+type LobbyEntry struct {
+	ParticipantID string     `json:"participant_id"`
+	Name          string     `json:"name,omitempty"`
+	Note          string     `json:"note,omitempty"`
+	Position      int        `json:"position"`
+	KnockedAt     time.Time  `json:"knocked_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+type LobbyData struct {
+	Queue []LobbyEntry `json:"queue"`
+}
+
+// LobbyPositionData tells a waiting guest where it is in the queue.
+type LobbyPositionData struct {
+	Position  int        `json:"position"` // 1 is next in line
+	Size      int        `json:"size"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// DenyData is the payload of a deny the server sends on its own.
+type DenyData struct {
+	Reason string `json:"reason"`
+}
+
+// LobbyMessageData is a text message between the lobby and those who may
+// admit. Admitters address one waiting guest with To, or the whole lobby
+// without it; waiting guests always write to the admitters.
+type LobbyMessageData struct {
+	To   string `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+func (d LobbyMessageData) Validate() error {
+	if d.Text == "" {
+		return errors.New("text is required")
+	}
+	if utf8.RuneCountInString(d.Text) > MaxLobbyMessageLength {
+		return fmt.Errorf("text is longer than %d characters", MaxLobbyMessageLength)
+	}
+	return nil
+}
+
+// knockNote trims a knock note to MaxKnockNoteLength characters.
+func knockNote(note string) string {
+	if utf8.RuneCountInString(note) <= MaxKnockNoteLength {
+		return note
+	}
+	return string([]rune(note)[:MaxKnockNoteLength])
+}
+
+// enterLobby queues a knocking guest. The caller must hold the mutex.
+func (r *Room) enterLobby(participant *Participant) {
+	r.lobby = append(r.lobby, &lobbyEntry{participant: participant, knockedAt: time.Now()})
+}
+
+// leaveLobby removes a guest from the lobby queue and reports whether it was
+// there. The caller must hold the mutex.
+func (r *Room) leaveLobby(participantID string) bool {
+	for i, entry := range r.lobby {
+		if entry.participant.ID == participantID {
+			if entry.timer != nil {
+				entry.timer.Stop()
+			}
+			r.lobby = append(r.lobby[:i], r.lobby[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// startKnockTimer calls expire once participant has waited for timeout.
+func (r *Room) startKnockTimer(participant *Participant, timeout time.Duration, expire func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, entry := range r.lobby {
+		if entry.participant == participant {
+			entry.expiresAt = entry.knockedAt.Add(timeout)
+			entry.timer = time.AfterFunc(time.Until(entry.expiresAt), expire)
+			return
+		}
+	}
+}
+
+// expireKnock removes participant if it is still waiting and reports
+// whether it was.
+func (r *Room) expireKnock(participant *Participant) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Guests[participant.ID] != participant || !r.leaveLobby(participant.ID) {
+		return false
+	}
+	participant.Status = StatusDisconnected
+	delete(r.Guests, participant.ID)
+	return true
+}
+
+// lobbyQueue returns the waiting guests in order.
+func (r *Room) lobbyQueue() ([]*Participant, []LobbyEntry) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	participants := make([]*Participant, len(r.lobby))
+	entries := make([]LobbyEntry, len(r.lobby))
+	for i, entry := range r.lobby {
+		participants[i] = entry.participant
+		entries[i] = LobbyEntry{
+			ParticipantID: entry.participant.ID,
+			Name:          entry.participant.Name,
+			Note:          entry.participant.KnockNote,
+			Position:      i + 1,
+			KnockedAt:     entry.knockedAt,
+		}
+		if !entry.expiresAt.IsZero() {
+			expiresAt := entry.expiresAt
+			entries[i].ExpiresAt = &expiresAt
+		}
+	}
+	return participants, entries
+}
+
+// announceLobby sends the queue to those who may admit and every waiting
+// guest its position.
+func announceLobby(room *Room) {
+	participants, entries := room.lobbyQueue()
+
+	room.sendToPermitted(PermissionAdmit, &Message{
+		Type:      MessageTypeLobby,
+		Slug:      room.Slug,
+		Data:      LobbyData{Queue: entries},
+		Timestamp: time.Now(),
+	})
+	for i, participant := range participants {
+		room.deliverTo(participant.ID, &Message{
+			Type: MessageTypeLobbyPosition,
+			Slug: room.Slug,
+			Data: LobbyPositionData{
+				Position:  entries[i].Position,
+				Size:      len(entries),
+				ExpiresAt: entries[i].ExpiresAt,
+			},
+			Timestamp: time.Now(),
+		}, nil)
+	}
+}
+
+// knocked puts a guest that just joined in the lobby queue.
+func (s *Server) knocked(slug string, room *Room, participant *Participant) {
+	if s.knockTimeout > 0 {
+		room.startKnockTimer(participant, s.knockTimeout, func() {
+			s.knockExpired(slug, room, participant)
+		})
+	}
+	announceLobby(room)
+}
+
+func (s *Server) knockExpired(slug string, room *Room, participant *Participant) {
+	s.mutex.RLock()
+	current := s.rooms[slug]
+	s.mutex.RUnlock()
+	if current != room || !room.expireKnock(participant) {
+		return
+	}
+
+	log.Printf("Knock of %s on room %s timed out", participant.ID, slug)
+	participant.endSession()
+	participant.Send(&Message{
+		Type:      MessageTypeDeny,
+		To:        participant.ID,
+		Slug:      slug,
+		Data:      DenyData{Reason: DenyReasonTimeout},
+		Timestamp: time.Now(),
+	})
+	participant.Close()
+	announceLobby(room)
+}
+
+func (s *Server) handleLobbyMessage(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(LobbyMessageData)
+
+	switch {
+	case participant.Status == StatusKnocking:
+		data.To = ""
+		room.sendToPermitted(PermissionAdmit, &Message{
+			Type:      MessageTypeLobbyMessage,
+			From:      participant.ID,
+			Slug:      room.Slug,
+			Data:      data,
+			Timestamp: time.Now(),
+		})
+
+	case room.permits(participant, PermissionAdmit):
+		lobbyMessage := &Message{
+			Type:      MessageTypeLobbyMessage,
+			From:      participant.ID,
+			To:        data.To,
+			Slug:      room.Slug,
+			Data:      data,
+			Timestamp: time.Now(),
+		}
+		if data.To == "" {
+			// Other admitters see what the lobby was told
+			room.sendMatching(func(p *Participant) bool {
+				return p != participant && (p.Status == StatusKnocking || p.can(PermissionAdmit))
+			}, lobbyMessage)
+			return
+		}
+		if target := room.GetParticipant(data.To); target == nil || target.Status != StatusKnocking {
+			message.receipt.report(errRecipientGone)
+			return
+		}
+		room.deliverTo(data.To, lobbyMessage, message.receipt)
+
+	default:
+		rejectMessage(participant, message, ErrCodeForbidden, "only the lobby and those who admit can send lobby messages")
+	}
+}
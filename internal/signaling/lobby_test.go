@@ -0,0 +1,150 @@
+package signaling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastOfType returns the last message of messageType conn received.
+func lastOfType(conn *recordingConn, messageType MessageType) *Message {
+	written := conn.written()
+	for i := len(written) - 1; i >= 0; i-- {
+		if written[i].Type == messageType {
+			return written[i]
+		}
+	}
+	return nil
+}
+
+// messagesOfType returns the data of the messages of the given type written
+// to conn, oldest first.
+func messagesOfType[T any](conn *recordingConn, messageType MessageType) []T {
+	var data []T
+	for _, message := range conn.written() {
+		if message.Type == messageType {
+			data = append(data, message.Data.(T))
+		}
+	}
+	return data
+}
+
+// errorCode returns the code of the last error written to conn, or "" if
+// there was none.
+func errorCode(conn *recordingConn) string {
+	if message := lastOfType(conn, MessageTypeError); message != nil {
+		return message.Data.(ErrorData).Code
+	}
+	return ""
+}
+
+func knock(server *Server, id, note string) *recordingConn {
+	conn := newRecordingConn()
+	server.joinRoom("test-room", &Participant{ID: id, Conn: conn, Role: RoleGuest, KnockNote: note})
+	return conn
+}
+
+func TestLobbyQueue(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+
+	first := knock(server, "k1", "")
+	assert.Equal(t, LobbyPositionData{Position: 1, Size: 1}, lastOfType(first, MessageTypeLobbyPosition).Data)
+
+	second := knock(server, "k2", "from the design team")
+	assert.Equal(t, LobbyPositionData{Position: 1, Size: 2}, lastOfType(first, MessageTypeLobbyPosition).Data)
+	assert.Equal(t, LobbyPositionData{Position: 2, Size: 2}, lastOfType(second, MessageTypeLobbyPosition).Data)
+
+	queue := lastOfType(conns["host1"], MessageTypeLobby).Data.(LobbyData).Queue
+	require.Len(t, queue, 2)
+	assert.Equal(t, "k1", queue[0].ParticipantID)
+	assert.Equal(t, 2, queue[1].Position)
+	assert.Equal(t, "from the design team", queue[1].Note)
+	assert.Nil(t, lastOfType(conns["guest1"], MessageTypeLobby), "guests that cannot admit do not see the lobby")
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeAllow, Data: "k1"})
+	assert.Equal(t, LobbyPositionData{Position: 1, Size: 1}, lastOfType(second, MessageTypeLobbyPosition).Data)
+	assert.Len(t, lastOfType(conns["host1"], MessageTypeLobby).Data.(LobbyData).Queue, 1)
+
+	server.leaveRoom("test-room", room.GetParticipant("k2"))
+	assert.Empty(t, lastOfType(conns["host1"], MessageTypeLobby).Data.(LobbyData).Queue)
+}
+
+func TestKnockTimeout(t *testing.T) {
+	server := NewServer(WithKnockTimeout(20 * time.Millisecond))
+	room, conns := handoffRoom(t, server)
+
+	conn := knock(server, "k1", "")
+	position := lastOfType(conn, MessageTypeLobbyPosition).Data.(LobbyPositionData)
+	require.NotNil(t, position.ExpiresAt)
+
+	waitClosed(t, conn)
+	assert.Equal(t, DenyData{Reason: DenyReasonTimeout}, lastOfType(conn, MessageTypeDeny).Data)
+	assert.Nil(t, room.GetParticipant("k1"))
+	assert.Empty(t, lastOfType(conns["host1"], MessageTypeLobby).Data.(LobbyData).Queue)
+}
+
+func TestAllowedGuestDoesNotTimeOut(t *testing.T) {
+	server := NewServer(WithKnockTimeout(20 * time.Millisecond))
+	room, _ := handoffRoom(t, server)
+
+	conn := knock(server, "k1", "")
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeAllow, Data: "k1"})
+	time.Sleep(40 * time.Millisecond)
+
+	assert.Equal(t, StatusInRoom, room.GetParticipant("k1").Status)
+	assert.Nil(t, lastOfType(conn, MessageTypeDeny))
+}
+
+func TestLobbyMessages(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleCoHost
+	first := knock(server, "k1", "")
+	second := knock(server, "k2", "")
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeLobbyMessage, Data: map[string]interface{}{"to": "k1", "text": "who are you?"}})
+	question := lastOfType(first, MessageTypeLobbyMessage)
+	require.NotNil(t, question)
+	assert.Equal(t, "host1", question.From)
+	assert.Equal(t, LobbyMessageData{To: "k1", Text: "who are you?"}, question.Data)
+	assert.Nil(t, lastOfType(second, MessageTypeLobbyMessage))
+
+	server.handleMessage("test-room", room.GetParticipant("k1"), &Message{Type: MessageTypeLobbyMessage, Data: map[string]interface{}{"to": "guest2", "text": "Alice, from accounting"}})
+	for _, id := range []string{"host1", "guest1"} {
+		answer := lastOfType(conns[id], MessageTypeLobbyMessage)
+		require.NotNil(t, answer, id)
+		assert.Equal(t, LobbyMessageData{Text: "Alice, from accounting"}, answer.Data)
+	}
+	assert.Nil(t, lastOfType(conns["guest2"], MessageTypeLobbyMessage))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeLobbyMessage, Data: map[string]interface{}{"text": "one moment please"}})
+	assert.Equal(t, "one moment please", lastOfType(second, MessageTypeLobbyMessage).Data.(LobbyMessageData).Text)
+	assert.Equal(t, "one moment please", lastOfType(conns["guest1"], MessageTypeLobbyMessage).Data.(LobbyMessageData).Text)
+
+	server.handleMessage("test-room", room.GetParticipant("guest2"), &Message{Type: MessageTypeLobbyMessage, Data: map[string]interface{}{"text": "hi"}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest2"]))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeLobbyMessage, Data: map[string]interface{}{"text": strings.Repeat("a", MaxLobbyMessageLength+1)}})
+	assert.Equal(t, ErrCodeInvalidMessage, errorCode(conns["host1"]))
+}
+
+func TestKnockNote(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	readUntil(t, host, MessageTypeParticipants)
+	dialWS(t, testServer, "token=guest-token&note=Bob+from+QA")
+
+	knock := readUntil(t, host, MessageTypeKnock)
+	assert.Equal(t, "Bob from QA", knock.Data.(map[string]interface{})["knock_note"])
+
+	assert.Len(t, []rune(knockNote(strings.Repeat("ä", MaxKnockNoteLength+5))), MaxKnockNoteLength)
+}
@@ -0,0 +1,225 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Client messages are read with their data left as raw JSON and decoded
+// according to their type before they are handled. A message that cannot be
+// decoded, or that a handler refuses, is answered with an error carrying a
+// stable code and the message's ID.
+
+const (
+	ErrCodeMalformedMessage   = "MALFORMED_MESSAGE"    // not a JSON message envelope
+	ErrCodeUnknownMessageType = "UNKNOWN_MESSAGE_TYPE" // clients cannot send this type
+	ErrCodeInvalidMessage     = "INVALID_MESSAGE"      // data does not match the type
+	ErrCodeForbidden          = "FORBIDDEN"            // the sender's role may not send this type
+	ErrCodeNotInRoom          = "NOT_IN_ROOM"          // the sender has not been let in yet
+)
+
+// MessageError rejects a client message.
+type MessageError struct {
+	Code    string
+	Message string
+}
+
+func (e *MessageError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func invalidData(code, format string, args ...interface{}) *MessageError {
+	return &MessageError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// validator is implemented by message data with constraints beyond its
+// JSON shape.
+type validator interface {
+	Validate() error
+}
+
+// messageDecoder converts the data of a client message into its typed form.
+type messageDecoder func(data interface{}) (interface{}, error)
+
+// inboundMessages lists the message types clients may send. A nil decoder
+// leaves the data untouched: the server relays it without looking inside.
+var inboundMessages = map[MessageType]messageDecoder{
+	MessageTypePing:                 nil,
+	MessageTypePong:                 nil,
+	MessageTypeOffer:                nil,
+	MessageTypeAnswer:               nil,
+	MessageTypeICECandidate:         nil,
+	MessageTypeAllow:                decodeGuestID,
+	MessageTypeDeny:                 decodeGuestID,
+	MessageTypeTransferHost:         decodeParticipantRef,
+	MessageTypeSetSuccessor:         decodeParticipantRef,
+	MessageTypeGrantRole:            decodeAs[RoleData],
+	MessageTypeRevokeRole:           decodeAs[RoleData],
+	MessageTypeMuteRequest:          decodeAs[MuteRequestData],
+	MessageTypeKick:                 decodeAs[RemovalData],
+	MessageTypeBan:                  decodeAs[RemovalData],
+	MessageTypeUnban:                decodeParticipantRef,
+	MessageTypeLobbyMessage:         decodeAs[LobbyMessageData],
+	MessageTypeRoomSettings:         decodeAs[RoomSettingsUpdate],
+	MessageTypeKeyExchange:          decodeAs[KeyExchangeData],
+	MessageTypeEncrypted:            decodeAs[EncryptedData],
+	MessageTypeSASStart:             decodeAs[SASData],
+	MessageTypeSASConfirm:           decodeAs[SASData],
+	MessageTypeSASFail:              decodeAs[SASData],
+	MessageTypeMLSKeyPackages:       decodeAs[MLSKeyPackagesData],
+	MessageTypeMLSKeyPackageRequest: decodeAs[MLSKeyPackageRequestData],
+	MessageTypeMLSProposal:          decodeAs[MLSProposalData],
+	MessageTypeMLSCommit:            decodeAs[MLSCommitData],
+}
+
+// inboundMessage is the wire form of a client message.
+type inboundMessage struct {
+	Message
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func (m *inboundMessage) message() Message {
+	message := m.Message
+	message.Data = nil
+	if len(m.Data) > 0 {
+		message.Data = m.Data
+	}
+	return message
+}
+
+// decodeMessage replaces the data of a message from a client speaking
+// version with its typed form.
+func decodeMessage(message *Message, version ProtocolVersion) error {
+	decode, ok := version.inboundDecoder(message.Type)
+	if !ok {
+		return invalidData(ErrCodeUnknownMessageType, "unknown message type %q", message.Type)
+	}
+	if decode == nil {
+		return nil
+	}
+
+	data, err := decode(message.Data)
+	if err != nil {
+		var messageErr *MessageError
+		if errors.As(err, &messageErr) {
+			return messageErr
+		}
+		return invalidData(ErrCodeInvalidMessage, "invalid %s data: %v", message.Type, err)
+	}
+	message.Data = data
+	return nil
+}
+
+// decodeAs decodes message data into T and validates it.
+func decodeAs[T any](data interface{}) (interface{}, error) {
+	var typed T
+	if err := unmarshalData(data, &typed); err != nil {
+		return nil, err
+	}
+	if v, ok := any(typed).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return typed, nil
+}
+
+// decodeGuestID decodes the data of allow and deny, the ID of a guest.
+func decodeGuestID(data interface{}) (interface{}, error) {
+	var guestID string
+	if err := unmarshalData(data, &guestID); err != nil {
+		return nil, err
+	}
+	if guestID == "" {
+		return nil, errors.New("a guest ID is required")
+	}
+	return guestID, nil
+}
+
+func unmarshalData(data interface{}, v interface{}) error {
+	if data == nil {
+		return errors.New("data is required")
+	}
+	switch raw := data.(type) {
+	case json.RawMessage:
+		return json.Unmarshal(raw, v)
+	case binaryData:
+		return raw.decode(v)
+	}
+	return decodeData(data, v)
+}
+
+// rejectMessage answers a client message with an error. The message is not
+// acked, the error takes the place of the ack.
+func rejectMessage(participant *Participant, message *Message, code, text string) {
+	message.receipt.claim()
+	participant.Send(&Message{
+		Type: MessageTypeError,
+		Data: ErrorData{
+			Code:    code,
+			Message: text,
+			ID:      message.ID,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+func (d KeyExchangeData) Validate() error {
+	if d.PublicKey == "" && len(d.Keys) == 0 {
+		return errors.New("public_key or keys is required")
+	}
+	for _, key := range d.Keys {
+		if key.Algorithm == "" || key.PublicKey == "" {
+			return errors.New("every key needs an algorithm and a public_key")
+		}
+	}
+	return nil
+}
+
+func (d EncryptedData) Validate() error {
+	if d.To == "" {
+		return errors.New("to is required")
+	}
+	if d.Data == "" {
+		return errors.New("data is required")
+	}
+	return nil
+}
+
+func (d MLSKeyPackagesData) Validate() error {
+	if len(d.KeyPackages) == 0 {
+		return invalidData(ErrCodeMLSInvalidMessage, "key_packages is required")
+	}
+	for _, keyPackage := range d.KeyPackages {
+		if err := validateMLSBlob(keyPackage); err != nil {
+			return invalidData(ErrCodeMLSInvalidMessage, "invalid KeyPackage: %v", err)
+		}
+	}
+	return nil
+}
+
+func (d MLSKeyPackageRequestData) Validate() error {
+	if d.ParticipantID == "" {
+		return invalidData(ErrCodeMLSInvalidMessage, "participant_id is required")
+	}
+	return nil
+}
+
+func (d MLSProposalData) Validate() error {
+	if validateMLSBlob(d.Message) != nil {
+		return invalidData(ErrCodeMLSInvalidMessage, "invalid proposal")
+	}
+	return nil
+}
+
+func (d MLSCommitData) Validate() error {
+	if validateMLSBlob(d.Message) != nil {
+		return invalidData(ErrCodeMLSInvalidMessage, "invalid commit")
+	}
+	if d.Welcome != "" && validateMLSBlob(d.Welcome) != nil {
+		return invalidData(ErrCodeMLSInvalidMessage, "invalid welcome")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package signaling
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMessageFromRawJSON(t *testing.T) {
+	message := &Message{
+		Type: MessageTypeEncrypted,
+		Data: json.RawMessage(`{"to":"host1","data":"Y2lwaGVy","algorithm":"mls","epoch":4}`),
+	}
+	require.NoError(t, decodeMessage(message, ProtocolV1))
+	assert.Equal(t, EncryptedData{To: "host1", Data: "Y2lwaGVy", Algorithm: "mls", Epoch: 4}, message.Data)
+
+	message = &Message{Type: MessageTypeAllow, Data: json.RawMessage(`"guest1"`)}
+	require.NoError(t, decodeMessage(message, ProtocolV1))
+	assert.Equal(t, "guest1", message.Data)
+
+	// Relayed types keep their data as it was sent
+	raw := json.RawMessage(`{"sdp":"v=0"}`)
+	message = &Message{Type: MessageTypeOffer, Data: raw}
+	require.NoError(t, decodeMessage(message, ProtocolV1))
+	assert.Equal(t, raw, message.Data)
+}
+
+func TestDecodeMessageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *Message
+		code    string
+	}{
+		{"unknown type", &Message{Type: "teleport"}, ErrCodeUnknownMessageType},
+		{"server-only type", &Message{Type: MessageTypePublicKeys}, ErrCodeUnknownMessageType},
+		{"missing data", &Message{Type: MessageTypeKeyExchange}, ErrCodeInvalidMessage},
+		{"wrong shape", &Message{Type: MessageTypeAllow, Data: json.RawMessage(`{"id":"guest1"}`)}, ErrCodeInvalidMessage},
+		{"wrong field type", &Message{Type: MessageTypeEncrypted, Data: json.RawMessage(`{"to":1}`)}, ErrCodeInvalidMessage},
+		{"failed validation", &Message{Type: MessageTypeEncrypted, Data: json.RawMessage(`{"to":"host1"}`)}, ErrCodeInvalidMessage},
+		{"family code", &Message{Type: MessageTypeMLSProposal, Data: json.RawMessage(`{"epoch":1}`)}, ErrCodeMLSInvalidMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeMessage(tt.message, ProtocolV1)
+			require.IsType(t, &MessageError{}, err)
+			assert.Equal(t, tt.code, err.(*MessageError).Code)
+		})
+	}
+}
+
+func TestRejectedMessageCarriesID(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	rejected := captureMessage(guestConn, MessageTypeError, nil)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeEncrypted,
+		ID:   "m1",
+		Ack:  true,
+		Data: map[string]interface{}{"data": "Y2lwaGVy"},
+	})
+
+	require.IsType(t, ErrorData{}, rejected.Data)
+	assert.Equal(t, ErrCodeInvalidMessage, rejected.Data.(ErrorData).Code)
+	assert.Equal(t, "m1", rejected.Data.(ErrorData).ID)
+	guestConn.AssertExpectations(t) // the error replaces the ack
+	hostConn.AssertNotCalled(t, "WriteJSON", isMessageType(MessageTypeEncrypted))
+
+	// A rejected ID is not remembered, so the fixed message can reuse it
+	assert.NotContains(t, room.seenIDs, guest.ID+"\x00m1")
+}
+
+func TestGuestCannotAllow(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, _ := keyExchangeRoom(t, server)
+
+	rejected := captureMessage(guestConn, MessageTypeError, nil)
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeAllow, ID: "a1", Data: "guest1"})
+
+	assert.Equal(t, ErrorData{Code: ErrCodeForbidden, Message: "you are not allowed to admit guests", ID: "a1"}, rejected.Data)
+	guestConn.AssertExpectations(t)
+}
+
+func TestMalformedMessageKeepsConnection(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	conn := dialHost(t, server)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":7}`)))
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "teleport", "id": "t1"}))
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "ping"}))
+
+	var codes []string
+	for len(codes) < 3 {
+		message := readUntil(t, conn, MessageTypeError)
+		data := message.Data.(map[string]interface{})
+		codes = append(codes, data["code"].(string))
+		if data["code"] == ErrCodeUnknownMessageType {
+			assert.Equal(t, "t1", data["id"])
+		}
+	}
+	assert.Equal(t, []string{ErrCodeMalformedMessage, ErrCodeMalformedMessage, ErrCodeUnknownMessageType}, codes)
+	readUntil(t, conn, MessageTypePong)
+}
@@ -0,0 +1,291 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The server acts as an MLS (RFC 9420) delivery service: it never parses
+// MLS messages, but stores KeyPackages and totally orders handshake messages
+// per room by epoch so that members agree on the group state.
+
+const (
+	maxMLSMessageSize         = 64 * 1024
+	maxKeyPackagesPerMember   = 10
+	ErrCodeMLSStaleEpoch      = "MLS_STALE_EPOCH"
+	ErrCodeMLSInvalidMessage  = "MLS_INVALID_MESSAGE"
+	ErrCodeMLSNoKeyPackage    = "MLS_NO_KEY_PACKAGE"
+	ErrCodeMLSTooManyPackages = "MLS_TOO_MANY_KEY_PACKAGES"
+)
+
+type MLSKeyPackagesData struct {
+	KeyPackages []string `json:"key_packages"` // base64 MLS KeyPackages
+}
+
+type MLSKeyPackageRequestData struct {
+	ParticipantID string `json:"participant_id"`
+}
+
+type MLSKeyPackageData struct {
+	ParticipantID string `json:"participant_id"`
+	KeyPackage    string `json:"key_package"`
+}
+
+// MLSProposalData carries a base64 MLSMessage containing a proposal.
+type MLSProposalData struct {
+	Epoch   uint64 `json:"epoch"`
+	Message string `json:"message"`
+}
+
+// MLSCommitData carries a base64 MLSMessage containing a commit, plus the
+// Welcome for members it adds.
+type MLSCommitData struct {
+	Epoch     uint64   `json:"epoch"`
+	Message   string   `json:"message"`
+	Welcome   string   `json:"welcome,omitempty"`
+	WelcomeTo []string `json:"welcome_to,omitempty"` // participant IDs of added members
+}
+
+type MLSWelcomeData struct {
+	Epoch   uint64 `json:"epoch"` // epoch the new member joins at
+	Welcome string `json:"welcome"`
+}
+
+// MLSGroup is the delivery service state of a room's MLS group.
+type MLSGroup struct {
+	mutex       sync.Mutex
+	epoch       uint64
+	keyPackages map[string][]string
+}
+
+func newMLSGroup() *MLSGroup {
+	return &MLSGroup{keyPackages: make(map[string][]string)}
+}
+
+// Epoch returns the current epoch of the group.
+func (g *MLSGroup) Epoch() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.epoch
+}
+
+// AddKeyPackages stores KeyPackages of a member and returns how many it has.
+func (g *MLSGroup) AddKeyPackages(participantID string, keyPackages []string) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.keyPackages[participantID])+len(keyPackages) > maxKeyPackagesPerMember {
+		return len(g.keyPackages[participantID]), fmt.Errorf("at most %d KeyPackages per member", maxKeyPackagesPerMember)
+	}
+	g.keyPackages[participantID] = append(g.keyPackages[participantID], keyPackages...)
+	return len(g.keyPackages[participantID]), nil
+}
+
+// ClaimKeyPackage removes and returns one KeyPackage of a member. The last
+// one is kept, as a last-resort KeyPackage, so a member can always be added.
+func (g *MLSGroup) ClaimKeyPackage(participantID string) (string, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	packages := g.keyPackages[participantID]
+	switch len(packages) {
+	case 0:
+		return "", false
+	case 1:
+		return packages[0], true
+	}
+	g.keyPackages[participantID] = packages[1:]
+	return packages[0], true
+}
+
+// RemoveMember drops the KeyPackages of a member that left.
+func (g *MLSGroup) RemoveMember(participantID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.keyPackages, participantID)
+}
+
+// checkEpoch reports whether a handshake message for epoch can be accepted.
+// The caller must hold the mutex.
+func (g *MLSGroup) checkEpoch(epoch uint64) error {
+	if epoch != g.epoch {
+		return fmt.Errorf("message targets epoch %d, group is at epoch %d", epoch, g.epoch)
+	}
+	return nil
+}
+
+// removeMLSMember drops the KeyPackages of a participant that left. The
+// remaining members are expected to commit its removal.
+func (r *Room) removeMLSMember(participantID string) {
+	r.mutex.RLock()
+	group := r.MLS
+	r.mutex.RUnlock()
+
+	if group != nil {
+		group.RemoveMember(participantID)
+	}
+}
+
+// mlsGroup returns the room's group, creating it on first use.
+func (r *Room) mlsGroup() *MLSGroup {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.MLS == nil {
+		r.MLS = newMLSGroup()
+	}
+	return r.MLS
+}
+
+func validateMLSBlob(blob string) error {
+	raw, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+	if len(raw) == 0 || len(raw) > maxMLSMessageSize {
+		return fmt.Errorf("message must be between 1 and %d bytes", maxMLSMessageSize)
+	}
+	return nil
+}
+
+func (s *Server) handleMLSKeyPackages(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(MLSKeyPackagesData)
+
+	if _, err := room.mlsGroup().AddKeyPackages(participant.ID, data.KeyPackages); err != nil {
+		rejectMessage(participant, message, ErrCodeMLSTooManyPackages, err.Error())
+	}
+}
+
+func (s *Server) handleMLSKeyPackageRequest(room *Room, participant *Participant, message *Message) {
+	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
+		return
+	}
+
+	data := message.Data.(MLSKeyPackageRequestData)
+
+	keyPackage, ok := room.mlsGroup().ClaimKeyPackage(data.ParticipantID)
+	if !ok {
+		rejectMessage(participant, message, ErrCodeMLSNoKeyPackage, "no KeyPackage available for "+data.ParticipantID)
+		return
+	}
+
+	participant.Send(&Message{
+		Type: MessageTypeMLSKeyPackage,
+		Slug: room.Slug,
+		Data: MLSKeyPackageData{
+			ParticipantID: data.ParticipantID,
+			KeyPackage:    keyPackage,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// handleMLSProposal fans a proposal for the current epoch out to the other
+// members.
+func (s *Server) handleMLSProposal(room *Room, participant *Participant, message *Message) {
+	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
+		return
+	}
+	if !room.permits(participant, PermissionManageKeys) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to send MLS proposals")
+		return
+	}
+
+	data := message.Data.(MLSProposalData)
+
+	group := room.mlsGroup()
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if err := group.checkEpoch(data.Epoch); err != nil {
+		rejectMessage(participant, message, ErrCodeMLSStaleEpoch, err.Error())
+		return
+	}
+
+	room.BroadcastToAll(&Message{
+		Type:      MessageTypeMLSProposal,
+		From:      participant.ID,
+		Slug:      room.Slug,
+		Data:      data,
+		Timestamp: time.Now(),
+	}, participant.ID)
+}
+
+// handleMLSCommit accepts the first commit for the current epoch and
+// advances the group. The commit is echoed to its sender too: receiving it
+// is the confirmation that it won and may be merged. Later commits for the
+// same epoch are rejected as stale.
+func (s *Server) handleMLSCommit(room *Room, participant *Participant, message *Message) {
+	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
+		return
+	}
+	if !room.permits(participant, PermissionManageKeys) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to send MLS commits")
+		return
+	}
+
+	data := message.Data.(MLSCommitData)
+
+	group := room.mlsGroup()
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if err := group.checkEpoch(data.Epoch); err != nil {
+		rejectMessage(participant, message, ErrCodeMLSStaleEpoch, err.Error())
+		return
+	}
+	group.epoch++
+
+	welcome := data.Welcome
+	welcomeTo := data.WelcomeTo
+	data.Welcome = ""
+	data.WelcomeTo = nil
+
+	room.BroadcastToAll(&Message{
+		Type:      MessageTypeMLSCommit,
+		From:      participant.ID,
+		Slug:      room.Slug,
+		Data:      data,
+		Timestamp: time.Now(),
+	}, "")
+
+	if welcome == "" {
+		return
+	}
+	for _, memberID := range welcomeTo {
+		member := room.GetParticipant(memberID)
+		if member == nil || member.Status != StatusInRoom {
+			log.Printf("Dropping MLS welcome for %s: not in room %s", memberID, room.Slug)
+			continue
+		}
+		member.Send(&Message{
+			Type: MessageTypeMLSWelcome,
+			From: participant.ID,
+			Slug: room.Slug,
+			Data: MLSWelcomeData{
+				Epoch:   group.epoch,
+				Welcome: welcome,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+// checkMLSApplication validates the epoch of an MLS application message
+// sent through encrypted_data. Messages from the previous epoch are still
+// accepted since they may have been sent while a commit was in flight.
+func (s *Server) checkMLSApplication(room *Room, epoch uint64) error {
+	current := room.mlsGroup().Epoch()
+	if epoch > current || epoch+1 < current {
+		return fmt.Errorf("application message targets epoch %d, group is at epoch %d", epoch, current)
+	}
+	return nil
+}
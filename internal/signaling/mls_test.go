@@ -0,0 +1,170 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func mlsBlob(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func isMessageType(messageType MessageType) interface{} {
+	return mock.MatchedBy(func(msg *Message) bool {
+		return msg.Type == messageType
+	})
+}
+
+func TestMLSKeyPackages(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	host := room.Host
+
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeMLSKeyPackages,
+		Data: map[string]interface{}{"key_packages": []interface{}{mlsBlob("kp-1"), mlsBlob("kp-2")}},
+	})
+
+	var claimed []string
+	hostConn.On("WriteJSON", isMessageType(MessageTypeMLSKeyPackage)).Run(func(args mock.Arguments) {
+		claimed = append(claimed, args.Get(0).(*Message).Data.(MLSKeyPackageData).KeyPackage)
+	}).Return(nil).Times(3)
+
+	request := &Message{
+		Type: MessageTypeMLSKeyPackageRequest,
+		Data: map[string]interface{}{"participant_id": guest.ID},
+	}
+	for i := 0; i < 3; i++ {
+		server.handleMessage("test-room", host, request)
+	}
+
+	// The last KeyPackage is kept as a last resort
+	assert.Equal(t, []string{mlsBlob("kp-1"), mlsBlob("kp-2"), mlsBlob("kp-2")}, claimed)
+
+	expectErrorCode(hostConn, ErrCodeMLSNoKeyPackage)
+	server.handleMessage("test-room", host, &Message{
+		Type: MessageTypeMLSKeyPackageRequest,
+		Data: map[string]interface{}{"participant_id": "nobody"},
+	})
+
+	expectErrorCode(guestConn, ErrCodeMLSInvalidMessage)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeMLSKeyPackages,
+		Data: map[string]interface{}{"key_packages": []interface{}{"not base64!"}},
+	})
+
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestMLSCommitAdvancesEpoch(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	host := room.Host
+
+	// Both members receive the commit, the sender as confirmation
+	hostConn.On("WriteJSON", isMessageType(MessageTypeMLSCommit)).Return(nil).Once()
+	guestConn.On("WriteJSON", isMessageType(MessageTypeMLSCommit)).Return(nil).Once()
+	guestConn.On("WriteJSON", mock.MatchedBy(func(msg *Message) bool {
+		data, ok := msg.Data.(MLSWelcomeData)
+		return msg.Type == MessageTypeMLSWelcome && ok && data.Epoch == 1 && data.Welcome == mlsBlob("welcome")
+	})).Return(nil).Once()
+
+	server.handleMessage("test-room", host, &Message{
+		Type: MessageTypeMLSCommit,
+		Data: map[string]interface{}{
+			"epoch":      float64(0),
+			"message":    mlsBlob("commit-0"),
+			"welcome":    mlsBlob("welcome"),
+			"welcome_to": []interface{}{guest.ID, "unknown"},
+		},
+	})
+	assert.Equal(t, uint64(1), room.mlsGroup().Epoch())
+
+	// A concurrent commit for the same epoch lost the race
+	expectErrorCode(guestConn, ErrCodeMLSStaleEpoch)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeMLSCommit,
+		Data: map[string]interface{}{"epoch": float64(0), "message": mlsBlob("commit-0b")},
+	})
+	assert.Equal(t, uint64(1), room.mlsGroup().Epoch())
+
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestMLSProposal(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	hostConn.On("WriteJSON", mock.MatchedBy(func(msg *Message) bool {
+		return msg.Type == MessageTypeMLSProposal && msg.From == guest.ID
+	})).Return(nil).Once()
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeMLSProposal,
+		Data: map[string]interface{}{"epoch": float64(0), "message": mlsBlob("proposal")},
+	})
+
+	expectErrorCode(guestConn, ErrCodeMLSStaleEpoch)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeMLSProposal,
+		Data: map[string]interface{}{"epoch": float64(4), "message": mlsBlob("proposal")},
+	})
+
+	assert.Equal(t, uint64(0), room.mlsGroup().Epoch())
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestMLSApplicationMessageEpoch(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+
+	group := room.mlsGroup()
+	group.epoch = 3
+
+	application := func(epoch float64, to string) *Message {
+		return &Message{
+			Type: MessageTypeEncrypted,
+			Data: map[string]interface{}{
+				"to":        to,
+				"algorithm": EncryptionAlgorithmMLS,
+				"epoch":     epoch,
+				"data":      mlsBlob("ciphertext"),
+			},
+		}
+	}
+
+	// Current and previous epochs are relayed
+	hostConn.On("WriteJSON", isMessageType(MessageTypeEncrypted)).Return(nil).Twice()
+	server.handleMessage("test-room", guest, application(3, "all"))
+	server.handleMessage("test-room", guest, application(2, "all"))
+
+	expectErrorCode(guestConn, ErrCodeMLSStaleEpoch)
+	server.handleMessage("test-room", guest, application(1, "all"))
+
+	expectErrorCode(guestConn, ErrCodeMLSInvalidMessage)
+	server.handleMessage("test-room", guest, application(3, "host1"))
+
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestMLSMemberLeaveDropsKeyPackages(t *testing.T) {
+	server := NewServer()
+	room, guest, _, hostConn := keyExchangeRoom(t, server)
+
+	_, err := room.mlsGroup().AddKeyPackages(guest.ID, []string{mlsBlob("kp")})
+	require.NoError(t, err)
+
+	guest.Conn.(*MockWebSocketConn).On("Close").Return(nil)
+	hostConn.On("WriteJSON", mock.Anything).Return(nil)
+	server.leaveRoom("test-room", guest)
+
+	_, ok := room.mlsGroup().ClaimKeyPackage(guest.ID)
+	assert.False(t, ok)
+}
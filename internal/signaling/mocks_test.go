@@ -1,6 +1,19 @@
 package signaling
 
-import "github.com/stretchr/testify/mock"
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
 
 type MockWebSocketConn struct {
 	mock.Mock
@@ -30,3 +43,104 @@ func (m *MockWebSocketConn) WriteMessage(messageType int, data []byte) error {
 	args := m.Called(messageType, data)
 	return args.Error(0)
 }
+
+// staticTokenVerifier accepts only the tokens it was constructed with.
+type staticTokenVerifier map[string]*TokenClaims
+
+func (v staticTokenVerifier) VerifyToken(token string) (*TokenClaims, error) {
+	switch token {
+	case "expired":
+		return nil, ErrTokenExpired
+	case "revoked":
+		return nil, ErrTokenRevoked
+	}
+	claims, ok := v[token]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	copied := *claims
+	return &copied, nil
+}
+
+func newTestVerifier() staticTokenVerifier {
+	return staticTokenVerifier{
+		"host-token":  {Slug: "test-room", Role: RoleHost},
+		"guest-token": {Slug: "test-room", Role: RoleGuest},
+		"alice-token": {Slug: "test-room", Role: RoleGuest, Name: "Alice"},
+		"did-token":   {Slug: "test-room", Role: RoleHost, DID: "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"},
+	}
+}
+
+// testIdentity signs key announcements like a client would.
+type testIdentity struct {
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+func newTestIdentity(t *testing.T) *testIdentity {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return &testIdentity{public: pub, private: priv}
+}
+
+func (i *testIdentity) did() string {
+	return DIDKeyFromEd25519(i.public)
+}
+
+// keyExchange returns key_exchange data as the server decodes it from JSON.
+func (i *testIdentity) keyExchange(slug, participantID, publicKey string, at time.Time) map[string]interface{} {
+	timestamp := at.UnixMilli()
+	signature := ed25519.Sign(i.private, KeyExchangePayload(slug, participantID, publicKey, timestamp))
+	return map[string]interface{}{
+		"public_key":   publicKey,
+		"identity_key": base64.StdEncoding.EncodeToString(i.public),
+		"timestamp":    float64(timestamp),
+		"signature":    base64.StdEncoding.EncodeToString(signature),
+	}
+}
+
+// keyBundleExchange is keyExchange for a typed key bundle.
+func (i *testIdentity) keyBundleExchange(slug, participantID string, keys []TypedKey, at time.Time) map[string]interface{} {
+	timestamp := at.UnixMilli()
+	signature := ed25519.Sign(i.private, KeyExchangePayload(slug, participantID, CanonicalKeyBundle(keys), timestamp))
+
+	entries := make([]interface{}, len(keys))
+	for j, key := range keys {
+		entries[j] = map[string]interface{}{"algorithm": string(key.Algorithm), "public_key": key.PublicKey}
+	}
+	return map[string]interface{}{
+		"keys":         entries,
+		"identity_key": base64.StdEncoding.EncodeToString(i.public),
+		"timestamp":    float64(timestamp),
+		"signature":    base64.StdEncoding.EncodeToString(signature),
+	}
+}
+
+// dialWS connects to testServer with the given query string, offering the
+// given subprotocols.
+func dialWS(t *testing.T, testServer *httptest.Server, query string, subprotocols ...string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?" + query
+	conn, _, err := dialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialHost serves server and joins test-room as its host.
+func dialHost(t *testing.T, server *Server) *websocket.Conn {
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	t.Cleanup(testServer.Close)
+
+	conn := dialWS(t, testServer, "token=host-token")
+	require.Eventually(t, func() bool { return server.GetRoomStats("test-room") != nil }, time.Second, 5*time.Millisecond)
+	return conn
+}
+
+// messageData converts the data of a message read from a client connection
+// into T.
+func messageData[T any](t *testing.T, message *Message) T {
+	var data T
+	require.NoError(t, decodeData(message.Data, &data))
+	return data
+}
@@ -0,0 +1,252 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// What a participant may do is decided by its role through rolePermissions.
+// The host grants and revokes roles at runtime; a room has exactly one host,
+// which only changes hands through transfer_host or promotion. Presenters
+// are guests who may always send to everyone; viewers only receive media,
+// answering offers but never making them.
+
+const (
+	RoleCoHost    ParticipantRole = "co_host"
+	RoleModerator ParticipantRole = "moderator"
+	RolePresenter ParticipantRole = "presenter"
+	RoleViewer    ParticipantRole = "viewer"
+
+	ErrCodeRoleChangeFailed = "ROLE_CHANGE_FAILED"
+)
+
+// Permission is an action only some roles may take.
+type Permission string
+
+const (
+	PermissionAdmit       Permission = "admit"        // allow knocking guests in
+	PermissionDeny        Permission = "deny"         // turn knocking guests away
+	PermissionKick        Permission = "kick"         // remove participants from the room
+	PermissionMuteRequest Permission = "mute_request" // ask participants to mute
+	PermissionLock        Permission = "lock"         // lock and unlock the room
+	PermissionConfigure   Permission = "configure"    // change the other room settings
+	PermissionManageKeys  Permission = "manage_keys"  // change the MLS group with proposals and commits
+	PermissionManageRoles Permission = "manage_roles" // grant and revoke roles, hand over the room
+	PermissionPublish     Permission = "publish"      // send offers, i.e. start sending media
+	PermissionBroadcast   Permission = "broadcast"    // send encrypted_data to everyone, even without guest_broadcast
+)
+
+// rolePermissions is the permission matrix.
+var rolePermissions = map[ParticipantRole][]Permission{
+	RoleHost: {
+		PermissionAdmit, PermissionDeny, PermissionKick, PermissionMuteRequest,
+		PermissionLock, PermissionConfigure, PermissionManageKeys, PermissionManageRoles,
+		PermissionPublish, PermissionBroadcast,
+	},
+	RoleCoHost: {
+		PermissionAdmit, PermissionDeny, PermissionKick, PermissionMuteRequest,
+		PermissionLock, PermissionConfigure, PermissionManageKeys,
+		PermissionPublish, PermissionBroadcast,
+	},
+	RoleModerator: {
+		PermissionAdmit, PermissionDeny, PermissionKick, PermissionMuteRequest,
+		PermissionManageKeys, PermissionPublish, PermissionBroadcast,
+	},
+	RolePresenter: {PermissionManageKeys, PermissionPublish, PermissionBroadcast},
+	RoleGuest:     {PermissionManageKeys, PermissionPublish},
+	RoleViewer:    {},
+}
+
+// grantableRoles are the roles the host can give to guests.
+var grantableRoles = map[ParticipantRole]bool{
+	RoleCoHost:    true,
+	RoleModerator: true,
+	RolePresenter: true,
+	RoleViewer:    true,
+}
+
+// Permissions returns what participants with role may do.
+func (role ParticipantRole) Permissions() []Permission {
+	return append([]Permission(nil), rolePermissions[role]...)
+}
+
+// Can reports whether role grants permission.
+func (role ParticipantRole) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether the participant is in the room and its role grants
+// permission. The caller must hold the room mutex, under which roles change.
+func (p *Participant) can(permission Permission) bool {
+	return p.Status == StatusInRoom && p.Role.Can(permission)
+}
+
+// permits is can for callers that do not hold the mutex.
+func (r *Room) permits(participant *Participant, permission Permission) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return participant.can(permission)
+}
+
+// roleOf returns the current role of a participant.
+func (r *Room) roleOf(participant *Participant) ParticipantRole {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return participant.Role
+}
+
+// RoleData is the payload of grant_role and revoke_role. Role is optional
+// for revoke_role; when set, the participant must still have it.
+type RoleData struct {
+	ParticipantID string          `json:"participant_id"`
+	Role          ParticipantRole `json:"role,omitempty"`
+}
+
+func (d RoleData) Validate() error {
+	if d.ParticipantID == "" {
+		return errors.New("participant_id is required")
+	}
+	if d.Role != "" && !grantableRoles[d.Role] {
+		return fmt.Errorf("role %q cannot be granted", d.Role)
+	}
+	return nil
+}
+
+type RoleChangedData struct {
+	ParticipantID string          `json:"participant_id"`
+	Role          ParticipantRole `json:"role"`
+	PreviousRole  ParticipantRole `json:"previous_role"`
+	Permissions   []Permission    `json:"permissions"`
+	By            string          `json:"by"`
+}
+
+// MuteRequestData asks a participant to mute a track. The server only
+// relays it; muting is up to the client.
+type MuteRequestData struct {
+	To     string `json:"to"`
+	Kind   string `json:"kind,omitempty"` // e.g. "audio", "video" or "screen"
+	Reason string `json:"reason,omitempty"`
+}
+
+func (d MuteRequestData) Validate() error {
+	if d.To == "" {
+		return errors.New("to is required")
+	}
+	return nil
+}
+
+// setRole changes the role of an admitted guest and returns its previous
+// role. If expected is set the guest must currently have it.
+func (r *Room) setRole(participantID string, role, expected ParticipantRole) (ParticipantRole, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	guest, exists := r.Guests[participantID]
+	if !exists {
+		if r.Host != nil && r.Host.ID == participantID {
+			return "", errors.New("the host's role cannot be changed, use transfer_host")
+		}
+		return "", errors.New("participant not found")
+	}
+	if guest.Status != StatusInRoom {
+		return "", errors.New("knocking guests must be admitted first")
+	}
+	if expected != "" && guest.Role != expected {
+		return "", fmt.Errorf("participant does not have role %q", expected)
+	}
+	if !r.settings.allows(role) {
+		return "", fmt.Errorf("role %q is not allowed in this room", role)
+	}
+
+	previous := guest.Role
+	guest.Role = role
+	return previous, nil
+}
+
+// sendToPermitted sends message to the admitted participants allowed to
+// take permission.
+func (r *Room) sendToPermitted(permission Permission, message *Message) int {
+	return r.sendMatching(func(p *Participant) bool {
+		return p.can(permission)
+	}, message)
+}
+
+func (s *Server) handleGrantRole(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(RoleData)
+	if data.Role == "" {
+		rejectMessage(participant, message, ErrCodeInvalidMessage, "role is required")
+		return
+	}
+	s.changeRole(room, participant, message, data.ParticipantID, data.Role, "")
+}
+
+func (s *Server) handleRevokeRole(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(RoleData)
+	s.changeRole(room, participant, message, data.ParticipantID, RoleGuest, data.Role)
+}
+
+func (s *Server) changeRole(room *Room, participant *Participant, message *Message, targetID string, role, expected ParticipantRole) {
+	if !room.permits(participant, PermissionManageRoles) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to change roles")
+		return
+	}
+
+	previous, err := room.setRole(targetID, role, expected)
+	if err != nil {
+		rejectMessage(participant, message, ErrCodeRoleChangeFailed, err.Error())
+		return
+	}
+	if previous == role {
+		return
+	}
+
+	room.sendMatching(func(*Participant) bool { return true }, &Message{
+		Type: MessageTypeRoleChanged,
+		From: participant.ID,
+		Slug: room.Slug,
+		Data: RoleChangedData{
+			ParticipantID: targetID,
+			Role:          role,
+			PreviousRole:  previous,
+			Permissions:   role.Permissions(),
+			By:            participant.ID,
+		},
+		Timestamp: time.Now(),
+	})
+	room.BroadcastToAll(&Message{
+		Type:      MessageTypeParticipants,
+		Slug:      room.Slug,
+		Data:      room.GetParticipantsData(),
+		Timestamp: time.Now(),
+	}, "")
+}
+
+func (s *Server) handleMuteRequest(room *Room, participant *Participant, message *Message) {
+	if !room.permits(participant, PermissionMuteRequest) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to ask others to mute")
+		return
+	}
+
+	data := message.Data.(MuteRequestData)
+	if target := room.GetParticipant(data.To); target != nil && room.roleOf(target) == RoleHost {
+		rejectMessage(participant, message, ErrCodeForbidden, "the host cannot be asked to mute")
+		return
+	}
+
+	room.deliverTo(data.To, &Message{
+		Type:      MessageTypeMuteRequest,
+		From:      participant.ID,
+		To:        data.To,
+		Slug:      room.Slug,
+		Data:      data,
+		Timestamp: time.Now(),
+	}, message.receipt)
+}
@@ -0,0 +1,190 @@
+package signaling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionMatrix(t *testing.T) {
+	for _, permission := range []Permission{PermissionAdmit, PermissionDeny, PermissionKick, PermissionMuteRequest, PermissionLock, PermissionConfigure, PermissionManageKeys, PermissionManageRoles} {
+		assert.True(t, RoleHost.Can(permission), permission)
+	}
+	assert.False(t, RoleCoHost.Can(PermissionManageRoles))
+	assert.True(t, RoleCoHost.Can(PermissionLock))
+	assert.True(t, RoleModerator.Can(PermissionKick))
+	assert.False(t, RoleModerator.Can(PermissionLock))
+	assert.True(t, RoleGuest.Can(PermissionManageKeys))
+	assert.False(t, RoleGuest.Can(PermissionAdmit))
+	assert.Empty(t, RoleViewer.Permissions())
+	assert.False(t, ParticipantRole("unknown").Can(PermissionManageKeys))
+
+	// Presenters differ from guests in that they may always send to everyone
+	assert.True(t, RolePresenter.Can(PermissionBroadcast))
+	assert.False(t, RoleGuest.Can(PermissionBroadcast))
+	assert.True(t, RoleGuest.Can(PermissionPublish))
+	assert.False(t, RoleViewer.Can(PermissionPublish))
+}
+
+func TestGrantRole(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "moderator"}})
+
+	assert.Equal(t, RoleModerator, room.GetParticipant("guest1").Role)
+	want := RoleChangedData{
+		ParticipantID: "guest1",
+		Role:          RoleModerator,
+		PreviousRole:  RoleGuest,
+		Permissions:   RoleModerator.Permissions(),
+		By:            "host1",
+	}
+	for _, conn := range conns {
+		assert.Equal(t, []RoleChangedData{want}, messagesOfType[RoleChangedData](conn, MessageTypeRoleChanged))
+		written := conn.written()
+		assert.Equal(t, MessageTypeParticipants, written[len(written)-1].Type)
+	}
+
+	// The moderator now sees knocks and can admit guests
+	server.joinRoom("test-room", &Participant{ID: "knocking", Conn: newRecordingConn(), Role: RoleGuest})
+	written := conns["guest1"].written()
+	assert.Equal(t, MessageTypeKnock, written[len(written)-2].Type)
+	written = conns["guest2"].written()
+	assert.Equal(t, MessageTypeParticipants, written[len(written)-1].Type)
+
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeAllow, Data: "knocking"})
+	assert.Equal(t, StatusInRoom, room.GetParticipant("knocking").Status)
+}
+
+func TestRevokeRole(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleCoHost
+
+	// Revoking a role the participant does not have fails
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeRevokeRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "moderator"}})
+	assert.Equal(t, ErrCodeRoleChangeFailed, errorCode(conns["host1"]))
+	assert.Equal(t, RoleCoHost, room.GetParticipant("guest1").Role)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeRevokeRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "co_host"}})
+	assert.Equal(t, RoleGuest, room.GetParticipant("guest1").Role)
+	changes := messagesOfType[RoleChangedData](conns["guest2"], MessageTypeRoleChanged)
+	require.Len(t, changes, 1)
+	assert.Equal(t, RoleCoHost, changes[0].PreviousRole)
+	assert.Equal(t, RoleGuest, changes[0].Role)
+}
+
+func TestRoleChangeRejections(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleCoHost
+
+	// Co-hosts cannot manage roles
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest2", "role": "co_host"}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest1"]))
+
+	// The host role only changes hands through transfer_host
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest2", "role": "host"}})
+	assert.Equal(t, ErrCodeInvalidMessage, errorCode(conns["host1"]))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "host1", "role": "viewer"}})
+	assert.Equal(t, ErrCodeRoleChangeFailed, errorCode(conns["host1"]))
+
+	assert.Equal(t, RoleGuest, room.GetParticipant("guest2").Role)
+	assert.Equal(t, RoleHost, room.Host.Role)
+}
+
+func TestKnockingGuestsCannotGetRoles(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	knocking := knock(server, "k1", "")
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "k1", "role": "moderator"}})
+	assert.Equal(t, ErrCodeRoleChangeFailed, errorCode(conns["host1"]))
+	assert.Equal(t, RoleGuest, room.GetParticipant("k1").Role)
+
+	// Even with a role, a knocking guest could not let itself in
+	room.GetParticipant("k1").Role = RoleModerator
+	server.handleMessage("test-room", room.GetParticipant("k1"), &Message{Type: MessageTypeAllow, Data: "k1"})
+	assert.Equal(t, ErrCodeForbidden, lastOfType(knocking, MessageTypeError).Data.(ErrorData).Code)
+	assert.Equal(t, StatusKnocking, room.GetParticipant("k1").Status)
+}
+
+func TestViewersOnlyReceiveMedia(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest2").Role = RoleViewer
+	viewer := room.GetParticipant("guest2")
+
+	server.handleMessage("test-room", viewer, &Message{Type: MessageTypeOffer, To: "host1", Data: "sdp"})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest2"]))
+	assert.Nil(t, lastOfType(conns["host1"], MessageTypeOffer))
+
+	// Viewers answer offers from others
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeOffer, To: "guest2", Data: "sdp"})
+	assert.NotNil(t, lastOfType(conns["guest2"], MessageTypeOffer))
+	server.handleMessage("test-room", viewer, &Message{Type: MessageTypeAnswer, To: "host1", Data: "sdp"})
+	assert.NotNil(t, lastOfType(conns["host1"], MessageTypeAnswer))
+}
+
+func TestPresentersMayAlwaysBroadcast(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.updateSettings(RoomSettingsUpdate{GuestBroadcast: boolPtr(false)})
+	encrypted := map[string]interface{}{"to": "all", "data": "c29tZQ==", "algorithm": "x25519"}
+
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeEncrypted, Data: encrypted})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest1"]))
+
+	room.GetParticipant("guest1").Role = RolePresenter
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeEncrypted, Data: encrypted})
+	assert.NotNil(t, lastOfType(conns["guest2"], MessageTypeEncrypted))
+}
+
+func TestRoleChecksDoNotRaceRoleChanges(t *testing.T) {
+	server := NewServer()
+	room, _ := handoffRoom(t, server)
+	guest := room.GetParticipant("guest1")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "moderator"}})
+			server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeRevokeRole, Data: map[string]interface{}{"participant_id": "guest1"}})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		server.handleMessage("test-room", guest, &Message{Type: MessageTypeMuteRequest, Data: map[string]interface{}{"to": "guest2"}})
+	}
+	<-done
+}
+
+func TestMuteRequest(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleModerator
+
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeMuteRequest, Data: map[string]interface{}{"to": "guest2", "kind": "audio"}})
+	request := lastOfType(conns["guest2"], MessageTypeMuteRequest)
+	require.NotNil(t, request)
+	assert.Equal(t, "guest1", request.From)
+	assert.Equal(t, MuteRequestData{To: "guest2", Kind: "audio"}, request.Data)
+
+	server.handleMessage("test-room", room.GetParticipant("guest1"), &Message{Type: MessageTypeMuteRequest, Data: map[string]interface{}{"to": "host1"}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest1"]))
+
+	server.handleMessage("test-room", room.GetParticipant("guest2"), &Message{Type: MessageTypeMuteRequest, Data: map[string]interface{}{"to": "guest1"}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest2"]))
+}
+
+func TestViewerCannotCommit(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest2").Role = RoleViewer
+
+	server.handleMessage("test-room", room.GetParticipant("guest2"), &Message{Type: MessageTypeMLSCommit, Data: map[string]interface{}{"epoch": 0, "message": "c29tZQ=="}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest2"]))
+}
@@ -0,0 +1,231 @@
+package signaling
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// The protocol version is negotiated with Sec-WebSocket-Protocol. Clients
+// that offer no version, or only the plain BaseProtocol, speak v1. Handlers
+// work with the newest message formats; decoders and adapters translate for
+// older clients:
+//
+//   - v2 clients exchange hello messages with capabilities.
+//   - v2 clients send allow and deny with a ParticipantRefData, v1 clients
+//     with the bare guest ID.
+//   - v2 clients get public_keys without the legacy keys map; every key is
+//     in bundles.
+
+const (
+	SubprotocolV1 = "kaamos.v1"
+	SubprotocolV2 = "kaamos.v2"
+)
+
+// ProtocolVersion is the signaling protocol version of a connection. The
+// zero value is treated as v1.
+type ProtocolVersion int
+
+const (
+	ProtocolV1 ProtocolVersion = 1
+	ProtocolV2 ProtocolVersion = 2
+)
+
+// supportedSubprotocols lists the subprotocols the server selects from, in
+// order of preference.
+var supportedSubprotocols = []string{
+	SubprotocolV2 + "+" + CodecCBOR,
+	SubprotocolV2 + "+" + CodecMsgpack,
+	SubprotocolV2,
+	SubprotocolV1,
+	BaseProtocol,
+}
+
+// ProtocolFromSubprotocol returns the version a negotiated subprotocol
+// stands for.
+func ProtocolFromSubprotocol(subprotocol string) ProtocolVersion {
+	version, _, _ := strings.Cut(subprotocol, "+")
+	if version == SubprotocolV2 {
+		return ProtocolV2
+	}
+	return ProtocolV1
+}
+
+func (v ProtocolVersion) Subprotocol() string {
+	if v >= ProtocolV2 {
+		return SubprotocolV2
+	}
+	return SubprotocolV1
+}
+
+// Capabilities are the optional features a peer supports.
+type Capabilities struct {
+	E2EE   []string `json:"e2ee,omitempty"`   // encryption schemes, e.g. "x25519" or "mls"
+	Codecs []string `json:"codecs,omitempty"` // message encodings
+	Resume bool     `json:"resume,omitempty"` // session resumption
+}
+
+func (c Capabilities) Validate() error {
+	for _, values := range [][]string{c.E2EE, c.Codecs} {
+		for _, value := range values {
+			if value == "" {
+				return errors.New("capabilities cannot be empty strings")
+			}
+		}
+	}
+	return nil
+}
+
+// intersect returns the capabilities both c and other support.
+func (c Capabilities) intersect(other Capabilities) Capabilities {
+	return Capabilities{
+		E2EE:   intersectStrings(c.E2EE, other.E2EE),
+		Codecs: intersectStrings(c.Codecs, other.Codecs),
+		Resume: c.Resume && other.Resume,
+	}
+}
+
+func intersectStrings(a, b []string) []string {
+	var common []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				common = append(common, x)
+				break
+			}
+		}
+	}
+	return common
+}
+
+// HelloData is sent by the server when a v2 connection opens and by the
+// client in reply. The server answers the client's hello with Negotiated
+// set to what both sides support.
+type HelloData struct {
+	Protocol     string        `json:"protocol,omitempty"`
+	Capabilities Capabilities  `json:"capabilities"`
+	Negotiated   *Capabilities `json:"negotiated,omitempty"`
+}
+
+func (d HelloData) Validate() error {
+	return d.Capabilities.Validate()
+}
+
+// ParticipantRefData names a participant, e.g. the guest of allow and deny.
+type ParticipantRefData struct {
+	ParticipantID string `json:"participant_id"`
+}
+
+// protocolV2Messages overrides inboundMessages for v2 clients.
+var protocolV2Messages = map[MessageType]messageDecoder{
+	MessageTypeHello: decodeAs[HelloData],
+	MessageTypeAllow: decodeParticipantRef,
+	MessageTypeDeny:  decodeParticipantRef,
+}
+
+// inboundDecoder returns the decoder for a message type sent by a client
+// speaking version v.
+func (v ProtocolVersion) inboundDecoder(messageType MessageType) (messageDecoder, bool) {
+	if v >= ProtocolV2 {
+		if decode, ok := protocolV2Messages[messageType]; ok {
+			return decode, true
+		}
+	}
+	decode, ok := inboundMessages[messageType]
+	return decode, ok
+}
+
+// decodeParticipantRef decodes a ParticipantRefData into the participant ID
+// handlers expect.
+func decodeParticipantRef(data interface{}) (interface{}, error) {
+	var ref ParticipantRefData
+	if err := unmarshalData(data, &ref); err != nil {
+		return nil, err
+	}
+	if ref.ParticipantID == "" {
+		return nil, errors.New("participant_id is required")
+	}
+	return ref.ParticipantID, nil
+}
+
+// adapt returns message in the form a client speaking version v expects,
+// or nil if such clients do not understand it. message is shared between
+// recipients and never modified.
+func (v ProtocolVersion) adapt(message *Message) *Message {
+	if v >= ProtocolV2 {
+		if data, ok := message.Data.(PublicKeysData); ok && data.Keys != nil {
+			data.Keys = nil
+			adapted := *message
+			adapted.Data = data
+			return &adapted
+		}
+		return message
+	}
+
+	if message.Type == MessageTypeHello {
+		return nil
+	}
+	return message
+}
+
+// protocolVersion returns the version negotiated by the participant's
+// current connection.
+func (p *Participant) protocolVersion() ProtocolVersion {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	return p.protocol
+}
+
+// negotiatedSubprotocol returns the subprotocol of the participant's
+// current connection.
+func (p *Participant) negotiatedSubprotocol() string {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	return subprotocol(p.protocol, p.codec)
+}
+
+// capabilities returns what the server supports.
+func (s *Server) capabilities() Capabilities {
+	return Capabilities{
+		E2EE:   []string{string(KeyAlgorithmX25519), string(KeyAlgorithmP256), EncryptionAlgorithmMLS},
+		Codecs: codecNames(),
+		Resume: s.resumeGracePeriod > 0,
+	}
+}
+
+// sendHello greets a v2 client with the server's capabilities.
+func (s *Server) sendHello(participant *Participant) {
+	if participant.protocolVersion() < ProtocolV2 {
+		return
+	}
+	participant.Send(&Message{
+		Type: MessageTypeHello,
+		Data: HelloData{
+			Protocol:     participant.negotiatedSubprotocol(),
+			Capabilities: s.capabilities(),
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// handleHello records the client's capabilities, which peers see in the
+// participant list, and replies with what both sides support.
+func (s *Server) handleHello(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(HelloData)
+	negotiated := s.capabilities().intersect(data.Capabilities)
+
+	room.mutex.Lock()
+	capabilities := data.Capabilities
+	participant.Capabilities = &capabilities
+	room.mutex.Unlock()
+
+	participant.Send(&Message{
+		Type: MessageTypeHello,
+		Data: HelloData{
+			Protocol:     participant.negotiatedSubprotocol(),
+			Capabilities: s.capabilities(),
+			Negotiated:   &negotiated,
+		},
+		Timestamp: time.Now(),
+	})
+}
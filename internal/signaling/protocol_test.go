@@ -0,0 +1,87 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelloExchange(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	conn := dialWS(t, testServer, "token=host-token", SubprotocolV1, SubprotocolV2)
+	assert.Equal(t, SubprotocolV2, conn.Subprotocol())
+
+	greeting := messageData[HelloData](t, readUntil(t, conn, MessageTypeHello))
+	assert.Equal(t, SubprotocolV2, greeting.Protocol)
+	assert.Contains(t, greeting.Capabilities.E2EE, EncryptionAlgorithmMLS)
+	assert.Equal(t, []string{CodecCBOR, CodecMsgpack, CodecJSON}, greeting.Capabilities.Codecs)
+	assert.True(t, greeting.Capabilities.Resume)
+
+	require.NoError(t, conn.WriteJSON(&Message{
+		Type: MessageTypeHello,
+		Data: HelloData{Capabilities: Capabilities{E2EE: []string{"mls", "rot13"}, Codecs: []string{"cbor", "json"}}},
+	}))
+	reply := messageData[HelloData](t, readUntil(t, conn, MessageTypeHello))
+	require.NotNil(t, reply.Negotiated)
+	assert.Equal(t, Capabilities{E2EE: []string{"mls"}, Codecs: []string{"cbor", "json"}}, *reply.Negotiated)
+
+	participants := server.GetRoomStats("test-room")["participants"].(*ParticipantsData)
+	require.NotNil(t, participants.Host.Capabilities)
+	assert.Equal(t, []string{"mls", "rot13"}, participants.Host.Capabilities.E2EE)
+}
+
+func TestV1ClientsDoNotSpeakHello(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	conn := dialWS(t, testServer, "token=host-token", BaseProtocol)
+	assert.Equal(t, BaseProtocol, conn.Subprotocol())
+
+	// No greeting: the first message is still the participant list
+	first := readUntil(t, conn, MessageTypeParticipants)
+	assert.Equal(t, MessageTypeParticipants, first.Type)
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "hello", "data": map[string]interface{}{}}))
+	rejected := readUntil(t, conn, MessageTypeError)
+	assert.Equal(t, ErrCodeUnknownMessageType, rejected.Data.(map[string]interface{})["code"])
+}
+
+func TestAllowDataShapeDependsOnProtocol(t *testing.T) {
+	v1 := &Message{Type: MessageTypeAllow, Data: json.RawMessage(`"guest1"`)}
+	require.NoError(t, decodeMessage(v1, ProtocolV1))
+	assert.Equal(t, "guest1", v1.Data)
+
+	v2 := &Message{Type: MessageTypeAllow, Data: json.RawMessage(`{"participant_id":"guest1"}`)}
+	require.NoError(t, decodeMessage(v2, ProtocolV2))
+	assert.Equal(t, "guest1", v2.Data)
+
+	err := decodeMessage(&Message{Type: MessageTypeAllow, Data: json.RawMessage(`"guest1"`)}, ProtocolV2)
+	require.Error(t, err)
+	assert.Equal(t, ErrCodeInvalidMessage, err.(*MessageError).Code)
+}
+
+func TestAdaptPublicKeysForV2(t *testing.T) {
+	message := &Message{
+		Type: MessageTypePublicKeys,
+		Data: PublicKeysData{
+			Keys:    map[string]string{"p1": "key"},
+			Bundles: map[string][]TypedKey{"p1": {{Algorithm: KeyAlgorithmEd25519, PublicKey: "key"}}},
+		},
+	}
+
+	adapted := ProtocolV2.adapt(message)
+	assert.Nil(t, adapted.Data.(PublicKeysData).Keys)
+	assert.NotEmpty(t, adapted.Data.(PublicKeysData).Bundles)
+	assert.NotNil(t, message.Data.(PublicKeysData).Keys, "the shared message is not modified")
+
+	assert.Same(t, message, ProtocolV1.adapt(message))
+	assert.Nil(t, ProtocolV1.adapt(&Message{Type: MessageTypeHello}))
+}
@@ -0,0 +1,171 @@
+package signaling
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Rooms only live on the server while someone is in them. A RoomRegistry
+// remembers the rooms created through the API and their settings; with one
+// configured, /ws handshakes for rooms it does not know are refused and new
+// rooms start with their registered settings.
+
+const (
+	ErrCodeRoomNotFound        = "ROOM_NOT_FOUND"
+	ErrCodeRoomClosed          = "ROOM_CLOSED"
+	ErrCodeRegistryUnavailable = "REGISTRY_UNAVAILABLE"
+)
+
+// ErrRoomNotRegistered is returned (possibly wrapped) by a RoomRegistry for
+// rooms it does not know, expired ones included.
+var ErrRoomNotRegistered = errors.New("room not registered")
+
+// RoomRegistry looks up and saves the settings and bans of registered rooms.
+type RoomRegistry interface {
+	RoomSettings(slug string) (RoomSettings, error)
+	SaveRoomSettings(slug string, settings RoomSettings) error
+	RoomBans(slug string) ([]Ban, error)
+	SaveRoomBans(slug string, bans []Ban) error
+}
+
+// WithRoomRegistry only lets participants join rooms known to registry.
+// Without one any slug can be joined.
+func WithRoomRegistry(registry RoomRegistry) Option {
+	return func(s *Server) {
+		s.registry = registry
+	}
+}
+
+// checkRegistered refuses handshakes for rooms missing from the registry.
+func (s *Server) checkRegistered(slug string) *handshakeError {
+	if s.registry == nil {
+		return nil
+	}
+	if _, err := s.registry.RoomSettings(slug); err != nil {
+		if errors.Is(err, ErrRoomNotRegistered) {
+			return &handshakeError{http.StatusNotFound, ErrCodeRoomNotFound, "room not found"}
+		}
+		log.Printf("Failed to look up room %s: %v", slug, err)
+		return &handshakeError{http.StatusServiceUnavailable, ErrCodeRegistryUnavailable, "room registry is unavailable"}
+	}
+	return nil
+}
+
+// newRoomSettings returns the settings the room starts with: its registered
+// settings if there are any, the server's otherwise.
+func (s *Server) newRoomSettings(slug string) RoomSettings {
+	if s.registry != nil {
+		settings, err := s.registry.RoomSettings(slug)
+		if err == nil {
+			return settings.clone()
+		}
+		if !errors.Is(err, ErrRoomNotRegistered) {
+			log.Printf("Failed to look up room %s: %v", slug, err)
+		}
+	}
+	return s.roomSettings.clone()
+}
+
+// saveRoomSettings keeps settings changed at runtime for when the room is
+// next created.
+func (s *Server) saveRoomSettings(slug string, settings RoomSettings) {
+	if s.registry == nil {
+		return
+	}
+	if err := s.registry.SaveRoomSettings(slug, settings); err != nil {
+		log.Printf("Failed to save settings of room %s: %v", slug, err)
+	}
+}
+
+// newRoomBans returns the bans the room starts with, those saved with the
+// registered room.
+func (s *Server) newRoomBans(slug string) []*Ban {
+	if s.registry == nil {
+		return nil
+	}
+	saved, err := s.registry.RoomBans(slug)
+	if err != nil {
+		if !errors.Is(err, ErrRoomNotRegistered) {
+			log.Printf("Failed to look up bans of room %s: %v", slug, err)
+		}
+		return nil
+	}
+
+	bans := make([]*Ban, len(saved))
+	for i := range saved {
+		bans[i] = &saved[i]
+	}
+	return bans
+}
+
+// saveRoomBans keeps the bans of the room for when it is next created. Saves
+// are serialized so that the last one holds the latest bans.
+func (s *Server) saveRoomBans(room *Room) {
+	if s.registry == nil {
+		return
+	}
+
+	room.bansMutex.Lock()
+	defer room.bansMutex.Unlock()
+
+	room.mutex.RLock()
+	bans := make([]Ban, len(room.bans))
+	for i, ban := range room.bans {
+		bans[i] = *ban
+	}
+	room.mutex.RUnlock()
+
+	if err := s.registry.SaveRoomBans(room.Slug, bans); err != nil {
+		log.Printf("Failed to save bans of room %s: %v", room.Slug, err)
+	}
+}
+
+// ApplyRoomSettings replaces the settings of a live room and announces them,
+// for settings changed outside the room. It reports whether the room is live.
+func (s *Server) ApplyRoomSettings(slug string, settings RoomSettings) bool {
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	room.mutex.Lock()
+	room.settings = settings.clone()
+	room.mutex.Unlock()
+
+	announceSettings(room, "", settings)
+	return true
+}
+
+// CloseRoom disconnects everyone in the room, e.g. after it was deleted, and
+// returns how many were disconnected.
+func (s *Server) CloseRoom(slug string) int {
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return 0
+	}
+
+	return room.disconnectMatching(func(*Participant) bool { return true }, ErrorData{
+		Code:    ErrCodeRoomClosed,
+		Message: "the room was closed",
+	})
+}
+
+// announceSettings tells everyone in the room, knocking guests included, the
+// room's settings.
+func announceSettings(room *Room, from string, settings RoomSettings) {
+	room.sendMatching(func(*Participant) bool { return true }, &Message{
+		Type:      MessageTypeRoomSettings,
+		From:      from,
+		Slug:      room.Slug,
+		Data:      settings,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,150 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRegistry struct {
+	mu    sync.Mutex
+	rooms map[string]RoomSettings
+	bans  map[string][]Ban
+	err   error
+}
+
+func newFakeRegistry(slugs ...string) *fakeRegistry {
+	registry := &fakeRegistry{rooms: make(map[string]RoomSettings), bans: make(map[string][]Ban)}
+	for _, slug := range slugs {
+		registry.rooms[slug] = DefaultRoomSettings()
+	}
+	return registry
+}
+
+func (r *fakeRegistry) RoomSettings(slug string) (RoomSettings, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return RoomSettings{}, r.err
+	}
+	settings, exists := r.rooms[slug]
+	if !exists {
+		return RoomSettings{}, ErrRoomNotRegistered
+	}
+	return settings, nil
+}
+
+func (r *fakeRegistry) SaveRoomSettings(slug string, settings RoomSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rooms[slug] = settings
+	return nil
+}
+
+func (r *fakeRegistry) RoomBans(slug string) ([]Ban, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.rooms[slug]; !exists {
+		return nil, ErrRoomNotRegistered
+	}
+	return r.bans[slug], nil
+}
+
+func (r *fakeRegistry) SaveRoomBans(slug string, bans []Ban) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bans[slug] = bans
+	return nil
+}
+
+func TestHandshakeRequiresRegisteredRoom(t *testing.T) {
+	registry := newFakeRegistry()
+	server := NewServer(WithTokenVerifier(newTestVerifier()), WithRoomRegistry(registry))
+
+	handshake := func() (int, string) {
+		w := httptest.NewRecorder()
+		server.HandleWebSocket(w, httptest.NewRequest(http.MethodGet, "/ws?token=host-token", nil))
+		var errData ErrorData
+		json.Unmarshal(w.Body.Bytes(), &errData)
+		return w.Code, errData.Code
+	}
+
+	status, code := handshake()
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, ErrCodeRoomNotFound, code)
+
+	registry.err = errors.New("disk on fire")
+	status, code = handshake()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, ErrCodeRegistryUnavailable, code)
+
+	registry.err = nil
+	registry.rooms["test-room"] = DefaultRoomSettings()
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+	readUntil(t, dialWS(t, testServer, "token=host-token"), MessageTypeParticipants)
+}
+
+func TestRoomsStartWithRegisteredSettings(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.rooms["registered"] = RoomSettings{Admission: AdmissionOpen, MaxParticipants: 3}
+	server := NewServer(WithRoomRegistry(registry), WithRoomSettings(RoomSettings{Admission: AdmissionKnock, Locked: true}))
+
+	server.joinRoom("registered", &Participant{ID: "host1", Conn: newRecordingConn(), Role: RoleHost})
+	assert.Equal(t, RoomSettings{Admission: AdmissionOpen, MaxParticipants: 3}, server.rooms["registered"].Settings())
+
+	server.joinRoom("unregistered", &Participant{ID: "host2", Conn: newRecordingConn(), Role: RoleHost})
+	assert.True(t, server.rooms["unregistered"].Settings().Locked, "falls back to the server's settings")
+}
+
+func TestRuntimeSettingsAreSaved(t *testing.T) {
+	registry := newFakeRegistry("test-room")
+	server := NewServer(WithRoomRegistry(registry))
+	room, _ := handoffRoom(t, server)
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeRoomSettings, Data: map[string]interface{}{"locked": true}})
+
+	saved, err := registry.RoomSettings("test-room")
+	require.NoError(t, err)
+	assert.True(t, saved.Locked)
+}
+
+func TestApplyRoomSettings(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	waiting := knock(server, "k1", "")
+
+	settings := RoomSettings{Admission: AdmissionKnock, MaxParticipants: 8}
+	assert.True(t, server.ApplyRoomSettings("test-room", settings))
+	assert.Equal(t, settings, room.Settings())
+	for _, conn := range []*recordingConn{conns["host1"], conns["guest2"], waiting} {
+		announced := lastOfType(conn, MessageTypeRoomSettings)
+		require.NotNil(t, announced)
+		assert.Empty(t, announced.From)
+		assert.Equal(t, 8, announced.Data.(RoomSettings).MaxParticipants)
+	}
+
+	assert.False(t, server.ApplyRoomSettings("missing-room", settings))
+}
+
+func TestCloseRoom(t *testing.T) {
+	server := NewServer()
+	_, conns := handoffRoom(t, server)
+
+	assert.Equal(t, 3, server.CloseRoom("test-room"))
+	for _, conn := range conns {
+		waitClosed(t, conn)
+		assert.Equal(t, ErrCodeRoomClosed, errorCode(conn))
+	}
+	assert.Equal(t, 0, server.CloseRoom("missing-room"))
+}
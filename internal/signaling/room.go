@@ -2,7 +2,12 @@ package signaling
 
 import (
 	"fmt"
+	"log"
+	"maps"
+	"slices"
 	"time"
+
+	"github.com/Kaamos-Comms/server/internal/transparency"
 )
 
 func NewRoom(slug string) *Room {
@@ -10,31 +15,196 @@ func NewRoom(slug string) *Room {
 		Slug:       slug,
 		Guests:     make(map[string]*Participant),
 		PublicKeys: make(map[string]string),
+		KeyBundles: make(map[string][]TypedKey),
+		KeyProofs:  make(map[string]KeyProof),
 		CreatedAt:  time.Now(),
+		settings:   DefaultRoomSettings(),
 	}
 }
 
 func (r *Room) SavePublicKey(participantID, publicKey string) error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
 	if err := ValidatePublicKey(publicKey); err != nil {
 		return fmt.Errorf("invalid public key: %w", err)
 	}
 
+	keys := []TypedKey{{Algorithm: KeyAlgorithmEd25519, PublicKey: publicKey}}
+	fingerprint := r.fingerprintKeys(participantID, keys)
+
+	r.mutex.Lock()
+	_, pending := r.saveKeys(participantID, keys, fingerprint, "")
+	r.mutex.Unlock()
+
+	r.logKeys(pending)
+	return nil
+}
+
+// SaveKeyProof stores a verified key announcement along with its keys.
+func (r *Room) SaveKeyProof(participantID string, proof KeyProof) error {
+	keys := proof.bundle()
+	if err := ValidateKeyBundle(keys); err != nil {
+		return fmt.Errorf("invalid key bundle: %w", err)
+	}
+
+	fingerprint := r.fingerprintKeys(participantID, keys)
+
+	r.mutex.Lock()
+	pending := r.saveKeyProof(participantID, keys, fingerprint, proof)
+	r.mutex.Unlock()
+
+	r.logKeys(pending)
+	return nil
+}
+
+// saveKeyProof is SaveKeyProof for validated keys. The caller must hold the
+// mutex.
+func (r *Room) saveKeyProof(participantID string, keys []TypedKey, fingerprint string, proof KeyProof) *pendingKeys {
+	identity := proof.DID
+	if identity == "" {
+		identity = proof.IdentityKey
+	}
+	participant, pending := r.saveKeys(participantID, keys, fingerprint, identity)
+	if r.KeyProofs == nil {
+		r.KeyProofs = make(map[string]KeyProof)
+	}
+	r.KeyProofs[participantID] = proof
+
+	if participant != nil {
+		participant.Keys.Proof = &proof
+		// Participants without a DID are bound to their first identity key
+		if participant.DID == "" && participant.IdentityKey == "" {
+			participant.IdentityKey = proof.IdentityKey
+		}
+	}
+
+	return pending
+}
+
+// fingerprintKeys returns the fingerprint of the participant's keys. Its
+// iterated hash is slow, so it takes the mutex only to look up the DID.
+func (r *Room) fingerprintKeys(participantID string, keys []TypedKey) string {
+	// Fingerprints bind the key to the DID when there is one, so that they
+	// stay comparable across rooms
+	identity := participantID
+	r.mutex.RLock()
+	if participant := r.findParticipant(participantID); participant != nil && participant.DID != "" {
+		identity = participant.DID
+	}
+	r.mutex.RUnlock()
+
+	fingerprint, err := KeyFingerprint(identity, keys)
+	if err != nil {
+		log.Printf("Failed to fingerprint keys of %s: %v", participantID, err)
+	}
+	return fingerprint
+}
+
+// saveKeys records the participant's keys and their fingerprint, computed
+// by fingerprintKeys, and returns the participant, if present, and the keys
+// to pass to logKeys once the mutex is released. The caller must hold the
+// mutex.
+func (r *Room) saveKeys(participantID string, keys []TypedKey, fingerprint, identity string) (*Participant, *pendingKeys) {
 	if r.PublicKeys == nil {
 		r.PublicKeys = make(map[string]string)
 	}
-	r.PublicKeys[participantID] = publicKey
+	if r.KeyBundles == nil {
+		r.KeyBundles = make(map[string][]TypedKey)
+	}
+	// The logged keys stay proven until they change
+	if !slices.Equal(r.KeyBundles[participantID], keys) {
+		delete(r.KeyLogIndex, participantID)
+	}
+	r.PublicKeys[participantID] = primaryKey(keys)
+	r.KeyBundles[participantID] = keys
 
-	if r.Host != nil && r.Host.ID == participantID {
-		r.Host.Keys.PublicKey = publicKey
+	participant := r.findParticipant(participantID)
+
+	if r.Fingerprints == nil {
+		r.Fingerprints = make(map[string]string)
 	}
-	if guest, exists := r.Guests[participantID]; exists {
-		guest.Keys.PublicKey = publicKey
+	r.Fingerprints[participantID] = fingerprint
+	r.forgetVerifications(participantID, fingerprint)
+
+	if participant != nil {
+		participant.Keys.PublicKey = primaryKey(keys)
+		participant.Keys.Bundle = keys
+		participant.Keys.Fingerprint = fingerprint
+		if identity == "" {
+			identity = participant.DID
+		}
 	}
 
-	return nil
+	if r.keyLog == nil {
+		return participant, nil
+	}
+	pending := &pendingKeys{participantID: participantID, keys: keys, identity: identity, limitKey: identity}
+	if participant != nil && participant.remoteIP != "" {
+		pending.limitKey = participant.remoteIP
+	}
+	if pending.limitKey == "" {
+		pending.limitKey = participantID
+	}
+	return participant, pending
+}
+
+// keyTransparency proves the current keys of the room against the latest
+// tree head. The log caches its tree and signed head, so each proof costs
+// O(log n) hashes. It returns nil without a key log. The caller must hold
+// the mutex.
+func (r *Room) keyTransparency() *KeyTransparency {
+	if r.keyLog == nil {
+		return nil
+	}
+
+	sth := r.keyLog.SignedTreeHead()
+	inclusions := make(map[string]*transparency.InclusionProof, len(r.KeyLogIndex))
+	for participantID, index := range r.KeyLogIndex {
+		if _, exists := r.PublicKeys[participantID]; !exists {
+			continue
+		}
+		proof, err := r.keyLog.InclusionProof(index, sth.TreeSize)
+		if err != nil {
+			log.Printf("Failed to prove keys of %s: %v", participantID, err)
+			continue
+		}
+		inclusions[participantID] = proof
+	}
+
+	return &KeyTransparency{TreeHead: sth, Inclusions: inclusions}
+}
+
+// KeyTransparency returns inclusion proofs for the current keys of the room.
+func (r *Room) KeyTransparency() *KeyTransparency {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.keyTransparency()
+}
+
+// GetKeyBundle returns all keys announced by the participant.
+func (r *Room) GetKeyBundle(participantID string) ([]TypedKey, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	keys, exists := r.KeyBundles[participantID]
+	return keys, exists
+}
+
+// GetFingerprint returns the fingerprint of the participant's current key.
+func (r *Room) GetFingerprint(participantID string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	fingerprint, exists := r.Fingerprints[participantID]
+	return fingerprint, exists
+}
+
+// GetKeyProof returns the proof of the participant's current key.
+func (r *Room) GetKeyProof(participantID string) (KeyProof, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	proof, exists := r.KeyProofs[participantID]
+	return proof, exists
 }
 
 func (r *Room) GetPublicKey(participantID string) (string, bool) {
@@ -60,21 +230,28 @@ func (r *Room) BroadcastPublicKeys(excludeID string) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	// Writers encode the message after the lock is released, so it must
+	// not share the room's maps
 	message := &Message{
 		Type: MessageTypePublicKeys,
 		Data: PublicKeysData{
-			Keys: r.PublicKeys,
+			Keys:         maps.Clone(r.PublicKeys),
+			Bundles:      maps.Clone(r.KeyBundles),
+			Proofs:       maps.Clone(r.KeyProofs),
+			Fingerprints: maps.Clone(r.Fingerprints),
+			Transparency: r.keyTransparency(),
 		},
 		Timestamp: time.Now(),
 	}
+	r.stamp(message)
 
 	if r.Host != nil && r.Host.ID != excludeID && r.Host.Status == StatusInRoom {
-		r.Host.Conn.WriteJSON(message)
+		r.Host.Send(message)
 	}
 
 	for _, guest := range r.Guests {
 		if guest.ID != excludeID && guest.Status == StatusInRoom {
-			guest.Conn.WriteJSON(message)
+			guest.Send(message)
 		}
 	}
 }
@@ -84,6 +261,10 @@ func (r *Room) RemovePublicKey(participantID string) {
 	defer r.mutex.Unlock()
 
 	delete(r.PublicKeys, participantID)
+	delete(r.KeyBundles, participantID)
+	delete(r.KeyProofs, participantID)
+	delete(r.KeyLogIndex, participantID)
+	delete(r.Fingerprints, participantID)
 }
 
 func (r *Room) AddParticipant(participant *Participant) error {
@@ -96,23 +277,36 @@ func (r *Room) AddParticipant(participant *Participant) error {
 		}
 		r.Host = participant
 		participant.Status = StatusInRoom
+		r.cancelPromotion()
 	} else {
-		participant.Status = StatusKnocking
+		if err := r.admits(participant); err != nil {
+			return err
+		}
 		r.Guests[participant.ID] = participant
+		if r.settings.Admission == AdmissionOpen {
+			participant.Status = StatusInRoom
+		} else {
+			participant.Status = StatusKnocking
+			r.enterLobby(participant)
+		}
 	}
 
 	return nil
 }
 
-func (r *Room) RemoveParticipant(participantID string) {
+// RemoveParticipant removes a participant and reports whether it was the
+// host.
+func (r *Room) RemoveParticipant(participantID string) bool {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	if r.Host != nil && r.Host.ID == participantID {
 		r.Host = nil
-	} else {
-		delete(r.Guests, participantID)
+		return true
 	}
+	r.leaveLobby(participantID)
+	delete(r.Guests, participantID)
+	return false
 }
 
 func (r *Room) GetParticipant(participantID string) *Participant {
@@ -135,6 +329,7 @@ func (r *Room) AllowGuest(guestID string) error {
 		return fmt.Errorf("guest not found")
 	}
 
+	r.leaveLobby(guestID)
 	guest.Status = StatusInRoom
 	return nil
 }
@@ -148,6 +343,7 @@ func (r *Room) DenyGuest(guestID string) error {
 		return fmt.Errorf("guest not found")
 	}
 
+	r.leaveLobby(guestID)
 	guest.Status = StatusDisconnected
 	delete(r.Guests, guestID)
 	return nil
@@ -162,13 +358,66 @@ func (r *Room) GetParticipantsData() *ParticipantsData {
 		count++
 	}
 
+	var host *Participant
+	if r.Host != nil {
+		host = r.Host.snapshot()
+	}
+	guests := make(map[string]*Participant, len(r.Guests))
+	for id, guest := range r.Guests {
+		guests[id] = guest.snapshot()
+	}
+
+	settings := r.settings.clone()
 	return &ParticipantsData{
-		Host:   r.Host,
-		Guests: r.Guests,
-		Count:  count,
+		Host:     host,
+		Guests:   guests,
+		Count:    count,
+		Settings: &settings,
 	}
 }
 
+// Snapshot returns a copy of what peers see of the participant, which stays
+// safe to encode after the lock is released.
+func (r *Room) Snapshot(participant *Participant) *Participant {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return participant.snapshot()
+}
+
+// snapshot copies the exported fields of the participant. The caller must
+// hold the room's mutex.
+func (p *Participant) snapshot() *Participant {
+	copied := &Participant{
+		ID:          p.ID,
+		Role:        p.Role,
+		Status:      p.Status,
+		Name:        p.Name,
+		KnockNote:   p.KnockNote,
+		Keys:        p.Keys,
+		JoinedAt:    p.JoinedAt,
+		DID:         p.DID,
+		IdentityKey: p.IdentityKey,
+	}
+	copied.Keys.Bundle = slices.Clone(p.Keys.Bundle)
+	if p.Keys.Proof != nil {
+		proof := *p.Keys.Proof
+		copied.Keys.Proof = &proof
+	}
+	if p.Verifications != nil {
+		copied.Verifications = make(map[string]*Verification, len(p.Verifications))
+		for peerID, verification := range p.Verifications {
+			verified := *verification
+			copied.Verifications[peerID] = &verified
+		}
+	}
+	if p.Capabilities != nil {
+		capabilities := *p.Capabilities
+		copied.Capabilities = &capabilities
+	}
+	return copied
+}
+
 func (r *Room) IsEmpty() bool {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -180,13 +429,15 @@ func (r *Room) BroadcastToAll(message *Message, excludeID string) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	r.stamp(message)
+
 	if r.Host != nil && r.Host.ID != excludeID {
-		r.Host.Conn.WriteJSON(message)
+		r.Host.Send(message)
 	}
 
 	for _, guest := range r.Guests {
 		if guest.ID != excludeID && guest.Status == StatusInRoom {
-			guest.Conn.WriteJSON(message)
+			guest.Send(message)
 		}
 	}
 }
@@ -195,8 +446,10 @@ func (r *Room) BroadcastToHost(message *Message) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	r.stamp(message)
+
 	if r.Host != nil {
-		r.Host.Conn.WriteJSON(message)
+		r.Host.Send(message)
 	}
 }
 
@@ -204,7 +457,59 @@ func (r *Room) BroadcastToGuest(guestID string, message *Message) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	r.stamp(message)
+
 	if guest, exists := r.Guests[guestID]; exists {
-		guest.Conn.WriteJSON(message)
+		guest.Send(message)
 	}
 }
+
+// sendMatching sends message to matching participants and returns how many
+// it was sent to.
+func (r *Room) sendMatching(match func(*Participant) bool, message *Message) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	r.stamp(message)
+
+	sent := 0
+	if r.Host != nil && match(r.Host) {
+		r.Host.Send(message)
+		sent++
+	}
+	for _, guest := range r.Guests {
+		if match(guest) {
+			guest.Send(message)
+			sent++
+		}
+	}
+	return sent
+}
+
+// disconnectMatching sends matching participants reason and closes their
+// connections. Their read loops then run the normal leaveRoom path.
+func (r *Room) disconnectMatching(match func(*Participant) bool, reason ErrorData) int {
+	r.mutex.RLock()
+	var targets []*Participant
+	if r.Host != nil && match(r.Host) {
+		targets = append(targets, r.Host)
+	}
+	for _, guest := range r.Guests {
+		if match(guest) {
+			targets = append(targets, guest)
+		}
+	}
+	r.mutex.RUnlock()
+
+	for _, participant := range targets {
+		participant.endSession()
+		participant.Send(&Message{
+			Type:      MessageTypeError,
+			Data:      reason,
+			Timestamp: time.Now(),
+		})
+		participant.Close()
+	}
+
+	return len(targets)
+}
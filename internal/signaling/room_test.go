@@ -1,10 +1,16 @@
 package signaling
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBroadcastToAll(t *testing.T) {
@@ -188,8 +194,57 @@ func TestGetParticipantsData(t *testing.T) {
 	room.AddParticipant(guest)
 
 	data := room.GetParticipantsData()
-	assert.Equal(t, host, data.Host)
+	assert.Equal(t, "host1", data.Host.ID)
+	assert.Equal(t, RoleHost, data.Host.Role)
 	assert.Equal(t, 1, len(data.Guests))
-	assert.Equal(t, guest, data.Guests["guest1"])
+	assert.Equal(t, StatusKnocking, data.Guests["guest1"].Status)
 	assert.Equal(t, 2, data.Count)
+
+	// The data is a snapshot that later changes do not touch
+	assert.NotSame(t, host, data.Host)
+	room.AllowGuest("guest1")
+	assert.Equal(t, StatusKnocking, data.Guests["guest1"].Status)
+}
+
+// encodingConn encodes what it is sent, like a real connection would.
+type encodingConn struct {
+	recordingConn
+}
+
+func (c *encodingConn) WriteJSON(v interface{}) error {
+	_, err := json.Marshal(v)
+	return err
+}
+
+func TestBroadcastWhileParticipantsChange(t *testing.T) {
+	room := NewRoom("test-room")
+	listener := &Participant{ID: "host1", Conn: &encodingConn{recordingConn{closed: make(chan struct{})}}, Role: RoleHost}
+	listener.startWriter(DefaultSendQueueSize, SlowConsumerDrop, time.Second)
+	require.NoError(t, room.AddParticipant(listener))
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			id := fmt.Sprintf("guest%d", i)
+			room.AddParticipant(&Participant{ID: id, Conn: newRecordingConn(), Role: RoleGuest})
+			room.AllowGuest(id)
+			room.SavePublicKey(id, key)
+			room.RemovePublicKey(id)
+			room.RemoveParticipant(id)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			room.BroadcastPublicKeys("")
+			room.BroadcastToHost(&Message{Type: MessageTypeParticipants, Data: room.GetParticipantsData()})
+		}
+	}()
+	wg.Wait()
+
+	listener.Close()
+	<-listener.send.done
 }
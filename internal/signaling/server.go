@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Kaamos-Comms/server/internal/did"
+	"github.com/Kaamos-Comms/server/internal/transparency"
 	"github.com/gorilla/websocket"
 )
 
@@ -15,20 +17,132 @@ type Server struct {
 	rooms    map[string]*Room
 	mutex    sync.RWMutex
 	upgrader websocket.Upgrader
+	verifier TokenVerifier
+	resolver did.Resolver
+	keyLog   *transparency.Log
+
+	keyLogLimiter *keyLogLimiter
+
+	sendQueueSize      int
+	writeTimeout       time.Duration
+	slowConsumerPolicy SlowConsumerPolicy
+
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+
+	resumeGracePeriod time.Duration
+	compressionLevel  int
+
+	hostPromotion      HostPromotionPolicy
+	hostPromotionGrace time.Duration
+
+	knockTimeout time.Duration
+	roomSettings RoomSettings
+	registry     RoomRegistry
+	ipExtractor  func(*http.Request) string
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithTokenVerifier sets the verifier used to authenticate /ws handshakes.
+// Without one every handshake is refused.
+func WithTokenVerifier(verifier TokenVerifier) Option {
+	return func(s *Server) {
+		s.verifier = verifier
+	}
+}
+
+// WithDIDResolver sets the resolver used to turn participant DIDs into
+// verification keys. The default resolves did:key and did:peer locally.
+func WithDIDResolver(resolver did.Resolver) Option {
+	return func(s *Server) {
+		s.resolver = resolver
+	}
+}
+
+// WithKeyLog records published keys in a transparency log, when they change
+// and within the rate set by WithKeyLogRate, and adds inclusion proofs to
+// public_keys broadcasts.
+func WithKeyLog(keyLog *transparency.Log) Option {
+	return func(s *Server) {
+		s.keyLog = keyLog
+	}
+}
+
+// WithSendQueue sets how many outbound messages are buffered per connection
+// and what happens when a participant does not keep up.
+func WithSendQueue(size int, policy SlowConsumerPolicy) Option {
+	return func(s *Server) {
+		s.sendQueueSize = size
+		s.slowConsumerPolicy = policy
+	}
+}
+
+// WithWriteTimeout bounds how long a single write to a connection may take.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = timeout
+	}
+}
+
+// WithHeartbeat sets how often connections are pinged and how long a pong
+// may take before the participant is considered gone. A zero interval
+// disables pings.
+func WithHeartbeat(pingInterval, pongTimeout time.Duration) Option {
+	return func(s *Server) {
+		s.pingInterval = pingInterval
+		s.pongTimeout = pongTimeout
+	}
 }
 
-func NewServer() *Server {
-	return &Server{
-		rooms: make(map[string]*Room),
+// WithIdleTimeout evicts participants that send no message, including
+// application pings, for the given duration. It is disabled by default.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithResumeGracePeriod sets how long a participant whose connection dropped
+// keeps its slot for resumption. Zero removes participants immediately.
+func WithResumeGracePeriod(period time.Duration) Option {
+	return func(s *Server) {
+		s.resumeGracePeriod = period
+	}
+}
+
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		rooms:              make(map[string]*Room),
+		resolver:           did.NewLocalRegistry(),
+		sendQueueSize:      DefaultSendQueueSize,
+		writeTimeout:       DefaultWriteTimeout,
+		slowConsumerPolicy: SlowConsumerDrop,
+		pingInterval:       DefaultPingInterval,
+		pongTimeout:        DefaultPongTimeout,
+		resumeGracePeriod:  DefaultResumeGracePeriod,
+		hostPromotion:      HostPromotionNone,
+		hostPromotionGrace: DefaultHostPromotionGrace,
+		roomSettings:       DefaultRoomSettings(),
+		keyLogLimiter:      newKeyLogLimiter(DefaultKeyLogInterval, DefaultKeyLogBurst),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    supportedSubprotocols,
 			CheckOrigin: func(r *http.Request) bool {
 				// TODO : check origin in production
 				return true
 			},
 		},
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 func generateParticipantID() string {
@@ -38,42 +152,74 @@ func generateParticipantID() string {
 }
 
 func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	slug := r.URL.Query().Get("slug")
-	roleStr := r.URL.Query().Get("role")
-	name := r.URL.Query().Get("name")
-
-	if slug == "" || roleStr == "" {
-		http.Error(w, "Missing slug or role", http.StatusBadRequest)
+	claims, herr := s.authenticate(r)
+	if herr != nil {
+		log.Printf("WebSocket handshake rejected: %s (%s)", herr.code, herr.message)
+		writeHandshakeError(w, herr)
 		return
 	}
-
-	var role ParticipantRole
-	switch roleStr {
-	case "host":
-		role = RoleHost
-	case "guest":
-		role = RoleGuest
-	default:
-		http.Error(w, "Invalid role", http.StatusBadRequest)
+	if herr := s.checkRegistered(claims.Slug); herr != nil {
+		log.Printf("WebSocket handshake rejected: %s (%s)", herr.code, herr.message)
+		writeHandshakeError(w, herr)
 		return
 	}
 
+	slug := claims.Slug
+	role := claims.Role
+	name := r.URL.Query().Get("name")
+	if claims.Name != "" {
+		name = claims.Name
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
+	protocol := ProtocolFromSubprotocol(conn.Subprotocol())
+	codec := CodecFromSubprotocol(conn.Subprotocol())
+	if err := s.enableCompression(conn); err != nil {
+		log.Printf("Failed to enable compression: %v", err)
+	}
+
+	if resumeToken := r.URL.Query().Get("resume"); resumeToken != "" {
+		if s.resumeSession(claims, resumeToken, conn, protocol, codec) {
+			return
+		}
+		writeMessage(conn, codec, &Message{
+			Type: MessageTypeError,
+			Data: ErrorData{
+				Code:    ErrCodeResumeFailed,
+				Message: "session cannot be resumed, joining as a new participant",
+			},
+			Timestamp: time.Now(),
+		})
+	}
 
 	participant := &Participant{
-		ID:       generateParticipantID(),
-		Conn:     conn,
-		Role:     role,
-		Status:   StatusConnected,
-		Name:     name,
-		JoinedAt: time.Now(),
+		ID:        generateParticipantID(),
+		Conn:      conn,
+		Role:      role,
+		Status:    StatusConnected,
+		Name:      name,
+		KnockNote: knockNote(r.URL.Query().Get("note")),
+		JoinedAt:  time.Now(),
+		DID:       claims.DID,
+		TokenID:   claims.ID,
+		tokenRole: role,
+		protocol:  protocol,
+		codec:     codec,
+
+		tokenSubject: claims.Subject,
+		remoteIP:     s.clientIP(r),
 	}
+	participant.startWriter(s.sendQueueSize, s.slowConsumerPolicy, s.writeTimeout)
 
 	s.joinRoom(slug, participant)
+	s.sendHello(participant)
+	if s.resumeGracePeriod > 0 {
+		participant.Send(s.issueResumeToken(participant))
+	}
 
 	go s.handleConnection(slug, participant)
 }
@@ -83,47 +229,92 @@ func (s *Server) joinRoom(slug string, participant *Participant) {
 	defer s.mutex.Unlock()
 
 	if _, exists := s.rooms[slug]; !exists {
-		s.rooms[slug] = NewRoom(slug)
+		room := NewRoom(slug)
+		room.keyLog = s.keyLog
+		room.keyLogLimit = s.keyLogLimiter
+		room.settings = s.newRoomSettings(slug)
+		room.bans = s.newRoomBans(slug)
+		s.rooms[slug] = room
 	}
 
 	room := s.rooms[slug]
+	room.mutex.RLock()
+	ban := room.banFor(participant)
+	room.mutex.RUnlock()
+	if ban != nil {
+		log.Printf("Rejected banned participant %s from room %s", participant.ID, slug)
+		participant.Send(&Message{
+			Type: MessageTypeError,
+			Data: ErrorData{
+				Code:    ErrCodeBanned,
+				Message: "you are banned from this room: " + string(ban.Reason),
+			},
+			Timestamp: time.Now(),
+		})
+		participant.Close()
+		return
+	}
+
 	err := room.AddParticipant(participant)
 	if err != nil {
 		log.Printf("Failed to add participant: %v", err)
-		participant.Conn.WriteJSON(&Message{
+		participant.Send(&Message{
 			Type: MessageTypeError,
 			Data: ErrorData{
-				Code:    "JOIN_FAILED",
+				Code:    joinErrorCode(err),
 				Message: err.Error(),
 			},
 			Timestamp: time.Now(),
 		})
-		participant.Conn.Close()
+		participant.Close()
 		return
 	}
 
+	joined := room.Snapshot(participant)
 	joinMessage := &Message{
 		Type:      MessageTypeJoin,
 		From:      participant.ID,
 		Slug:      slug,
-		Data:      participant,
+		Data:      joined,
 		Timestamp: time.Now(),
 	}
 
-	if participant.Role == RoleGuest {
+	switch {
+	case participant.Status == StatusKnocking:
 		knockMessage := &Message{
 			Type:      MessageTypeKnock,
 			From:      participant.ID,
 			Slug:      slug,
-			Data:      participant,
+			Data:      joined,
 			Timestamp: time.Now(),
 		}
-		room.BroadcastToHost(knockMessage)
-	} else {
+		room.sendToPermitted(PermissionAdmit, knockMessage)
+		s.knocked(slug, room, participant)
+	case participant.Role == RoleHost:
 		room.BroadcastToAll(joinMessage, participant.ID)
+		room.sendMatching(func(p *Participant) bool { return p != participant }, &Message{
+			Type:      MessageTypeHostChanged,
+			Slug:      slug,
+			Data:      HostChangedData{HostID: participant.ID, Reason: HostChangeJoined},
+			Timestamp: time.Now(),
+		})
+	default:
+		// Open admission: the guest is let in as if the host allowed it
+		participant.Send(&Message{
+			Type:      MessageTypeAllow,
+			To:        participant.ID,
+			Slug:      slug,
+			Timestamp: time.Now(),
+		})
+		room.BroadcastToAll(&Message{
+			Type:      MessageTypeParticipants,
+			Slug:      slug,
+			Data:      room.GetParticipantsData(),
+			Timestamp: time.Now(),
+		}, participant.ID)
 	}
 
-	participant.Conn.WriteJSON(&Message{
+	participant.Send(&Message{
 		Type:      MessageTypeParticipants,
 		Slug:      slug,
 		Data:      room.GetParticipantsData(),
@@ -132,14 +323,30 @@ func (s *Server) joinRoom(slug string, participant *Participant) {
 }
 
 func (s *Server) handleConnection(slug string, participant *Participant) {
-	defer s.leaveRoom(slug, participant)
+	// A resumed session may swap the participant's connection, so this
+	// loop sticks to the one it started with
+	participant.connMutex.Lock()
+	conn, codec := participant.Conn, participant.codec
+	participant.connMutex.Unlock()
+	if codec == nil {
+		codec = JSONCodec
+	}
+	heartbeat := s.startHeartbeat(participant.ID, conn)
 
 	for {
-		var message Message
-		err := participant.Conn.ReadJSON(&message)
+		_, payload, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("Read message error: %v", err)
-			break
+			heartbeat.stop()
+			s.disconnected(slug, participant, conn, err)
+			return
+		}
+		heartbeat.messageReceived()
+
+		var message Message
+		if err := codec.Unmarshal(payload, &message); err != nil {
+			rejectMessage(participant, &message, ErrCodeMalformedMessage, "message cannot be decoded: "+err.Error())
+			continue
 		}
 
 		message.From = participant.ID
@@ -158,11 +365,19 @@ func (s *Server) leaveRoom(slug string, participant *Participant) {
 	if !exists {
 		return
 	}
+	if room.GetParticipant(participant.ID) != participant {
+		// Already removed, e.g. denied or kicked
+		participant.Close()
+		return
+	}
 
+	participant.endSession()
 	room.RemovePublicKey(participant.ID)
+	room.removeMLSMember(participant.ID)
 
-	room.RemoveParticipant(participant.ID)
-	participant.Conn.Close()
+	wasKnocking := participant.Status == StatusKnocking
+	wasHost := room.RemoveParticipant(participant.ID)
+	participant.Close()
 
 	leaveMessage := &Message{
 		Type:      MessageTypeLeave,
@@ -175,8 +390,15 @@ func (s *Server) leaveRoom(slug string, participant *Participant) {
 	room.BroadcastPublicKeys(participant.ID)
 
 	if room.IsEmpty() {
+		room.mutex.Lock()
+		room.cancelPromotion()
+		room.mutex.Unlock()
 		delete(s.rooms, slug)
 		log.Printf("Room %s deleted (empty)", slug)
+	} else if wasHost {
+		s.hostLeft(slug, room, participant.ID)
+	} else if wasKnocking {
+		announceLobby(room)
 	}
 }
 
@@ -198,16 +420,29 @@ func (s *Server) GetRoomStats(slug string) map[string]interface{} {
 	}
 }
 
+// KeyTransparency returns inclusion proofs for the current keys of a room,
+// or nil if the room does not exist or no key log is configured.
+func (s *Server) KeyTransparency(slug string) *KeyTransparency {
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return room.KeyTransparency()
+}
+
 func (s *Server) Shutdown() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	for _, room := range s.rooms {
 		if room.Host != nil {
-			room.Host.Conn.Close()
+			room.Host.Close()
 		}
 		for _, guest := range room.Guests {
-			guest.Conn.Close()
+			guest.Close()
 		}
 	}
 	s.rooms = make(map[string]*Room)
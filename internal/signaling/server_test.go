@@ -1,6 +1,7 @@
 package signaling
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestShutdown(t *testing.T) {
@@ -51,13 +53,13 @@ func TestShutdown(t *testing.T) {
 }
 
 func TestWebSocketConnection(t *testing.T) {
-	server := NewServer()
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
 
 	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
 	defer testServer.Close()
 
 	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") +
-		"?slug=test-room&role=host&name=TestHost"
+		"?token=host-token&name=TestHost"
 
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	assert.NoError(t, err)
@@ -79,13 +81,13 @@ func TestNewServer(t *testing.T) {
 }
 
 func TestWebSocketUpgrade(t *testing.T) {
-	server := NewServer()
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
 	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
 	defer testServer.Close()
 
 	// Convert HTTP URL to WebSocket URL
 	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") +
-		"?slug=test-room&role=host&name=TestHost"
+		"?token=host-token&name=TestHost"
 
 	// Connect via WebSocket
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
@@ -102,14 +104,14 @@ func TestWebSocketUpgrade(t *testing.T) {
 }
 
 func TestJoinRoomAsGuest(t *testing.T) {
-	server := NewServer()
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
 
 	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
 	defer testServer.Close()
 
 	// Connect as host
 	hostURL := "ws" + strings.TrimPrefix(testServer.URL, "http") +
-		"?slug=test-room&role=host&name=Host"
+		"?slug=test-room&role=host&token=host-token&name=Host"
 	hostConn, _, err := websocket.DefaultDialer.Dial(hostURL, nil)
 	assert.NoError(t, err)
 	defer hostConn.Close()
@@ -118,7 +120,7 @@ func TestJoinRoomAsGuest(t *testing.T) {
 
 	// Connect as guest
 	guestURL := "ws" + strings.TrimPrefix(testServer.URL, "http") +
-		"?slug=test-room&role=guest&name=Guest"
+		"?token=guest-token&name=Guest"
 	guestConn, _, err := websocket.DefaultDialer.Dial(guestURL, nil)
 	assert.NoError(t, err)
 	defer guestConn.Close()
@@ -132,16 +134,21 @@ func TestJoinRoomAsGuest(t *testing.T) {
 }
 
 func TestInvalidWebSocketParams(t *testing.T) {
-	server := NewServer()
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
 
 	tests := []struct {
 		name     string
 		query    string
 		expected int
+		code     string
 	}{
-		{"missing slug", "role=host&name=Test", http.StatusBadRequest},
-		{"missing role", "slug=room&name=Test", http.StatusBadRequest},
-		{"invalid role", "slug=room&role=invalid&name=Test", http.StatusBadRequest},
+		{"missing token", "slug=test-room&role=host&name=Test", http.StatusUnauthorized, ErrCodeTokenMissing},
+		{"unknown token", "token=forged&name=Test", http.StatusUnauthorized, ErrCodeTokenInvalid},
+		{"expired token", "token=expired&name=Test", http.StatusUnauthorized, ErrCodeTokenExpired},
+		{"revoked token", "token=revoked&name=Test", http.StatusUnauthorized, ErrCodeTokenRevoked},
+		{"slug mismatch", "token=guest-token&slug=other-room", http.StatusForbidden, ErrCodeSlugMismatch},
+		{"role mismatch", "token=guest-token&role=host", http.StatusForbidden, ErrCodeRoleMismatch},
+		{"invalid role", "token=guest-token&role=invalid", http.StatusBadRequest, ErrCodeInvalidRole},
 	}
 
 	for _, tt := range tests {
@@ -151,13 +158,63 @@ func TestInvalidWebSocketParams(t *testing.T) {
 
 			server.HandleWebSocket(w, req)
 			assert.Equal(t, tt.expected, w.Code)
+
+			var errData ErrorData
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errData))
+			assert.Equal(t, tt.code, errData.Code)
 		})
 	}
 }
 
-func TestGetRoomStats(t *testing.T) {
+func TestWebSocketRequiresVerifier(t *testing.T) {
 	server := NewServer()
 
+	req := httptest.NewRequest(http.MethodGet, "/ws?token=host-token", nil)
+	w := httptest.NewRecorder()
+
+	server.HandleWebSocket(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws?token=from-query", nil)
+	assert.Equal(t, "from-query", tokenFromRequest(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", BaseProtocol+", "+TokenProtocolPrefix+"from-protocol")
+	assert.Equal(t, "from-protocol", tokenFromRequest(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.AddCookie(&http.Cookie{Name: TokenCookieName, Value: "from-cookie"})
+	assert.Equal(t, "from-cookie", tokenFromRequest(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	assert.Equal(t, "", tokenFromRequest(req))
+}
+
+func TestWebSocketTokenViaSubprotocol(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{BaseProtocol, TokenProtocolPrefix + "host-token"},
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, BaseProtocol, conn.Subprotocol())
+
+	time.Sleep(50 * time.Millisecond)
+
+	stats := server.GetRoomStats("test-room")
+	assert.NotNil(t, stats)
+	assert.Equal(t, true, stats["has_host"])
+}
+
+func TestGetRoomStats(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+
 	// Room does not exist
 	stats := server.GetRoomStats("nonexistent")
 	assert.Nil(t, stats)
@@ -167,7 +224,7 @@ func TestGetRoomStats(t *testing.T) {
 	defer testServer.Close()
 
 	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") +
-		"?slug=test-room&role=host&name=Host"
+		"?slug=test-room&role=host&token=host-token&name=Host"
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	assert.NoError(t, err)
 	defer conn.Close()
@@ -180,3 +237,88 @@ func TestGetRoomStats(t *testing.T) {
 	assert.Equal(t, true, stats["has_host"])
 	assert.Equal(t, 0, stats["guests_count"])
 }
+
+func TestDisconnectToken(t *testing.T) {
+	server := NewServer()
+	room := NewRoom("test-room")
+	server.rooms["test-room"] = room
+
+	mockHostConn := &MockWebSocketConn{}
+	mockGuestConn := &MockWebSocketConn{}
+
+	host := &Participant{ID: "host1", Conn: mockHostConn, Role: RoleHost, TokenID: "host-jti"}
+	guest := &Participant{ID: "guest1", Conn: mockGuestConn, Role: RoleGuest, TokenID: "guest-jti"}
+	room.AddParticipant(host)
+	room.AddParticipant(guest)
+
+	mockGuestConn.On("WriteJSON", mock.MatchedBy(func(m *Message) bool {
+		data, ok := m.Data.(ErrorData)
+		return m.Type == MessageTypeError && ok && data.Code == ErrCodeTokenRevoked
+	})).Return(nil).Once()
+	mockGuestConn.On("Close").Return(nil).Once()
+
+	assert.Equal(t, 1, server.DisconnectToken("guest-jti"))
+	assert.Equal(t, 0, server.DisconnectToken("unknown-jti"))
+
+	mockGuestConn.AssertExpectations(t)
+	mockHostConn.AssertNotCalled(t, "Close")
+}
+
+func TestDisconnectRoomKeepsCaller(t *testing.T) {
+	server := NewServer()
+	room := NewRoom("test-room")
+	server.rooms["test-room"] = room
+
+	mockHostConn := &MockWebSocketConn{}
+	mockGuestConn := &MockWebSocketConn{}
+
+	room.AddParticipant(&Participant{ID: "host1", Conn: mockHostConn, Role: RoleHost, TokenID: "host-jti"})
+	room.AddParticipant(&Participant{ID: "guest1", Conn: mockGuestConn, Role: RoleGuest, TokenID: "guest-jti"})
+
+	mockGuestConn.On("WriteJSON", mock.Anything).Return(nil).Once()
+	mockGuestConn.On("Close").Return(nil).Once()
+
+	assert.Equal(t, 1, server.DisconnectRoom("test-room", "host-jti"))
+	assert.Equal(t, 0, server.DisconnectRoom("missing-room", ""))
+
+	mockGuestConn.AssertExpectations(t)
+	mockHostConn.AssertNotCalled(t, "Close")
+}
+
+func TestWebSocketUsesNameFromToken(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?token=alice-token&name=Mallory"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	participants := server.GetRoomStats("test-room")["participants"].(*ParticipantsData)
+	for _, guest := range participants.Guests {
+		assert.Equal(t, "Alice", guest.Name)
+	}
+	assert.Len(t, participants.Guests, 1)
+}
+
+func TestWebSocketBindsDIDFromToken(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "?token=did-token"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	var message Message
+	assert.NoError(t, conn.ReadJSON(&message))
+	assert.Equal(t, MessageTypeParticipants, message.Type)
+
+	data := message.Data.(map[string]interface{})
+	host := data["host"].(map[string]interface{})
+	assert.Equal(t, "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", host["did"])
+}
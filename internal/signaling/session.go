@@ -0,0 +1,304 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A participant whose connection drops is suspended rather than removed:
+// it keeps its ID, status and keys for the grace period while messages to it
+// are buffered. Reconnecting with the resume token issued in the session
+// message restores the slot and replays what was missed. A client that
+// reconnects before the server noticed the drop takes over its session: the
+// old connection is closed and what it had not written yet is replayed on
+// the new one.
+
+const (
+	DefaultResumeGracePeriod = 30 * time.Second
+	maxReplayMessages        = 256
+	ErrCodeResumeFailed      = "RESUME_FAILED"
+)
+
+var errNotResumable = errors.New("session cannot be resumed")
+
+// SessionData tells a participant its ID and how to resume its session.
+type SessionData struct {
+	ParticipantID string `json:"participant_id"`
+	ResumeToken   string `json:"resume_token"`
+	GracePeriod   int    `json:"grace_period"` // seconds a dropped session is kept
+	Resumed       bool   `json:"resumed,omitempty"`
+	Replayed      int    `json:"replayed,omitempty"`  // missed messages that follow
+	Truncated     bool   `json:"truncated,omitempty"` // older missed messages were discarded
+}
+
+// session is the resumption state of a participant, guarded by its
+// connMutex.
+type session struct {
+	token     string // empty when the session cannot be resumed
+	suspended bool
+	replay    []outbound
+	truncated bool
+	expiry    *time.Timer
+}
+
+// buffer keeps a message for replay, discarding and returning the oldest
+// when full.
+func (s *session) buffer(item outbound) []outbound {
+	var evicted []outbound
+	if len(s.replay) >= maxReplayMessages {
+		evicted = s.replay[:1]
+		s.replay = s.replay[1:]
+		s.truncated = true
+	}
+	s.replay = append(s.replay, item)
+	return evicted
+}
+
+func generateResumeToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// issueResumeToken gives the participant a new resume token and returns the
+// session message announcing it.
+func (s *Server) issueResumeToken(participant *Participant) *Message {
+	token := generateResumeToken()
+
+	participant.connMutex.Lock()
+	participant.session.token = token
+	participant.connMutex.Unlock()
+
+	return &Message{
+		Type: MessageTypeSession,
+		Data: SessionData{
+			ParticipantID: participant.ID,
+			ResumeToken:   token,
+			GracePeriod:   int(s.resumeGracePeriod / time.Second),
+		},
+		Timestamp: time.Now(),
+	}
+}
+
+// endSession makes the participant's session impossible to resume, e.g.
+// because it left or was removed from the room on purpose.
+func (p *Participant) endSession() {
+	p.connMutex.Lock()
+	if p.session.expiry != nil {
+		p.session.expiry.Stop()
+	}
+	missed := p.session.replay
+	p.session = session{}
+	p.connMutex.Unlock()
+
+	reportAll(missed, errRecipientGone)
+}
+
+// disconnected runs when conn, a connection of the participant, is gone.
+// Unless the client closed it deliberately, the participant is suspended for
+// the grace period; otherwise it leaves right away. Nothing happens if a
+// resumed session already replaced conn.
+func (s *Server) disconnected(slug string, participant *Participant, conn WebSocketConnInterface, readErr error) {
+	if !participant.connectedWith(conn) {
+		log.Printf("Connection of participant %s was taken over", participant.ID)
+		return
+	}
+	deliberate := websocket.IsCloseError(readErr, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+	if deliberate || !s.suspend(slug, participant, conn) {
+		s.leaveRoom(slug, participant)
+	}
+}
+
+// connectedWith reports whether conn is the participant's current
+// connection.
+func (p *Participant) connectedWith(conn WebSocketConnInterface) bool {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	return p.Conn == conn
+}
+
+// suspend keeps the participant in its room for the grace period. Messages
+// its writer had not written yet are kept for replay. It also reports true
+// if a resumed session replaced conn in the meantime.
+func (s *Server) suspend(slug string, participant *Participant, conn WebSocketConnInterface) bool {
+	if s.resumeGracePeriod <= 0 {
+		return false
+	}
+
+	s.mutex.RLock()
+	room, exists := s.rooms[slug]
+	s.mutex.RUnlock()
+	if !exists || room.GetParticipant(participant.ID) != participant {
+		return false
+	}
+
+	participant.connMutex.Lock()
+	if participant.Conn != conn {
+		participant.connMutex.Unlock()
+		return true
+	}
+	if participant.session.token == "" {
+		participant.connMutex.Unlock()
+		return false
+	}
+	participant.session.suspended = true
+	participant.session.replay = nil
+	participant.session.truncated = false
+	participant.session.expiry = time.AfterFunc(s.resumeGracePeriod, func() {
+		s.expireSession(slug, participant)
+	})
+
+	// The connection is dead, so queued messages wait for the next one
+	var evicted []outbound
+	if participant.send != nil {
+		for _, item := range participant.send.detach() {
+			evicted = append(evicted, participant.session.buffer(item)...)
+		}
+	}
+	participant.connMutex.Unlock()
+
+	reportAll(evicted, errQueueFull)
+	log.Printf("Participant %s suspended for %v", participant.ID, s.resumeGracePeriod)
+	return true
+}
+
+// expireSession removes a participant that did not resume in time.
+func (s *Server) expireSession(slug string, participant *Participant) {
+	participant.connMutex.Lock()
+	if !participant.session.suspended {
+		participant.connMutex.Unlock()
+		return
+	}
+	missed := participant.session.replay
+	participant.session = session{}
+	participant.connMutex.Unlock()
+
+	reportAll(missed, errRecipientGone)
+	log.Printf("Session of participant %s expired", participant.ID)
+	s.leaveRoom(slug, participant)
+}
+
+// resume reattaches a participant to a new connection and returns the
+// messages it missed. A live connection is closed and the messages it had
+// not written yet are the ones missed.
+func (s *Server) resume(participant *Participant, token string, conn WebSocketConnInterface, protocol ProtocolVersion, codec Codec) ([]outbound, bool, error) {
+	participant.connMutex.Lock()
+	defer participant.connMutex.Unlock()
+
+	current := participant.session.token
+	if current == "" || subtle.ConstantTimeCompare([]byte(current), []byte(token)) != 1 {
+		return nil, false, errNotResumable
+	}
+
+	var replay []outbound
+	var truncated bool
+	if participant.session.suspended {
+		participant.session.expiry.Stop()
+		replay, truncated = participant.session.replay, participant.session.truncated
+	} else {
+		if participant.send != nil {
+			replay = participant.send.detach()
+		}
+		// Its read loop sees the connection replaced and leaves the
+		// participant alone
+		participant.Conn.Close()
+	}
+
+	participant.session = session{}
+	participant.Conn = conn
+	participant.protocol = protocol
+	participant.codec = codec
+	participant.startWriter(s.sendQueueSize, s.slowConsumerPolicy, s.writeTimeout)
+	return replay, truncated, nil
+}
+
+// resumeSession restores the participant holding token, which
+// may now speak another protocol version or codec. It reports whether the
+// connection now belongs to that participant.
+func (s *Server) resumeSession(claims *TokenClaims, token string, conn WebSocketConnInterface, protocol ProtocolVersion, codec Codec) bool {
+	s.mutex.RLock()
+	room, exists := s.rooms[claims.Slug]
+	s.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	participant := room.findResumable(token, claims)
+	if participant == nil {
+		return false
+	}
+
+	replay, truncated, err := s.resume(participant, token, conn, protocol, codec)
+	if err != nil {
+		return false
+	}
+	room.setTokenID(participant, claims.ID)
+	s.sendHello(participant)
+
+	message := s.issueResumeToken(participant)
+	data := message.Data.(SessionData)
+	data.Resumed = true
+	data.Replayed = len(replay)
+	data.Truncated = truncated
+	message.Data = data
+	participant.Send(message)
+	for _, missed := range replay {
+		participant.enqueue(missed)
+	}
+	participant.Send(&Message{
+		Type:      MessageTypeParticipants,
+		Slug:      claims.Slug,
+		Data:      room.GetParticipantsData(),
+		Timestamp: time.Now(),
+	})
+
+	log.Printf("Participant %s resumed with %d missed messages", participant.ID, len(replay))
+	go s.handleConnection(claims.Slug, participant)
+	return true
+}
+
+// findResumable returns the participant holding token, suspended or not,
+// provided the new connection was authenticated for the same role and
+// identity.
+func (r *Room) findResumable(token string, claims *TokenClaims) *Participant {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	candidates := make([]*Participant, 0, len(r.Guests)+1)
+	if r.Host != nil {
+		candidates = append(candidates, r.Host)
+	}
+	for _, guest := range r.Guests {
+		candidates = append(candidates, guest)
+	}
+
+	for _, participant := range candidates {
+		participant.connMutex.Lock()
+		current := participant.session.token
+		participant.connMutex.Unlock()
+
+		if current == "" || subtle.ConstantTimeCompare([]byte(current), []byte(token)) != 1 {
+			continue
+		}
+		if participant.authenticatedRole() != claims.Role || participant.DID != claims.DID {
+			return nil
+		}
+		return participant
+	}
+	return nil
+}
+
+// setTokenID records the token a participant is now connected with, so that
+// revoking it disconnects the participant.
+func (r *Room) setTokenID(participant *Participant, tokenID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	participant.TokenID = tokenID
+}
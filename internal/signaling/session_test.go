@@ -0,0 +1,194 @@
+package signaling
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readUntil reads messages until one of the given type arrives.
+func readUntil(t *testing.T, conn *websocket.Conn, messageType MessageType) *Message {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var message Message
+		require.NoError(t, conn.ReadJSON(&message))
+		if message.Type == messageType {
+			return &message
+		}
+	}
+}
+
+func TestResumeAfterDrop(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	issued := messageData[SessionData](t, readUntil(t, host, MessageTypeSession))
+	require.NotEmpty(t, issued.ResumeToken)
+	assert.Equal(t, int(DefaultResumeGracePeriod/time.Second), issued.GracePeriod)
+
+	// Drop the connection without a close frame
+	host.UnderlyingConn().Close()
+	require.Eventually(t, func() bool {
+		room := server.rooms["test-room"]
+		participant := room.GetParticipant(issued.ParticipantID)
+		participant.connMutex.Lock()
+		defer participant.connMutex.Unlock()
+		return participant.session.suspended
+	}, time.Second, 5*time.Millisecond)
+
+	// A guest knocks while the host is away
+	dialWS(t, testServer, "token=guest-token")
+	require.Eventually(t, func() bool {
+		return len(server.GetRoomStats("test-room")["participants"].(*ParticipantsData).Guests) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	resumed := dialWS(t, testServer, "token=host-token&resume="+issued.ResumeToken)
+	data := messageData[SessionData](t, readUntil(t, resumed, MessageTypeSession))
+	assert.True(t, data.Resumed)
+	assert.Equal(t, issued.ParticipantID, data.ParticipantID)
+	assert.Equal(t, 2, data.Replayed) // knock and lobby
+	assert.NotEqual(t, issued.ResumeToken, data.ResumeToken)
+
+	knock := readUntil(t, resumed, MessageTypeKnock)
+	assert.NotEqual(t, issued.ParticipantID, knock.From)
+	readUntil(t, resumed, MessageTypeParticipants)
+
+	participants := server.GetRoomStats("test-room")["participants"].(*ParticipantsData)
+	require.NotNil(t, participants.Host)
+	assert.Equal(t, issued.ParticipantID, participants.Host.ID)
+
+	// The old token cannot be reused
+	resumed.UnderlyingConn().Close()
+	time.Sleep(20 * time.Millisecond)
+	again := dialWS(t, testServer, "token=host-token&resume="+issued.ResumeToken)
+	errorMessage := readUntil(t, again, MessageTypeError)
+	assert.Equal(t, ErrCodeResumeFailed, errorMessage.Data.(map[string]interface{})["code"])
+}
+
+func TestResumeTakesOverLiveSession(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	issued := messageData[SessionData](t, readUntil(t, host, MessageTypeSession))
+
+	// The client reconnects before the server noticed its old connection drop
+	resumed := dialWS(t, testServer, "token=host-token&resume="+issued.ResumeToken)
+	data := messageData[SessionData](t, readUntil(t, resumed, MessageTypeSession))
+	assert.True(t, data.Resumed)
+	assert.Equal(t, issued.ParticipantID, data.ParticipantID)
+
+	host.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := host.ReadMessage()
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "the old connection is closed")
+			break
+		}
+	}
+
+	// The old connection's read loop leaves the participant alone
+	time.Sleep(20 * time.Millisecond)
+	participant := server.rooms["test-room"].GetParticipant(issued.ParticipantID)
+	require.NotNil(t, participant)
+	participant.connMutex.Lock()
+	suspended := participant.session.suspended
+	participant.connMutex.Unlock()
+	assert.False(t, suspended)
+
+	dialWS(t, testServer, "token=guest-token")
+	readUntil(t, resumed, MessageTypeKnock)
+}
+
+func TestSuspendKeepsQueuedMessages(t *testing.T) {
+	server := NewServer()
+	conn := newRecordingConn()
+	conn.gate = make(chan struct{}) // never opened: the connection is dead
+	participant := &Participant{ID: "host1", Conn: conn, Role: RoleHost, Status: StatusInRoom}
+	participant.session.token = "resume"
+	participant.startWriter(DefaultSendQueueSize, SlowConsumerDrop, time.Second)
+	room := NewRoom("test-room")
+	require.NoError(t, room.AddParticipant(participant))
+	server.rooms["test-room"] = room
+
+	participant.Send(&Message{Type: MessageTypeOffer})
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&conn.writing) == 1 }, time.Second, time.Millisecond)
+	participant.Send(&Message{Type: MessageTypeAnswer})
+	participant.Send(&Message{Type: MessageTypeICECandidate})
+
+	require.True(t, server.suspend("test-room", participant, conn))
+	conn.Close()
+	defer participant.endSession()
+
+	participant.connMutex.Lock()
+	var kept []MessageType
+	for _, item := range participant.session.replay {
+		kept = append(kept, item.message.Type)
+	}
+	participant.connMutex.Unlock()
+	assert.Equal(t, []MessageType{MessageTypeAnswer, MessageTypeICECandidate}, kept)
+	assert.Empty(t, conn.written())
+}
+
+func TestResumeGracePeriodExpires(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()), WithResumeGracePeriod(50*time.Millisecond))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	readUntil(t, host, MessageTypeSession)
+	host.UnderlyingConn().Close()
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NotNil(t, server.GetRoomStats("test-room"))
+	assert.Eventually(t, func() bool { return server.GetRoomStats("test-room") == nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestDeliberateCloseLeavesImmediately(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	readUntil(t, host, MessageTypeSession)
+	require.NoError(t, host.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+
+	assert.Eventually(t, func() bool { return server.GetRoomStats("test-room") == nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestResumeRequiresSameRole(t *testing.T) {
+	server := NewServer(WithTokenVerifier(newTestVerifier()))
+	testServer := httptest.NewServer(http.HandlerFunc(server.HandleWebSocket))
+	defer testServer.Close()
+
+	host := dialWS(t, testServer, "token=host-token")
+	issued := messageData[SessionData](t, readUntil(t, host, MessageTypeSession))
+	host.UnderlyingConn().Close()
+	time.Sleep(20 * time.Millisecond)
+
+	guest := dialWS(t, testServer, "token=guest-token&resume="+issued.ResumeToken)
+	errorMessage := readUntil(t, guest, MessageTypeError)
+	assert.Equal(t, ErrCodeResumeFailed, errorMessage.Data.(map[string]interface{})["code"])
+}
+
+func TestReplayBufferIsBounded(t *testing.T) {
+	var s session
+	for i := 0; i < maxReplayMessages+5; i++ {
+		s.buffer(outbound{message: &Message{Type: MessageTypeOffer, Data: i}})
+	}
+
+	assert.Len(t, s.replay, maxReplayMessages)
+	assert.True(t, s.truncated)
+	assert.Equal(t, 5, s.replay[0].message.Data)
+}
@@ -0,0 +1,201 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// RoomSettings control who may join a room and what guests may do there.
+// New rooms start with their registered settings, or the server's
+// (WithRoomSettings) if they have none; at runtime
+// participants change them with a partial room_settings, and every change is
+// broadcast as room_settings. Changing Locked takes PermissionLock, anything
+// else PermissionConfigure.
+
+const (
+	ErrCodeJoinFailed     = "JOIN_FAILED"
+	ErrCodeRoomFull       = "ROOM_FULL"
+	ErrCodeRoomLocked     = "ROOM_LOCKED"
+	ErrCodeRoleNotAllowed = "ROLE_NOT_ALLOWED"
+)
+
+var (
+	errRoomFull       = errors.New("the room is full")
+	errRoomLocked     = errors.New("the room is locked")
+	errRoleNotAllowed = errors.New("your role is not allowed in this room")
+)
+
+// AdmissionPolicy decides whether guests wait in the lobby.
+type AdmissionPolicy string
+
+const (
+	// AdmissionKnock makes guests knock until they are allowed in.
+	AdmissionKnock AdmissionPolicy = "knock"
+	// AdmissionOpen lets guests straight in.
+	AdmissionOpen AdmissionPolicy = "open"
+)
+
+type RoomSettings struct {
+	// MaxParticipants caps the participants, knocking guests included. The
+	// host can always join. Zero means no limit.
+	MaxParticipants int  `json:"max_participants"`
+	Locked          bool `json:"locked"` // only the host can join
+	// Admission is AdmissionKnock if empty.
+	Admission AdmissionPolicy `json:"admission"`
+	// AllowedRoles limits the roles participants may join with or be
+	// granted. The host is always allowed; empty allows every role.
+	AllowedRoles []ParticipantRole `json:"allowed_roles,omitempty"`
+	// GuestBroadcast lets guests and viewers send encrypted_data to "all",
+	// MLS application messages included.
+	GuestBroadcast bool `json:"guest_broadcast"`
+}
+
+// DefaultRoomSettings returns the settings rooms had before they were
+// configurable.
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{Admission: AdmissionKnock, GuestBroadcast: true}
+}
+
+func (s RoomSettings) Validate() error {
+	if s.MaxParticipants < 0 {
+		return errors.New("max_participants cannot be negative")
+	}
+	if s.Admission != AdmissionKnock && s.Admission != AdmissionOpen {
+		return fmt.Errorf("unknown admission policy %q", s.Admission)
+	}
+	for _, role := range s.AllowedRoles {
+		if _, known := rolePermissions[role]; !known {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+func (s RoomSettings) clone() RoomSettings {
+	s.AllowedRoles = slices.Clone(s.AllowedRoles)
+	return s
+}
+
+func (s RoomSettings) allows(role ParticipantRole) bool {
+	return role == RoleHost || len(s.AllowedRoles) == 0 || slices.Contains(s.AllowedRoles, role)
+}
+
+// mayBroadcast reports whether participants with role may send
+// encrypted_data to everyone.
+func (s RoomSettings) mayBroadcast(role ParticipantRole) bool {
+	return s.GuestBroadcast || role.Can(PermissionBroadcast)
+}
+
+// WithRoomSettings sets the settings new rooms start with.
+func WithRoomSettings(settings RoomSettings) Option {
+	return func(s *Server) {
+		s.roomSettings = settings.clone()
+	}
+}
+
+// RoomSettingsUpdate is the payload of a room_settings sent by a client.
+// Fields left out keep their value.
+type RoomSettingsUpdate struct {
+	MaxParticipants *int               `json:"max_participants,omitempty"`
+	Locked          *bool              `json:"locked,omitempty"`
+	Admission       *AdmissionPolicy   `json:"admission,omitempty"`
+	AllowedRoles    *[]ParticipantRole `json:"allowed_roles,omitempty"`
+	GuestBroadcast  *bool              `json:"guest_broadcast,omitempty"`
+}
+
+func (u RoomSettingsUpdate) Validate() error {
+	return u.Apply(DefaultRoomSettings()).Validate()
+}
+
+// lockOnly reports whether the update only locks or unlocks the room.
+func (u RoomSettingsUpdate) lockOnly() bool {
+	return u.Locked != nil && u.MaxParticipants == nil && u.Admission == nil &&
+		u.AllowedRoles == nil && u.GuestBroadcast == nil
+}
+
+// Apply returns settings with the update applied.
+func (u RoomSettingsUpdate) Apply(settings RoomSettings) RoomSettings {
+	settings = settings.clone()
+	if u.MaxParticipants != nil {
+		settings.MaxParticipants = *u.MaxParticipants
+	}
+	if u.Locked != nil {
+		settings.Locked = *u.Locked
+	}
+	if u.Admission != nil {
+		settings.Admission = *u.Admission
+	}
+	if u.AllowedRoles != nil {
+		settings.AllowedRoles = slices.Clone(*u.AllowedRoles)
+	}
+	if u.GuestBroadcast != nil {
+		settings.GuestBroadcast = *u.GuestBroadcast
+	}
+	return settings
+}
+
+// admits checks a guest that is about to join against the settings. The
+// caller must hold the mutex.
+func (r *Room) admits(participant *Participant) error {
+	if r.settings.Locked {
+		return errRoomLocked
+	}
+	if !r.settings.allows(participant.Role) {
+		return errRoleNotAllowed
+	}
+	count := len(r.Guests)
+	if r.Host != nil {
+		count++
+	}
+	if r.settings.MaxParticipants > 0 && count >= r.settings.MaxParticipants {
+		return errRoomFull
+	}
+	return nil
+}
+
+// Settings returns the room's settings.
+func (r *Room) Settings() RoomSettings {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.settings.clone()
+}
+
+func (r *Room) updateSettings(update RoomSettingsUpdate) RoomSettings {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.settings = update.Apply(r.settings)
+	return r.settings.clone()
+}
+
+// joinErrorCode returns the error code telling a participant why
+// AddParticipant refused it.
+func joinErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errRoomFull):
+		return ErrCodeRoomFull
+	case errors.Is(err, errRoomLocked):
+		return ErrCodeRoomLocked
+	case errors.Is(err, errRoleNotAllowed):
+		return ErrCodeRoleNotAllowed
+	}
+	return ErrCodeJoinFailed
+}
+
+func (s *Server) handleRoomSettings(room *Room, participant *Participant, message *Message) {
+	update := message.Data.(RoomSettingsUpdate)
+
+	permission := PermissionConfigure
+	if update.lockOnly() {
+		permission = PermissionLock
+	}
+	if !room.permits(participant, permission) {
+		rejectMessage(participant, message, ErrCodeForbidden, "you are not allowed to change the room settings")
+		return
+	}
+
+	settings := room.updateSettings(update)
+	s.saveRoomSettings(room.Slug, settings)
+	announceSettings(room, participant.ID, settings)
+}
@@ -0,0 +1,127 @@
+package signaling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRoomsStartWithServerSettings(t *testing.T) {
+	settings := RoomSettings{MaxParticipants: 2, Admission: AdmissionOpen, AllowedRoles: []ParticipantRole{RoleGuest}}
+	server := NewServer(WithRoomSettings(settings))
+	settings.AllowedRoles[0] = RoleViewer
+
+	server.joinRoom("fresh-room", &Participant{ID: "host1", Conn: newRecordingConn(), Role: RoleHost})
+	assert.Equal(t, RoomSettings{MaxParticipants: 2, Admission: AdmissionOpen, AllowedRoles: []ParticipantRole{RoleGuest}}, server.rooms["fresh-room"].Settings())
+	assert.Equal(t, DefaultRoomSettings(), NewRoom("other-room").Settings())
+}
+
+func TestJoinEnforcesRoomSettings(t *testing.T) {
+	server := NewServer()
+	room, _ := handoffRoom(t, server)
+	room.updateSettings(RoomSettingsUpdate{MaxParticipants: intPtr(4)})
+
+	knock(server, "k1", "")
+	assert.Equal(t, ErrCodeRoomFull, errorCode(knock(server, "k2", "")), "knocking guests take up room")
+	assert.Nil(t, room.GetParticipant("k2"))
+
+	server.leaveRoom("test-room", room.GetParticipant("k1"))
+	room.updateSettings(RoomSettingsUpdate{Locked: boolPtr(true)})
+	assert.Equal(t, ErrCodeRoomLocked, errorCode(knock(server, "k3", "")))
+
+	room.updateSettings(RoomSettingsUpdate{Locked: boolPtr(false), AllowedRoles: &[]ParticipantRole{RoleViewer}})
+	assert.Equal(t, ErrCodeRoleNotAllowed, errorCode(knock(server, "k4", "")))
+
+	// The host is never kept out
+	server.leaveRoom("test-room", room.Host)
+	room.updateSettings(RoomSettingsUpdate{Locked: boolPtr(true), MaxParticipants: intPtr(1)})
+	host := newRecordingConn()
+	server.joinRoom("test-room", &Participant{ID: "host2", Conn: host, Role: RoleHost})
+	assert.Empty(t, errorCode(host))
+	assert.Equal(t, "host2", room.GetParticipantsData().Host.ID)
+}
+
+func TestOpenAdmission(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	open := AdmissionOpen
+	room.updateSettings(RoomSettingsUpdate{Admission: &open})
+
+	conn := knock(server, "open1", "")
+
+	assert.Equal(t, StatusInRoom, room.GetParticipant("open1").Status)
+	assert.NotNil(t, lastOfType(conn, MessageTypeAllow))
+	assert.Nil(t, lastOfType(conn, MessageTypeLobbyPosition))
+	assert.Nil(t, lastOfType(conns["host1"], MessageTypeKnock))
+	assert.Contains(t, lastOfType(conns["guest1"], MessageTypeParticipants).Data.(*ParticipantsData).Guests, "open1")
+}
+
+func TestGuestBroadcast(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.updateSettings(RoomSettingsUpdate{GuestBroadcast: boolPtr(false)})
+	guest := room.GetParticipant("guest1")
+
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeEncrypted, Data: map[string]interface{}{"to": "all", "data": "c2VjcmV0"}})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest1"]))
+	assert.Nil(t, lastOfType(conns["guest2"], MessageTypeEncrypted))
+
+	server.handleMessage("test-room", guest, &Message{Type: MessageTypeEncrypted, Data: map[string]interface{}{"to": "guest2", "data": "c2VjcmV0"}})
+	assert.NotNil(t, lastOfType(conns["guest2"], MessageTypeEncrypted))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeEncrypted, Data: map[string]interface{}{"to": "all", "data": "c2VjcmV0"}})
+	assert.Equal(t, "host1", lastOfType(conns["guest1"], MessageTypeEncrypted).From)
+}
+
+func TestRoomSettingsMessage(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.GetParticipant("guest1").Role = RoleModerator
+	room.GetParticipant("guest2").Role = RoleCoHost
+	waiting := knock(server, "k1", "")
+
+	send := func(sender string, data map[string]interface{}) {
+		server.handleMessage("test-room", room.GetParticipant(sender), &Message{Type: MessageTypeRoomSettings, Data: data})
+	}
+
+	send("guest1", map[string]interface{}{"locked": true})
+	assert.Equal(t, ErrCodeForbidden, errorCode(conns["guest1"]))
+	assert.False(t, room.Settings().Locked)
+
+	send("guest2", map[string]interface{}{"locked": true})
+	assert.True(t, room.Settings().Locked)
+	for _, conn := range []*recordingConn{conns["host1"], conns["guest1"], waiting} {
+		announced := lastOfType(conn, MessageTypeRoomSettings)
+		require.NotNil(t, announced)
+		assert.Equal(t, "guest2", announced.From)
+		assert.True(t, announced.Data.(RoomSettings).Locked)
+	}
+
+	send("guest2", map[string]interface{}{"max_participants": 10, "guest_broadcast": false})
+	settings := room.Settings()
+	assert.Equal(t, 10, settings.MaxParticipants)
+	assert.False(t, settings.GuestBroadcast)
+	assert.True(t, settings.Locked, "fields left out keep their value")
+
+	send("host1", map[string]interface{}{"admission": "whenever"})
+	assert.Equal(t, ErrCodeInvalidMessage, errorCode(conns["host1"]))
+
+	assert.Equal(t, &settings, room.GetParticipantsData().Settings)
+}
+
+func TestGrantRoleRespectsAllowedRoles(t *testing.T) {
+	server := NewServer()
+	room, conns := handoffRoom(t, server)
+	room.updateSettings(RoomSettingsUpdate{AllowedRoles: &[]ParticipantRole{RoleGuest, RoleViewer}})
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "moderator"}})
+	assert.Equal(t, ErrCodeRoleChangeFailed, errorCode(conns["host1"]))
+
+	server.handleMessage("test-room", room.Host, &Message{Type: MessageTypeGrantRole, Data: map[string]interface{}{"participant_id": "guest1", "role": "viewer"}})
+	assert.Equal(t, RoleViewer, room.GetParticipant("guest1").Role)
+}
+
+func boolPtr(v bool) *bool { return &v }
+
+func intPtr(v int) *int { return &v }
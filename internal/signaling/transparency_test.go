@@ -0,0 +1,154 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Kaamos-Comms/server/internal/transparency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyExchangeBroadcastIncludesInclusionProofs(t *testing.T) {
+	keyLog, err := transparency.NewLog(nil)
+	require.NoError(t, err)
+
+	server := NewServer(WithKeyLog(keyLog))
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	room.keyLog = keyLog
+	identity := newTestIdentity(t)
+	guest.DID = identity.did()
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+
+	var broadcast *Message
+	hostConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Run(func(args mock.Arguments) {
+		broadcast = args.Get(0).(*Message)
+	}).Return(nil).Once()
+	guestConn.On("WriteJSON", mock.AnythingOfType("*signaling.Message")).Return(nil).Once()
+
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeKeyExchange,
+		Data: identity.keyExchange("test-room", guest.ID, publicKey, time.Now()),
+	})
+
+	require.NotNil(t, broadcast)
+	audit := broadcast.Data.(PublicKeysData).Transparency
+	require.NotNil(t, audit)
+	assert.NoError(t, transparency.VerifyTreeHead(keyLog.PublicKey(), audit.TreeHead))
+
+	inclusion := audit.Inclusions[guest.ID]
+	require.NotNil(t, inclusion)
+	assert.NoError(t, inclusion.Verify(audit.TreeHead.RootHash))
+
+	// The logged entry is the key the peers were given, under the DID
+	leaf, err := base64.StdEncoding.DecodeString(inclusion.Leaf)
+	require.NoError(t, err)
+	var entry transparency.Entry
+	require.NoError(t, json.Unmarshal(leaf, &entry))
+	assert.Equal(t, guest.DID, entry.Identity)
+	assert.Equal(t, guest.ID, entry.ParticipantID)
+	assert.Equal(t, []transparency.Key{{Algorithm: "ed25519", PublicKey: publicKey}}, entry.Keys)
+
+	assert.Equal(t, []uint64{0}, keyLog.IdentityIndexes(guest.DID))
+}
+
+func TestKeyTransparencyWithoutLog(t *testing.T) {
+	server := NewServer()
+	room := NewRoom("test-room")
+	server.rooms["test-room"] = room
+
+	require.NoError(t, room.SavePublicKey("user1", base64.StdEncoding.EncodeToString(make([]byte, 32))))
+	assert.Nil(t, server.KeyTransparency("test-room"))
+	assert.Nil(t, server.KeyTransparency("missing-room"))
+}
+
+func TestKeyLogKeepsReplacedKeys(t *testing.T) {
+	keyLog, err := transparency.NewLog(nil)
+	require.NoError(t, err)
+
+	room := NewRoom("test-room")
+	room.keyLog = keyLog
+
+	first, _, _ := GenerateEd25519KeyPair()
+	second, _, _ := GenerateEd25519KeyPair()
+	require.NoError(t, room.SavePublicKey("user1", first))
+	require.NoError(t, room.SavePublicKey("user1", second))
+
+	// Both keys stay in the log; only the current one is proven
+	assert.Equal(t, uint64(2), keyLog.Size())
+	audit := room.KeyTransparency()
+	assert.Equal(t, uint64(1), audit.Inclusions["user1"].LeafIndex)
+
+	room.RemovePublicKey("user1")
+	assert.Empty(t, room.KeyTransparency().Inclusions)
+	assert.Equal(t, uint64(2), keyLog.Size())
+}
+
+func TestKeyLogRateLimitsPublishers(t *testing.T) {
+	keyLog, err := transparency.NewLog(nil)
+	require.NoError(t, err)
+
+	room := NewRoom("test-room")
+	room.keyLog = keyLog
+	room.keyLogLimit = newKeyLogLimiter(time.Hour, 2)
+
+	var keys []string
+	for i := 0; i < 3; i++ {
+		key, _, _ := GenerateEd25519KeyPair()
+		keys = append(keys, key)
+		require.NoError(t, room.SavePublicKey("user1", key))
+	}
+
+	// The last key is shared but neither logged nor proven
+	assert.Equal(t, uint64(2), keyLog.Size())
+	current, _ := room.GetPublicKey("user1")
+	assert.Equal(t, keys[2], current)
+	assert.NotContains(t, room.KeyTransparency().Inclusions, "user1")
+
+	// Going back to logged keys needs no new entry
+	require.NoError(t, room.SavePublicKey("user2", keys[0]))
+	require.NoError(t, room.SavePublicKey("user1", keys[1]))
+	assert.Equal(t, uint64(1), room.KeyTransparency().Inclusions["user1"].LeafIndex)
+}
+
+// blockingStorage holds appends until released.
+type blockingStorage struct {
+	appending chan struct{}
+	release   chan struct{}
+}
+
+func (s *blockingStorage) Load() ([][]byte, error) { return nil, nil }
+
+func (s *blockingStorage) Append([]byte) error {
+	s.appending <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestKeyLogAppendsOutsideRoomLock(t *testing.T) {
+	storage := &blockingStorage{appending: make(chan struct{}), release: make(chan struct{})}
+	keyLog, err := transparency.NewLog(nil, transparency.WithStorage(storage))
+	require.NoError(t, err)
+
+	room := NewRoom("test-room")
+	room.keyLog = keyLog
+	key, _, _ := GenerateEd25519KeyPair()
+
+	saved := make(chan error)
+	go func() { saved <- room.SavePublicKey("user1", key) }()
+	<-storage.appending
+
+	// The room keeps working while the entry is stored
+	current, _ := room.GetPublicKey("user1")
+	assert.Equal(t, key, current)
+	assert.Empty(t, room.KeyTransparency().Inclusions)
+
+	close(storage.release)
+	require.NoError(t, <-saved)
+	assert.Contains(t, room.KeyTransparency().Inclusions, "user1")
+}
@@ -2,8 +2,10 @@ package signaling
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Kaamos-Comms/server/internal/transparency"
 	"github.com/gorilla/websocket"
 )
 
@@ -11,19 +13,51 @@ const (
 	RoleHost  ParticipantRole = "host"
 	RoleGuest ParticipantRole = "guest"
 
-	MessageTypeJoin         MessageType = "join"
-	MessageTypeLeave        MessageType = "leave"
-	MessageTypeKnock        MessageType = "knock"
-	MessageTypeAllow        MessageType = "allow"
-	MessageTypeDeny         MessageType = "deny"
-	MessageTypeOffer        MessageType = "offer"
-	MessageTypeAnswer       MessageType = "answer"
-	MessageTypeICECandidate MessageType = "ice_candidate"
-	MessageTypeParticipants MessageType = "participants"
-	MessageTypeError        MessageType = "error"
-	MessageTypeKeyExchange  MessageType = "key_exchange"
-	MessageTypePublicKeys   MessageType = "public_keys"
-	MessageTypeEncrypted    MessageType = "encrypted_data"
+	MessageTypeJoin           MessageType = "join"
+	MessageTypeLeave          MessageType = "leave"
+	MessageTypeKnock          MessageType = "knock"
+	MessageTypeAllow          MessageType = "allow"
+	MessageTypeDeny           MessageType = "deny"
+	MessageTypeOffer          MessageType = "offer"
+	MessageTypeAnswer         MessageType = "answer"
+	MessageTypeICECandidate   MessageType = "ice_candidate"
+	MessageTypeParticipants   MessageType = "participants"
+	MessageTypeError          MessageType = "error"
+	MessageTypeKeyExchange    MessageType = "key_exchange"
+	MessageTypePublicKeys     MessageType = "public_keys"
+	MessageTypeEncrypted      MessageType = "encrypted_data"
+	MessageTypePreKeysLow     MessageType = "prekeys_low"
+	MessageTypeSession        MessageType = "session" // participant ID and resume token
+	MessageTypePing           MessageType = "ping"    // application-level keepalive
+	MessageTypePong           MessageType = "pong"
+	MessageTypeHello          MessageType = "hello" // capability exchange, protocol v2
+	MessageTypeTransferHost   MessageType = "transfer_host"
+	MessageTypeSetSuccessor   MessageType = "set_successor" // guest promoted by HostPromotionSuccessor
+	MessageTypeHostChanged    MessageType = "host_changed"
+	MessageTypeGrantRole      MessageType = "grant_role"
+	MessageTypeRevokeRole     MessageType = "revoke_role" // back to guest
+	MessageTypeRoleChanged    MessageType = "role_changed"
+	MessageTypeMuteRequest    MessageType = "mute_request"
+	MessageTypeKick           MessageType = "kick"
+	MessageTypeBan            MessageType = "ban"
+	MessageTypeUnban          MessageType = "unban"
+	MessageTypeLobby          MessageType = "lobby"          // queue of knocking guests
+	MessageTypeLobbyPosition  MessageType = "lobby_position" // a knocking guest's place in the queue
+	MessageTypeLobbyMessage   MessageType = "lobby_message"
+	MessageTypeRoomSettings   MessageType = "room_settings"
+	MessageTypeAck            MessageType = "ack"
+	MessageTypeDeliveryFailed MessageType = "delivery_failed"
+
+	MessageTypeSASStart   MessageType = "sas_start"
+	MessageTypeSASConfirm MessageType = "sas_confirm"
+	MessageTypeSASFail    MessageType = "sas_fail"
+
+	MessageTypeMLSKeyPackages       MessageType = "mls_key_packages"        // upload KeyPackages
+	MessageTypeMLSKeyPackageRequest MessageType = "mls_key_package_request" // claim a member's KeyPackage
+	MessageTypeMLSKeyPackage        MessageType = "mls_key_package"         // claimed KeyPackage
+	MessageTypeMLSProposal          MessageType = "mls_proposal"
+	MessageTypeMLSCommit            MessageType = "mls_commit"
+	MessageTypeMLSWelcome           MessageType = "mls_welcome"
 
 	StatusConnected    ParticipantStatus = "connected"
 	StatusKnocking     ParticipantStatus = "knocking"
@@ -31,41 +65,145 @@ const (
 	StatusDisconnected ParticipantStatus = "disconnected"
 )
 
+// KeyAlgorithm identifies the type of a TypedKey.
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmEd25519 KeyAlgorithm = "ed25519" // signing
+	KeyAlgorithmX25519  KeyAlgorithm = "x25519"  // key agreement
+	KeyAlgorithmP256    KeyAlgorithm = "p256"    // ECDH key agreement, SEC1 encoded
+)
+
+// TypedKey is a base64-encoded public key tagged with its algorithm.
+type TypedKey struct {
+	Algorithm KeyAlgorithm `json:"algorithm"`
+	PublicKey string       `json:"public_key"`
+}
+
 type ParticipantKeys struct {
-	PublicKey string `json:"public_key"` // Base64-encoded public key
+	PublicKey   string     `json:"public_key"`            // Base64-encoded primary key, kept for older clients
+	Bundle      []TypedKey `json:"bundle,omitempty"`      // every announced key
+	Fingerprint string     `json:"fingerprint,omitempty"` // KeyFingerprint of PublicKey
+	Proof       *KeyProof  `json:"proof,omitempty"`       // signature binding the keys to the participant's identity
 }
 
 type Participant struct {
-	ID       string                 `json:"id"`
-	Conn     WebSocketConnInterface `json:"-"`
-	Role     ParticipantRole        `json:"role"`
-	Status   ParticipantStatus      `json:"status"`
-	Name     string                 `json:"name,omitempty"`
-	Keys     ParticipantKeys        `json:"keys,omitempty"` // ← НОВОЕ ПОЛЕ
-	JoinedAt time.Time              `json:"joined_at"`
+	ID     string                 `json:"id"`
+	Conn   WebSocketConnInterface `json:"-"`
+	Role   ParticipantRole        `json:"role"`
+	Status ParticipantStatus      `json:"status"`
+	Name   string                 `json:"name,omitempty"`
+	// KnockNote is shown to the host when the guest knocks.
+	KnockNote string          `json:"knock_note,omitempty"`
+	Keys      ParticipantKeys `json:"keys,omitempty"` // ← НОВОЕ ПОЛЕ
+	JoinedAt  time.Time       `json:"joined_at"`
+	DID       string          `json:"did,omitempty"` // stable identity proven via did:key login
+	TokenID   string          `json:"-"`             // jti of the token used to connect
+	// tokenRole is the role the token was issued for; Role changes when
+	// the host hands over.
+	tokenRole ParticipantRole
+	// tokenSubject and remoteIP identify the participant for bans.
+	tokenSubject string
+	remoteIP     string
+
+	// IdentityKey is the base64 Ed25519 key pinned by the first signed
+	// key_exchange of a participant without a DID.
+	IdentityKey string `json:"identity_key,omitempty"`
+
+	// Verifications records SAS ceremonies with other participants by peer ID.
+	Verifications map[string]*Verification `json:"verifications,omitempty"`
+
+	// Capabilities are announced by the participant's hello, if any.
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// connMutex guards Conn, protocol, codec, send and session, which
+	// change when a suspended participant resumes, and serializes direct
+	// writes.
+	connMutex sync.Mutex
+	protocol  ProtocolVersion
+	codec     Codec      // nil means JSON
+	send      *sendQueue // nil until the connection's writer is started
+	session   session
 }
 
 type Room struct {
-	Slug       string                  `json:"slug"`
-	Host       *Participant            `json:"host,omitempty"`
-	Guests     map[string]*Participant `json:"guests"`
-	PublicKeys map[string]string       `json:"public_keys"` // ← НОВОЕ ПОЛЕ
-	CreatedAt  time.Time               `json:"created_at"`
-	mutex      sync.RWMutex
+	Slug         string                  `json:"slug"`
+	Host         *Participant            `json:"host,omitempty"`
+	Guests       map[string]*Participant `json:"guests"`
+	PublicKeys   map[string]string       `json:"public_keys"` // ← НОВОЕ ПОЛЕ
+	KeyBundles   map[string][]TypedKey   `json:"key_bundles,omitempty"`
+	KeyProofs    map[string]KeyProof     `json:"key_proofs,omitempty"`
+	CreatedAt    time.Time               `json:"created_at"`
+	MLS          *MLSGroup               `json:"-"`
+	KeyLogIndex  map[string]uint64       `json:"-"` // participantID -> transparency log index of its keys
+	Fingerprints map[string]string       `json:"-"` // participantID -> KeyFingerprint
+	keyLog       *transparency.Log
+	keyLogLimit  *keyLogLimiter
+	ceremonies   map[string]*sasCeremony // verificationID -> pending SAS ceremony
+	successor    string                  // guest designated by the host for promotion
+	promotion    *time.Timer             // pending automatic host promotion
+	bans         []*Ban
+	bansMutex    sync.Mutex    // serializes saving bans to the registry
+	lobby        []*lobbyEntry // knocking guests in the order they knocked
+	settings     RoomSettings
+	seq          atomic.Uint64
+	seenMutex    sync.Mutex
+	seenIDs      map[string]uint64 // sender and client message ID -> seq
+	seenOrder    []string
+	mutex        sync.RWMutex
 }
 
+// KeyExchangeData announces either a single Ed25519 PublicKey or a typed
+// key bundle in Keys.
 type KeyExchangeData struct {
-	PublicKey string `json:"public_key"`
+	PublicKey   string     `json:"public_key,omitempty"`
+	Keys        []TypedKey `json:"keys,omitempty"`
+	IdentityKey string     `json:"identity_key,omitempty"` // required unless the participant has a DID
+	Timestamp   int64      `json:"timestamp"`              // unix milliseconds
+	Signature   string     `json:"signature"`              // base64 Ed25519 signature over KeyExchangePayload
+}
+
+// KeyProof is a verified key announcement. It is forwarded to peers so they
+// can check the binding themselves instead of trusting the server.
+type KeyProof struct {
+	PublicKey   string     `json:"public_key,omitempty"`
+	Keys        []TypedKey `json:"keys,omitempty"`
+	IdentityKey string     `json:"identity_key"`
+	DID         string     `json:"did,omitempty"`
+	Timestamp   int64      `json:"timestamp"`
+	Signature   string     `json:"signature"`
 }
 
 type PublicKeysData struct {
-	Keys map[string]string `json:"keys"` // participantID -> publicKey
+	Keys         map[string]string     `json:"keys"`                   // participantID -> primary publicKey
+	Bundles      map[string][]TypedKey `json:"bundles,omitempty"`      // participantID -> all keys
+	Proofs       map[string]KeyProof   `json:"proofs,omitempty"`       // participantID -> proof
+	Fingerprints map[string]string     `json:"fingerprints,omitempty"` // participantID -> KeyFingerprint
+	Transparency *KeyTransparency      `json:"transparency,omitempty"` // present when a key log is configured
+}
+
+// KeyTransparency lets clients audit the keys they were served: every key
+// has an inclusion proof against the same signed tree head.
+type KeyTransparency struct {
+	TreeHead   transparency.SignedTreeHead             `json:"tree_head"`
+	Inclusions map[string]*transparency.InclusionProof `json:"inclusions"` // participantID -> proof
+}
+
+// PreKeysLowData asks the owner of an identity to upload one-time prekeys.
+type PreKeysLowData struct {
+	Remaining int `json:"remaining"`
+	Threshold int `json:"threshold"`
 }
 
+// EncryptionAlgorithmMLS marks encrypted_data carrying an MLS application
+// message; Epoch is then required.
+const EncryptionAlgorithmMLS = "mls"
+
 type EncryptedData struct {
-	To        string `json:"to"`        // ID получателя
-	Data      string `json:"data"`      // Base64-кодированные зашифрованные данные
-	Algorithm string `json:"algorithm"` // "ed25519" или другой алгоритм
+	To        string `json:"to"`              // ID получателя
+	Data      string `json:"data"`            // Base64-кодированные зашифрованные данные
+	Algorithm string `json:"algorithm"`       // "ed25519" или другой алгоритм
+	Epoch     uint64 `json:"epoch,omitempty"` // MLS epoch, for Algorithm "mls"
 }
 
 type MessageType string
@@ -92,11 +230,16 @@ func NewWebSocketConnWrapper(conn *websocket.Conn) *WebSocketConnWrapper {
 
 type Message struct {
 	Type      MessageType `json:"type"`
+	ID        string      `json:"id,omitempty"`  // client-chosen, makes resends idempotent
+	Seq       uint64      `json:"seq,omitempty"` // per-room sequence number assigned by the server
+	Ack       bool        `json:"ack,omitempty"` // the sender wants an ack or delivery_failed
 	From      string      `json:"from,omitempty"`
 	To        string      `json:"to,omitempty"`
 	Slug      string      `json:"slug,omitempty"`
 	Data      interface{} `json:"data,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
+
+	receipt *receipt
 }
 
 type JoinData struct {
@@ -108,9 +251,12 @@ type ParticipantsData struct {
 	Host   *Participant            `json:"host,omitempty"`
 	Guests map[string]*Participant `json:"guests"`
 	Count  int                     `json:"count"`
+	// Settings lets joining participants know the room's settings.
+	Settings *RoomSettings `json:"settings,omitempty"`
 }
 
 type ErrorData struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	ID      string `json:"id,omitempty"` // ID of the rejected message
 }
@@ -0,0 +1,334 @@
+package signaling
+
+import (
+	"errors"
+	"time"
+)
+
+// SAS (short authentication string) verification: two participants compare
+// the safety number of their keys out of band and both confirm that it
+// matches. The server coordinates the ceremony and records its outcome on
+// the participants; it cannot fake a match, since each side computes the
+// number from the keys it actually received.
+
+const (
+	sasTimeout        = 10 * time.Minute
+	ErrCodeSASInvalid = "SAS_INVALID"
+	ErrCodeSASNoKeys  = "SAS_NO_KEYS"
+)
+
+var (
+	errSASUnknown    = errors.New("unknown or expired verification")
+	errSASNoKeys     = errors.New("both participants must have exchanged keys")
+	errSASNoPeer     = errors.New("peer is not in the room")
+	errSASKeyChanged = errors.New("keys changed during verification")
+)
+
+type VerificationStatus string
+
+const (
+	VerificationPending  VerificationStatus = "pending"
+	VerificationVerified VerificationStatus = "verified"
+	VerificationFailed   VerificationStatus = "failed"
+)
+
+// Verification is the outcome of a SAS ceremony with a peer. It is dropped
+// once the peer's key changes.
+type Verification struct {
+	PeerID          string             `json:"peer_id"`
+	Status          VerificationStatus `json:"status"`
+	PeerFingerprint string             `json:"peer_fingerprint"` // fingerprint of the peer key that was compared
+	Reason          string             `json:"reason,omitempty"`
+	At              time.Time          `json:"at"`
+}
+
+// SASData is the payload of sas_start, sas_confirm and sas_fail. Clients
+// send To with sas_start and VerificationID with the others.
+type SASData struct {
+	VerificationID string             `json:"verification_id,omitempty"`
+	From           string             `json:"from,omitempty"`
+	To             string             `json:"to,omitempty"`
+	SafetyNumber   string             `json:"safety_number,omitempty"`
+	Status         VerificationStatus `json:"status,omitempty"`
+	Reason         string             `json:"reason,omitempty"`
+}
+
+type sasCeremony struct {
+	id           string
+	initiator    string
+	responder    string
+	fingerprints map[string]string // fingerprints compared, by participant
+	confirmed    map[string]bool
+	expiresAt    time.Time
+}
+
+func (c *sasCeremony) includes(participantID string) bool {
+	return participantID == c.initiator || participantID == c.responder
+}
+
+func (c *sasCeremony) matches(participant *Participant) bool {
+	return c.includes(participant.ID)
+}
+
+func (c *sasCeremony) peerOf(participantID string) string {
+	if participantID == c.initiator {
+		return c.responder
+	}
+	return c.initiator
+}
+
+func (c *sasCeremony) safetyNumber() string {
+	return SafetyNumber(c.fingerprints[c.initiator], c.fingerprints[c.responder])
+}
+
+// startSAS opens a ceremony between two participants in the room.
+func (r *Room) startSAS(initiatorID, responderID string) (*sasCeremony, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for id, ceremony := range r.ceremonies {
+		if now.After(ceremony.expiresAt) {
+			delete(r.ceremonies, id)
+		}
+	}
+
+	responder := r.findParticipant(responderID)
+	if responder == nil || responder.Status != StatusInRoom {
+		return nil, errSASNoPeer
+	}
+	initiatorFingerprint, hasInitiator := r.Fingerprints[initiatorID]
+	responderFingerprint, hasResponder := r.Fingerprints[responderID]
+	if !hasInitiator || !hasResponder || initiatorFingerprint == "" || responderFingerprint == "" {
+		return nil, errSASNoKeys
+	}
+
+	ceremony := &sasCeremony{
+		id:        generateParticipantID(),
+		initiator: initiatorID,
+		responder: responderID,
+		fingerprints: map[string]string{
+			initiatorID: initiatorFingerprint,
+			responderID: responderFingerprint,
+		},
+		confirmed: make(map[string]bool),
+		expiresAt: now.Add(sasTimeout),
+	}
+	if r.ceremonies == nil {
+		r.ceremonies = make(map[string]*sasCeremony)
+	}
+	r.ceremonies[ceremony.id] = ceremony
+	return ceremony, nil
+}
+
+// confirmSAS records a participant's confirmation and returns the resulting
+// status. The ceremony fails if either key changed since it started.
+func (r *Room) confirmSAS(verificationID, participantID string) (*sasCeremony, VerificationStatus, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ceremony, err := r.activeCeremony(verificationID, participantID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for id, fingerprint := range ceremony.fingerprints {
+		if r.Fingerprints[id] != fingerprint {
+			r.finishSAS(ceremony, VerificationFailed, errSASKeyChanged.Error())
+			return ceremony, VerificationFailed, nil
+		}
+	}
+
+	ceremony.confirmed[participantID] = true
+	if !ceremony.confirmed[ceremony.peerOf(participantID)] {
+		return ceremony, VerificationPending, nil
+	}
+
+	r.finishSAS(ceremony, VerificationVerified, "")
+	return ceremony, VerificationVerified, nil
+}
+
+// failSAS aborts a ceremony on behalf of either participant.
+func (r *Room) failSAS(verificationID, participantID, reason string) (*sasCeremony, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ceremony, err := r.activeCeremony(verificationID, participantID)
+	if err != nil {
+		return nil, err
+	}
+	r.finishSAS(ceremony, VerificationFailed, reason)
+	return ceremony, nil
+}
+
+// activeCeremony returns an unexpired ceremony the participant takes part
+// in. The caller must hold the mutex.
+func (r *Room) activeCeremony(verificationID, participantID string) (*sasCeremony, error) {
+	ceremony, exists := r.ceremonies[verificationID]
+	if !exists || !ceremony.includes(participantID) {
+		return nil, errSASUnknown
+	}
+	if time.Now().After(ceremony.expiresAt) {
+		delete(r.ceremonies, verificationID)
+		return nil, errSASUnknown
+	}
+	return ceremony, nil
+}
+
+// finishSAS records the outcome on both participants and closes the
+// ceremony. The caller must hold the mutex.
+func (r *Room) finishSAS(ceremony *sasCeremony, status VerificationStatus, reason string) {
+	delete(r.ceremonies, ceremony.id)
+
+	now := time.Now()
+	for _, id := range []string{ceremony.initiator, ceremony.responder} {
+		participant := r.findParticipant(id)
+		if participant == nil {
+			continue
+		}
+		peerID := ceremony.peerOf(id)
+		if participant.Verifications == nil {
+			participant.Verifications = make(map[string]*Verification)
+		}
+		participant.Verifications[peerID] = &Verification{
+			PeerID:          peerID,
+			Status:          status,
+			PeerFingerprint: ceremony.fingerprints[peerID],
+			Reason:          reason,
+			At:              now,
+		}
+	}
+}
+
+// forgetVerifications drops verifications of a participant whose key no
+// longer has the given fingerprint. The caller must hold the mutex.
+func (r *Room) forgetVerifications(participantID, fingerprint string) {
+	forget := func(participant *Participant) {
+		if verification, exists := participant.Verifications[participantID]; exists && verification.PeerFingerprint != fingerprint {
+			delete(participant.Verifications, participantID)
+		}
+	}
+	if r.Host != nil {
+		forget(r.Host)
+	}
+	for _, guest := range r.Guests {
+		forget(guest)
+	}
+}
+
+// findParticipant looks a participant up by ID. The caller must hold the
+// mutex.
+func (r *Room) findParticipant(participantID string) *Participant {
+	if r.Host != nil && r.Host.ID == participantID {
+		return r.Host
+	}
+	return r.Guests[participantID]
+}
+
+func (s *Server) handleSASStart(room *Room, participant *Participant, message *Message) {
+	if participant.Status != StatusInRoom {
+		rejectMessage(participant, message, ErrCodeNotInRoom, "waiting for the host to let you in")
+		return
+	}
+
+	data := message.Data.(SASData)
+	if data.To == "" || data.To == participant.ID {
+		rejectMessage(participant, message, ErrCodeSASInvalid, "sas_start needs a peer to verify")
+		return
+	}
+
+	ceremony, err := room.startSAS(participant.ID, data.To)
+	if err != nil {
+		code := ErrCodeSASInvalid
+		if errors.Is(err, errSASNoKeys) {
+			code = ErrCodeSASNoKeys
+		}
+		rejectMessage(participant, message, code, err.Error())
+		return
+	}
+
+	// The initiator gets the same message, which carries the verification ID
+	room.sendMatching(ceremony.matches, &Message{
+		Type: MessageTypeSASStart,
+		From: participant.ID,
+		Data: SASData{
+			VerificationID: ceremony.id,
+			From:           ceremony.initiator,
+			To:             ceremony.responder,
+			SafetyNumber:   ceremony.safetyNumber(),
+			Status:         VerificationPending,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+func (s *Server) handleSASConfirm(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(SASData)
+	if data.VerificationID == "" {
+		rejectMessage(participant, message, ErrCodeSASInvalid, "sas_confirm needs a verification_id")
+		return
+	}
+
+	ceremony, status, err := room.confirmSAS(data.VerificationID, participant.ID)
+	if err != nil {
+		rejectMessage(participant, message, ErrCodeSASInvalid, err.Error())
+		return
+	}
+
+	result := SASData{
+		VerificationID: ceremony.id,
+		From:           participant.ID,
+		Status:         status,
+	}
+	switch status {
+	case VerificationPending:
+		peerID := ceremony.peerOf(participant.ID)
+		room.sendMatching(func(p *Participant) bool { return p.ID == peerID }, &Message{
+			Type:      MessageTypeSASConfirm,
+			From:      participant.ID,
+			Data:      result,
+			Timestamp: time.Now(),
+		})
+	case VerificationVerified:
+		room.sendMatching(ceremony.matches, &Message{
+			Type:      MessageTypeSASConfirm,
+			From:      participant.ID,
+			Data:      result,
+			Timestamp: time.Now(),
+		})
+	case VerificationFailed:
+		result.Reason = errSASKeyChanged.Error()
+		room.sendMatching(ceremony.matches, &Message{
+			Type:      MessageTypeSASFail,
+			From:      participant.ID,
+			Data:      result,
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+func (s *Server) handleSASFail(room *Room, participant *Participant, message *Message) {
+	data := message.Data.(SASData)
+	if data.VerificationID == "" {
+		rejectMessage(participant, message, ErrCodeSASInvalid, "sas_fail needs a verification_id")
+		return
+	}
+
+	ceremony, err := room.failSAS(data.VerificationID, participant.ID, data.Reason)
+	if err != nil {
+		rejectMessage(participant, message, ErrCodeSASInvalid, err.Error())
+		return
+	}
+
+	room.sendMatching(ceremony.matches, &Message{
+		Type: MessageTypeSASFail,
+		From: participant.ID,
+		Data: SASData{
+			VerificationID: ceremony.id,
+			From:           participant.ID,
+			Status:         VerificationFailed,
+			Reason:         data.Reason,
+		},
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,191 @@
+package signaling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sasRoom returns a room whose host and guest have both published keys.
+func sasRoom(t *testing.T, server *Server) (*Room, *Participant, *MockWebSocketConn, *MockWebSocketConn) {
+	room, guest, guestConn, hostConn := keyExchangeRoom(t, server)
+	for _, id := range []string{"host1", guest.ID} {
+		publicKey, _, err := GenerateEd25519KeyPair()
+		require.NoError(t, err)
+		require.NoError(t, room.SavePublicKey(id, publicKey))
+	}
+	return room, guest, guestConn, hostConn
+}
+
+// captureSAS records the next SAS message written to conn.
+func captureSAS(conn *MockWebSocketConn, messageType MessageType, data *SASData) {
+	conn.On("WriteJSON", isMessageType(messageType)).Run(func(args mock.Arguments) {
+		*data = args.Get(0).(*Message).Data.(SASData)
+	}).Return(nil).Once()
+}
+
+func startSAS(t *testing.T, server *Server, guest *Participant, guestConn, hostConn *MockWebSocketConn) SASData {
+	var toHost, toGuest SASData
+	captureSAS(hostConn, MessageTypeSASStart, &toHost)
+	captureSAS(guestConn, MessageTypeSASStart, &toGuest)
+
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeSASStart,
+		Data: map[string]interface{}{"to": "host1"},
+	})
+
+	require.NotEmpty(t, toGuest.VerificationID)
+	assert.Equal(t, toGuest, toHost)
+	return toGuest
+}
+
+func TestSASStartSendsSafetyNumber(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := sasRoom(t, server)
+
+	started := startSAS(t, server, guest, guestConn, hostConn)
+
+	hostFingerprint, _ := room.GetFingerprint("host1")
+	guestFingerprint, _ := room.GetFingerprint(guest.ID)
+	assert.Equal(t, guest.ID, started.From)
+	assert.Equal(t, "host1", started.To)
+	assert.Equal(t, SafetyNumber(hostFingerprint, guestFingerprint), started.SafetyNumber)
+	assert.Len(t, started.SafetyNumber, 60)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestSASStartRequiresKeys(t *testing.T) {
+	server := NewServer()
+	_, guest, guestConn, _ := keyExchangeRoom(t, server)
+
+	expectErrorCode(guestConn, ErrCodeSASNoKeys)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeSASStart,
+		Data: map[string]interface{}{"to": "host1"},
+	})
+
+	expectErrorCode(guestConn, ErrCodeSASInvalid)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeSASStart,
+		Data: map[string]interface{}{"to": guest.ID},
+	})
+
+	guestConn.AssertExpectations(t)
+}
+
+func TestSASBothConfirmationsVerify(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := sasRoom(t, server)
+	host := room.GetParticipant("host1")
+	started := startSAS(t, server, guest, guestConn, hostConn)
+
+	// The first confirmation is relayed to the peer only
+	var relayed SASData
+	captureSAS(hostConn, MessageTypeSASConfirm, &relayed)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeSASConfirm,
+		Data: map[string]interface{}{"verification_id": started.VerificationID},
+	})
+	assert.Equal(t, VerificationPending, relayed.Status)
+	assert.Empty(t, guest.Verifications)
+
+	var toHost, toGuest SASData
+	captureSAS(hostConn, MessageTypeSASConfirm, &toHost)
+	captureSAS(guestConn, MessageTypeSASConfirm, &toGuest)
+	server.handleMessage("test-room", host, &Message{
+		Type: MessageTypeSASConfirm,
+		Data: map[string]interface{}{"verification_id": started.VerificationID},
+	})
+	assert.Equal(t, VerificationVerified, toHost.Status)
+	assert.Equal(t, VerificationVerified, toGuest.Status)
+
+	hostFingerprint, _ := room.GetFingerprint("host1")
+	require.Contains(t, guest.Verifications, "host1")
+	assert.Equal(t, VerificationVerified, guest.Verifications["host1"].Status)
+	assert.Equal(t, hostFingerprint, guest.Verifications["host1"].PeerFingerprint)
+	require.Contains(t, host.Verifications, guest.ID)
+	assert.Equal(t, VerificationVerified, host.Verifications[guest.ID].Status)
+
+	// The ceremony is closed
+	expectErrorCode(hostConn, ErrCodeSASInvalid)
+	server.handleMessage("test-room", host, &Message{
+		Type: MessageTypeSASConfirm,
+		Data: map[string]interface{}{"verification_id": started.VerificationID},
+	})
+
+	// A new key of the host invalidates the guest's verification
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+	require.NoError(t, room.SavePublicKey("host1", publicKey))
+	assert.NotContains(t, guest.Verifications, "host1")
+
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestSASFailIsRecorded(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := sasRoom(t, server)
+	host := room.GetParticipant("host1")
+	started := startSAS(t, server, guest, guestConn, hostConn)
+
+	var toHost, toGuest SASData
+	captureSAS(hostConn, MessageTypeSASFail, &toHost)
+	captureSAS(guestConn, MessageTypeSASFail, &toGuest)
+	server.handleMessage("test-room", host, &Message{
+		Type: MessageTypeSASFail,
+		Data: map[string]interface{}{"verification_id": started.VerificationID, "reason": "mismatch"},
+	})
+
+	assert.Equal(t, "mismatch", toGuest.Reason)
+	assert.Equal(t, toGuest, toHost)
+	require.Contains(t, guest.Verifications, "host1")
+	assert.Equal(t, VerificationFailed, guest.Verifications["host1"].Status)
+	assert.Equal(t, "mismatch", host.Verifications[guest.ID].Reason)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestSASFailsWhenKeysChange(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := sasRoom(t, server)
+	started := startSAS(t, server, guest, guestConn, hostConn)
+
+	publicKey, _, err := GenerateEd25519KeyPair()
+	require.NoError(t, err)
+	require.NoError(t, room.SavePublicKey("host1", publicKey))
+
+	var toGuest SASData
+	captureSAS(hostConn, MessageTypeSASFail, &SASData{})
+	captureSAS(guestConn, MessageTypeSASFail, &toGuest)
+	server.handleMessage("test-room", guest, &Message{
+		Type: MessageTypeSASConfirm,
+		Data: map[string]interface{}{"verification_id": started.VerificationID},
+	})
+
+	assert.Equal(t, VerificationFailed, toGuest.Status)
+	assert.Equal(t, VerificationFailed, guest.Verifications["host1"].Status)
+	hostConn.AssertExpectations(t)
+	guestConn.AssertExpectations(t)
+}
+
+func TestSASRejectsOutsiders(t *testing.T) {
+	server := NewServer()
+	room, guest, guestConn, hostConn := sasRoom(t, server)
+	started := startSAS(t, server, guest, guestConn, hostConn)
+
+	otherConn := &MockWebSocketConn{}
+	other := &Participant{ID: "guest2", Conn: otherConn, Role: RoleGuest}
+	require.NoError(t, room.AddParticipant(other))
+	require.NoError(t, room.AllowGuest(other.ID))
+
+	expectErrorCode(otherConn, ErrCodeSASInvalid)
+	server.handleMessage("test-room", other, &Message{
+		Type: MessageTypeSASConfirm,
+		Data: map[string]interface{}{"verification_id": started.VerificationID},
+	})
+	otherConn.AssertExpectations(t)
+}
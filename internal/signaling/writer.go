@@ -0,0 +1,280 @@
+package signaling
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// gorilla/websocket allows one concurrent writer per connection. Every
+// participant with a live connection owns a send queue drained by a single
+// writer goroutine; handlers and broadcasts only enqueue.
+
+const (
+	DefaultSendQueueSize = 64
+	DefaultWriteTimeout  = 10 * time.Second
+)
+
+// SlowConsumerPolicy decides what happens to a message for a participant
+// whose send queue is full.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDrop discards the new message.
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerCoalesce replaces a queued snapshot message of the same
+	// type with the new one, and discards messages that are not snapshots.
+	SlowConsumerCoalesce SlowConsumerPolicy = "coalesce"
+	// SlowConsumerDisconnect closes the connection.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// ParseSlowConsumerPolicy parses a policy name; the empty string selects
+// SlowConsumerDrop.
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, bool) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case "":
+		return SlowConsumerDrop, true
+	case SlowConsumerDrop, SlowConsumerCoalesce, SlowConsumerDisconnect:
+		return policy, true
+	}
+	return "", false
+}
+
+// snapshotTypes carry complete state, so a newer message of the same type
+// supersedes a queued one.
+var snapshotTypes = map[MessageType]bool{
+	MessageTypeParticipants: true,
+	MessageTypePublicKeys:   true,
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// outbound is a queued message and, if its sender asked for an ack, the
+// receipt to report its delivery to.
+type outbound struct {
+	message *Message
+	receipt *receipt
+}
+
+// sendQueue is the outbound queue of one connection.
+type sendQueue struct {
+	mutex   sync.Mutex
+	pending []outbound
+	closing bool // flush pending messages, then close
+	stopped bool
+	wake    chan struct{}
+	done    chan struct{}
+
+	size    int
+	policy  SlowConsumerPolicy
+	timeout time.Duration
+}
+
+func newSendQueue(size int, policy SlowConsumerPolicy, timeout time.Duration) *sendQueue {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	return &sendQueue{
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		size:    size,
+		policy:  policy,
+		timeout: timeout,
+	}
+}
+
+// push enqueues item. It returns the items it had to discard, which may
+// include item itself, and why: errRecipientGone when the connection is
+// closing, errQueueFull when the queue is full, or errSlowConsumer when the
+// consumer must be disconnected.
+func (q *sendQueue) push(item outbound) ([]outbound, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closing || q.stopped {
+		return []outbound{item}, errRecipientGone
+	}
+
+	if len(q.pending) < q.size {
+		q.pending = append(q.pending, item)
+		q.notify()
+		return nil, nil
+	}
+
+	switch q.policy {
+	case SlowConsumerCoalesce:
+		if snapshotTypes[item.message.Type] {
+			for i, queued := range q.pending {
+				if queued.message.Type == item.message.Type {
+					q.pending[i] = item
+					return []outbound{queued}, errQueueFull
+				}
+			}
+		}
+	case SlowConsumerDisconnect:
+		discarded := append(q.pending, item)
+		q.stopped = true
+		q.pending = nil
+		q.notify()
+		return discarded, errSlowConsumer
+	}
+	return []outbound{item}, errQueueFull
+}
+
+// close asks the writer to flush pending messages and close the connection.
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closing = true
+	q.notify()
+}
+
+// detach stops the writer without writing the pending messages, which it
+// returns so that they can be delivered over another connection.
+func (q *sendQueue) detach() []outbound {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items := q.pending
+	q.pending = nil
+	q.stopped = true
+	q.notify()
+	return items
+}
+
+// notify wakes the writer. The caller must hold the mutex.
+func (q *sendQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending messages and whether the writer should close
+// the connection once they are written.
+func (q *sendQueue) take() ([]outbound, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.stopped {
+		return nil, true
+	}
+	items := q.pending
+	q.pending = nil
+	return items, q.closing
+}
+
+// run writes queued messages to conn with codec until the queue is closed
+// or a write fails. It closes conn on exit.
+func (q *sendQueue) run(conn WebSocketConnInterface, codec Codec) {
+	defer close(q.done)
+	defer conn.Close()
+
+	for range q.wake {
+		items, finished := q.take()
+		for i, item := range items {
+			if deadliner, ok := conn.(writeDeadliner); ok {
+				deadliner.SetWriteDeadline(time.Now().Add(q.timeout))
+			}
+			if err := writeMessage(conn, codec, item.message); err != nil {
+				log.Printf("Write message error: %v", err)
+				q.mutex.Lock()
+				q.stopped = true
+				unsent := q.pending
+				q.pending = nil
+				q.mutex.Unlock()
+
+				item.receipt.report(err)
+				reportAll(append(items[i+1:], unsent...), errRecipientGone)
+				return
+			}
+			item.receipt.report(nil)
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+func reportAll(items []outbound, err error) {
+	for _, item := range items {
+		item.receipt.report(err)
+	}
+}
+
+// startWriter gives the participant a send queue and its writer goroutine.
+// The caller must hold connMutex if the participant is shared.
+func (p *Participant) startWriter(size int, policy SlowConsumerPolicy, timeout time.Duration) {
+	p.send = newSendQueue(size, policy, timeout)
+	go p.send.run(p.Conn, p.codec)
+}
+
+// Send delivers a message to the participant. Participants without a writer
+// are written to directly, one message at a time. Messages to a suspended
+// participant are kept for replay.
+func (p *Participant) Send(message *Message) {
+	p.enqueue(outbound{message: message})
+}
+
+// deliver sends a message and reports its delivery to receipt.
+func (p *Participant) deliver(message *Message, receipt *receipt) {
+	p.enqueue(outbound{message: message, receipt: receipt.claim()})
+}
+
+func (p *Participant) enqueue(item outbound) {
+	p.connMutex.Lock()
+	if p.session.suspended {
+		evicted := p.session.buffer(item)
+		p.connMutex.Unlock()
+		reportAll(evicted, errQueueFull)
+		return
+	}
+	item.message = p.protocol.adapt(item.message)
+	if item.message == nil {
+		p.connMutex.Unlock()
+		return
+	}
+	queue := p.send
+	if queue == nil {
+		err := writeMessage(p.Conn, p.codec, item.message)
+		p.connMutex.Unlock()
+		if err != nil {
+			log.Printf("Write message error: %v", err)
+		}
+		item.receipt.report(err)
+		return
+	}
+	conn := p.Conn
+	p.connMutex.Unlock()
+
+	discarded, err := queue.push(item)
+	switch err {
+	case errSlowConsumer:
+		log.Printf("Disconnecting slow participant %s", p.ID)
+		// Closing interrupts a write the writer may be blocked in
+		conn.Close()
+	case errQueueFull:
+		log.Printf("Dropped %s message to slow participant %s", discarded[0].message.Type, p.ID)
+	}
+	reportAll(discarded, err)
+}
+
+// Close closes the participant's connection after the messages already
+// sent to it have been written.
+func (p *Participant) Close() {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+
+	if p.send == nil {
+		p.Conn.Close()
+		return
+	}
+	p.send.close()
+}
@@ -0,0 +1,205 @@
+package signaling
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn records written messages and fails the test on concurrent
+// writes. Writes block while gate is non-nil and open.
+type recordingConn struct {
+	mutex     sync.Mutex
+	messages  []*Message
+	writing   int32
+	overlap   int32
+	closed    chan struct{}
+	closeOnce sync.Once
+	gate      chan struct{}
+	deadlines int32
+}
+
+func newRecordingConn() *recordingConn {
+	return &recordingConn{closed: make(chan struct{})}
+}
+
+func (c *recordingConn) WriteJSON(v interface{}) error {
+	if atomic.AddInt32(&c.writing, 1) > 1 {
+		atomic.StoreInt32(&c.overlap, 1)
+	}
+	defer atomic.AddInt32(&c.writing, -1)
+
+	if c.gate != nil {
+		select {
+		case <-c.gate:
+		case <-c.closed:
+			return errors.New("closed")
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messages = append(c.messages, v.(*Message))
+	return nil
+}
+
+func (c *recordingConn) ReadJSON(v interface{}) error { return errors.New("not implemented") }
+
+func (c *recordingConn) ReadMessage() (int, []byte, error) {
+	return 0, nil, errors.New("not implemented")
+}
+
+func (c *recordingConn) WriteMessage(messageType int, data []byte) error { return nil }
+
+func (c *recordingConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *recordingConn) SetWriteDeadline(t time.Time) error {
+	atomic.AddInt32(&c.deadlines, 1)
+	return nil
+}
+
+func (c *recordingConn) written() []*Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*Message(nil), c.messages...)
+}
+
+func waitClosed(t *testing.T, conn *recordingConn) {
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
+
+func TestWriterSerializesConcurrentSends(t *testing.T) {
+	conn := newRecordingConn()
+	participant := &Participant{ID: "p1", Conn: conn}
+	participant.startWriter(1000, SlowConsumerDrop, time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				participant.Send(&Message{Type: MessageTypeOffer})
+			}
+		}()
+	}
+	wg.Wait()
+
+	participant.Close()
+	waitClosed(t, conn)
+	<-participant.send.done
+
+	assert.Len(t, conn.written(), 500)
+	assert.Zero(t, atomic.LoadInt32(&conn.overlap))
+	assert.Equal(t, int32(500), atomic.LoadInt32(&conn.deadlines))
+}
+
+func TestWriterFlushesBeforeClose(t *testing.T) {
+	conn := newRecordingConn()
+	conn.gate = make(chan struct{})
+	participant := &Participant{ID: "p1", Conn: conn}
+	participant.startWriter(10, SlowConsumerDrop, time.Second)
+
+	participant.Send(&Message{Type: MessageTypeError})
+	participant.Close()
+	participant.Send(&Message{Type: MessageTypeOffer}) // discarded once closing
+	close(conn.gate)
+
+	waitClosed(t, conn)
+	<-participant.send.done
+	messages := conn.written()
+	require.Len(t, messages, 1)
+	assert.Equal(t, MessageTypeError, messages[0].Type)
+}
+
+func push(queue *sendQueue, message *Message) ([]outbound, error) {
+	return queue.push(outbound{message: message})
+}
+
+func TestSendQueueDropPolicy(t *testing.T) {
+	queue := newSendQueue(2, SlowConsumerDrop, time.Second)
+
+	for i := 0; i < 2; i++ {
+		discarded, err := push(queue, &Message{Type: MessageTypeOffer})
+		assert.Empty(t, discarded)
+		assert.NoError(t, err)
+	}
+	message := &Message{Type: MessageTypeParticipants}
+	discarded, err := push(queue, message)
+	assert.Equal(t, errQueueFull, err)
+	require.Len(t, discarded, 1)
+	assert.Same(t, message, discarded[0].message)
+	assert.Len(t, queue.pending, 2)
+}
+
+func TestSendQueueCoalescePolicy(t *testing.T) {
+	queue := newSendQueue(2, SlowConsumerCoalesce, time.Second)
+	push(queue, &Message{Type: MessageTypeParticipants, Data: 1})
+	push(queue, &Message{Type: MessageTypeOffer})
+
+	discarded, err := push(queue, &Message{Type: MessageTypeParticipants, Data: 2})
+	assert.Equal(t, errQueueFull, err)
+	require.Len(t, discarded, 1)
+	assert.Equal(t, 1, discarded[0].message.Data)
+	require.Len(t, queue.pending, 2)
+	assert.Equal(t, 2, queue.pending[0].message.Data)
+
+	// Only snapshots are coalesced
+	_, err = push(queue, &Message{Type: MessageTypeOffer})
+	assert.Equal(t, errQueueFull, err)
+	_, err = push(queue, &Message{Type: MessageTypePublicKeys})
+	assert.Equal(t, errQueueFull, err)
+}
+
+func TestWriterDisconnectPolicy(t *testing.T) {
+	conn := newRecordingConn()
+	conn.gate = make(chan struct{}) // never opened: the consumer is stuck
+	participant := &Participant{ID: "p1", Conn: conn}
+	participant.startWriter(1, SlowConsumerDisconnect, time.Second)
+
+	// One message is being written, one waits in the queue
+	participant.Send(&Message{Type: MessageTypeOffer})
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&conn.writing) == 1 }, time.Second, time.Millisecond)
+	participant.Send(&Message{Type: MessageTypeOffer})
+	participant.Send(&Message{Type: MessageTypeOffer})
+
+	waitClosed(t, conn)
+	<-participant.send.done
+	assert.Empty(t, conn.written())
+}
+
+func TestSendWithoutWriterWritesDirectly(t *testing.T) {
+	conn := newRecordingConn()
+	participant := &Participant{ID: "p1", Conn: conn}
+
+	participant.Send(&Message{Type: MessageTypeOffer})
+	assert.Len(t, conn.written(), 1)
+
+	participant.Close()
+	waitClosed(t, conn)
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	policy, ok := ParseSlowConsumerPolicy("")
+	assert.True(t, ok)
+	assert.Equal(t, SlowConsumerDrop, policy)
+
+	policy, ok = ParseSlowConsumerPolicy("coalesce")
+	assert.True(t, ok)
+	assert.Equal(t, SlowConsumerCoalesce, policy)
+
+	_, ok = ParseSlowConsumerPolicy("block")
+	assert.False(t, ok)
+}
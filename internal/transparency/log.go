@@ -0,0 +1,423 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is a published public key as recorded in the log.
+type Key struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+// Entry records that a participant published keys under an identity.
+type Entry struct {
+	Identity      string `json:"identity"` // DID, or the identity key of participants without one
+	Room          string `json:"room"`
+	ParticipantID string `json:"participant_id"`
+	Keys          []Key  `json:"keys"`
+	Timestamp     int64  `json:"timestamp"` // unix milliseconds
+}
+
+// SignedTreeHead commits the log to its first TreeSize entries.
+type SignedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"` // unix milliseconds
+	RootHash  string `json:"root_hash"` // base64
+	Signature string `json:"signature"` // base64 Ed25519 signature over TreeHeadPayload
+}
+
+// InclusionProof shows that Leaf is entry LeafIndex of the tree of TreeSize.
+// Leaf holds the exact bytes that were hashed, so clients need not
+// reproduce the server's JSON encoding.
+type InclusionProof struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	TreeSize  uint64   `json:"tree_size"`
+	Leaf      string   `json:"leaf"`       // base64 leaf data
+	AuditPath []string `json:"audit_path"` // base64 hashes
+}
+
+// ConsistencyProof shows that the tree of Second extends the tree of First.
+type ConsistencyProof struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Path   []string `json:"path"` // base64 hashes
+}
+
+// TreeHeadPayload is the message signed in a SignedTreeHead.
+func TreeHeadPayload(treeSize uint64, timestamp int64, rootHash string) []byte {
+	return []byte(fmt.Sprintf("kaamos-tree-head:%d:%d:%s", treeSize, timestamp, rootHash))
+}
+
+// VerifyTreeHead checks the signature of a tree head.
+func VerifyTreeHead(publicKey ed25519.PublicKey, sth SignedTreeHead) error {
+	signature, err := base64.StdEncoding.DecodeString(sth.Signature)
+	if err != nil || !ed25519.Verify(publicKey, TreeHeadPayload(sth.TreeSize, sth.Timestamp, sth.RootHash), signature) {
+		return errors.New("invalid tree head signature")
+	}
+	return nil
+}
+
+// DefaultMaxEntries bounds a log. Entries cannot be dropped from a Merkle
+// log without breaking the proofs of the others, so a full log refuses new
+// entries until it is rotated to a new file and signing key.
+const DefaultMaxEntries = 1 << 20
+
+// ErrLogFull is returned by Append once a log holds its maximum of entries.
+var ErrLogFull = errors.New("key log is full")
+
+// Log is an append-only Merkle log of published keys. Its leaves are kept
+// in memory and, with a Storage, persisted.
+type Log struct {
+	appendMu   sync.Mutex // serializes appends, which may wait for storage
+	mu         sync.RWMutex
+	leaves     [][]byte // leaf data
+	tree       tree
+	byIdentity map[string][]uint64
+	latest     map[string]loggedKeys // publisher -> its latest entry
+	storage    Storage
+	maxEntries uint64
+	signer     ed25519.PrivateKey
+	now        func() time.Time
+
+	headMu sync.Mutex
+	head   *SignedTreeHead // signed head of the current size
+}
+
+// loggedKeys is the latest entry of a publisher.
+type loggedKeys struct {
+	digest [sha256.Size]byte // of the keys
+	index  uint64
+}
+
+// Option configures a Log.
+type Option func(*Log)
+
+// WithStorage persists the leaves of the log in storage and loads the
+// leaves stored before.
+func WithStorage(storage Storage) Option {
+	return func(l *Log) {
+		l.storage = storage
+	}
+}
+
+// WithMaxEntries caps the number of entries. Zero means DefaultMaxEntries.
+func WithMaxEntries(maxEntries uint64) Option {
+	return func(l *Log) {
+		if maxEntries > 0 {
+			l.maxEntries = maxEntries
+		}
+	}
+}
+
+// NewLog creates a log that signs tree heads with signer, or with a fresh
+// key if signer is nil. Tree heads signed with a fresh key cannot be
+// checked against the ones signed before a restart.
+func NewLog(signer ed25519.PrivateKey, opts ...Option) (*Log, error) {
+	if signer == nil {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate log key: %w", err)
+		}
+		signer = priv
+	}
+
+	l := &Log{
+		byIdentity: make(map[string][]uint64),
+		latest:     make(map[string]loggedKeys),
+		maxEntries: DefaultMaxEntries,
+		signer:     signer,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.storage != nil {
+		leaves, err := l.storage.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key log: %w", err)
+		}
+		for _, data := range leaves {
+			var entry Entry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return nil, fmt.Errorf("corrupt key log entry %d: %w", len(l.leaves), err)
+			}
+			digest, err := keysDigest(entry)
+			if err != nil {
+				return nil, err
+			}
+			l.add(data, entry, digest)
+		}
+	}
+	return l, nil
+}
+
+// ParseSigningKey decodes a base64 Ed25519 key for signing tree heads,
+// either a 32-byte seed or a 64-byte private key.
+func ParseSigningKey(value string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		priv := ed25519.NewKeyFromSeed(key[:ed25519.SeedSize])
+		if !bytes.Equal(priv, key) {
+			return nil, errors.New("public half does not match the seed")
+		}
+		return priv, nil
+	}
+	return nil, fmt.Errorf("expected %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+}
+
+// PublicKey returns the key that verifies tree heads.
+func (l *Log) PublicKey() ed25519.PublicKey {
+	return l.signer.Public().(ed25519.PublicKey)
+}
+
+// Append adds an entry and returns its index. The log only grows when keys
+// change: if the identity last logged the same keys, from any room or
+// participant, Append returns the index of that entry instead. Entries
+// without an identity are compared with those of the same participant.
+func (l *Log) Append(entry Entry) (uint64, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	digest, err := keysDigest(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+
+	// Only appends change the log, so it stays as read here until add
+	l.mu.RLock()
+	latest, exists := l.latest[publisher(entry)]
+	size := uint64(len(l.leaves))
+	l.mu.RUnlock()
+	if exists && latest.digest == digest {
+		return latest.index, nil
+	}
+	if size >= l.maxEntries {
+		return 0, ErrLogFull
+	}
+
+	// Readers need not wait for storage
+	if l.storage != nil {
+		if err := l.storage.Append(data); err != nil {
+			return 0, fmt.Errorf("failed to store key log entry: %w", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.add(data, entry, digest), nil
+}
+
+// Logged returns the index Append would return for entry without adding
+// it, if its keys are already the latest of its identity.
+func (l *Log) Logged(entry Entry) (uint64, bool) {
+	digest, err := keysDigest(entry)
+	if err != nil {
+		return 0, false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	latest, exists := l.latest[publisher(entry)]
+	if !exists || latest.digest != digest {
+		return 0, false
+	}
+	return latest.index, true
+}
+
+// add indexes a stored leaf. The caller must hold the mutex.
+func (l *Log) add(data []byte, entry Entry, digest [sha256.Size]byte) uint64 {
+	index := uint64(len(l.leaves))
+	l.leaves = append(l.leaves, data)
+	l.tree.append(LeafHash(data))
+	if entry.Identity != "" {
+		l.byIdentity[entry.Identity] = append(l.byIdentity[entry.Identity], index)
+	}
+	l.latest[publisher(entry)] = loggedKeys{digest: digest, index: index}
+	return index
+}
+
+// publisher returns who published an entry: its identity or, without one,
+// its participant.
+func publisher(entry Entry) string {
+	if entry.Identity != "" {
+		return entry.Identity
+	}
+	return "\x00" + entry.Room + "\x00" + entry.ParticipantID
+}
+
+// keysDigest identifies the keys of an entry.
+func keysDigest(entry Entry) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(entry.Keys)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// Close closes the storage of the log, if it can be closed.
+func (l *Log) Close() error {
+	if closer, ok := l.storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Size returns the number of entries.
+func (l *Log) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return uint64(len(l.leaves))
+}
+
+// Entry returns the entry at index.
+func (l *Log) Entry(index uint64) (*Entry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if index >= uint64(len(l.leaves)) {
+		return nil, ErrIndexOutOfRange
+	}
+	var entry Entry
+	if err := json.Unmarshal(l.leaves[index], &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// IdentityIndexes returns the indexes of every entry of an identity.
+func (l *Log) IdentityIndexes(identity string) []uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return append([]uint64(nil), l.byIdentity[identity]...)
+}
+
+// SignedTreeHead returns the signed head of the current tree. The head is
+// signed once per tree size, so its timestamp is when the tree first
+// reached that size.
+func (l *Log) SignedTreeHead() SignedTreeHead {
+	l.headMu.Lock()
+	defer l.headMu.Unlock()
+
+	l.mu.RLock()
+	size := l.tree.size()
+	if l.head != nil && l.head.TreeSize == size {
+		l.mu.RUnlock()
+		return *l.head
+	}
+	root := l.tree.root(size)
+	l.mu.RUnlock()
+
+	sth := SignedTreeHead{
+		TreeSize:  size,
+		Timestamp: l.now().UnixMilli(),
+		RootHash:  base64.StdEncoding.EncodeToString(root),
+	}
+	sth.Signature = base64.StdEncoding.EncodeToString(
+		ed25519.Sign(l.signer, TreeHeadPayload(sth.TreeSize, sth.Timestamp, sth.RootHash)))
+	l.head = &sth
+	return sth
+}
+
+// InclusionProof proves entry index against the tree of size treeSize.
+func (l *Log) InclusionProof(index, treeSize uint64) (*InclusionProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if treeSize > l.tree.size() || index >= treeSize {
+		return nil, ErrIndexOutOfRange
+	}
+
+	return &InclusionProof{
+		LeafIndex: index,
+		TreeSize:  treeSize,
+		Leaf:      base64.StdEncoding.EncodeToString(l.leaves[index]),
+		AuditPath: encodeHashes(l.tree.inclusionPath(index, treeSize)),
+	}, nil
+}
+
+// ConsistencyProof proves that the tree of size second extends the tree of
+// size first.
+func (l *Log) ConsistencyProof(first, second uint64) (*ConsistencyProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if first > second || second > l.tree.size() {
+		return nil, ErrIndexOutOfRange
+	}
+
+	var path [][]byte
+	if first > 0 && first < second {
+		path = l.tree.consistencyPath(first, second)
+	}
+	return &ConsistencyProof{
+		First:  first,
+		Second: second,
+		Path:   encodeHashes(path),
+	}, nil
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = base64.StdEncoding.EncodeToString(hash)
+	}
+	return encoded
+}
+
+// DecodeHashes decodes the base64 hashes of a proof.
+func DecodeHashes(encoded []string) ([][]byte, error) {
+	hashes := make([][]byte, len(encoded))
+	for i, hash := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		hashes[i] = decoded
+	}
+	return hashes, nil
+}
+
+// Verify checks the proof against a tree head root.
+func (p *InclusionProof) Verify(rootHash string) error {
+	leaf, err := base64.StdEncoding.DecodeString(p.Leaf)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	root, err := base64.StdEncoding.DecodeString(rootHash)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	path, err := DecodeHashes(p.AuditPath)
+	if err != nil {
+		return err
+	}
+	return VerifyInclusion(LeafHash(leaf), p.LeafIndex, p.TreeSize, path, root)
+}
@@ -0,0 +1,217 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry(identity, participantID string) Entry {
+	return Entry{
+		Identity:      identity,
+		Room:          "test-room",
+		ParticipantID: participantID,
+		Keys:          []Key{{Algorithm: "ed25519", PublicKey: base64.StdEncoding.EncodeToString(make([]byte, 32))}},
+		Timestamp:     1700000000000,
+	}
+}
+
+func TestLogInclusionAgainstTreeHead(t *testing.T) {
+	log, err := NewLog(nil)
+	require.NoError(t, err)
+
+	// alice rotates her key after bob joins
+	rotated := testEntry("did:key:alice", "participant-2")
+	rotated.Keys = []Key{{Algorithm: "ed25519", PublicKey: base64.StdEncoding.EncodeToString(make([]byte, 31))}}
+	for i, entry := range []Entry{testEntry("did:key:alice", "participant-0"), testEntry("did:key:bob", "participant-1"), rotated} {
+		index, err := log.Append(entry)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i), index)
+	}
+
+	sth := log.SignedTreeHead()
+	assert.Equal(t, uint64(3), sth.TreeSize)
+	assert.NoError(t, VerifyTreeHead(log.PublicKey(), sth))
+
+	proof, err := log.InclusionProof(2, sth.TreeSize)
+	require.NoError(t, err)
+	assert.NoError(t, proof.Verify(sth.RootHash))
+
+	entry, err := log.Entry(2)
+	require.NoError(t, err)
+	assert.Equal(t, "did:key:alice", entry.Identity)
+	assert.Equal(t, []uint64{0, 2}, log.IdentityIndexes("did:key:alice"))
+
+	_, err = log.InclusionProof(3, sth.TreeSize)
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+}
+
+func TestLogTreeHeadSignature(t *testing.T) {
+	log, err := NewLog(nil)
+	require.NoError(t, err)
+	other, err := NewLog(nil)
+	require.NoError(t, err)
+
+	sth := log.SignedTreeHead()
+	assert.NoError(t, VerifyTreeHead(log.PublicKey(), sth))
+	assert.Error(t, VerifyTreeHead(other.PublicKey(), sth))
+
+	sth.TreeSize++
+	assert.Error(t, VerifyTreeHead(log.PublicKey(), sth))
+}
+
+func TestLogConsistency(t *testing.T) {
+	log, err := NewLog(nil)
+	require.NoError(t, err)
+
+	log.Append(testEntry("did:key:alice", "p1"))
+	log.Append(testEntry("did:key:bob", "p2"))
+	log.Append(testEntry("did:key:carol", "p3"))
+	old := log.SignedTreeHead()
+
+	log.Append(testEntry("did:key:dave", "p4"))
+	log.Append(testEntry("did:key:erin", "p5"))
+	current := log.SignedTreeHead()
+
+	proof, err := log.ConsistencyProof(old.TreeSize, current.TreeSize)
+	require.NoError(t, err)
+
+	path, err := DecodeHashes(proof.Path)
+	require.NoError(t, err)
+	oldRoot, _ := base64.StdEncoding.DecodeString(old.RootHash)
+	newRoot, _ := base64.StdEncoding.DecodeString(current.RootHash)
+	assert.NoError(t, VerifyConsistency(old.TreeSize, current.TreeSize, path, oldRoot, newRoot))
+
+	_, err = log.ConsistencyProof(4, 9)
+	assert.ErrorIs(t, err, ErrIndexOutOfRange)
+}
+
+func TestLogSkipsRepublishedKeys(t *testing.T) {
+	log, err := NewLog(nil)
+	require.NoError(t, err)
+
+	first, err := log.Append(testEntry("did:key:alice", "p1"))
+	require.NoError(t, err)
+	log.Append(testEntry("did:key:bob", "p2"))
+
+	// Rejoining, even in another room, does not change the keys
+	again := testEntry("did:key:alice", "p3")
+	again.Room = "other-room"
+	again.Timestamp += 60000
+	index, found := log.Logged(again)
+	assert.True(t, found)
+	assert.Equal(t, first, index)
+	index, err = log.Append(again)
+	require.NoError(t, err)
+	assert.Equal(t, first, index)
+	assert.Equal(t, uint64(2), log.Size())
+
+	// Changing keys, and changing them back, does
+	rotated := testEntry("did:key:alice", "p3")
+	rotated.Keys = nil
+	_, found = log.Logged(rotated)
+	assert.False(t, found)
+	log.Append(rotated)
+	index, err = log.Append(again)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+
+	// Without an identity, only the same participant republishes
+	anonymous, err := log.Append(testEntry("", "p4"))
+	require.NoError(t, err)
+	index, _ = log.Append(testEntry("", "p4"))
+	assert.Equal(t, anonymous, index)
+	index, _ = log.Append(testEntry("", "p5"))
+	assert.NotEqual(t, anonymous, index)
+}
+
+func TestLogRefusesEntriesWhenFull(t *testing.T) {
+	log, err := NewLog(nil, WithMaxEntries(2))
+	require.NoError(t, err)
+
+	log.Append(testEntry("did:key:alice", "p1"))
+	log.Append(testEntry("did:key:bob", "p2"))
+	_, err = log.Append(testEntry("did:key:carol", "p3"))
+	assert.ErrorIs(t, err, ErrLogFull)
+
+	_, err = log.Append(testEntry("did:key:alice", "p1"))
+	assert.NoError(t, err, "entries already logged are still found")
+}
+
+func TestLogSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.log")
+	_, signer, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	storage, err := NewFileStorage(path)
+	require.NoError(t, err)
+	log, err := NewLog(signer, WithStorage(storage))
+	require.NoError(t, err)
+	log.Append(testEntry("did:key:alice", "p1"))
+	log.Append(testEntry("did:key:bob", "p2"))
+	log.Append(testEntry("did:key:carol", "p3"))
+	before := log.SignedTreeHead()
+	require.NoError(t, log.Close())
+
+	storage, err = NewFileStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+	reopened, err := NewLog(signer, WithStorage(storage))
+	require.NoError(t, err)
+
+	after := reopened.SignedTreeHead()
+	assert.Equal(t, before.TreeSize, after.TreeSize)
+	assert.Equal(t, before.RootHash, after.RootHash)
+	assert.NoError(t, VerifyTreeHead(log.PublicKey(), after), "the configured key signs across restarts")
+	assert.Equal(t, []uint64{1}, reopened.IdentityIndexes("did:key:bob"))
+
+	index, err := reopened.Append(testEntry("did:key:dave", "p4"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), index)
+}
+
+func TestFileStorageDropsPartialEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.log")
+	storage, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, storage.Append([]byte("first")))
+	storage.file.WriteString("c2Vjb25")
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+	leaves, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first")}, leaves)
+
+	require.NoError(t, storage.Append([]byte("second")))
+	leaves, err = storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, leaves)
+}
+
+func TestParseSigningKey(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 7
+	priv := ed25519.NewKeyFromSeed(seed)
+
+	fromSeed, err := ParseSigningKey(base64.StdEncoding.EncodeToString(seed))
+	require.NoError(t, err)
+	assert.Equal(t, priv, fromSeed)
+
+	fromKey, err := ParseSigningKey(base64.StdEncoding.EncodeToString(priv))
+	require.NoError(t, err)
+	assert.Equal(t, priv, fromKey)
+
+	mismatched := append(ed25519.PrivateKey(nil), priv...)
+	mismatched[40] ^= 1
+	for _, value := range []string{"not base64!", base64.StdEncoding.EncodeToString(seed[:16]), base64.StdEncoding.EncodeToString(mismatched)} {
+		_, err := ParseSigningKey(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,226 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+// Hashing follows RFC 6962 (and RFC 9162): leaves and interior nodes use
+// distinct prefixes so that a leaf can never be passed off as a node.
+
+var (
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrInvalidProof    = errors.New("invalid proof")
+)
+
+// LeafHash returns the hash of a leaf's data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// EmptyRoot is the root hash of a tree without leaves.
+func EmptyRoot() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// splitPoint returns the largest power of two smaller than n (n > 1).
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// tree keeps the hash of every complete subtree, so that roots and proofs
+// cost O(log n) hashes instead of rehashing every leaf.
+type tree struct {
+	// levels[h][i] is the hash of leaves [i<<h, (i+1)<<h)
+	levels [][][]byte
+}
+
+func (t *tree) size() uint64 {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return uint64(len(t.levels[0]))
+}
+
+// append adds a leaf hash and the subtrees it completes.
+func (t *tree) append(leafHash []byte) {
+	if len(t.levels) == 0 {
+		t.levels = append(t.levels, nil)
+	}
+	t.levels[0] = append(t.levels[0], leafHash)
+
+	for h := 0; len(t.levels[h])%2 == 0; h++ {
+		if h+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		n := len(t.levels[h])
+		t.levels[h+1] = append(t.levels[h+1], nodeHash(t.levels[h][n-2], t.levels[h][n-1]))
+	}
+}
+
+// hash is the Merkle tree hash of leaves [start, end). Ranges produced by
+// the RFC 6962 splits are aligned, so only the right edge is ever hashed.
+func (t *tree) hash(start, end uint64) []byte {
+	n := end - start
+	if n == 0 {
+		return EmptyRoot()
+	}
+	if n&(n-1) == 0 && start%n == 0 {
+		h := bits.TrailingZeros64(n)
+		return t.levels[h][start>>h]
+	}
+	k := splitPoint(n)
+	return nodeHash(t.hash(start, start+k), t.hash(start+k, end))
+}
+
+// root is the Merkle tree hash of the first size leaves.
+func (t *tree) root(size uint64) []byte {
+	return t.hash(0, size)
+}
+
+// inclusionPath is PATH(m, D[size]) from RFC 6962 section 2.1.1.
+func (t *tree) inclusionPath(m, size uint64) [][]byte {
+	return t.path(m, 0, size)
+}
+
+func (t *tree) path(m, start, end uint64) [][]byte {
+	n := end - start
+	if n <= 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(t.path(m, start, start+k), t.hash(start+k, end))
+	}
+	return append(t.path(m-k, start+k, end), t.hash(start, start+k))
+}
+
+// consistencyPath is PROOF(m, D[size]) from RFC 6962 section 2.1.2.
+func (t *tree) consistencyPath(m, size uint64) [][]byte {
+	return t.subproof(m, 0, size, true)
+}
+
+func (t *tree) subproof(m, start, end uint64, complete bool) [][]byte {
+	n := end - start
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.hash(start, end)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(t.subproof(m, start, start+k, complete), t.hash(start+k, end))
+	}
+	return append(t.subproof(m-k, start+k, end, false), t.hash(start, start+k))
+}
+
+// VerifyInclusion checks that leafHash is the leaf at index in the tree of
+// the given size and root, using the algorithm of RFC 9162 section 2.1.3.2.
+func VerifyInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrIndexOutOfRange
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size second with newRoot
+// extends the tree of size first with oldRoot, using the algorithm of
+// RFC 9162 section 2.1.4.2.
+func VerifyConsistency(first, second uint64, proof [][]byte, oldRoot, newRoot []byte) error {
+	switch {
+	case first > second:
+		return ErrIndexOutOfRange
+	case first == second:
+		if len(proof) != 0 || !bytes.Equal(oldRoot, newRoot) {
+			return ErrInvalidProof
+		}
+		return nil
+	case first == 0:
+		// Every tree extends the empty tree
+		if len(proof) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+
+	if len(proof) == 0 {
+		return ErrInvalidProof
+	}
+
+	// If first is a power of two, the old root is the first node of the path
+	if first&(first-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, oldRoot) || !bytes.Equal(sr, newRoot) {
+		return ErrInvalidProof
+	}
+	return nil
+}
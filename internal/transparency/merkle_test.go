@@ -0,0 +1,116 @@
+package transparency
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash([]byte(fmt.Sprintf("leaf-%d", i)))
+	}
+	return leaves
+}
+
+func testTree(leaves [][]byte) *tree {
+	t := &tree{}
+	for _, leaf := range leaves {
+		t.append(leaf)
+	}
+	return t
+}
+
+// rootOf is the recursive definition of the Merkle tree hash from RFC 6962,
+// which the cached tree must agree with.
+func rootOf(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return EmptyRoot()
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(rootOf(leaves[:k]), rootOf(leaves[k:]))
+}
+
+func TestEmptyAndSingleLeafRoots(t *testing.T) {
+	// SHA-256 of the empty string, as RFC 6962 requires
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(EmptyRoot()))
+
+	// The hash of an empty leaf, from the RFC 6962 test vectors
+	assert.Equal(t, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d", hex.EncodeToString(LeafHash(nil)))
+}
+
+func TestInclusionProofs(t *testing.T) {
+	for size := 1; size <= 20; size++ {
+		leaves := testLeaves(size)
+		tree := testTree(leaves)
+		root := tree.root(uint64(size))
+		require.Equal(t, rootOf(leaves), root, "size %d", size)
+
+		for index := 0; index < size; index++ {
+			proof := tree.inclusionPath(uint64(index), uint64(size))
+			require.NoError(t, VerifyInclusion(leaves[index], uint64(index), uint64(size), proof, root),
+				"index %d size %d", index, size)
+
+			// The proof must not verify another leaf
+			other := leaves[(index+1)%size]
+			if size > 1 {
+				assert.Error(t, VerifyInclusion(other, uint64(index), uint64(size), proof, root))
+			}
+		}
+	}
+}
+
+func TestInclusionProofRejectsTampering(t *testing.T) {
+	leaves := testLeaves(7)
+	tree := testTree(leaves)
+	root := tree.root(7)
+	proof := tree.inclusionPath(3, 7)
+
+	assert.ErrorIs(t, VerifyInclusion(leaves[3], 7, 7, proof, root), ErrIndexOutOfRange)
+	assert.Error(t, VerifyInclusion(leaves[3], 3, 4, proof, root))
+	assert.Error(t, VerifyInclusion(leaves[3], 3, 7, proof[:len(proof)-1], root))
+	assert.Error(t, VerifyInclusion(leaves[3], 3, 7, append(proof, root), root))
+}
+
+func TestConsistencyProofs(t *testing.T) {
+	leaves := testLeaves(20)
+	tree := testTree(leaves)
+
+	for second := 1; second <= len(leaves); second++ {
+		newRoot := tree.root(uint64(second))
+		require.Equal(t, rootOf(leaves[:second]), newRoot, "size %d", second)
+		for first := 0; first <= second; first++ {
+			oldRoot := tree.root(uint64(first))
+
+			var proof [][]byte
+			if first > 0 && first < second {
+				proof = tree.consistencyPath(uint64(first), uint64(second))
+			}
+			require.NoError(t, VerifyConsistency(uint64(first), uint64(second), proof, oldRoot, newRoot),
+				"first %d second %d", first, second)
+
+			if first > 0 && first < second {
+				assert.Error(t, VerifyConsistency(uint64(first), uint64(second), proof, rootOf(leaves[1:first+1]), newRoot))
+			}
+		}
+	}
+}
+
+func TestConsistencyProofDetectsRewrite(t *testing.T) {
+	leaves := testLeaves(8)
+	oldRoot := rootOf(leaves[:5])
+
+	rewritten := append([][]byte(nil), leaves...)
+	rewritten[2] = LeafHash([]byte("forged"))
+	tree := testTree(rewritten)
+	proof := tree.consistencyPath(5, 8)
+
+	assert.Error(t, VerifyConsistency(5, 8, proof, oldRoot, tree.root(8)))
+}
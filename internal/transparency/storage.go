@@ -0,0 +1,96 @@
+package transparency
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Storage keeps the leaves of a log, so that its history survives restarts.
+// A log that restarts empty would sign tree heads that contradict the ones
+// clients already saw.
+type Storage interface {
+	// Load returns every stored leaf, oldest first.
+	Load() ([][]byte, error)
+	// Append stores a leaf after the others. The leaf must be stored when
+	// Append returns.
+	Append(leaf []byte) error
+}
+
+// FileStorage stores leaves in a file, one base64 leaf per line.
+type FileStorage struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileStorage opens or creates the leaf file at path.
+func NewFileStorage(path string) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key log: %w", err)
+	}
+	return &FileStorage{file: file}, nil
+}
+
+// Load reads every leaf. A last line without a newline was cut short by a
+// crash during Append, which never returned, so it is dropped.
+func (s *FileStorage) Load() ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var leaves [][]byte
+	var offset int64
+	reader := bufio.NewReader(s.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := s.file.Truncate(offset); err != nil {
+					return nil, fmt.Errorf("failed to drop partial key log entry: %w", err)
+				}
+			}
+			return leaves, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		leaf, err := base64.StdEncoding.DecodeString(string(bytes.TrimSuffix(line, []byte("\n"))))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt key log entry %d: %w", len(leaves), err)
+		}
+		leaves = append(leaves, leaf)
+		offset += int64(len(line))
+	}
+}
+
+func (s *FileStorage) Append(leaf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	line := base64.StdEncoding.EncodeToString(leaf) + "\n"
+	if _, err := s.file.WriteString(line); err != nil {
+		// Do not leave half a line for the next leaf to be appended to
+		s.file.Truncate(info.Size())
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileStorage) Close() error {
+	return s.file.Close()
+}